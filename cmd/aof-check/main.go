@@ -0,0 +1,105 @@
+package main
+
+import (
+	"MiddlewareSelf/redis/aof"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// aof-check 离线检查 AOF 文件，报告第一处残缺/损坏的偏移，--fix 时截断到最后一条完整命令。
+// --truncate-to-timestamp 用于时间点恢复：只保留指定时间（unix 秒）之前写入的命令。
+//
+//	go run ./cmd/aof-check appendonly.aof
+//	go run ./cmd/aof-check --fix appendonly.aof
+//	go run ./cmd/aof-check --truncate-to-timestamp 1700000000 --output restore.aof appendonly.aof
+func main() {
+	fix := flag.Bool("fix", false, "truncate the file to the last valid command")
+	until := flag.Int64("truncate-to-timestamp", 0, "keep only commands written at or before this unix timestamp")
+	output := flag.String("output", "", "with --truncate-to-timestamp, write the result to this file instead of truncating in place")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: aof-check [--fix] [--truncate-to-timestamp <unix> [--output <file>]] <file.aof>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	fileName := aof.AofName
+	if flag.NArg() > 0 {
+		fileName = flag.Arg(0)
+	}
+
+	if *until > 0 {
+		truncateToTimestamp(fileName, *until, *output)
+		return
+	}
+
+	result, err := aof.Check(fileName)
+	if result == nil {
+		fmt.Printf("cannot open %s: %v\n", fileName, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("AOF analyzed: file=%s size=%d ok_up_to=%d commands=%d diff=%d\n",
+		fileName, result.Size, result.ValidOffset, result.Commands, result.Size-result.ValidOffset)
+	if err == nil {
+		fmt.Println("AOF is valid")
+		return
+	}
+
+	var corruption *aof.CorruptionError
+	switch {
+	case errors.Is(err, aof.ErrTruncated):
+		fmt.Printf("AOF has an incomplete command at the end, first bad offset=%d\n", result.ValidOffset)
+	case errors.As(err, &corruption):
+		fmt.Printf("AOF is corrupted, first bad offset=%d: %s\n", corruption.Offset, corruption.Reason)
+	default:
+		fmt.Printf("read %s failed: %v\n", fileName, err)
+		os.Exit(1)
+	}
+
+	if !*fix {
+		fmt.Println("run with --fix to truncate the file to the last valid command")
+		os.Exit(1)
+	}
+	if err := aof.Truncate(fileName, result.ValidOffset); err != nil {
+		fmt.Printf("truncate failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Successfully truncated AOF to %d bytes (%d bytes discarded)\n",
+		result.ValidOffset, result.Size-result.ValidOffset)
+}
+
+// truncateToTimestamp 找到第一条晚于 until 的 #TS 注解，把它之前的内容作为恢复用的 AOF。
+func truncateToTimestamp(fileName string, until int64, output string) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		fmt.Printf("cannot open %s: %v\n", fileName, err)
+		os.Exit(1)
+	}
+	commands := 0
+	offset, stopped, err := aof.ScanUntil(f, until, func([][]byte) { commands++ })
+	_ = f.Close()
+	if err != nil {
+		fmt.Printf("AOF is not valid before the target timestamp: %v\nrun with --fix first\n", err)
+		os.Exit(1)
+	}
+	if !stopped {
+		fmt.Printf("no annotation later than %d found, all %d commands kept (is aof-timestamp-enabled on?)\n", until, commands)
+		if output == "" {
+			return
+		}
+	}
+
+	if output == "" {
+		err = aof.Truncate(fileName, offset)
+		output = fileName
+	} else {
+		err = aof.CopyPrefix(fileName, output, offset)
+	}
+	if err != nil {
+		fmt.Printf("write %s failed: %v\n", output, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Successfully wrote %s: %d commands up to timestamp %d (%d bytes)\n", output, commands, until, offset)
+}
@@ -27,10 +27,29 @@ var commandKeywords = []string{
 
 func main() {
 	addr := flag.String("addr", "127.0.0.1:8080", "redis server address")
+	socket := flag.String("s", "", "server unix socket path (overrides --addr)")
 	timeout := flag.Duration("timeout", 3*time.Second, "dial/command timeout")
+	useTLS := flag.Bool("tls", false, "establish a secure TLS connection")
+	caCert := flag.String("cacert", "", "CA certificate file to verify the server with")
+	cert := flag.String("cert", "", "client certificate to authenticate with")
+	key := flag.String("key", "", "private key file for --cert")
+	sni := flag.String("sni", "", "server name for SNI and hostname verification (default: verify the certificate chain only)")
+	pass := flag.String("pass", "", "password to AUTH with after connecting (requirepass)")
 	flag.Parse()
 
-	cli, err := client.DialPipeline(*addr, *timeout)
+	opts := client.DialOptions{Timeout: *timeout, Password: *pass}
+	if *socket != "" {
+		opts.Network = "unix"
+		*addr = *socket
+	}
+	if *useTLS {
+		tlsConfig, err := client.LoadTLSConfig(*caCert, *cert, *key, *sni)
+		if err != nil {
+			log.Fatalf("load tls config failed: %v", err)
+		}
+		opts.TLSConfig = tlsConfig
+	}
+	cli, err := client.DialPipelineWithOptions(*addr, opts)
 	if err != nil {
 		log.Fatalf("dial %s failed: %v", *addr, err)
 	}
@@ -146,7 +165,7 @@ func saveHistory(editor *liner.State, path string) {
 func printHelp() {
 	fmt.Println("examples:")
 	fmt.Println("  PING")
-	fmt.Println("  AUTH your-password     # if server supports auth")
+	fmt.Println("  AUTH your-password     # when the server sets requirepass (or start with --pass)")
 	fmt.Println("  SET mykey hello")
 	fmt.Println("  GET mykey")
 	fmt.Println("  DEL mykey")
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"MiddlewareSelf/redis/sentinel"
+	"MiddlewareSelf/tcp"
+	"flag"
+	"log"
+)
+
+// sentinel 监控一个主库，主库下线时与其它 sentinel 协商完成自动故障转移。
+//
+//	go run ./cmd/sentinel -config sentinel.conf
+//
+// 客户端通过 SENTINEL get-master-addr-by-name <name> 查询当前主库地址。
+func main() {
+	configFile := flag.String("config", "sentinel.conf", "path to sentinel.conf style config file")
+	flag.Parse()
+
+	cfg, err := sentinel.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Load config failed: %v", err)
+	}
+	s := sentinel.New(*cfg)
+	s.Start()
+	log.Printf("[SENTINEL] myid %s, listening on %s", s.RunID(), cfg.Address)
+	if err := tcp.ListenAndServeWithSignal(&tcp.Config{Address: cfg.Address}, s); err != nil {
+		log.Fatalf("Sentinel stopped: %v", err)
+	}
+}
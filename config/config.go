@@ -0,0 +1,297 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ServerProperties 保存服务端配置项。
+// 字段通过 cfg 标签与 redis.conf 风格配置文件中的配置名一一对应。
+type ServerProperties struct {
+	Bind string `cfg:"bind"`
+	Port int    `cfg:"port"`
+	// MaxClients 为最大连接数，达到后拒绝新连接，0 表示不限制
+	MaxClients int `cfg:"maxclients"`
+	// Timeout 为空闲超时秒数，超过该时间没有发送命令的客户端被断开，0 表示不断开
+	Timeout int `cfg:"timeout"`
+	// TCPKeepAlive 为 TCP keepalive 探测间隔秒数，0 表示关闭
+	TCPKeepAlive int `cfg:"tcp-keepalive"`
+	// IOModel 为 goroutine（每个连接一个 goroutine）或 epoll（Linux 事件循环，适合大量空闲连接）
+	IOModel string `cfg:"io-model"`
+	// EventLoops 为 epoll 模式下事件循环的个数，0 表示使用 CPU 个数
+	EventLoops int `cfg:"event-loops"`
+	// UnixSocket 非空时同时在该路径上监听 Unix socket；UnixSocketPerm 为 socket 文件的八进制权限（如 700）
+	UnixSocket     string   `cfg:"unixsocket"`
+	UnixSocketPerm FileMode `cfg:"unixsocketperm"`
+	// TLSPort 非 0 时在该端口上接受 TLS 连接；port 为 0 时只接受 TLS 连接
+	TLSPort int `cfg:"tls-port"`
+	// TLSCertFile / TLSKeyFile 为本实例的证书与私钥，收到 SIGHUP 时重新读取
+	TLSCertFile string `cfg:"tls-cert-file"`
+	TLSKeyFile  string `cfg:"tls-key-file"`
+	// TLSCACertFile 用于校验客户端证书与主库证书
+	TLSCACertFile string `cfg:"tls-ca-cert-file"`
+	// TLSAuthClients 为 yes（客户端必须出示证书）、no 或 optional
+	TLSAuthClients string `cfg:"tls-auth-clients"`
+	// TLSReplication 为 yes 时副本通过 TLS 连接主库
+	TLSReplication bool `cfg:"tls-replication"`
+	// RequirePass 非空时客户端必须先通过 AUTH 认证才能执行其它命令
+	RequirePass string `cfg:"requirepass"`
+	// ProtoMaxBulkLen 为单个 bulk 参数的最大字节数，超过时按协议错误关闭连接
+	ProtoMaxBulkLen int64 `cfg:"proto-max-bulk-len"`
+	// ClientOutputBufferLimit 为各类客户端的输出缓冲限制，每个类别一行
+	ClientOutputBufferLimit OutputBufferLimits `cfg:"client-output-buffer-limit"`
+
+	AppendOnly  bool   `cfg:"appendonly"`
+	AppendFsync string `cfg:"appendfsync"`
+	// AofLoadTruncated 对应 Redis aof-load-truncated：尾部命令残缺时截断并继续启动。
+	AofLoadTruncated bool `cfg:"aof-load-truncated"`
+	// AofLoadCorrupted 为 yes 时，即使文件中部损坏也强制截断后启动（会丢弃损坏点之后的数据）。
+	AofLoadCorrupted bool `cfg:"aof-load-corrupted"`
+	// AofTimestampEnabled 为 yes 时在 AOF 中写入 #TS 注解，支持按时间点恢复。
+	AofTimestampEnabled bool `cfg:"aof-timestamp-enabled"`
+
+	AutoAofRewritePercentage int   `cfg:"auto-aof-rewrite-percentage"`
+	AutoAofRewriteMinSize    int64 `cfg:"auto-aof-rewrite-min-size"`
+
+	// ReplicaOf 为 "host port"，非空时启动后作为该主库的副本
+	ReplicaOf       string `cfg:"replicaof"`
+	ReplicaReadOnly bool   `cfg:"replica-read-only"`
+	// MasterAuth 为主库的 requirepass，副本连接主库时用于认证
+	MasterAuth string `cfg:"masterauth"`
+	// ReplBacklogSize 为复制 backlog 字节数，断线期间的写入不超过该大小即可部分同步
+	ReplBacklogSize int `cfg:"repl-backlog-size"`
+	// MinReplicasToWrite 大于 0 时，lag 不超过 MinReplicasMaxLag 秒的副本不足该数量则拒绝写入
+	MinReplicasToWrite int `cfg:"min-replicas-to-write"`
+	MinReplicasMaxLag  int `cfg:"min-replicas-max-lag"`
+
+	ClusterEnabled bool `cfg:"cluster-enabled"`
+	// ClusterConfigFile 为集群节点配置（nodes.conf），由服务端自动维护
+	ClusterConfigFile string `cfg:"cluster-config-file"`
+	// ClusterAnnounceIP 为 MOVED 应答中宣布的本节点 IP，为空时使用 bind 地址或 127.0.0.1
+	ClusterAnnounceIP string `cfg:"cluster-announce-ip"`
+	// ClusterNodeTimeout 单位为毫秒，节点超过该时间不可达被标记为 PFAIL
+	ClusterNodeTimeout int `cfg:"cluster-node-timeout"`
+}
+
+// Default 返回与 Redis 默认值对齐的配置。
+func Default() *ServerProperties {
+	return &ServerProperties{
+		Bind:                     "",
+		Port:                     8080,
+		MaxClients:               1000,
+		Timeout:                  0,
+		TCPKeepAlive:             300,
+		IOModel:                  "goroutine",
+		TLSAuthClients:           "yes",
+		ProtoMaxBulkLen:          512 << 20,
+		AppendOnly:               true,
+		AppendFsync:              "everysec",
+		AofLoadTruncated:         true,
+		AofLoadCorrupted:         false,
+		AutoAofRewritePercentage: 100,
+		AutoAofRewriteMinSize:    1 << 20,
+		ReplicaReadOnly:          true,
+		ReplBacklogSize:          1 << 20,
+		MinReplicasMaxLag:        10,
+		ClusterConfigFile:        "nodes.conf",
+		ClusterNodeTimeout:       15000,
+		ClientOutputBufferLimit: OutputBufferLimits{
+			Replica: OutputBufferLimit{Hard: 256 << 20, Soft: 64 << 20, SoftSeconds: 60},
+		},
+	}
+}
+
+// OutputBufferLimit 为一类客户端的输出缓冲限制：待发送的数据超过 Hard 字节，
+// 或持续 SoftSeconds 秒超过 Soft 字节时断开连接。0 表示不限制。
+type OutputBufferLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds int
+}
+
+// OutputBufferLimits 对应 client-output-buffer-limit <class> <hard> <soft> <soft-seconds>，
+// class 为 normal 或 replica（slave）；大小可带 kb / mb / gb 等单位。
+type OutputBufferLimits struct {
+	Normal  OutputBufferLimit
+	Replica OutputBufferLimit
+}
+
+func (l *OutputBufferLimits) Set(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) != 4 {
+		return fmt.Errorf("expect '<class> <hard> <soft> <soft-seconds>', got '%s'", value)
+	}
+	var limit OutputBufferLimit
+	var err error
+	if limit.Hard, err = parseMemory(fields[1]); err != nil {
+		return err
+	}
+	if limit.Soft, err = parseMemory(fields[2]); err != nil {
+		return err
+	}
+	if limit.SoftSeconds, err = strconv.Atoi(fields[3]); err != nil || limit.SoftSeconds < 0 {
+		return fmt.Errorf("invalid soft seconds '%s'", fields[3])
+	}
+	switch strings.ToLower(fields[0]) {
+	case "normal":
+		l.Normal = limit
+	case "replica", "slave":
+		l.Replica = limit
+	case "pubsub":
+		// 未实现发布订阅，接受配置以兼容 Redis 的配置文件
+	default:
+		return fmt.Errorf("unknown client class '%s'", fields[0])
+	}
+	return nil
+}
+
+// FileMode 为八进制表示的文件权限，0 表示不修改。
+type FileMode os.FileMode
+
+func (m *FileMode) Set(value string) error {
+	n, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || n > 0o777 {
+		return fmt.Errorf("invalid permission '%s', expect octal such as 700", value)
+	}
+	*m = FileMode(n)
+	return nil
+}
+
+// parseMemory 解析带单位的大小：k / m / g 为 1000 进制，kb / mb / gb 为 1024 进制（与 Redis 一致）。
+func parseMemory(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+		{"g", 1000 * 1000 * 1000}, {"m", 1000 * 1000}, {"k", 1000}, {"b", 1},
+	}
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, mul = strings.TrimSuffix(lower, u.suffix), u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size '%s'", value)
+	}
+	return n * mul, nil
+}
+
+// Address 返回 bind:port 形式的监听地址，port 为 0 时返回空字符串（不监听普通端口）。
+func (p *ServerProperties) Address() string {
+	if p.Port == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", p.Bind, p.Port)
+}
+
+// TLSAddress 返回 bind:tls-port 形式的 TLS 监听地址，tls-port 为 0 时返回空字符串。
+func (p *ServerProperties) TLSAddress() string {
+	if p.TLSPort == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", p.Bind, p.TLSPort)
+}
+
+// Load 读取配置文件；文件不存在时返回默认配置。
+func Load(path string) (*ServerProperties, error) {
+	props := Default()
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return props, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := Parse(f, props); err != nil {
+		return nil, fmt.Errorf("load config %s: %w", path, err)
+	}
+	return props, nil
+}
+
+// Parse 按 "name value" 的行格式解析配置，# 开头为注释。
+// 未知配置项会报错，避免拼写错误被静默忽略。
+func Parse(reader io.Reader, props *ServerProperties) error {
+	fields := fieldsByName(props)
+	scanner := bufio.NewScanner(reader)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, " ")
+		name = strings.ToLower(name)
+		value = strings.Trim(strings.TrimSpace(value), "\"")
+
+		field, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("line %d: unknown config '%s'", lineNo, name)
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("line %d: invalid value for '%s': %w", lineNo, name, err)
+		}
+	}
+	return scanner.Err()
+}
+
+func fieldsByName(props *ServerProperties) map[string]reflect.Value {
+	v := reflect.ValueOf(props).Elem()
+	t := v.Type()
+	fields := make(map[string]reflect.Value, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := t.Field(i).Tag.Lookup("cfg")
+		if !ok {
+			continue
+		}
+		fields[name] = v.Field(i)
+	}
+	return fields
+}
+
+func setField(field reflect.Value, value string) error {
+	if setter, ok := field.Addr().Interface().(interface{ Set(string) error }); ok {
+		return setter.Set(value)
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		b, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported field kind %s", field.Kind())
+	}
+	return nil
+}
+
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "true", "1":
+		return true, nil
+	case "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("expect yes/no, got '%s'", value)
+}
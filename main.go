@@ -1,36 +1,144 @@
 package main
 
 import (
+	"MiddlewareSelf/config"
 	"MiddlewareSelf/redis/aof"
+	"MiddlewareSelf/redis/cluster"
 	"MiddlewareSelf/redis/database"
+	"MiddlewareSelf/redis/replication"
 	"MiddlewareSelf/tcp"
+	"flag"
 	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 func main() {
 	// 1. 准备配置
-	// 这里配置监听本地的 8080 端口，设置最大连接数和超时时间
+	// 默认读取当前目录下的 redis.conf，文件不存在时使用默认值（监听 8080 端口）
+	configFile := flag.String("config", "redis.conf", "path to redis.conf style config file")
+	flag.Parse()
+
+	props, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Load config failed: %v", err)
+	}
 	cfg := &tcp.Config{
-		Address:    ":8080",
-		MaxConnect: 1000,
-		Timeout:    10 * time.Second,
+		Address:        props.Address(),
+		MaxConnect:     uint32(props.MaxClients),
+		Timeout:        time.Duration(props.Timeout) * time.Second,
+		KeepAlive:      time.Duration(props.TCPKeepAlive) * time.Second,
+		IOModel:        props.IOModel,
+		EventLoops:     props.EventLoops,
+		UnixSocket:     props.UnixSocket,
+		UnixSocketPerm: os.FileMode(props.UnixSocketPerm),
+	}
+	if props.TCPKeepAlive <= 0 {
+		// tcp-keepalive 0 关闭 keepalive，而 tcp.Config 中 0 表示系统默认
+		cfg.KeepAlive = -1
+	}
+	if props.TLSPort != 0 || props.TLSReplication {
+		tlsCtx, err := tcp.NewTLSContext(tcp.TLSOptions{
+			CertFile:    props.TLSCertFile,
+			KeyFile:     props.TLSKeyFile,
+			CAFile:      props.TLSCACertFile,
+			AuthClients: props.TLSAuthClients,
+		})
+		if err != nil {
+			log.Fatalf("Load TLS certificates failed: %v", err)
+		}
+		cfg.TLSAddress = props.TLSAddress()
+		cfg.TLS = tlsCtx
 	}
 
 	// 2. 准备 DB + AOF + Redis Handler
-	db := database.MakeDbs()
-	if err := db.EnableAOF(aof.SyncEverySec); err != nil {
-		log.Fatalf("Enable AOF failed: %v", err)
+	// AOF 中部损坏时拒绝启动，避免带着残缺数据对外服务
+	db, err := database.MakeDbsWithOptions(aof.LoadOptions{
+		LoadTruncated: props.AofLoadTruncated,
+		LoadCorrupted: props.AofLoadCorrupted,
+	})
+	if err != nil {
+		log.Fatalf("Load AOF failed: %v", err)
 	}
-	if err := db.StartAutoRewriteLoop(2*time.Second, 1<<20, 100); err != nil {
-		log.Fatalf("Start auto rewrite loop failed: %v", err)
+	if props.AppendOnly {
+		policy, err := aof.ParseSyncPolicy(props.AppendFsync)
+		if err != nil {
+			log.Fatalf("Invalid appendfsync: %v", err)
+		}
+		if err := db.EnableAOF(policy); err != nil {
+			log.Fatalf("Enable AOF failed: %v", err)
+		}
+		db.AOF().SetTimestampEnabled(props.AofTimestampEnabled)
+		if err := db.StartAutoRewriteLoop(2*time.Second, props.AutoAofRewriteMinSize, float64(props.AutoAofRewritePercentage)); err != nil {
+			log.Fatalf("Start auto rewrite loop failed: %v", err)
+		}
 	}
 	handler := tcp.MakeRedisHandler(db)
+	handler.SetProtoMaxBulkLen(props.ProtoMaxBulkLen)
+	normal, replica := props.ClientOutputBufferLimit.Normal, props.ClientOutputBufferLimit.Replica
+	handler.SetOutputBufferLimit(normal.Hard, normal.Soft, normal.SoftSeconds)
+	handler.SetRequirePass(props.RequirePass)
+	replOpts := replication.Options{
+		Port:               props.Port,
+		ReadOnly:           props.ReplicaReadOnly,
+		BacklogSize:        props.ReplBacklogSize,
+		MinReplicasToWrite: props.MinReplicasToWrite,
+		MinReplicasMaxLag:  props.MinReplicasMaxLag,
+		OutputHardLimit:    replica.Hard,
+		OutputSoftLimit:    replica.Soft,
+		OutputSoftSeconds:  replica.SoftSeconds,
+		MasterAuth:         props.MasterAuth,
+	}
+	if props.TLSReplication {
+		// 通过 TLS 连接主库，并向主库宣布本实例的 TLS 端口
+		replOpts.TLSConfig = cfg.TLS.ClientConfig()
+		if props.TLSPort != 0 {
+			replOpts.Port = props.TLSPort
+		}
+	}
+	repl := replication.NewServer(db, replOpts)
+	handler.SetReplication(repl)
+	if props.ReplicaOf != "" {
+		fields := strings.Fields(props.ReplicaOf)
+		if len(fields) != 2 {
+			log.Fatalf("Invalid replicaof: %q", props.ReplicaOf)
+		}
+		port, err := strconv.Atoi(fields[1])
+		if err != nil {
+			log.Fatalf("Invalid replicaof port: %v", err)
+		}
+		repl.ReplicaOf(fields[0], port)
+	}
+	if props.ClusterEnabled {
+		announceIP := props.ClusterAnnounceIP
+		if announceIP == "" && props.Bind != "0.0.0.0" {
+			announceIP = props.Bind
+		}
+		c, err := cluster.New(db, cluster.Options{
+			ConfigFile:  props.ClusterConfigFile,
+			AnnounceIP:  announceIP,
+			Port:        props.Port,
+			NodeTimeout: time.Duration(props.ClusterNodeTimeout) * time.Millisecond,
+		})
+		if err != nil {
+			log.Fatalf("Enable cluster failed: %v", err)
+		}
+		// 集群总线监听 port+10000
+		busListener, err := net.Listen("tcp", net.JoinHostPort(props.Bind, strconv.Itoa(props.Port+10000)))
+		if err != nil {
+			log.Fatalf("Listen cluster bus failed: %v", err)
+		}
+		c.ServeBus(busListener)
+		handler.SetCluster(c)
+	}
 
 	// 3. 启动服务
 	// 这个函数会阻塞在这里，直到收到退出信号（比如 Ctrl+C）或者发生严重错误
 	log.Println("Server is preparing to start...")
-	err := tcp.ListenAndServeWithSignal(cfg, handler)
+	err = tcp.ListenAndServeWithSignal(cfg, handler)
 	if err != nil {
 		log.Fatalf("Server start failed: %v", err)
 	}
@@ -2,11 +2,12 @@ package aof
 
 import (
 	"MiddlewareSelf/redis/resp"
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -16,8 +17,14 @@ const (
 	AofName = "appendonly.aof"
 	// RewriteTempName 为重写阶段的临时文件。
 	RewriteTempName = "temp.aof"
+
+	// flushThreshold 为 everysec/no 策略下缓冲区主动写入内核的阈值（与原 bufio 默认大小一致）。
+	flushThreshold = 4096
 )
 
+// ErrClosed 表示 AOF 已关闭，之后的追加都会被拒绝。
+var ErrClosed = errors.New("aof is closed")
+
 type SyncPolicy int
 
 const (
@@ -26,17 +33,43 @@ const (
 	SyncNo                         // 由操作系统决定
 )
 
-type AOF struct {
-	File        *os.File
-	fileName    string
-	bufWriter   *bufio.Writer
-	stopChan    chan struct{}
-	syncPolicy  SyncPolicy
+// ParseSyncPolicy 解析 appendfsync 配置值（always / everysec / no）。
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "everysec":
+		return SyncEverySec, nil
+	case "no":
+		return SyncNo, nil
+	}
+	return SyncEverySec, fmt.Errorf("unknown sync policy '%s'", s)
+}
 
-	mu            sync.Mutex
+type AOF struct {
+	File     *os.File
+	fileName string
+	// buf 为尚未写入文件的命令（对应 Redis aof_buf）。
+	// 写失败时保留在缓冲区等待重试，不会像 bufio.Writer 那样一次出错后永久不可用。
+	buf        []byte
+	fileSize   int64
+	stopChan   chan struct{}
+	loopWG     sync.WaitGroup
+	syncPolicy SyncPolicy
+
+	mu sync.Mutex
+	// closed 在 Close 开始时置位（aof.mu 内），之后的追加返回 ErrClosed
+	closed bool
+	// syncMu 串行化 fsync 与文件关闭/替换，组提交在 aof.mu 之外 fsync 时持有它
+	syncMu        sync.Mutex
 	rewriting     bool
 	rewriteBuffer [][]byte
 
+	// statusMu 保护最近一次写入/重写的结果，读取时不需要等待可能很慢的 aof.mu
+	statusMu       sync.RWMutex
+	lastWriteErr   error
+	lastRewriteErr error
+
 	// snapshotProvider 由上层（DB）注入，用于提供“fork 时刻”的只读快照命令。
 	snapshotProvider SnapshotProvider
 
@@ -46,6 +79,22 @@ type AOF struct {
 
 	closeAutoOnce sync.Once
 	closeSyncOnce sync.Once
+
+	// timestampEnabled 对应 aof-timestamp-enabled：每秒最多写一条 #TS 注解，用于时间点恢复。
+	timestampEnabled bool
+	lastTimestamp    int64
+
+	// selectedDB 为文件流当前所在的 DB，Enqueue 切换 DB 时先写入 SELECT；-1 表示未知
+	selectedDB int
+
+	// 组提交状态（仅 SyncAlways 使用），见 group_commit.go
+	pendingBatch *commitBatch
+	commitCh     chan struct{}
+	fsyncCount   int64
+	// fileGen 在重写换入新文件前对旧文件 fsync 成功时递增，此前追加的数据都已在新文件中落盘
+	fileGen int64
+	// rewriteAbortErr 为重写期间提交失败的错误：快照可能包含被回滚的写入，本次重写必须放弃
+	rewriteAbortErr error
 }
 
 func NewAOF(policy SyncPolicy) (*AOF, error) {
@@ -62,25 +111,36 @@ func NewAOFWithFile(policy SyncPolicy, fileName string) (*AOF, error) {
 		}
 	}
 	aof := &AOF{
-		File:        f,
-		fileName:    fileName,
-		stopChan:    make(chan struct{}),
-		bufWriter:   bufio.NewWriter(f),
-		syncPolicy:  policy,
-		rewriting:   false,
+		File:            f,
+		fileName:        fileName,
+		stopChan:        make(chan struct{}),
+		syncPolicy:      policy,
+		rewriting:       false,
 		autoRewriteStop: make(chan struct{}),
+		commitCh:        make(chan struct{}, 1),
+		selectedDB:      -1,
 	}
 	if fi, statErr := f.Stat(); statErr == nil {
 		aof.lastRewriteSize = fi.Size()
+		aof.fileSize = fi.Size()
 	}
-	if policy == SyncEverySec {
-		go aof.syncLoop()
+	aof.loopWG.Add(1)
+	go aof.syncLoop()
+	if policy == SyncAlways {
+		aof.loopWG.Add(1)
+		go aof.commitLoop()
 	}
 	log.Printf("[AOF] opened file=%s policy=%d", fileName, policy)
 	return aof, nil
 }
 
+// syncLoop 每秒执行一次后台落盘：
+// - everysec：flush + fsync；
+// - no：只 flush，fsync 交给操作系统；
+// - always：正常情况下由组提交负责，只有处于写错误状态时才在这里重试。
+// 写入恢复后自动清除错误状态，上层随之恢复接受写命令。
 func (aof *AOF) syncLoop() {
+	defer aof.loopWG.Done()
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
@@ -89,14 +149,95 @@ func (aof *AOF) syncLoop() {
 		case <-aof.stopChan:
 			return
 		case <-ticker.C:
+			if aof.syncPolicy == SyncAlways && aof.LastWriteErr() == nil {
+				continue
+			}
 			aof.mu.Lock()
-			_ = aof.bufWriter.Flush()
-			_ = aof.File.Sync()
+			err := aof.flushLocked()
+			if err == nil && aof.syncPolicy != SyncNo {
+				err = aof.File.Sync()
+			}
+			aof.setWriteStatus(err)
 			aof.mu.Unlock()
 		}
 	}
 }
 
+// flushLocked 把 aof.buf 写入文件，调用方需持有 aof.mu。
+// 写失败时缓冲区保留等待重试；发生短写时先截断回写入前的长度，避免文件尾部留下半条命令。
+func (aof *AOF) flushLocked() error {
+	if len(aof.buf) == 0 {
+		return nil
+	}
+	n, err := aof.File.Write(aof.buf)
+	if err != nil {
+		if n > 0 {
+			if truncErr := aof.File.Truncate(aof.fileSize); truncErr != nil {
+				// 截断失败只能接受已写入的前缀，剩余部分下次重试
+				aof.fileSize += int64(n)
+				aof.buf = append(aof.buf[:0], aof.buf[n:]...)
+			}
+		}
+		return err
+	}
+	aof.fileSize += int64(n)
+	aof.buf = aof.buf[:0]
+	return nil
+}
+
+// setWriteStatus 记录最近一次写入/fsync 的结果，并在状态切换时打日志。
+func (aof *AOF) setWriteStatus(err error) {
+	aof.statusMu.Lock()
+	defer aof.statusMu.Unlock()
+	if err != nil {
+		if aof.lastWriteErr == nil {
+			log.Printf("[AOF] write error, rejecting write commands until recovered: %v", err)
+		}
+		aof.lastWriteErr = err
+		return
+	}
+	if aof.lastWriteErr != nil {
+		log.Printf("[AOF] write recovered, accepting write commands again")
+	}
+	aof.lastWriteErr = nil
+}
+
+// LastWriteErr 返回最近一次写入或 fsync 的错误，正常时为 nil。
+// 非 nil 期间上层应拒绝写命令（MISCONF）。
+func (aof *AOF) LastWriteErr() error {
+	aof.statusMu.RLock()
+	defer aof.statusMu.RUnlock()
+	return aof.lastWriteErr
+}
+
+// Status 是 INFO persistence 所需的 AOF 状态。
+type Status struct {
+	Rewriting      bool
+	LastWriteErr   error
+	LastRewriteErr error
+	CurrentSize    int64
+	BaseSize       int64
+	BufferLength   int
+}
+
+// Status 返回当前 AOF 状态快照。
+func (aof *AOF) Status() Status {
+	aof.mu.Lock()
+	st := Status{
+		Rewriting:    aof.rewriting,
+		CurrentSize:  aof.fileSize + int64(len(aof.buf)),
+		BaseSize:     aof.lastRewriteSize,
+		BufferLength: len(aof.buf),
+	}
+	aof.mu.Unlock()
+
+	aof.statusMu.RLock()
+	st.LastWriteErr = aof.lastWriteErr
+	st.LastRewriteErr = aof.lastRewriteErr
+	aof.statusMu.RUnlock()
+	return st
+}
+
 // SetSnapshotProvider 注入重写快照提供器。
 func (aof *AOF) SetSnapshotProvider(provider SnapshotProvider) {
 	aof.mu.Lock()
@@ -104,64 +245,155 @@ func (aof *AOF) SetSnapshotProvider(provider SnapshotProvider) {
 	aof.snapshotProvider = provider
 }
 
+// SetTimestampEnabled 开关时间戳注解。
+func (aof *AOF) SetTimestampEnabled(enabled bool) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.timestampEnabled = enabled
+}
+
 // AppendCommand 以 RESP Array 格式将命令写入 AOF。
 // 如果重写正在进行，会把同一份命令追加到 rewrite buffer（模拟 COW 增量收集）。
+// SyncAlways 下通过组提交等待本条命令 fsync 完成后才返回。
 func (aof *AOF) AppendCommand(args [][]byte) error {
 	if len(args) == 0 {
 		return fmt.Errorf("empty command args")
 	}
 
-	encoded := encodeRESPCommand(args)
-
 	aof.mu.Lock()
-	defer aof.mu.Unlock()
-
-	if _, err := aof.bufWriter.Write(encoded); err != nil {
+	batch, err := aof.appendLocked(encodeRESPCommand(args))
+	aof.mu.Unlock()
+	if err != nil {
 		return err
 	}
+	return Commit{batch: batch}.Wait()
+}
 
-	switch aof.syncPolicy {
-	case SyncAlways:
-		if err := aof.bufWriter.Flush(); err != nil {
-			return err
+// Commit 表示一次追加的落盘结果。
+type Commit struct {
+	batch *commitBatch
+}
+
+// Wait 阻塞到数据按 SyncPolicy 落盘：SyncAlways 等待组提交 fsync 完成，其它策略立即返回。
+func (c Commit) Wait() error {
+	if c.batch == nil {
+		return nil
+	}
+	return c.batch.wait()
+}
+
+// Poll 不阻塞地返回提交是否已有结果及其错误；非 SyncAlways 策略下总是已完成。
+func (c Commit) Poll() (bool, error) {
+	if c.batch == nil {
+		return true, nil
+	}
+	return c.batch.result()
+}
+
+// Enqueue 把一次命令执行产生的效果命令写入缓冲区，不等待落盘。
+// dbIndex 与文件流当前 DB 不同时先补一条 SELECT，保证重放时落到同一个 DB。
+// 调用方可以在自己的临界区内 Enqueue 以保证顺序，再在临界区外 Wait，不影响组提交的合并效果。
+//
+// after 为调用方上一次追加的 Commit：它所在的批次已提交失败时拒绝本次追加并返回该错误。
+// 提交失败时本批之后已加入的写入也一并失败（见 failBatchLocked），
+// 因此建立在失败写入之上的修改永远不会单独落盘，调用方可以按追加的逆序回滚。
+func (aof *AOF) Enqueue(after Commit, dbIndex int, cmds [][][]byte) (Commit, error) {
+	if len(cmds) == 0 {
+		return Commit{}, nil
+	}
+	encoded := make([]byte, 0, 64*len(cmds))
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if aof.closed {
+		return Commit{}, ErrClosed
+	}
+	if done, err := after.Poll(); done && err != nil {
+		return Commit{}, err
+	}
+	if dbIndex != aof.selectedDB {
+		encoded = append(encoded, encodeRESPCommand([][]byte{[]byte("SELECT"), []byte(strconv.Itoa(dbIndex))})...)
+		aof.selectedDB = dbIndex
+	}
+	for _, args := range cmds {
+		if len(args) == 0 {
+			return Commit{}, fmt.Errorf("empty command args")
 		}
-		if err := aof.File.Sync(); err != nil {
-			return err
+		encoded = append(encoded, encodeRESPCommand(args)...)
+	}
+	batch, err := aof.appendLocked(encoded)
+	return Commit{batch: batch}, err
+}
+
+// appendLocked 在 aof.mu 内写入缓冲区；SyncAlways 时返回需要等待的提交批次。
+// Close 之后返回 ErrClosed：提交协程已退出，加入的批次永远不会被提交。
+func (aof *AOF) appendLocked(encoded []byte) (*commitBatch, error) {
+	if aof.closed {
+		return nil, ErrClosed
+	}
+	// 进入新的一秒时在命令前补一条时间戳注解，与命令一起写入并进入 rewrite buffer
+	if aof.timestampEnabled {
+		if now := time.Now().Unix(); now > aof.lastTimestamp {
+			aof.lastTimestamp = now
+			encoded = append(encodeTimestamp(now), encoded...)
 		}
-	case SyncNo, SyncEverySec:
-		// SyncNo: 依赖 OS；SyncEverySec: 后台 ticker 负责 flush+sync。
 	}
 
+	aof.buf = append(aof.buf, encoded...)
+
 	if aof.rewriting {
 		cmdCopy := make([]byte, len(encoded))
 		copy(cmdCopy, encoded)
 		aof.rewriteBuffer = append(aof.rewriteBuffer, cmdCopy)
 	}
 
-	return nil
+	switch aof.syncPolicy {
+	case SyncAlways:
+		return aof.joinBatchLocked(), nil
+	case SyncNo, SyncEverySec:
+		// SyncNo: 依赖 OS；SyncEverySec: 后台 ticker 负责 flush+sync。
+		// 缓冲区过大时先写入内核，失败由后台循环重试。
+		if len(aof.buf) >= flushThreshold {
+			aof.setWriteStatus(aof.flushLocked())
+		}
+	}
+	return nil, nil
 }
 
 func encodeRESPCommand(args [][]byte) []byte {
 	return resp.MakeArrayReply(args).ToBytes()
 }
 
+func encodeTimestamp(unix int64) []byte {
+	return []byte(TimestampPrefix + strconv.FormatInt(unix, 10) + resp.CRLF)
+}
+
+// Close 停止后台协程并关闭文件。此后的追加返回 ErrClosed，
+// 已加入批次的写入由这里最后提交一次，不会被挂起或静默丢弃。
 func (aof *AOF) Close() {
+	aof.mu.Lock()
+	aof.closed = true
+	aof.mu.Unlock()
+
 	aof.closeAutoOnce.Do(func() {
 		close(aof.autoRewriteStop)
 	})
 	aof.autoRewriteWG.Wait()
 
-	if aof.syncPolicy == SyncEverySec {
-		aof.closeSyncOnce.Do(func() {
-			close(aof.stopChan)
-		})
-		time.Sleep(100 * time.Millisecond)
+	aof.closeSyncOnce.Do(func() {
+		close(aof.stopChan)
+	})
+	aof.loopWG.Wait()
+	if aof.syncPolicy == SyncAlways {
+		// 提交协程已退出，closed 置位后也不会再有新批次，最后一批由这里落盘并唤醒等待者
+		aof.commitPending()
 	}
 	aof.mu.Lock()
 	defer aof.mu.Unlock()
+	aof.syncMu.Lock()
+	defer aof.syncMu.Unlock()
 
-	if aof.bufWriter != nil {
-		_ = aof.bufWriter.Flush()
+	if err := aof.flushLocked(); err != nil {
+		log.Printf("[AOF] flush on close failed, %d bytes lost: %v", len(aof.buf), err)
 	}
 	if aof.File != nil {
 		_ = aof.File.Sync()
@@ -216,17 +448,7 @@ func (aof *AOF) StartAutoRewriteLoop(interval time.Duration, minSizeBytes int64,
 
 func (aof *AOF) shouldAutoRewrite(minSizeBytes int64, growthPercent float64) (currentSize int64, baseline int64, should bool, err error) {
 	aof.mu.Lock()
-	if err = aof.bufWriter.Flush(); err != nil {
-		aof.mu.Unlock()
-		return
-	}
-	fi, statErr := aof.File.Stat()
-	if statErr != nil {
-		aof.mu.Unlock()
-		err = statErr
-		return
-	}
-	currentSize = fi.Size()
+	currentSize = aof.fileSize + int64(len(aof.buf))
 	baseline = aof.lastRewriteSize
 	rewriting := aof.rewriting
 	aof.mu.Unlock()
@@ -248,7 +470,9 @@ func (aof *AOF) shouldAutoRewrite(minSizeBytes int64, growthPercent float64) (cu
 
 func IsWriteCmd(cmd string) bool {
 	switch cmd {
-	case "SET", "DEL", "HSET", "LPUSH", "SADD", "EXPIRE", "SETWITHTTL":
+	case "SET", "DEL", "HSET", "LPUSH", "SADD", "EXPIRE", "SETWITHTTL",
+		"PEXPIRE", "EXPIREAT", "PEXPIREAT", "INCRBYFLOAT", "FLUSHDB", "FLUSHALL",
+		"RESTORE", "RESTORE-ASKING", "MIGRATE":
 		return true
 	}
 	return false
@@ -0,0 +1,136 @@
+package aof
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// 组提交（group commit）：SyncAlways 下并发写入者不再各自 flush+fsync。
+//
+// 流程：
+// 1) AppendCommand 在 aof.mu 内把编码后的命令写入 buf，并加入当前待提交批次 pendingBatch；
+// 2) 唤醒唯一的 commitLoop 协程，调用方阻塞在批次的 done 上；
+// 3) commitLoop 取走整批，flush 后释放 aof.mu，在 syncMu 内对文件 fsync，
+//    fsync 期间新的写入者可以继续进入下一批；
+// 4) fsync 完成后关闭 done，本批所有写入者同时返回，fsync 成本被整批分摊。
+//
+// 写入或 fsync 失败时本批数据被丢弃（文件截回本批之前的长度），fsync 期间已加入下一批的写入
+// 建立在失败的写入之上，一并失败。上层据此回滚内存修改，AOF 与内存保持一致。
+
+// commitBatch 是一次 flush+fsync 覆盖的一组写入。
+type commitBatch struct {
+	done chan struct{}
+	err  error
+}
+
+func newCommitBatch() *commitBatch {
+	return &commitBatch{done: make(chan struct{})}
+}
+
+// wait 阻塞到本批数据落盘，返回本批的提交结果。
+func (b *commitBatch) wait() error {
+	<-b.done
+	return b.err
+}
+
+// result 不阻塞地返回本批是否已有提交结果及其错误。
+func (b *commitBatch) result() (bool, error) {
+	select {
+	case <-b.done:
+		return true, b.err
+	default:
+		return false, nil
+	}
+}
+
+// joinBatchLocked 把当前写入加入待提交批次并唤醒提交协程，调用方需持有 aof.mu。
+func (aof *AOF) joinBatchLocked() *commitBatch {
+	if aof.pendingBatch == nil {
+		aof.pendingBatch = newCommitBatch()
+	}
+	select {
+	case aof.commitCh <- struct{}{}:
+	default:
+		// 提交协程已被唤醒，本次写入会被同一批或下一批带走
+	}
+	return aof.pendingBatch
+}
+
+func (aof *AOF) commitLoop() {
+	defer aof.loopWG.Done()
+	for {
+		select {
+		case <-aof.stopChan:
+			return
+		case <-aof.commitCh:
+			aof.commitPending()
+		}
+	}
+}
+
+// commitPending 提交当前批次：持锁 flush，释放 aof.mu 后再 fsync。
+func (aof *AOF) commitPending() {
+	aof.mu.Lock()
+	batch := aof.pendingBatch
+	if batch == nil {
+		aof.mu.Unlock()
+		return
+	}
+	aof.pendingBatch = nil
+	start := aof.fileSize
+	if err := aof.flushLocked(); err != nil {
+		aof.failBatchLocked(batch, start, err)
+		aof.mu.Unlock()
+		return
+	}
+	file, gen := aof.File, aof.fileGen
+	// 先拿 syncMu 再放 aof.mu，保证 rewrite/close 不会在 fsync 途中关闭文件
+	aof.syncMu.Lock()
+	aof.mu.Unlock()
+
+	err := file.Sync()
+	atomic.AddInt64(&aof.fsyncCount, 1)
+	aof.syncMu.Unlock()
+
+	if err != nil {
+		aof.mu.Lock()
+		if gen == aof.fileGen {
+			aof.failBatchLocked(batch, start, err)
+			aof.mu.Unlock()
+			return
+		}
+		aof.mu.Unlock()
+		// 重新拿锁之前重写已换入新文件，本批数据已随新文件落盘
+		err = nil
+	}
+	aof.setWriteStatus(err)
+	close(batch.done)
+}
+
+// failBatchLocked 以 err 结束提交失败的批次，调用方需持有 aof.mu。
+// 文件截回本批写入前的长度 start；缓冲区中的下一批建立在本批之上，一并丢弃并失败；
+// 进行中的重写的快照可能包含这些写入，同样放弃。
+func (aof *AOF) failBatchLocked(batch *commitBatch, start int64, err error) {
+	if aof.fileSize > start {
+		if truncErr := aof.File.Truncate(start); truncErr != nil {
+			log.Printf("[AOF] truncate failed batch failed: %v", truncErr)
+		} else {
+			aof.fileSize = start
+		}
+	}
+	aof.buf = aof.buf[:0]
+	// 截掉的部分可能包含 SELECT，下一条命令重新选择 DB
+	aof.selectedDB = -1
+	if aof.rewriting {
+		aof.rewriteAbortErr = err
+	}
+	aof.setWriteStatus(err)
+
+	for _, b := range []*commitBatch{batch, aof.pendingBatch} {
+		if b != nil {
+			b.err = err
+			close(b.done)
+		}
+	}
+	aof.pendingBatch = nil
+}
@@ -0,0 +1,213 @@
+package aof
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGroupCommitConcurrentAppend(t *testing.T) {
+	aofPath := filepath.Join(t.TempDir(), AofName)
+	a, err := NewAOFWithFile(SyncAlways, aofPath)
+	if err != nil {
+		t.Fatalf("NewAOFWithFile failed: %v", err)
+	}
+
+	const writers, perWriter = 50, 20
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				key := []byte(fmt.Sprintf("k-%d-%d", w, i))
+				if err := a.AppendCommand([][]byte{[]byte("SET"), key, []byte("v")}); err != nil {
+					t.Errorf("append failed: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// AppendCommand 返回即已落盘：不 Close 也能读到全部命令
+	if cmds := readAOFCommands(t, aofPath); len(cmds) != writers*perWriter {
+		t.Fatalf("expected %d commands on disk, got %d", writers*perWriter, len(cmds))
+	}
+	fsyncs := atomic.LoadInt64(&a.fsyncCount)
+	if fsyncs == 0 || fsyncs > writers*perWriter {
+		t.Fatalf("unexpected fsync count %d for %d writes", fsyncs, writers*perWriter)
+	}
+	t.Logf("%d writes committed with %d fsyncs", writers*perWriter, fsyncs)
+	a.Close()
+}
+
+// Close 之后的追加立即返回 ErrClosed，不会加入永远不被提交的批次而挂起。
+func TestAppendAfterClose(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncEverySec} {
+		a, err := NewAOFWithFile(policy, filepath.Join(t.TempDir(), AofName))
+		if err != nil {
+			t.Fatalf("NewAOFWithFile failed: %v", err)
+		}
+		a.Close()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- a.AppendCommand([][]byte{[]byte("SET"), []byte("k"), []byte("v")})
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrClosed) {
+				t.Fatalf("policy %d: AppendCommand after Close = %v", policy, err)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("policy %d: AppendCommand after Close hangs", policy)
+		}
+		if _, err := a.Enqueue(Commit{}, 0, [][][]byte{{[]byte("DEL"), []byte("k")}}); !errors.Is(err, ErrClosed) {
+			t.Fatalf("policy %d: Enqueue after Close = %v", policy, err)
+		}
+	}
+}
+
+// BenchmarkAppendCommand50Clients 对比三种 SyncPolicy 在 50 个并发写入者下的吞吐。
+//
+//	go test ./redis/aof -run ^$ -bench AppendCommand50Clients
+func BenchmarkAppendCommand50Clients(b *testing.B) {
+	policies := []struct {
+		name   string
+		policy SyncPolicy
+	}{
+		{"always", SyncAlways},
+		{"everysec", SyncEverySec},
+		{"no", SyncNo},
+	}
+	for _, p := range policies {
+		b.Run(p.name, func(b *testing.B) {
+			a, err := NewAOFWithFile(p.policy, filepath.Join(b.TempDir(), AofName))
+			if err != nil {
+				b.Fatalf("NewAOFWithFile failed: %v", err)
+			}
+			defer a.Close()
+
+			args := [][]byte{[]byte("SET"), []byte("key"), []byte("value")}
+			const clients = 50
+			var next int64
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for c := 0; c < clients; c++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for atomic.AddInt64(&next, 1) <= int64(b.N) {
+						if err := a.AppendCommand(args); err != nil {
+							b.Errorf("append failed: %v", err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			b.StopTimer()
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "writes/s")
+		})
+	}
+}
+
+func TestWriteErrorRecovery(t *testing.T) {
+	aofPath := filepath.Join(t.TempDir(), AofName)
+	a, err := NewAOFWithFile(SyncAlways, aofPath)
+	if err != nil {
+		t.Fatalf("NewAOFWithFile failed: %v", err)
+	}
+	defer a.Close()
+
+	// 用只读句柄模拟磁盘不可写
+	a.mu.Lock()
+	writable := a.File
+	readonly, err := os.Open(aofPath)
+	if err != nil {
+		a.mu.Unlock()
+		t.Fatalf("open readonly failed: %v", err)
+	}
+	a.File = readonly
+	a.mu.Unlock()
+
+	if err := a.AppendCommand([][]byte{[]byte("SET"), []byte("k"), []byte("v")}); err == nil {
+		t.Fatal("append should fail on a readonly file")
+	}
+	if a.LastWriteErr() == nil || a.Status().LastWriteErr == nil {
+		t.Fatal("write error should be recorded")
+	}
+
+	// 磁盘恢复后由后台循环清除错误；提交失败的命令已被丢弃，不会在恢复后补写
+	a.mu.Lock()
+	a.File = writable
+	a.mu.Unlock()
+	_ = readonly.Close()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for a.LastWriteErr() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("write error was not cleared after the disk recovered")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := a.AppendCommand([][]byte{[]byte("SET"), []byte("k"), []byte("v2")}); err != nil {
+		t.Fatalf("append after recovery failed: %v", err)
+	}
+	cmds := readAOFCommands(t, aofPath)
+	if len(cmds) != 1 || string(cmds[0][2]) != "v2" {
+		t.Fatalf("only the command appended after recovery should be on disk, got %q", cmds)
+	}
+}
+
+// 提交失败的批次以及 fsync 期间已加入下一批的写入都失败，
+// 之后以失败批次为 after 的追加被拒绝，建立在失败写入之上的修改不会单独落盘。
+func TestEnqueueAfterFailedCommit(t *testing.T) {
+	aofPath := filepath.Join(t.TempDir(), AofName)
+	a, err := NewAOFWithFile(SyncAlways, aofPath)
+	if err != nil {
+		t.Fatalf("NewAOFWithFile failed: %v", err)
+	}
+	defer a.Close()
+	set := [][][]byte{{[]byte("SET"), []byte("k"), []byte("v")}}
+	ok, err := a.Enqueue(Commit{}, 0, set)
+	if err != nil {
+		t.Fatalf("enqueue failed: %v", err)
+	}
+	if err := ok.Wait(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+
+	a.mu.Lock()
+	readonly, err := os.Open(aofPath)
+	if err != nil {
+		a.mu.Unlock()
+		t.Fatalf("open readonly failed: %v", err)
+	}
+	defer readonly.Close()
+	a.File = readonly
+	a.mu.Unlock()
+
+	failed, err := a.Enqueue(ok, 0, set)
+	if err != nil {
+		t.Fatalf("enqueue failed: %v", err)
+	}
+	if err := failed.Wait(); err == nil {
+		t.Fatal("commit should fail on a readonly file")
+	}
+	if _, err := a.Enqueue(failed, 0, set); err == nil {
+		t.Fatal("enqueue after a failed commit should be rejected")
+	}
+	if done, err := failed.Poll(); !done || err == nil {
+		t.Fatalf("Poll = %v, %v", done, err)
+	}
+	if cmds := readAOFCommands(t, aofPath); len(cmds) != 2 {
+		t.Fatalf("failed batch should be discarded, got %d commands", len(cmds))
+	}
+}
@@ -0,0 +1,352 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+// TimestampPrefix 是时间戳注解行的前缀，完整格式为 "#TS:<unix 秒>\r\n"。
+const TimestampPrefix = "#TS:"
+
+const (
+	// maxCommandArgs 限制单条命令的参数个数，避免损坏的长度字段导致超大内存分配。
+	maxCommandArgs = 1024 * 1024
+	// maxBulkLen 为单个参数的上限，与 proto-max-bulk-len 默认值一致，超过视为损坏。
+	maxBulkLen = 512 * 1024 * 1024
+	// bulkChunk 为读取参数内容的分块大小：按实际读到的数据扩容，长度字段再大也不会一次分配
+	bulkChunk = 64 * 1024
+)
+
+// ErrTruncated 表示文件末尾存在不完整的命令（通常是崩溃时的残缺写入）。
+var ErrTruncated = errors.New("aof: truncated command at end of file")
+
+// CorruptionError 描述 AOF 中第一处无法解析的位置。
+type CorruptionError struct {
+	Offset int64
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("aof: corrupted command at offset %d: %s", e.Offset, e.Reason)
+}
+
+// LoadOptions 控制加载时遇到坏数据的处理方式。
+type LoadOptions struct {
+	// LoadTruncated 对应 aof-load-truncated：尾部命令残缺时截断到最后一条完整命令并继续。
+	LoadTruncated bool
+	// LoadCorrupted 为 true 时，文件中部损坏也截断后继续（丢弃损坏点之后的全部数据）。
+	LoadCorrupted bool
+	// UntilTimestamp 非 0 时做时间点恢复：遇到晚于该时间（unix 秒）的 #TS 注解即停止回放，
+	// 并把文件截断到该注解处；截断前的完整文件另存为 <file>.pitr-<unix>。
+	UntilTimestamp int64
+}
+
+// DefaultLoadOptions 与 Redis 默认行为一致：容忍尾部残缺，拒绝中部损坏。
+var DefaultLoadOptions = LoadOptions{LoadTruncated: true}
+
+// LoadResult 是一次扫描/加载的统计结果。
+type LoadResult struct {
+	Size        int64 // 文件原始大小
+	ValidOffset int64 // 最后一条完整命令结束处的偏移
+	Commands    int   // 成功解析的命令条数
+	Truncated   bool  // 是否因尾部残缺截断了文件
+	Corrupted   bool  // 是否因中部损坏截断了文件
+	Stopped     bool  // 是否因时间点恢复提前停止
+}
+
+// CommandReader 按 RESP Array 逐条读取命令，并记录已消费的字节偏移。
+// AOF 文件与主从复制流使用同一种格式，二者共用这个读取器。
+type CommandReader struct {
+	reader *bufio.Reader
+	pos    int64
+	// until 非 0 时遇到更晚的时间戳注解即停止
+	until int64
+}
+
+// errReachedTimestamp 表示读到了晚于目标时间的注解，此时 pos 回退到该注解行的起始偏移。
+var errReachedTimestamp = errors.New("aof: reached target timestamp")
+
+func NewCommandReader(r io.Reader) *CommandReader {
+	return &CommandReader{reader: bufio.NewReader(r)}
+}
+
+// Offset 返回已消费的字节数（最后一条完整命令的结束位置）。
+func (r *CommandReader) Offset() int64 {
+	return r.pos
+}
+
+// ReadCommand 读取下一条完整命令。
+// 返回 io.EOF 表示恰好在命令边界结束；ErrTruncated 表示读到一半遇到 EOF；
+// *CorruptionError 表示内容格式非法。
+func (r *CommandReader) ReadCommand() ([][]byte, error) {
+	start := r.pos
+	corrupt := func(reason string) error {
+		return &CorruptionError{Offset: start, Reason: reason}
+	}
+
+	line, err := r.readLine()
+	if err != nil {
+		if err == io.EOF && len(line) == 0 {
+			return nil, io.EOF
+		}
+		return nil, r.wrapReadErr(err)
+	}
+	// 注解行（如 #TS:1700000000）不是命令，解析后跳过
+	for len(line) > 0 && line[0] == '#' {
+		if bytes.HasPrefix(line, []byte(TimestampPrefix)) {
+			ts, err := strconv.ParseInt(string(line[len(TimestampPrefix):]), 10, 64)
+			if err != nil {
+				return nil, corrupt(fmt.Sprintf("invalid timestamp annotation %q", line))
+			}
+			if r.until > 0 && ts > r.until {
+				r.pos = start
+				return nil, errReachedTimestamp
+			}
+		}
+		start = r.pos
+		line, err = r.readLine()
+		if err != nil {
+			if err == io.EOF && len(line) == 0 {
+				return nil, io.EOF
+			}
+			return nil, r.wrapReadErr(err)
+		}
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, corrupt(fmt.Sprintf("expect '*', got %q", firstByte(line)))
+	}
+	n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || n < 1 || n > maxCommandArgs {
+		return nil, corrupt(fmt.Sprintf("invalid array length %q", line[1:]))
+	}
+
+	args := make([][]byte, 0, n)
+	for i := int64(0); i < n; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, r.wrapReadErr(err)
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, corrupt(fmt.Sprintf("arg #%d: expect '$', got %q", i+1, firstByte(line)))
+		}
+		size, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, corrupt(fmt.Sprintf("arg #%d: invalid bulk length %q", i+1, line[1:]))
+		}
+		body, err := r.readBulk(int(size))
+		if err != nil {
+			return nil, r.wrapReadErr(err)
+		}
+		var crlf [2]byte
+		read, err := io.ReadFull(r.reader, crlf[:])
+		r.pos += int64(read)
+		if err != nil {
+			return nil, r.wrapReadErr(err)
+		}
+		if crlf != [2]byte{'\r', '\n'} {
+			return nil, corrupt(fmt.Sprintf("arg #%d: bulk missing CRLF", i+1))
+		}
+		args = append(args, body)
+	}
+	return args, nil
+}
+
+// readBulk 分块读取 size 字节的参数内容。文件在中途结束时（尾部残缺）只分配了实际读到的部分。
+func (r *CommandReader) readBulk(size int) ([]byte, error) {
+	body := make([]byte, 0, min(size, bulkChunk))
+	for remaining := size; remaining > 0; {
+		chunk := min(remaining, bulkChunk)
+		start := len(body)
+		if cap(body)-start < chunk {
+			grown := make([]byte, start, max(2*cap(body), start+chunk))
+			copy(grown, body)
+			body = grown
+		}
+		body = body[:start+chunk]
+		read, err := io.ReadFull(r.reader, body[start:])
+		r.pos += int64(read)
+		if err != nil {
+			return nil, err
+		}
+		remaining -= chunk
+	}
+	return body, nil
+}
+
+// readLine 读取一行并去掉 CRLF；行尾缺少 \r 视为格式错误由调用方处理。
+func (r *CommandReader) readLine() ([]byte, error) {
+	line, err := r.reader.ReadBytes('\n')
+	r.pos += int64(len(line))
+	if err != nil {
+		return line, err
+	}
+	if !bytes.HasSuffix(line, []byte{'\r', '\n'}) {
+		// 把裸 \n 保留下来，让上层按非法格式报告
+		return line, nil
+	}
+	return line[:len(line)-2], nil
+}
+
+func (r *CommandReader) wrapReadErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+func firstByte(line []byte) string {
+	if len(line) == 0 {
+		return ""
+	}
+	return string(line[:1])
+}
+
+// Scan 顺序解析 AOF 流，对每条完整命令回调 fn。
+// 返回最后一条完整命令结束处的偏移，以及遇到的第一个错误（正常结束时为 nil）。
+func Scan(r io.Reader, fn func(args [][]byte)) (int64, error) {
+	offset, _, err := ScanUntil(r, 0, fn)
+	return offset, err
+}
+
+// ScanUntil 与 Scan 相同，但遇到晚于 until（unix 秒）的 #TS 注解时停止，
+// 此时 stopped=true，offset 为该注解行的起始偏移。until=0 表示不限制。
+func ScanUntil(r io.Reader, until int64, fn func(args [][]byte)) (offset int64, stopped bool, err error) {
+	reader := NewCommandReader(r)
+	reader.until = until
+	for {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			if err == errReachedTimestamp {
+				return reader.pos, true, nil
+			}
+			if err == io.EOF {
+				return offset, false, nil
+			}
+			return offset, false, err
+		}
+		offset = reader.pos
+		if fn != nil {
+			fn(args)
+		}
+	}
+}
+
+// Check 只扫描不回放，用于离线检查（aof-check）。
+// 只要文件能打开就返回非 nil 的结果，error 指出第一处残缺或损坏。
+func Check(fileName string) (*LoadResult, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result := &LoadResult{}
+	if fi, err := f.Stat(); err == nil {
+		result.Size = fi.Size()
+	}
+	result.ValidOffset, err = Scan(f, func([][]byte) { result.Commands++ })
+	return result, err
+}
+
+// Load 回放 AOF 文件中的命令。
+//
+// 处理策略：
+// 1) 尾部残缺：LoadTruncated=true 时截断到最后一条完整命令并继续，否则返回错误；
+// 2) 中部损坏：默认拒绝启动，LoadCorrupted=true 时截断后继续；
+// 3) 其它 I/O 错误直接返回。
+func Load(fileName string, opts LoadOptions, apply func(args [][]byte)) (*LoadResult, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	result := &LoadResult{}
+	if fi, statErr := f.Stat(); statErr == nil {
+		result.Size = fi.Size()
+	}
+	result.ValidOffset, result.Stopped, err = ScanUntil(f, opts.UntilTimestamp, func(args [][]byte) {
+		result.Commands++
+		apply(args)
+	})
+	_ = f.Close()
+
+	var corruption *CorruptionError
+	switch {
+	case err == nil && result.Stopped:
+		return result, truncateToTimestamp(fileName, opts.UntilTimestamp, result)
+	case err == nil:
+		return result, nil
+	case errors.Is(err, ErrTruncated):
+		if !opts.LoadTruncated {
+			return result, fmt.Errorf("%w (valid up to offset %d of %d); enable aof-load-truncated or run aof-check --fix",
+				err, result.ValidOffset, result.Size)
+		}
+		result.Truncated = true
+	case errors.As(err, &corruption):
+		if !opts.LoadCorrupted {
+			return result, fmt.Errorf("%w; run aof-check --fix or enable aof-load-corrupted to start anyway", err)
+		}
+		result.Corrupted = true
+	default:
+		return result, err
+	}
+
+	log.Printf("[AOF] !!! %v, truncating %s from %d to %d bytes (%d bytes discarded) and continuing",
+		err, fileName, result.Size, result.ValidOffset, result.Size-result.ValidOffset)
+	if truncErr := Truncate(fileName, result.ValidOffset); truncErr != nil {
+		return result, fmt.Errorf("truncate %s failed: %w", fileName, truncErr)
+	}
+	return result, nil
+}
+
+// truncateToTimestamp 先把完整文件另存一份，再截断原文件，便于恢复点选错后再找回。
+func truncateToTimestamp(fileName string, until int64, result *LoadResult) error {
+	backup := fmt.Sprintf("%s.pitr-%d", fileName, until)
+	if err := CopyPrefix(fileName, backup, result.Size); err != nil {
+		return fmt.Errorf("backup %s before point-in-time truncate failed: %w", fileName, err)
+	}
+	log.Printf("[AOF] point-in-time recovery: replayed %d commands up to ts=%d, truncating %s from %d to %d bytes (full copy saved to %s)",
+		result.Commands, until, fileName, result.Size, result.ValidOffset, backup)
+	return Truncate(fileName, result.ValidOffset)
+}
+
+// CopyPrefix 把 src 的前 size 字节写入 dst（dst 已存在时覆盖）并刷盘。
+func CopyPrefix(src, dst string, size int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(out, in, size); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Truncate 把 AOF 文件截断到指定偏移并刷盘。
+func Truncate(fileName string, offset int64) error {
+	f, err := os.OpenFile(fileName, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	return f.Sync()
+}
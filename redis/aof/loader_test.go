@@ -0,0 +1,153 @@
+package aof
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeAOFFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), AofName)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("write aof failed: %v", err)
+	}
+	return path
+}
+
+func TestLoadTruncatedTail(t *testing.T) {
+	valid := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"
+	path := writeAOFFile(t, valid+"*3\r\n$3\r\nSET\r\n$2\r\nk2\r\n$5\r\nva")
+
+	var replayed int
+	result, err := Load(path, DefaultLoadOptions, func([][]byte) { replayed++ })
+	if err != nil {
+		t.Fatalf("Load should recover truncated tail, got: %v", err)
+	}
+	if !result.Truncated || replayed != 1 || result.ValidOffset != int64(len(valid)) {
+		t.Fatalf("unexpected result: %+v replayed=%d", result, replayed)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if fi.Size() != int64(len(valid)) {
+		t.Fatalf("file should be truncated to %d, got %d", len(valid), fi.Size())
+	}
+
+	// 不允许截断时应拒绝加载
+	path = writeAOFFile(t, valid+"*1\r\n$4\r\nPI")
+	if _, err := Load(path, LoadOptions{}, func([][]byte) {}); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected ErrTruncated, got: %v", err)
+	}
+}
+
+func TestLoadMidFileCorruption(t *testing.T) {
+	valid := "*2\r\n$3\r\nDEL\r\n$1\r\nk\r\n"
+	tail := "*2\r\n$3\r\nDEL\r\n$1\r\nx\r\n"
+	path := writeAOFFile(t, valid+"garbage\r\n"+tail)
+
+	_, err := Load(path, DefaultLoadOptions, func([][]byte) {})
+	var corruption *CorruptionError
+	if !errors.As(err, &corruption) {
+		t.Fatalf("expected CorruptionError, got: %v", err)
+	}
+	if corruption.Offset != int64(len(valid)) {
+		t.Fatalf("expected bad offset %d, got %d", len(valid), corruption.Offset)
+	}
+
+	// 强制加载：截断损坏点之后的数据
+	result, err := Load(path, LoadOptions{LoadCorrupted: true}, func([][]byte) {})
+	if err != nil {
+		t.Fatalf("forced load failed: %v", err)
+	}
+	if !result.Corrupted || result.Commands != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	check, err := Check(path)
+	if err != nil || check.Size != int64(len(valid)) {
+		t.Fatalf("file should be valid after forced load, result=%+v err=%v", check, err)
+	}
+}
+
+// 损坏的长度字段不能导致超大分配或 panic，应报告为该命令处的损坏。
+func TestLoadHugeBulkLength(t *testing.T) {
+	valid := "*2\r\n$3\r\nDEL\r\n$1\r\nk\r\n"
+	for _, size := range []string{"9223372036854775807", "99999999999", "9223372036854775808"} {
+		path := writeAOFFile(t, valid+"*2\r\n$3\r\nDEL\r\n$"+size+"\r\nk\r\n")
+		result, err := Check(path)
+		var corruption *CorruptionError
+		if !errors.As(err, &corruption) || corruption.Offset != int64(len(valid)) {
+			t.Fatalf("$%s: expected CorruptionError at offset %d, got: %v", size, len(valid), err)
+		}
+		if result.Commands != 1 {
+			t.Fatalf("$%s: unexpected result: %+v", size, result)
+		}
+		if _, err := Load(path, DefaultLoadOptions, func([][]byte) {}); !errors.As(err, &corruption) {
+			t.Fatalf("$%s: Load should refuse to start, got: %v", size, err)
+		}
+	}
+
+	// 不超过上限、只是比剩余数据长的参数仍按尾部残缺处理
+	path := writeAOFFile(t, valid+"*2\r\n$3\r\nDEL\r\n$104857600\r\nk")
+	if _, err := Check(path); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected ErrTruncated, got: %v", err)
+	}
+}
+
+func TestLoadUntilTimestamp(t *testing.T) {
+	before := "#TS:100\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"
+	after := "#TS:200\r\n*2\r\n$3\r\nDEL\r\n$1\r\nk\r\n"
+	path := writeAOFFile(t, before+after)
+
+	var replayed [][][]byte
+	result, err := Load(path, LoadOptions{UntilTimestamp: 150}, func(args [][]byte) {
+		replayed = append(replayed, args)
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !result.Stopped || len(replayed) != 1 || string(replayed[0][0]) != "SET" {
+		t.Fatalf("expected only SET to be replayed, result=%+v replayed=%q", result, replayed)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil || fi.Size() != int64(len(before)) {
+		t.Fatalf("file should be truncated to %d bytes, fi=%v err=%v", len(before), fi, err)
+	}
+	backup, err := os.ReadFile(path + ".pitr-150")
+	if err != nil || string(backup) != before+after {
+		t.Fatalf("backup should hold the original file, err=%v", err)
+	}
+}
+
+func TestAppendCommandTimestampAnnotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AofName)
+	a, err := NewAOFWithFile(SyncAlways, path)
+	if err != nil {
+		t.Fatalf("NewAOFWithFile failed: %v", err)
+	}
+	a.SetTimestampEnabled(true)
+	for i := 0; i < 3; i++ {
+		if err := a.AppendCommand([][]byte{[]byte("SET"), []byte("k"), []byte("v")}); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+	}
+	a.Close()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read aof failed: %v", err)
+	}
+	if !bytes.HasPrefix(content, []byte(TimestampPrefix)) {
+		t.Fatalf("aof should start with a timestamp annotation: %q", content)
+	}
+	// 注解不影响解析
+	if cmds := readAOFCommands(t, path); len(cmds) != 3 {
+		t.Fatalf("expected 3 commands, got %d", len(cmds))
+	}
+}
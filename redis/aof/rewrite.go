@@ -1,8 +1,8 @@
 package aof
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -26,6 +26,9 @@ type rewriteChildResult struct {
 	err error
 }
 
+// ErrRewriteInProgress 表示已有一次重写在进行中。
+var ErrRewriteInProgress = errors.New("rewrite already in progress")
+
 // Rewrite 执行一次 AOF 重写。
 //
 // 时间线：
@@ -34,10 +37,22 @@ type rewriteChildResult struct {
 // 3) 子协程完成后，主协程将 rewriteBuffer 追加到 temp.aof；
 // 4) 原子 rename(temp.aof -> appendonly.aof)，并重建当前 AOF 句柄。
 func (aof *AOF) Rewrite(ctx context.Context) error {
+	err := aof.rewrite(ctx)
+	if errors.Is(err, ErrRewriteInProgress) {
+		return err
+	}
+	// 记录结果供 INFO persistence 的 aof_last_bgrewrite_status 使用
+	aof.statusMu.Lock()
+	aof.lastRewriteErr = err
+	aof.statusMu.Unlock()
+	return err
+}
+
+func (aof *AOF) rewrite(ctx context.Context) error {
 	aof.mu.Lock()
 	if aof.rewriting {
 		aof.mu.Unlock()
-		return fmt.Errorf("rewrite already in progress")
+		return ErrRewriteInProgress
 	}
 	if aof.snapshotProvider == nil {
 		aof.mu.Unlock()
@@ -45,6 +60,9 @@ func (aof *AOF) Rewrite(ctx context.Context) error {
 	}
 	aof.rewriting = true
 	aof.rewriteBuffer = aof.rewriteBuffer[:0]
+	aof.rewriteAbortErr = nil
+	// 新文件由快照开头，快照结束时所在的 DB 未知，下一条增量命令必须重新 SELECT
+	aof.selectedDB = -1
 	aof.mu.Unlock()
 
 	start := time.Now()
@@ -119,6 +137,11 @@ func (aof *AOF) Rewrite(ctx context.Context) error {
 		aof.rewriteBuffer = nil
 	}()
 
+	if aof.rewriteAbortErr != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("rewrite aborted by aof write error: %w", aof.rewriteAbortErr)
+	}
+
 	log.Printf("[AOF-REWRITE] merge incremental buffer, buffered_cmd=%d", len(aof.rewriteBuffer))
 	appendFile, err := os.OpenFile(tmpPath, os.O_APPEND|os.O_WRONLY, 0644)
 	if err != nil {
@@ -142,8 +165,10 @@ func (aof *AOF) Rewrite(ctx context.Context) error {
 		return fmt.Errorf("close merged temp file failed: %w", err)
 	}
 
-	// 在替换前先把当前 AOF 刷盘并关闭。
-	if err := aof.bufWriter.Flush(); err != nil {
+	// 在替换前先把当前 AOF 刷盘并关闭；syncMu 保证组提交不会在 fsync 途中遇到文件被关闭。
+	aof.syncMu.Lock()
+	defer aof.syncMu.Unlock()
+	if err := aof.flushLocked(); err != nil {
 		_ = os.Remove(tmpPath)
 		return fmt.Errorf("flush current aof failed: %w", err)
 	}
@@ -151,6 +176,12 @@ func (aof *AOF) Rewrite(ctx context.Context) error {
 		_ = os.Remove(tmpPath)
 		return fmt.Errorf("sync current aof failed: %w", err)
 	}
+	// 此前追加的数据都已在新文件中落盘：待提交的批次直接完成，fsync 失败尚未处理的批次也不再丢弃
+	aof.fileGen++
+	if batch := aof.pendingBatch; batch != nil {
+		aof.pendingBatch = nil
+		close(batch.done)
+	}
 	if err := aof.File.Close(); err != nil {
 		_ = os.Remove(tmpPath)
 		return fmt.Errorf("close current aof failed: %w", err)
@@ -206,7 +237,9 @@ func (aof *AOF) reopenWriter() error {
 		return err
 	}
 	aof.File = newFile
-	aof.bufWriter = bufio.NewWriter(newFile)
+	if fi, statErr := newFile.Stat(); statErr == nil {
+		aof.fileSize = fi.Size()
+	}
 	return nil
 }
 
@@ -0,0 +1,380 @@
+package client
+
+import (
+	"MiddlewareSelf/redis/cluster/hashslot"
+	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/resp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClusterOptions 为 ClusterClient 配置。
+type ClusterOptions struct {
+	// Addrs 为种子节点地址，用于首次获取拓扑
+	Addrs []string
+	// DialTimeout 为建立连接的超时，默认 3 秒
+	DialTimeout time.Duration
+	// PoolSize 为每个节点保留的空闲连接数上限，默认 4
+	PoolSize int
+	// MaxRedirects 为单条命令跟随 MOVED / ASK / TRYAGAIN 的最大次数，默认 5
+	MaxRedirects int
+}
+
+// ClusterClient 是集群客户端：通过 CLUSTER SLOTS 获取槽分布，在客户端计算键的槽，
+// 把 Pipeline 按节点拆分并发执行，遇到 MOVED / ASK 时自动重试。
+//
+// 结果仍按命令原顺序逐条投递，ResponseIndex 为命令在原 Pipeline 中的位置（从 1 开始）。
+type ClusterClient struct {
+	opts ClusterOptions
+
+	mu     sync.RWMutex
+	slots  [hashslot.SlotCount]string
+	pools  map[string]*Pool
+	stale  bool
+	closed bool
+	// refreshMu 保证同一时刻只有一个拓扑刷新
+	refreshMu sync.Mutex
+}
+
+// NewClusterClient 连接种子节点并获取初始拓扑。
+func NewClusterClient(opts ClusterOptions) (*ClusterClient, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("cluster client needs at least one seed address")
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 3 * time.Second
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 4
+	}
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = 5
+	}
+	c := &ClusterClient{opts: opts, pools: make(map[string]*Pool)}
+	ctx, cancel := context.WithTimeout(context.Background(), opts.DialTimeout)
+	defer cancel()
+	if err := c.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close 关闭所有节点的空闲连接，之后的 ExecStream 返回错误。
+func (c *ClusterClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	for _, pool := range c.pools {
+		_ = pool.Close()
+	}
+	return nil
+}
+
+// Refresh 依次向已知节点（先当前拓扑中的节点，再种子节点）查询 CLUSTER SLOTS，用第一个成功的结果替换槽表。
+func (c *ClusterClient) Refresh(ctx context.Context) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	c.mu.RLock()
+	candidates := make([]string, 0, len(c.pools)+len(c.opts.Addrs))
+	for addr := range c.pools {
+		candidates = append(candidates, addr)
+	}
+	c.mu.RUnlock()
+	candidates = append(candidates, c.opts.Addrs...)
+
+	var lastErr error
+	for _, addr := range candidates {
+		results, err := c.execOn(ctx, addr, []Command{NewCommand("CLUSTER", "SLOTS")})
+		if err == nil {
+			err = results[0].Err
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		slots, err := parseClusterSlots(addr, results[0].Reply)
+		if err != nil {
+			lastErr = fmt.Errorf("CLUSTER SLOTS from %s: %w", addr, err)
+			continue
+		}
+		c.mu.Lock()
+		c.slots = slots
+		c.stale = false
+		c.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("refresh cluster topology failed: %w", lastErr)
+}
+
+// parseClusterSlots 解析 CLUSTER SLOTS 应答：[[start, end, [ip, port, id], replica...], ...]，只使用主节点。
+// 主节点 ip 为空时表示与被查询的节点相同。
+func parseClusterSlots(from string, reply _interface.Reply) ([hashslot.SlotCount]string, error) {
+	var slots [hashslot.SlotCount]string
+	if e, ok := reply.(*resp.ErrorReply); ok {
+		return slots, errors.New(e.Error)
+	}
+	ranges, ok := reply.(*resp.MultiBulkReply)
+	if !ok {
+		// 没有任何槽分配时应答为空数组
+		if arr, isArr := reply.(*resp.ArrayReply); isArr && len(arr.Args) == 0 {
+			return slots, nil
+		}
+		return slots, fmt.Errorf("unexpected reply %q", reply.ToBytes())
+	}
+	fromHost, _, _ := net.SplitHostPort(from)
+	for _, r := range ranges.Replies {
+		entry, ok := r.(*resp.MultiBulkReply)
+		if !ok || len(entry.Replies) < 3 {
+			return slots, errors.New("invalid slot range entry")
+		}
+		start, ok1 := entry.Replies[0].(*resp.IntegerReply)
+		end, ok2 := entry.Replies[1].(*resp.IntegerReply)
+		master, ok3 := entry.Replies[2].(*resp.MultiBulkReply)
+		if !ok1 || !ok2 || !ok3 || len(master.Replies) < 2 ||
+			start.Code() < 0 || end.Code() >= hashslot.SlotCount || start.Code() > end.Code() {
+			return slots, errors.New("invalid slot range entry")
+		}
+		ip, ok4 := master.Replies[0].(*resp.BulkReply)
+		port, ok5 := master.Replies[1].(*resp.IntegerReply)
+		if !ok4 || !ok5 {
+			return slots, errors.New("invalid node entry")
+		}
+		host := string(ip.Arg)
+		if host == "" || host == "?" {
+			host = fromHost
+		}
+		addr := net.JoinHostPort(host, strconv.FormatInt(port.Code(), 10))
+		for slot := start.Code(); slot <= end.Code(); slot++ {
+			slots[slot] = addr
+		}
+	}
+	return slots, nil
+}
+
+// ExecStream 执行 Pipeline：命令按键的槽分组到各节点并发执行，结果按原顺序流式返回。
+// 没有键的命令发往任意一个节点；多键命令按第一个键路由，跨槽时由服务端返回 CROSSSLOT。
+// 与 PipelineClient 不同，一个节点的连接失败只影响发往该节点的命令，其余命令照常返回。
+func (c *ClusterClient) ExecStream(ctx context.Context, commands []Command) (<-chan PipelineResult, error) {
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("empty pipeline commands")
+	}
+	c.mu.RLock()
+	closed, stale := c.closed, c.stale
+	c.mu.RUnlock()
+	if closed {
+		return nil, fmt.Errorf("cluster client is closed")
+	}
+	if stale {
+		// 刷新失败时沿用旧拓扑，依靠 MOVED 重定向
+		_ = c.Refresh(ctx)
+	}
+
+	// 按节点分组，indexes 为命令在原 Pipeline 中的下标
+	type batch struct {
+		addr    string
+		indexes []int
+	}
+	var batches []*batch
+	byAddr := make(map[string]*batch)
+	c.mu.RLock()
+	for i, cmd := range commands {
+		addr := c.routeLocked(cmd)
+		b := byAddr[addr]
+		if b == nil {
+			b = &batch{addr: addr}
+			byAddr[addr] = b
+			batches = append(batches, b)
+		}
+		b.indexes = append(b.indexes, i)
+	}
+	c.mu.RUnlock()
+
+	type indexed struct {
+		index  int
+		result PipelineResult
+	}
+	pending := make(chan indexed, len(commands))
+	var wg sync.WaitGroup
+	for _, b := range batches {
+		wg.Add(1)
+		go func(b *batch) {
+			defer wg.Done()
+			cmds := make([]Command, len(b.indexes))
+			for i, index := range b.indexes {
+				cmds[i] = commands[index]
+			}
+			c.execBatch(ctx, b.addr, cmds, func(i int, res PipelineResult) {
+				pending <- indexed{index: b.indexes[i], result: res}
+			})
+		}(b)
+	}
+	go func() {
+		wg.Wait()
+		close(pending)
+	}()
+
+	// 各节点的结果到达顺序不确定，按原顺序重排后投递
+	resultCh := make(chan PipelineResult, 16)
+	go func() {
+		defer close(resultCh)
+		results := make([]*PipelineResult, len(commands))
+		next, succeeded := 0, 0
+		for item := range pending {
+			res := item.result
+			results[item.index] = &res
+			for next < len(results) && results[next] != nil {
+				out := *results[next]
+				out.ResponseIndex = next + 1
+				out.SuccessBefore = succeeded
+				if out.Err == nil {
+					succeeded++
+				}
+				resultCh <- out
+				results[next] = nil
+				next++
+			}
+		}
+	}()
+	return resultCh, nil
+}
+
+// routeLocked 返回命令应发往的节点地址。
+func (c *ClusterClient) routeLocked(cmd Command) string {
+	if len(cmd.Args) > 0 {
+		keys := hashslot.KeysOf(strings.ToUpper(string(cmd.Args[0])), cmd.Args)
+		if len(keys) > 0 {
+			if addr := c.slots[hashslot.KeySlot(string(keys[0]))]; addr != "" {
+				return addr
+			}
+		}
+	}
+	for _, addr := range c.slots {
+		if addr != "" {
+			return addr
+		}
+	}
+	return c.opts.Addrs[0]
+}
+
+// execBatch 在 addr 上执行一组命令，逐条通过 emit 投递最终结果；遇到重定向的命令在批次结束后单独重试。
+func (c *ClusterClient) execBatch(ctx context.Context, addr string, cmds []Command, emit func(int, PipelineResult)) {
+	results, err := c.execOn(ctx, addr, cmds)
+	if err != nil {
+		// 连接失败说明拓扑可能已变化，下次执行前刷新
+		c.markStale()
+		for i := range cmds {
+			emit(i, PipelineResult{Err: fmt.Errorf("node %s: %w", addr, err)})
+		}
+		return
+	}
+	for i, res := range results {
+		if res.Err == nil {
+			if _, _, ok := parseRedirect(res.Reply); ok {
+				emit(i, c.retry(ctx, cmds[i], res.Reply))
+				continue
+			}
+		}
+		emit(i, res)
+	}
+}
+
+// retry 按重定向应答重试一条命令，最多 MaxRedirects 次。
+func (c *ClusterClient) retry(ctx context.Context, cmd Command, reply _interface.Reply) PipelineResult {
+	for attempt := 0; attempt < c.opts.MaxRedirects; attempt++ {
+		kind, addr, _ := parseRedirect(reply)
+		var results []PipelineResult
+		var err error
+		switch kind {
+		case "MOVED":
+			c.updateSlot(cmd, addr)
+			results, err = c.execOn(ctx, addr, []Command{cmd})
+		case "ASK":
+			// ASKING 只对同一连接上的下一条命令生效，因此与命令放在同一个 Pipeline 中
+			results, err = c.execOn(ctx, addr, []Command{NewCommand("ASKING"), cmd})
+			if err == nil {
+				results = results[1:]
+			}
+		case "TRYAGAIN":
+			select {
+			case <-ctx.Done():
+				return PipelineResult{Err: ctx.Err()}
+			case <-time.After(10 * time.Millisecond):
+			}
+			c.mu.RLock()
+			addr = c.routeLocked(cmd)
+			c.mu.RUnlock()
+			results, err = c.execOn(ctx, addr, []Command{cmd})
+		}
+		if err != nil {
+			c.markStale()
+			return PipelineResult{Err: fmt.Errorf("node %s: %w", addr, err)}
+		}
+		if results[0].Err != nil {
+			return results[0]
+		}
+		reply = results[0].Reply
+		if _, _, ok := parseRedirect(reply); !ok {
+			return results[0]
+		}
+	}
+	return PipelineResult{Err: fmt.Errorf("too many cluster redirections, last reply %q", reply.ToBytes())}
+}
+
+// updateSlot 在收到 MOVED 时更新该命令所在槽的归属，并标记拓扑需要整体刷新。
+func (c *ClusterClient) updateSlot(cmd Command, addr string) {
+	keys := hashslot.KeysOf(strings.ToUpper(string(cmd.Args[0])), cmd.Args)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(keys) > 0 {
+		c.slots[hashslot.KeySlot(string(keys[0]))] = addr
+	}
+	c.stale = true
+}
+
+func (c *ClusterClient) markStale() {
+	c.mu.Lock()
+	c.stale = true
+	c.mu.Unlock()
+}
+
+// parseRedirect 识别 -MOVED slot addr / -ASK slot addr / -TRYAGAIN 应答。
+func parseRedirect(reply _interface.Reply) (kind, addr string, ok bool) {
+	e, isErr := reply.(*resp.ErrorReply)
+	if !isErr {
+		return "", "", false
+	}
+	fields := strings.Fields(e.Error)
+	switch {
+	case len(fields) == 3 && (fields[0] == "MOVED" || fields[0] == "ASK"):
+		return fields[0], fields[2], true
+	case len(fields) > 0 && fields[0] == "TRYAGAIN":
+		return fields[0], "", true
+	}
+	return "", "", false
+}
+
+// execOn 在 addr 的连接池上执行命令，语义见 Pool.Pipeline。
+func (c *ClusterClient) execOn(ctx context.Context, addr string, cmds []Command) ([]PipelineResult, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("cluster client is closed")
+	}
+	pool := c.pools[addr]
+	if pool == nil {
+		pool = NewPool(PoolOptions{Addr: addr, DialTimeout: c.opts.DialTimeout, MaxIdle: c.opts.PoolSize, MaxRetries: -1})
+		c.pools[addr] = pool
+	}
+	c.mu.Unlock()
+	return pool.Pipeline(ctx, cmds)
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// cmdable 为常用命令封装，返回 Go 类型而不是原始应答；Pool 与 MuxClient 通过内嵌 cmdable 获得这些方法。
+type cmdable func(ctx context.Context, args ...interface{}) (interface{}, error)
+
+// Ping 执行 PING。
+func (c cmdable) Ping(ctx context.Context) error {
+	_, err := c(ctx, "PING")
+	return err
+}
+
+// Get 返回键的值，键不存在时返回 ErrNil。
+func (c cmdable) Get(ctx context.Context, key string) (string, error) {
+	v, err := c(ctx, "GET", key)
+	if err != nil {
+		return "", err
+	}
+	return asString(v)
+}
+
+// Set 写入键，ttl 大于 0 时以毫秒精度设置过期时间。
+func (c cmdable) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	args := []interface{}{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", ttl.Milliseconds())
+	}
+	_, err := c(ctx, args...)
+	return err
+}
+
+// Del 删除键，返回实际删除的数量。
+func (c cmdable) Del(ctx context.Context, keys ...string) (int64, error) {
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, "DEL")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	return asInt(c(ctx, args...))
+}
+
+// Expire 以毫秒精度设置过期时间，键不存在时返回 false。
+func (c cmdable) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	n, err := asInt(c(ctx, "PEXPIRE", key, ttl.Milliseconds()))
+	return n == 1, err
+}
+
+// TTL 返回剩余生存时间。与 Redis 一致，键不存在时返回 -2，没有过期时间时返回 -1（单位均为纳秒）。
+func (c cmdable) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ms, err := asInt(c(ctx, "PTTL", key))
+	if err != nil || ms < 0 {
+		return time.Duration(ms), err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// Incr 把键的整数值加 1，返回新值。
+func (c cmdable) Incr(ctx context.Context, key string) (int64, error) {
+	return asInt(c(ctx, "INCR", key))
+}
+
+// IncrBy 把键的整数值加 delta，返回新值。
+func (c cmdable) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return asInt(c(ctx, "INCRBY", key, delta))
+}
+
+// IncrByFloat 把键的值加上浮点数 delta，返回新值。
+func (c cmdable) IncrByFloat(ctx context.Context, key string, delta float64) (float64, error) {
+	v, err := c(ctx, "INCRBYFLOAT", key, delta)
+	if err != nil {
+		return 0, err
+	}
+	s, err := asString(v)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func asString(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case []byte:
+		return string(val), nil
+	case string:
+		return val, nil
+	}
+	return "", fmt.Errorf("unexpected reply type %T, want string", v)
+}
+
+func asInt(v interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected reply type %T, want integer", v)
+	}
+	return n, nil
+}
@@ -0,0 +1,319 @@
+package client
+
+import (
+	_interface "MiddlewareSelf/redis/interface"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrMuxClosed 表示多路复用客户端已关闭。
+	ErrMuxClosed = errors.New("mux client is closed")
+	// ErrConnLost 表示命令已发出或排队时连接断开，命令是否被执行未知。
+	ErrConnLost = errors.New("connection lost")
+)
+
+// MuxOptions 为多路复用客户端配置，零值字段使用括号中的默认值。
+type MuxOptions struct {
+	Addr string
+	// DialTimeout 为建立连接的超时（3 秒）
+	DialTimeout time.Duration
+	// ReadTimeout 为等待一条应答的超时（3 秒），WriteTimeout 为一次 flush 的超时（同 ReadTimeout），负数表示不限
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// QueueSize 为排队与在途命令的容量（1024），队列满时 Do 等待直到 ctx 结束
+	QueueSize int
+	// MaxBatch 为一次 flush 最多合并的命令数（128，不超过 QueueSize）
+	MaxBatch int
+	// 断线后按指数退避重连，间隔从 MinRetryBackoff（8ms）开始翻倍，不超过 MaxRetryBackoff（512ms）
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+}
+
+type muxResult struct {
+	reply _interface.Reply
+	err   error
+}
+
+type muxCall struct {
+	ctx  context.Context
+	args [][]byte
+	// done 带 1 个缓冲，调用方提前返回时结果直接丢弃
+	done chan muxResult
+}
+
+// MuxClient 让多个 goroutine 共享一条连接：Do 把命令放入队列，写协程批量编码并 flush，
+// 读协程按 FIFO 顺序把应答交还给对应的调用方，相当于隐式 Pipeline。
+// 连接断开时所有在途命令以 ErrConnLost 失败，后台自动重连。
+type MuxClient struct {
+	cmdable
+
+	opts  MuxOptions
+	queue chan *muxCall
+
+	stopChan  chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewMuxClient 建立连接并启动读写协程，首次建连失败直接返回错误。
+func NewMuxClient(opts MuxOptions) (*MuxClient, error) {
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 3 * time.Second
+	}
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = 3 * time.Second
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = opts.ReadTimeout
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.MaxBatch <= 0 {
+		opts.MaxBatch = 128
+	}
+	// 在途队列装满未 flush 的命令时读协程会空等，批大小不能超过队列容量
+	if opts.MaxBatch > opts.QueueSize {
+		opts.MaxBatch = opts.QueueSize
+	}
+	if opts.MinRetryBackoff <= 0 {
+		opts.MinRetryBackoff = 8 * time.Millisecond
+	}
+	if opts.MaxRetryBackoff <= 0 {
+		opts.MaxRetryBackoff = 512 * time.Millisecond
+	}
+
+	c := &MuxClient{
+		opts:     opts,
+		queue:    make(chan *muxCall, opts.QueueSize),
+		stopChan: make(chan struct{}),
+	}
+	c.cmdable = c.Do
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.wg.Add(1)
+	go c.run(conn)
+	return c, nil
+}
+
+// Close 关闭连接并停止后台协程，排队与在途的命令以 ErrMuxClosed 或 ErrConnLost 失败。
+func (c *MuxClient) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stopChan)
+	})
+	c.wg.Wait()
+	return nil
+}
+
+// Do 执行一条命令，参数与返回值的约定同 Pool.Do。
+func (c *MuxClient) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	cmd, err := buildCommand(args)
+	if err != nil {
+		return nil, err
+	}
+	call := &muxCall{ctx: ctx, args: cmd.Args, done: make(chan muxResult, 1)}
+	select {
+	case <-c.stopChan:
+		return nil, ErrMuxClosed
+	default:
+	}
+	select {
+	case c.queue <- call:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("mux queue full: %w", ctx.Err())
+	case <-c.stopChan:
+		return nil, ErrMuxClosed
+	}
+	select {
+	case res := <-call.done:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return replyValue(res.reply)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.stopChan:
+		return nil, ErrMuxClosed
+	}
+}
+
+func (c *MuxClient) dial() (net.Conn, error) {
+	d := net.Dialer{Timeout: c.opts.DialTimeout}
+	conn, err := d.Dial("tcp", c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetNoDelay(true)
+	}
+	return conn, nil
+}
+
+func (c *MuxClient) lostErr(cause error) error {
+	return fmt.Errorf("%w: %s: %v", ErrConnLost, c.opts.Addr, cause)
+}
+
+// run 在当前连接上收发命令，连接断开后重连，直到 Close。
+func (c *MuxClient) run(conn net.Conn) {
+	defer c.wg.Done()
+	for conn != nil {
+		err := c.session(conn)
+		if err == ErrMuxClosed {
+			break
+		}
+		log.Printf("[MUX] connection to %s lost: %v, reconnecting", c.opts.Addr, err)
+		conn = c.reconnect()
+	}
+	c.failQueued(ErrMuxClosed)
+}
+
+// reconnect 按指数退避重连；每次失败都让已排队的命令失败，避免调用方无限等待。Close 后返回 nil。
+func (c *MuxClient) reconnect() net.Conn {
+	backoff := c.opts.MinRetryBackoff
+	for {
+		select {
+		case <-c.stopChan:
+			return nil
+		default:
+		}
+		conn, err := c.dial()
+		if err == nil {
+			log.Printf("[MUX] reconnected to %s", c.opts.Addr)
+			return conn
+		}
+		c.failQueued(c.lostErr(err))
+		select {
+		case <-c.stopChan:
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > c.opts.MaxRetryBackoff {
+			backoff = c.opts.MaxRetryBackoff
+		}
+	}
+}
+
+func (c *MuxClient) failQueued(err error) {
+	for {
+		select {
+		case call := <-c.queue:
+			call.done <- muxResult{err: err}
+		default:
+			return
+		}
+	}
+}
+
+// session 运行一条连接的读写协程，任一方出错后关闭连接，让剩余的在途命令失败，返回断开原因。
+func (c *MuxClient) session(conn net.Conn) error {
+	inflight := make(chan *muxCall, c.opts.QueueSize)
+	writerDone := make(chan struct{})
+	readerDone := make(chan struct{})
+	var readErr error
+	go func() {
+		defer close(readerDone)
+		readErr = c.readLoop(conn, bufio.NewReaderSize(conn, 32*1024), inflight, writerDone)
+	}()
+
+	err := c.writeLoop(conn, bufio.NewWriterSize(conn, 32*1024), inflight, readerDone)
+	close(writerDone)
+	_ = conn.Close()
+	<-readerDone
+	if err == nil {
+		err = readErr
+	}
+
+	failErr := err
+	if err != ErrMuxClosed {
+		failErr = c.lostErr(err)
+	}
+	for {
+		select {
+		case call := <-inflight:
+			call.done <- muxResult{err: failErr}
+		default:
+			return err
+		}
+	}
+}
+
+// writeLoop 从队列取命令，先登记到在途队列再编码，凑满 MaxBatch 或队列取空后 flush。
+// 读协程退出时返回 nil，Close 时返回 ErrMuxClosed，写失败时返回写错误。
+func (c *MuxClient) writeLoop(conn net.Conn, writer *bufio.Writer, inflight chan<- *muxCall, readerDone <-chan struct{}) error {
+	for {
+		var call *muxCall
+		select {
+		case call = <-c.queue:
+		case <-readerDone:
+			return nil
+		case <-c.stopChan:
+			return ErrMuxClosed
+		}
+
+		for n := 0; call != nil; {
+			// 调用方已放弃的命令不再发送
+			if call.ctx.Err() == nil {
+				select {
+				case inflight <- call:
+				case <-readerDone:
+					call.done <- muxResult{err: c.lostErr(errors.New("reader stopped"))}
+					return nil
+				case <-c.stopChan:
+					call.done <- muxResult{err: ErrMuxClosed}
+					return ErrMuxClosed
+				}
+				if err := writeCommand(writer, call.args); err != nil {
+					return err
+				}
+				n++
+			}
+			if n >= c.opts.MaxBatch {
+				break
+			}
+			select {
+			case call = <-c.queue:
+			default:
+				call = nil
+			}
+		}
+
+		if c.opts.WriteTimeout > 0 {
+			_ = conn.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout))
+		}
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// readLoop 按发送顺序逐条读取应答并交还调用方，读失败时返回错误；写协程退出时返回 nil。
+func (c *MuxClient) readLoop(conn net.Conn, reader *bufio.Reader, inflight <-chan *muxCall, writerDone <-chan struct{}) error {
+	for {
+		var call *muxCall
+		select {
+		case call = <-inflight:
+		case <-writerDone:
+			return nil
+		}
+		if c.opts.ReadTimeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(c.opts.ReadTimeout))
+		}
+		reply, err := readOneReply(reader)
+		if err != nil {
+			// 关闭连接让可能阻塞在 flush 上的写协程尽快退出
+			_ = conn.Close()
+			call.done <- muxResult{err: c.lostErr(err)}
+			return err
+		}
+		call.done <- muxResult{reply: reply}
+	}
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMuxClientConcurrentCallers(t *testing.T) {
+	srv := startMockKV(t)
+	c, err := NewMuxClient(MuxOptions{Addr: srv.addr(), MaxBatch: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 50; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			key := "k" + strconv.Itoa(g)
+			for i := 1; i <= 20; i++ {
+				// 应答按 FIFO 交还，每个调用方只能看到自己命令的结果
+				n, err := c.Incr(ctx, key)
+				if err != nil || n != int64(i) {
+					t.Errorf("%s: Incr = %d, %v, want %d", key, n, err, i)
+					return
+				}
+				if _, err := c.Incr(ctx, "shared"); err != nil {
+					t.Errorf("Incr shared: %v", err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if v, err := c.Get(ctx, "shared"); err != nil || v != "1000" {
+		t.Fatalf("shared = %q, %v", v, err)
+	}
+	if _, err := c.Get(ctx, "missing"); err != ErrNil {
+		t.Fatalf("Get missing key: %v", err)
+	}
+	if n := srv.acceptedConns(); n != 1 {
+		t.Fatalf("all callers should share one connection, accepted %d", n)
+	}
+}
+
+func TestMuxClientReconnectAndClose(t *testing.T) {
+	srv := startMockKV(t)
+	c, err := NewMuxClient(MuxOptions{Addr: srv.addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := c.Set(ctx, "k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.kill()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		v, err := c.Get(ctx, "k")
+		if err == nil {
+			if v != "v" {
+				t.Fatalf("Get after reconnect = %q", v)
+			}
+			break
+		}
+		if !errors.Is(err, ErrConnLost) {
+			t.Fatalf("expect ErrConnLost while reconnecting, got %v", err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("client did not reconnect: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := srv.acceptedConns(); n != 2 {
+		t.Fatalf("accepted %d connections, want 2", n)
+	}
+
+	_ = c.Close()
+	if _, err := c.Do(ctx, "PING"); err != ErrMuxClosed {
+		t.Fatalf("Do after Close: %v", err)
+	}
+}
+
+func TestMuxClientFailsInflightOnConnLoss(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// 服务端读到命令后不应答直接断开
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 64)
+			_, _ = conn.Read(buf)
+			_ = conn.Close()
+		}
+	}()
+
+	c, err := NewMuxClient(MuxOptions{Addr: ln.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Do(context.Background(), "PING"); !errors.Is(err, ErrConnLost) {
+				t.Errorf("in-flight call: expect ErrConnLost, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Do(ctx, "PING"); err == nil {
+		t.Fatal("expect error from a server that never replies")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -36,6 +37,7 @@ type PipelineResult struct {
 	ResponseIndex int
 	Reply         _interface.Reply
 	Err           error
+	// SuccessBefore 在接收阶段为此前成功的响应数，在发送阶段出错时为已写入的命令数
 	SuccessBefore int
 }
 
@@ -45,15 +47,45 @@ type PipelineClient struct {
 	conn   net.Conn
 	reader *bufio.Reader
 	writer *bufio.Writer
+	// readTimeout / writeTimeout 为单次读写的超时，0 表示只受 ctx 截止时间约束
+	readTimeout  time.Duration
+	writeTimeout time.Duration
 
 	mu     sync.Mutex
 	closed bool
 }
 
+// DialOptions 为建立 Pipeline 连接的选项。
+type DialOptions struct {
+	// Network 为 "tcp"（默认）或 "unix"，为 unix 时地址为 socket 文件路径
+	Network string
+	// Timeout 为建立连接（含 TLS 握手）的超时，0 表示不限
+	Timeout time.Duration
+	// TLSConfig 非 nil 时通过 TLS 连接，可由 LoadTLSConfig 生成
+	TLSConfig *tls.Config
+	// Password 非空时建连后先发送 AUTH，服务端拒绝时返回 *ReplyError
+	Password string
+}
+
 // DialPipeline 建立 TCP 连接并启用 TCP_NODELAY（关闭 Nagle 算法）。
 func DialPipeline(address string, timeout time.Duration) (*PipelineClient, error) {
-	d := net.Dialer{Timeout: timeout}
-	conn, err := d.Dial("tcp", address)
+	return DialPipelineWithOptions(address, DialOptions{Timeout: timeout})
+}
+
+// DialUnix 通过 Unix socket 连接同一主机上的服务端，省去回环 TCP 的开销。
+func DialUnix(path string, timeout time.Duration) (*PipelineClient, error) {
+	return DialPipelineWithOptions(path, DialOptions{Network: "unix", Timeout: timeout})
+}
+
+// DialPipelineWithOptions 按 opts 建立连接，TLSConfig 非 nil 时在连接上完成 TLS 握手，
+// Password 非空时随后完成认证。
+func DialPipelineWithOptions(address string, opts DialOptions) (*PipelineClient, error) {
+	network := opts.Network
+	if network == "" {
+		network = "tcp"
+	}
+	d := net.Dialer{Timeout: opts.Timeout}
+	conn, err := d.Dial(network, address)
 	if err != nil {
 		return nil, err
 	}
@@ -64,12 +96,69 @@ func DialPipeline(address string, timeout time.Duration) (*PipelineClient, error
 			return nil, fmt.Errorf("set TCP_NODELAY failed: %w", err)
 		}
 	}
+	if opts.TLSConfig != nil {
+		if conn, err = handshakeTLS(conn, address, opts.TLSConfig, opts.Timeout); err != nil {
+			return nil, err
+		}
+	}
 
-	return &PipelineClient{
+	cli := &PipelineClient{
 		conn:   conn,
 		reader: bufio.NewReaderSize(conn, 32*1024),
 		writer: bufio.NewWriterSize(conn, 32*1024),
-	}, nil
+	}
+	if opts.Password != "" {
+		ctx := context.Background()
+		if opts.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
+		}
+		if err := cli.call(ctx, NewCommand("AUTH", opts.Password)); err != nil {
+			_ = cli.Close()
+			return nil, err
+		}
+	}
+	return cli, nil
+}
+
+// call 执行一条命令并丢弃应答，服务端返回错误应答时返回 *ReplyError。用于建连后的 AUTH / HELLO。
+func (p *PipelineClient) call(ctx context.Context, cmd Command) error {
+	ch, err := p.ExecStream(ctx, []Command{cmd})
+	if err != nil {
+		return err
+	}
+	for res := range ch {
+		if res.Err != nil {
+			err = res.Err
+			continue
+		}
+		if errReply, ok := res.Reply.(*resp.ErrorReply); ok {
+			err = &ReplyError{Msg: errReply.Error}
+		}
+	}
+	return err
+}
+
+// SetTimeouts 设置单次读（等待一条应答）与写（一次 flush）的超时，需在 ExecStream 之前调用。
+func (p *PipelineClient) SetTimeouts(read, write time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readTimeout, p.writeTimeout = read, write
+}
+
+// opDeadline 返回一次读写的截止时间：timeout 与 ctx 截止时间中较早者，都没有时为零值。
+func opDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	deadline, ok := ctx.Deadline()
+	if timeout > 0 {
+		if d := time.Now().Add(timeout); !ok || d.Before(deadline) {
+			return d
+		}
+	}
+	if ok {
+		return deadline
+	}
+	return time.Time{}
 }
 
 // Close 关闭连接。
@@ -89,7 +178,9 @@ func (p *PipelineClient) Close() error {
 // 关键行为：
 // 1) 发送端：边编码边写入 bufio.Writer，不拼巨大总包；
 // 2) 接收端：底层 socket 循环中读到一个完整 RESP 就立刻向上游投递；
-// 3) 错误定位：若第 N 条响应读取失败，会返回明确错误并带上前 N-1 条成功计数。
+// 3) 错误定位：若第 N 条响应读取失败，会返回明确错误并带上前 N-1 条成功计数；
+// 4) 发送阶段在第 N 条命令处失败（编码/写入/flush 出错或 ctx 取消）时只投递这一条错误结果，
+//    SuccessBefore 为已写入的命令数，此时所有命令都没有应答；flush 出错时本批命令已写入缓冲，计入其中。
 func (p *PipelineClient) ExecStream(ctx context.Context, commands []Command) (<-chan PipelineResult, error) {
 	if len(commands) == 0 {
 		return nil, fmt.Errorf("empty pipeline commands")
@@ -107,12 +198,15 @@ func (p *PipelineClient) ExecStream(ctx context.Context, commands []Command) (<-
 		defer p.mu.Unlock()
 		defer close(resultCh)
 
-		if deadline, ok := ctx.Deadline(); ok {
+		if deadline, ok := ctx.Deadline(); ok || p.readTimeout > 0 || p.writeTimeout > 0 {
 			_ = p.conn.SetDeadline(deadline)
 			defer func() {
 				_ = p.conn.SetDeadline(time.Time{})
 			}()
 		}
+		if p.writeTimeout > 0 {
+			_ = p.conn.SetWriteDeadline(opDeadline(ctx, p.writeTimeout))
+		}
 
 		// 1) 流式发送：逐条编码、逐条写入（并按批 flush）。
 		for i, cmd := range commands {
@@ -138,7 +232,11 @@ func (p *PipelineClient) ExecStream(ctx context.Context, commands []Command) (<-
 
 			// 减少系统调用，同时避免无限积压。
 			if (i+1)%64 == 0 {
-				if err := p.writer.Flush(); err != nil {
+				err := p.writer.Flush()
+				if p.writeTimeout > 0 {
+					_ = p.conn.SetWriteDeadline(opDeadline(ctx, p.writeTimeout))
+				}
+				if err != nil {
 					resultCh <- PipelineResult{
 						ResponseIndex: i + 1,
 						Err:           fmt.Errorf("pipeline flush failed after command #%d: %w", i+1, err),
@@ -151,9 +249,9 @@ func (p *PipelineClient) ExecStream(ctx context.Context, commands []Command) (<-
 
 		if err := p.writer.Flush(); err != nil {
 			resultCh <- PipelineResult{
-				ResponseIndex: 1,
-				Err:           fmt.Errorf("pipeline final flush failed: %w", err),
-				SuccessBefore: 0,
+				ResponseIndex: len(commands),
+				Err:           fmt.Errorf("pipeline flush failed after command #%d: %w", len(commands), err),
+				SuccessBefore: len(commands),
 			}
 			return
 		}
@@ -171,6 +269,9 @@ func (p *PipelineClient) ExecStream(ctx context.Context, commands []Command) (<-
 			default:
 			}
 
+			if p.readTimeout > 0 {
+				_ = p.conn.SetReadDeadline(opDeadline(ctx, p.readTimeout))
+			}
 			reply, err := readOneReply(p.reader)
 			if err != nil {
 				resultCh <- PipelineResult{
@@ -255,6 +356,8 @@ func readOneReply(reader *bufio.Reader) (_interface.Reply, error) {
 		return readBulkReply(reader, line)
 	case '*':
 		return readArrayReply(reader, line)
+	case '_', '#', ',', '(', '=', '!', '%', '~', '|', '>':
+		return readResp3Reply(reader, line)
 	default:
 		return nil, fmt.Errorf("unknown resp type: %q", line[0])
 	}
@@ -294,39 +397,30 @@ func readArrayReply(reader *bufio.Reader, header []byte) (_interface.Reply, erro
 		return resp.MakeArrayReply(nil), nil
 	}
 
-	args := make([][]byte, 0, n)
+	// 元素全部为 bulk string（或 nil）时返回 ArrayReply，否则（如 CLUSTER SLOTS）返回逐元素解析的 MultiBulkReply
+	replies := make([]_interface.Reply, 0, n)
+	allBulk := true
 	for i := int64(0); i < n; i++ {
-		line, err := readLine(reader)
+		reply, err := readOneReply(reader)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("array element #%d: %w", i+1, err)
 		}
-		if len(line) == 0 || line[0] != '$' {
-			return nil, fmt.Errorf("array element #%d is not bulk string", i+1)
+		switch reply.(type) {
+		case *resp.BulkReply, *resp.NullReply:
+		default:
+			allBulk = false
 		}
-
-		strlen, err := strconv.ParseInt(string(line[1:]), 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid array bulk length: %w", err)
-		}
-		if strlen < -1 {
-			return nil, fmt.Errorf("invalid array bulk length: %d", strlen)
-		}
-
-		if strlen == -1 {
-			args = append(args, nil)
-			continue
-		}
-
-		body := make([]byte, strlen+2)
-		if _, err := io.ReadFull(reader, body); err != nil {
-			return nil, err
-		}
-		if !bytes.HasSuffix(body, []byte(resp.CRLF)) {
-			return nil, fmt.Errorf("array bulk element missing CRLF")
+		replies = append(replies, reply)
+	}
+	if !allBulk {
+		return resp.MakeMultiBulkReply(replies), nil
+	}
+	args := make([][]byte, len(replies))
+	for i, reply := range replies {
+		if bulk, ok := reply.(*resp.BulkReply); ok {
+			args[i] = bulk.Arg
 		}
-		args = append(args, body[:strlen])
 	}
-
 	return resp.MakeArrayReply(args), nil
 }
 
@@ -3,9 +3,12 @@ package client
 import (
 	"MiddlewareSelf/redis/parser"
 	"MiddlewareSelf/redis/resp"
+	"bufio"
 	"context"
+	"math/big"
 	"net"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -116,6 +119,43 @@ func TestPipelineReadFailAtNthResponse(t *testing.T) {
 	}
 }
 
+func TestPipelineFlushFailure(t *testing.T) {
+	addr, shutdown := startMockRedisServer(t, func(conn net.Conn) {
+		defer conn.Close()
+		_, _ = conn.Read(make([]byte, 1))
+	})
+	defer shutdown()
+
+	cli, err := DialPipeline(addr, time.Second)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer cli.Close()
+	// 关闭写方向，命令都能写入缓冲，最后的 flush 失败
+	if err := cli.conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmds := []Command{
+		NewCommand("SET", "k", "1"),
+		NewCommand("GET", "k"),
+	}
+	resCh, err := cli.ExecStream(context.Background(), cmds)
+	if err != nil {
+		t.Fatalf("ExecStream failed: %v", err)
+	}
+	var got []PipelineResult
+	for res := range resCh {
+		got = append(got, res)
+	}
+	if len(got) != 1 || got[0].Err == nil {
+		t.Fatalf("expected a single flush error, got %+v", got)
+	}
+	if got[0].ResponseIndex != 2 || got[0].SuccessBefore != 2 {
+		t.Fatalf("expected ResponseIndex=2 SuccessBefore=2, got %d %d", got[0].ResponseIndex, got[0].SuccessBefore)
+	}
+}
+
 func startMockRedisServer(t *testing.T, handler func(conn net.Conn)) (string, func()) {
 	t.Helper()
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
@@ -143,3 +183,80 @@ func startMockRedisServer(t *testing.T, handler func(conn net.Conn)) (string, fu
 
 	return ln.Addr().String(), shutdown
 }
+
+func TestReadResp3Replies(t *testing.T) {
+	input := "%2\r\n$6\r\nserver\r\n$5\r\nredis\r\n$5\r\nproto\r\n:3\r\n" +
+		"~2\r\n:1\r\n_\r\n" +
+		"#t\r\n,3.25\r\n(12345678901234567890\r\n=8\r\ntxt:text\r\n" +
+		"|1\r\n+ttl\r\n:10\r\n$1\r\nv\r\n" +
+		"*2\r\n$1\r\na\r\n_\r\n" +
+		"!9\r\nERR oops!\r\n"
+	reader := bufio.NewReader(strings.NewReader(input))
+	next := func() interface{} {
+		t.Helper()
+		reply, err := readOneReply(reader)
+		if err != nil {
+			t.Fatalf("readOneReply: %v", err)
+		}
+		v, err := replyValue(reply)
+		if err != nil {
+			return err
+		}
+		return v
+	}
+
+	m := next().(map[string]interface{})
+	if string(m["server"].([]byte)) != "redis" || m["proto"] != int64(3) {
+		t.Fatalf("map = %v", m)
+	}
+	if set := next().([]interface{}); len(set) != 2 || set[0] != int64(1) || set[1] != nil {
+		t.Fatalf("set = %v", set)
+	}
+	if v := next(); v != true {
+		t.Fatalf("boolean = %v", v)
+	}
+	if v := next(); v != 3.25 {
+		t.Fatalf("double = %v", v)
+	}
+	if v := next().(*big.Int); v.String() != "12345678901234567890" {
+		t.Fatalf("big number = %v", v)
+	}
+	if v := next(); v != "text" {
+		t.Fatalf("verbatim = %v", v)
+	}
+	if v := next(); string(v.([]byte)) != "v" {
+		t.Fatalf("attribute should yield the attached value, got %v", v)
+	}
+	if arr := next().([]interface{}); len(arr) != 2 || arr[1] != nil {
+		t.Fatalf("array with RESP3 null = %v", arr)
+	}
+	if err, ok := next().(*ReplyError); !ok || err.Msg != "ERR oops!" {
+		t.Fatalf("blob error = %v", err)
+	}
+}
+
+func TestReadNestedArrayReply(t *testing.T) {
+	input := "*4\r\n:1\r\n-ERR wrong type\r\n$-1\r\n*2\r\n$1\r\na\r\n*1\r\n:7\r\n"
+	reply, err := readOneReply(bufio.NewReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reply.(*resp.MultiBulkReply); !ok || string(reply.ToBytes()) != input {
+		t.Fatalf("reply = %T %q", reply, reply.ToBytes())
+	}
+	v, err := replyValue(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arr := v.([]interface{})
+	if arr[0] != int64(1) || arr[2] != nil {
+		t.Fatalf("elements = %v", arr)
+	}
+	if e, ok := arr[1].(*ReplyError); !ok || e.Msg != "ERR wrong type" {
+		t.Fatalf("error element = %v", arr[1])
+	}
+	nested := arr[3].([]interface{})
+	if string(nested[0].([]byte)) != "a" || nested[1].([]interface{})[0] != int64(7) {
+		t.Fatalf("nested = %v", nested)
+	}
+}
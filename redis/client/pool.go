@@ -0,0 +1,515 @@
+package client
+
+import (
+	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/resp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNil 表示应答为 nil（例如 GET 不存在的键）。
+	ErrNil = errors.New("redis: nil")
+	// ErrPoolClosed 表示连接池已关闭。
+	ErrPoolClosed = errors.New("connection pool is closed")
+)
+
+// ReplyError 是服务端返回的错误应答（-ERR ...）。
+type ReplyError struct {
+	Msg string
+}
+
+func (e *ReplyError) Error() string {
+	return e.Msg
+}
+
+// PoolOptions 为连接池配置，零值字段使用括号中的默认值。
+type PoolOptions struct {
+	Addr string
+	// DialTimeout 为建立连接的超时（3 秒）
+	DialTimeout time.Duration
+	// ReadTimeout 为等待一条应答的超时（3 秒），WriteTimeout 为一次写入的超时（同 ReadTimeout），负数表示不限
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// MinIdle 为后台保持的最少空闲连接数（0），MaxIdle 为归还后保留的最多空闲连接数（8）
+	MinIdle int
+	MaxIdle int
+	// MaxActive 为同时借出的连接数上限，达到上限时借用方等待直到 ctx 结束（0 表示不限）
+	MaxActive int
+	// IdleCheck 为健康检查阈值：空闲超过该时间的连接借出前先 PING（30 秒），负数表示不检查
+	IdleCheck time.Duration
+	// MaxRetries 为建连失败后的重试次数（3，负数表示不重试），重试间隔从 MinRetryBackoff（8ms）开始翻倍，不超过 MaxRetryBackoff（512ms）
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+	// Protocol 为 3 时建连后发送 HELLO 3 切换到 RESP3（默认 RESP2）
+	Protocol int
+	// TLSConfig 非 nil 时通过 TLS 建连
+	TLSConfig *tls.Config
+	// Password 非空时建连后先发送 AUTH
+	Password string
+}
+
+// poolMaintainPeriod 为后台补足 MinIdle 的周期。
+const poolMaintainPeriod = time.Second
+
+type poolConn struct {
+	cli      *PipelineClient
+	lastUsed time.Time
+}
+
+// Pool 是并发安全的连接池：每次调用借出一条独占连接，用完归还，不同 goroutine 之间互不阻塞。
+// 连接读写出错后直接丢弃，下次借用时重新建连（带退避重试）。
+type Pool struct {
+	cmdable
+
+	opts PoolOptions
+	// tokens 限制同时借出的连接数，MaxActive 为 0 时为 nil
+	tokens chan struct{}
+
+	mu     sync.Mutex
+	idle   []*poolConn
+	total  int
+	closed bool
+
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// PoolStats 为连接池状态。
+type PoolStats struct {
+	// TotalConns 为当前打开的连接数（借出 + 空闲）
+	TotalConns int
+	IdleConns  int
+}
+
+// NewPool 创建连接池。不会立即建连，MinIdle 大于 0 时由后台协程补足空闲连接。
+func NewPool(opts PoolOptions) *Pool {
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 3 * time.Second
+	}
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = 3 * time.Second
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = opts.ReadTimeout
+	}
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = 8
+	}
+	if opts.MinIdle > opts.MaxIdle {
+		opts.MinIdle = opts.MaxIdle
+	}
+	if opts.IdleCheck == 0 {
+		opts.IdleCheck = 30 * time.Second
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.MinRetryBackoff <= 0 {
+		opts.MinRetryBackoff = 8 * time.Millisecond
+	}
+	if opts.MaxRetryBackoff <= 0 {
+		opts.MaxRetryBackoff = 512 * time.Millisecond
+	}
+	p := &Pool{opts: opts, stopChan: make(chan struct{})}
+	p.cmdable = p.Do
+	if opts.MaxActive > 0 {
+		p.tokens = make(chan struct{}, opts.MaxActive)
+	}
+	if opts.MinIdle > 0 {
+		p.wg.Add(1)
+		go p.maintain()
+	}
+	return p
+}
+
+// Stats 返回连接池状态。
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolStats{TotalConns: p.total, IdleConns: len(p.idle)}
+}
+
+// Close 关闭空闲连接并停止后台协程，借出中的连接在归还时关闭。
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.total -= len(idle)
+	p.mu.Unlock()
+
+	close(p.stopChan)
+	for _, pc := range idle {
+		_ = pc.cli.Close()
+	}
+	p.wg.Wait()
+	return nil
+}
+
+// Pipeline 借出一条连接执行一组命令，返回与 cmds 一一对应的结果。
+// 返回的 error 表示没能拿到可用连接；单条命令的读写失败体现在对应结果的 Err 中，此时连接被丢弃。
+func (p *Pool) Pipeline(ctx context.Context, cmds []Command) ([]PipelineResult, error) {
+	pc, err := p.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := pc.cli.ExecStream(ctx, cmds)
+	if err != nil {
+		p.put(pc, true)
+		return nil, err
+	}
+	// 发送阶段出错时 ExecStream 只投递一条错误结果，其余命令都没有应答，
+	// 按下标记录已投递的结果，未投递的都以该错误填充
+	results := make([]PipelineResult, len(cmds))
+	filled := make([]bool, len(cmds))
+	succeeded, broken := 0, false
+	var failure error
+	for res := range ch {
+		results[res.ResponseIndex-1] = res
+		filled[res.ResponseIndex-1] = true
+		if res.Err != nil {
+			failure, broken = res.Err, true
+		} else {
+			succeeded++
+		}
+	}
+	for i := range results {
+		if filled[i] {
+			continue
+		}
+		err := fmt.Errorf("no response from %s for command #%d", p.opts.Addr, i+1)
+		if failure != nil {
+			err = fmt.Errorf("no response from %s for command #%d: %w", p.opts.Addr, i+1, failure)
+		}
+		results[i] = PipelineResult{ResponseIndex: i + 1, Err: err, SuccessBefore: succeeded}
+		broken = true
+	}
+	p.put(pc, broken)
+	return results, nil
+}
+
+// Do 执行一条命令并把应答转换为 Go 值：简单字符串为 string，整数为 int64，bulk 为 []byte，
+// 数组为 []interface{}；nil 应答返回 ErrNil，错误应答返回 *ReplyError。
+// RESP3 下 map 为 map[string]interface{}，set / push 为 []interface{}，boolean 为 bool，double 为 float64，
+// big number 为 *big.Int，verbatim string 为 string，attribute 只返回它所修饰的值。
+// 参数可以是 string、[]byte、整数或浮点数。
+func (p *Pool) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	cmd, err := buildCommand(args)
+	if err != nil {
+		return nil, err
+	}
+	results, err := p.Pipeline(ctx, []Command{cmd})
+	if err != nil {
+		return nil, err
+	}
+	if results[0].Err != nil {
+		return nil, results[0].Err
+	}
+	return replyValue(results[0].Reply)
+}
+
+// buildCommand 把 Do 的参数编码为命令。
+func buildCommand(args []interface{}) (Command, error) {
+	if len(args) == 0 {
+		return Command{}, errors.New("empty command")
+	}
+	cmd := Command{Args: make([][]byte, len(args))}
+	for i, arg := range args {
+		b, err := toArg(arg)
+		if err != nil {
+			return Command{}, err
+		}
+		cmd.Args[i] = b
+	}
+	return cmd, nil
+}
+
+func toArg(arg interface{}) ([]byte, error) {
+	switch v := arg.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case int:
+		return []byte(strconv.Itoa(v)), nil
+	case int64:
+		return []byte(strconv.FormatInt(v, 10)), nil
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64)), nil
+	}
+	return nil, fmt.Errorf("unsupported argument type %T", arg)
+}
+
+// replyValue 把应答转换为 Go 值，规则见 Do。
+func replyValue(reply _interface.Reply) (interface{}, error) {
+	switch r := reply.(type) {
+	case *resp.SimpleReply:
+		return r.Status, nil
+	case *resp.ErrorReply:
+		return nil, &ReplyError{Msg: r.Error}
+	case *resp.IntegerReply:
+		return r.Code(), nil
+	case *resp.BulkReply:
+		if r.Arg == nil {
+			return nil, ErrNil
+		}
+		return r.Arg, nil
+	case *resp.ArrayReply:
+		if r.Args == nil {
+			return nil, ErrNil
+		}
+		out := make([]interface{}, len(r.Args))
+		for i, arg := range r.Args {
+			if arg != nil {
+				out[i] = arg
+			}
+		}
+		return out, nil
+	case *resp.MultiBulkReply:
+		if r.Replies == nil {
+			return nil, ErrNil
+		}
+		return elemValues(r.Replies), nil
+	case *resp.NullReply:
+		return nil, ErrNil
+	case *resp.BoolReply:
+		return r.Value, nil
+	case *resp.DoubleReply:
+		return r.Value, nil
+	case *resp.BigNumberReply:
+		return r.Value, nil
+	case *resp.VerbatimReply:
+		return string(r.Text), nil
+	case *resp.SetReply:
+		return elemValues(r.Elems), nil
+	case *resp.PushReply:
+		return elemValues(r.Elems), nil
+	case *resp.AttributeReply:
+		return replyValue(r.Reply)
+	case *resp.MapReply:
+		out := make(map[string]interface{}, len(r.Pairs)/2)
+		values := elemValues(r.Pairs)
+		for i := 0; i+1 < len(values); i += 2 {
+			out[mapKey(values[i])] = values[i+1]
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", reply.ToBytes())
+}
+
+// elemValues 逐个转换聚合类型的元素，nil 元素为 nil，错误应答元素为 *ReplyError。
+func elemValues(elems []_interface.Reply) []interface{} {
+	out := make([]interface{}, len(elems))
+	for i, elem := range elems {
+		v, err := replyValue(elem)
+		if err != nil && err != ErrNil {
+			out[i] = err
+			continue
+		}
+		out[i] = v
+	}
+	return out
+}
+
+func mapKey(v interface{}) string {
+	switch k := v.(type) {
+	case []byte:
+		return string(k)
+	case string:
+		return k
+	}
+	return fmt.Sprint(v)
+}
+
+// get 借出一条连接：优先复用最近归还的空闲连接，空闲过久的先 PING 确认可用，没有空闲连接时新建。
+func (p *Pool) get(ctx context.Context) (*poolConn, error) {
+	if p.tokens != nil {
+		select {
+		case p.tokens <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("connection pool exhausted: %w", ctx.Err())
+		}
+	}
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			p.releaseToken()
+			return nil, ErrPoolClosed
+		}
+		n := len(p.idle)
+		if n == 0 {
+			p.total++
+			p.mu.Unlock()
+			break
+		}
+		pc := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		if p.opts.IdleCheck > 0 && time.Since(pc.lastUsed) > p.opts.IdleCheck && !p.ping(ctx, pc) {
+			p.discard(pc)
+			continue
+		}
+		return pc, nil
+	}
+
+	cli, err := p.dial(ctx)
+	if err != nil {
+		p.mu.Lock()
+		p.total--
+		p.mu.Unlock()
+		p.releaseToken()
+		return nil, err
+	}
+	return &poolConn{cli: cli, lastUsed: time.Now()}, nil
+}
+
+// put 归还连接；broken 为 true 或空闲连接已达 MaxIdle 时关闭连接。
+func (p *Pool) put(pc *poolConn, broken bool) {
+	defer p.releaseToken()
+	pc.lastUsed = time.Now()
+	p.mu.Lock()
+	if !broken && !p.closed && len(p.idle) < p.opts.MaxIdle {
+		p.idle = append(p.idle, pc)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	p.discard(pc)
+}
+
+func (p *Pool) discard(pc *poolConn) {
+	_ = pc.cli.Close()
+	p.mu.Lock()
+	p.total--
+	p.mu.Unlock()
+}
+
+func (p *Pool) releaseToken() {
+	if p.tokens != nil {
+		<-p.tokens
+	}
+}
+
+func (p *Pool) ping(ctx context.Context, pc *poolConn) bool {
+	ch, err := pc.cli.ExecStream(ctx, []Command{NewCommand("PING")})
+	if err != nil {
+		return false
+	}
+	ok := false
+	for res := range ch {
+		_, isPong := res.Reply.(*resp.SimpleReply)
+		ok = res.Err == nil && isPong
+	}
+	return ok
+}
+
+// dial 建立连接，失败后按指数退避重试最多 MaxRetries 次。
+func (p *Pool) dial(ctx context.Context) (*PipelineClient, error) {
+	backoff := p.opts.MinRetryBackoff
+	retries := p.opts.MaxRetries
+	if retries < 0 {
+		retries = 0
+	}
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("dial %s: %w (last error: %v)", p.opts.Addr, ctx.Err(), lastErr)
+			case <-p.stopChan:
+				return nil, ErrPoolClosed
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > p.opts.MaxRetryBackoff {
+				backoff = p.opts.MaxRetryBackoff
+			}
+		}
+		cli, err := p.connect(ctx)
+		if err == nil {
+			return cli, nil
+		}
+		var replyErr *ReplyError
+		if errors.As(err, &replyErr) {
+			// 服务端拒绝了 AUTH 或 HELLO，重试没有意义
+			return nil, err
+		}
+		lastErr = err
+	}
+	if retries == 0 {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("dial %s failed after %d retries: %w", p.opts.Addr, retries, lastErr)
+}
+
+// connect 建立一条连接，按配置认证并协商协议版本。
+func (p *Pool) connect(ctx context.Context) (*PipelineClient, error) {
+	cli, err := DialPipelineWithOptions(p.opts.Addr, DialOptions{Timeout: p.opts.DialTimeout, TLSConfig: p.opts.TLSConfig, Password: p.opts.Password})
+	if err != nil {
+		return nil, err
+	}
+	cli.SetTimeouts(p.opts.ReadTimeout, p.opts.WriteTimeout)
+	if p.opts.Protocol == resp.RESP3 {
+		if err := cli.call(ctx, NewCommand("HELLO", strconv.Itoa(resp.RESP3))); err != nil {
+			_ = cli.Close()
+			return nil, err
+		}
+	}
+	return cli, nil
+}
+
+// maintain 定期补足 MinIdle 个空闲连接。
+func (p *Pool) maintain() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(poolMaintainPeriod)
+	defer ticker.Stop()
+	for {
+		p.fillIdle()
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) fillIdle() {
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.idle) >= p.opts.MinIdle {
+			p.mu.Unlock()
+			return
+		}
+		p.total++
+		p.mu.Unlock()
+
+		cli, err := p.connect(context.Background())
+		p.mu.Lock()
+		if err != nil || p.closed {
+			p.total--
+			p.mu.Unlock()
+			if cli != nil {
+				_ = cli.Close()
+			}
+			if err != nil {
+				log.Printf("[POOL] fill idle connections to %s failed: %v", p.opts.Addr, err)
+			}
+			return
+		}
+		p.idle = append(p.idle, &poolConn{cli: cli, lastUsed: time.Now()})
+		p.mu.Unlock()
+	}
+}
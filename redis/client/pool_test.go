@@ -0,0 +1,270 @@
+package client
+
+import (
+	"MiddlewareSelf/redis/parser"
+	"MiddlewareSelf/redis/resp"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockKV 是支持多连接的最小 KV 服务端，只实现 PING / GET / SET / DEL / INCR。
+type mockKV struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	data     map[string]string
+	conns    map[net.Conn]struct{}
+	accepted int
+}
+
+func startMockKV(t *testing.T) *mockKV {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	s := &mockKV{ln: ln, data: make(map[string]string), conns: make(map[net.Conn]struct{})}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns[conn] = struct{}{}
+			s.accepted++
+			s.mu.Unlock()
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = ln.Close()
+		s.kill()
+	})
+	return s
+}
+
+func (s *mockKV) addr() string {
+	return s.ln.Addr().String()
+}
+
+// kill 断开所有已建立的连接，模拟服务端重启。
+func (s *mockKV) kill() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+func (s *mockKV) acceptedConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+func (s *mockKV) serve(conn net.Conn) {
+	defer conn.Close()
+	for payload := range parser.ParseStream(conn) {
+		if payload.Err != nil {
+			return
+		}
+		args := payload.Data.(*resp.ArrayReply).Args
+		var reply []byte
+		s.mu.Lock()
+		switch strings.ToUpper(string(args[0])) {
+		case "PING":
+			reply = resp.MakeSimpleReply("PONG").ToBytes()
+		case "GET":
+			if v, ok := s.data[string(args[1])]; ok {
+				reply = resp.MakeBulkReply([]byte(v)).ToBytes()
+			} else {
+				reply = resp.MakeBulkReply(nil).ToBytes()
+			}
+		case "SET":
+			s.data[string(args[1])] = string(args[2])
+			reply = resp.MakeSimpleReply("OK").ToBytes()
+		case "DEL":
+			n := 0
+			for _, key := range args[1:] {
+				if _, ok := s.data[string(key)]; ok {
+					delete(s.data, string(key))
+					n++
+				}
+			}
+			reply = resp.MakeIntegerReply(int64(n)).ToBytes()
+		case "INCR":
+			n, _ := strconv.ParseInt(s.data[string(args[1])], 10, 64)
+			n++
+			s.data[string(args[1])] = strconv.FormatInt(n, 10)
+			reply = resp.MakeIntegerReply(n).ToBytes()
+		default:
+			reply = resp.MakeErrorReply("ERR unknown command '" + string(args[0]) + "'").ToBytes()
+		}
+		s.mu.Unlock()
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+func TestPoolTypedHelpers(t *testing.T) {
+	srv := startMockKV(t)
+	pool := NewPool(PoolOptions{Addr: srv.addr()})
+	defer pool.Close()
+	ctx := context.Background()
+
+	if err := pool.Ping(ctx); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if err := pool.Set(ctx, "k", 42, 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if v, err := pool.Get(ctx, "k"); err != nil || v != "42" {
+		t.Fatalf("Get = %q, %v", v, err)
+	}
+	if _, err := pool.Get(ctx, "missing"); err != ErrNil {
+		t.Fatalf("Get missing key: %v", err)
+	}
+	if n, err := pool.Incr(ctx, "k"); err != nil || n != 43 {
+		t.Fatalf("Incr = %d, %v", n, err)
+	}
+	if n, err := pool.Del(ctx, "k", "missing"); err != nil || n != 1 {
+		t.Fatalf("Del = %d, %v", n, err)
+	}
+	var replyErr *ReplyError
+	if _, err := pool.Do(ctx, "NOPE"); !errors.As(err, &replyErr) || !strings.HasPrefix(replyErr.Msg, "ERR unknown command") {
+		t.Fatalf("Do unknown command: %v", err)
+	}
+	if stats := pool.Stats(); stats.TotalConns != 1 || stats.IdleConns != 1 {
+		t.Fatalf("sequential calls should reuse one connection, stats = %+v", stats)
+	}
+}
+
+func TestPoolConcurrentCallersAndMaxActive(t *testing.T) {
+	srv := startMockKV(t)
+	pool := NewPool(PoolOptions{Addr: srv.addr(), MaxActive: 2})
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.Incr(context.Background(), "counter"); err != nil {
+				t.Errorf("Incr: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := pool.Get(context.Background(), "counter"); v != "20" {
+		t.Fatalf("counter = %q", v)
+	}
+	if n := srv.acceptedConns(); n > 2 {
+		t.Fatalf("MaxActive=2 but %d connections were opened", n)
+	}
+
+	// 连接全部借出时，借用方等待到 ctx 结束
+	a, _ := pool.get(context.Background())
+	b, _ := pool.get(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := pool.Ping(ctx); err == nil || !strings.Contains(err.Error(), "exhausted") {
+		t.Fatalf("Ping on exhausted pool: %v", err)
+	}
+	pool.put(a, false)
+	pool.put(b, false)
+}
+
+func TestPoolReconnect(t *testing.T) {
+	srv := startMockKV(t)
+	ctx := context.Background()
+
+	// 健康检查：空闲连接借出前 PING 失败，丢弃后重新建连，调用方无感知
+	checked := NewPool(PoolOptions{Addr: srv.addr(), IdleCheck: time.Nanosecond})
+	defer checked.Close()
+	if err := checked.Set(ctx, "k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	srv.kill()
+	if v, err := checked.Get(ctx, "k"); err != nil || v != "v" {
+		t.Fatalf("Get after server dropped connections = %q, %v", v, err)
+	}
+
+	// 不做健康检查时失败一次，坏连接被丢弃，下一次调用重新建连
+	unchecked := NewPool(PoolOptions{Addr: srv.addr(), IdleCheck: -1})
+	defer unchecked.Close()
+	if err := unchecked.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	srv.kill()
+	if err := unchecked.Ping(ctx); err == nil {
+		t.Fatal("expect error on dropped connection")
+	}
+	if err := unchecked.Ping(ctx); err != nil {
+		t.Fatalf("Ping after reconnect: %v", err)
+	}
+
+	// 地址不可达：退避重试后返回错误
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadAddr := ln.Addr().String()
+	_ = ln.Close()
+	dead := NewPool(PoolOptions{Addr: deadAddr, MaxRetries: 2, MinRetryBackoff: time.Millisecond})
+	defer dead.Close()
+	if err := dead.Ping(ctx); err == nil || !strings.Contains(err.Error(), "after 2 retries") {
+		t.Fatalf("Ping unreachable address: %v", err)
+	}
+	if stats := dead.Stats(); stats.TotalConns != 0 {
+		t.Fatalf("failed dials should not be counted, stats = %+v", stats)
+	}
+}
+
+// 发送阶段在中途失败时，所有命令都没有应答，每条结果都带上真实的错误，坏连接被丢弃。
+func TestPoolPipelineWriteFailure(t *testing.T) {
+	srv := startMockKV(t)
+	pool := NewPool(PoolOptions{Addr: srv.addr()})
+	defer pool.Close()
+	ctx := context.Background()
+
+	results, err := pool.Pipeline(ctx, []Command{NewCommand("PING"), {}, NewCommand("PING")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range results {
+		if res.ResponseIndex != i+1 || res.Reply != nil {
+			t.Fatalf("result #%d = %+v", i+1, res)
+		}
+		if res.Err == nil || !strings.Contains(res.Err.Error(), "empty command args") {
+			t.Fatalf("result #%d: err = %v, want the write error", i+1, res.Err)
+		}
+	}
+	if stats := pool.Stats(); stats.IdleConns != 0 {
+		t.Fatalf("broken connection should be discarded, stats = %+v", stats)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		t.Fatalf("Ping after failed pipeline: %v", err)
+	}
+}
+
+func TestPoolMinIdle(t *testing.T) {
+	srv := startMockKV(t)
+	pool := NewPool(PoolOptions{Addr: srv.addr(), MinIdle: 3})
+	defer pool.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for pool.Stats().IdleConns < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("MinIdle not reached, stats = %+v", pool.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := srv.acceptedConns(); n != 3 {
+		t.Fatalf("accepted %d connections, want 3", n)
+	}
+}
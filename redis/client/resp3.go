@@ -0,0 +1,91 @@
+package client
+
+import (
+	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/resp"
+	"bufio"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// readResp3Reply 解析 RESP3 新增的类型，聚合类型的元素经 readOneReply 递归解析。
+func readResp3Reply(reader *bufio.Reader, header []byte) (_interface.Reply, error) {
+	body := string(header[1:])
+	switch header[0] {
+	case '_':
+		return resp.MakeNullReply(), nil
+	case '#':
+		if body != "t" && body != "f" {
+			return nil, fmt.Errorf("invalid boolean reply: %q", body)
+		}
+		return resp.MakeBoolReply(body == "t"), nil
+	case ',':
+		v, err := resp.ParseDouble(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid double reply: %w", err)
+		}
+		return resp.MakeDoubleReply(v), nil
+	case '(':
+		v, ok := new(big.Int).SetString(body, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid big number reply: %q", body)
+		}
+		return resp.MakeBigNumberReply(v), nil
+	case '=', '!':
+		reply, err := readBulkReply(reader, header)
+		if err != nil {
+			return nil, err
+		}
+		blob := reply.(*resp.BulkReply).Arg
+		if header[0] == '!' {
+			return resp.MakeErrorReply(string(blob)), nil
+		}
+		if len(blob) < 4 || blob[3] != ':' {
+			return nil, fmt.Errorf("invalid verbatim string reply")
+		}
+		return resp.MakeVerbatimReply(string(blob[:3]), blob[4:]), nil
+	case '%', '|':
+		pairs, err := readAggregate(reader, header, 2)
+		if err != nil {
+			return nil, err
+		}
+		if header[0] == '%' {
+			return resp.MakeMapReply(pairs), nil
+		}
+		// attribute 之后紧跟它所修饰的值
+		reply, err := readOneReply(reader)
+		if err != nil {
+			return nil, err
+		}
+		return resp.MakeAttributeReply(pairs, reply), nil
+	case '~', '>':
+		elems, err := readAggregate(reader, header, 1)
+		if err != nil {
+			return nil, err
+		}
+		if header[0] == '~' {
+			return resp.MakeSetReply(elems), nil
+		}
+		return resp.MakePushReply(elems), nil
+	}
+	return nil, fmt.Errorf("unknown resp type: %q", header[0])
+}
+
+// readAggregate 读取头部计数乘以 per 个元素（map / attribute 每项含键和值）。
+func readAggregate(reader *bufio.Reader, header []byte, per int) ([]_interface.Reply, error) {
+	n, err := strconv.ParseInt(string(header[1:]), 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid aggregate length: %q", header[1:])
+	}
+	total := n * int64(per)
+	elems := make([]_interface.Reply, 0, total)
+	for i := int64(0); i < total; i++ {
+		elem, err := readOneReply(reader)
+		if err != nil {
+			return nil, fmt.Errorf("aggregate element #%d: %w", i+1, err)
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
@@ -0,0 +1,254 @@
+package cluster
+
+import (
+	"MiddlewareSelf/redis/client"
+	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/parser"
+	"MiddlewareSelf/redis/resp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// 集群总线在 busport（默认 port+10000）上运行。与 Redis 的二进制协议不同，这里每条消息是一个
+// RESP bulk 数组，发送方等待对方以 PONG 应答，因此可以直接复用 redis/client 与 redis/parser：
+//
+//	type sender-id ip port busport master-id config-epoch current-epoch slots failing-id
+//	[gossip: id ip port busport flags]...
+//
+// type 为 PING / MEET / FAIL / PONG；slots 为 16384 位的位图；master-id 与 failing-id 无值时为 "-"；
+// gossip 的 flags 为 "pfail" / "fail" / "-"。
+const (
+	msgPing = "PING"
+	msgMeet = "MEET"
+	msgFail = "FAIL"
+	msgPong = "PONG"
+
+	headerFields = 10
+	gossipFields = 5
+)
+
+type gossipEntry struct {
+	id      string
+	ip      string
+	port    int
+	busPort int
+	flags   string
+}
+
+type message struct {
+	typ          string
+	sender       string
+	ip           string
+	port         int
+	busPort      int
+	masterID     string
+	configEpoch  int64
+	currentEpoch int64
+	slots        []byte
+	failing      string
+	gossip       []gossipEntry
+}
+
+func (m *message) claims(slot int) bool {
+	return m.slots[slot/8]&(1<<(slot%8)) != 0
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func fromDash(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+func (m *message) encode() [][]byte {
+	args := make([][]byte, 0, headerFields+gossipFields*len(m.gossip))
+	for _, field := range []string{
+		m.typ, m.sender, m.ip, strconv.Itoa(m.port), strconv.Itoa(m.busPort), orDash(m.masterID),
+		strconv.FormatInt(m.configEpoch, 10), strconv.FormatInt(m.currentEpoch, 10),
+	} {
+		args = append(args, []byte(field))
+	}
+	args = append(args, m.slots, []byte(orDash(m.failing)))
+	for _, g := range m.gossip {
+		args = append(args, []byte(g.id), []byte(g.ip), []byte(strconv.Itoa(g.port)),
+			[]byte(strconv.Itoa(g.busPort)), []byte(orDash(g.flags)))
+	}
+	return args
+}
+
+func decodeMessage(args [][]byte) (*message, error) {
+	if len(args) < headerFields || (len(args)-headerFields)%gossipFields != 0 {
+		return nil, fmt.Errorf("invalid bus message with %d fields", len(args))
+	}
+	if len(args[8]) != SlotCount/8 {
+		return nil, errors.New("invalid slot bitmap")
+	}
+	m := &message{
+		typ:      string(args[0]),
+		sender:   string(args[1]),
+		ip:       string(args[2]),
+		masterID: fromDash(string(args[5])),
+		slots:    args[8],
+		failing:  fromDash(string(args[9])),
+	}
+	var errs [4]error
+	m.port, errs[0] = strconv.Atoi(string(args[3]))
+	m.busPort, errs[1] = strconv.Atoi(string(args[4]))
+	m.configEpoch, errs[2] = strconv.ParseInt(string(args[6]), 10, 64)
+	m.currentEpoch, errs[3] = strconv.ParseInt(string(args[7]), 10, 64)
+	if err := errors.Join(errs[:]...); err != nil {
+		return nil, fmt.Errorf("invalid bus message header: %w", err)
+	}
+	for i := headerFields; i < len(args); i += gossipFields {
+		g := gossipEntry{id: string(args[i]), ip: string(args[i+1]), flags: fromDash(string(args[i+4]))}
+		var err1, err2 error
+		g.port, err1 = strconv.Atoi(string(args[i+2]))
+		g.busPort, err2 = strconv.Atoi(string(args[i+3]))
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("invalid gossip entry")
+		}
+		m.gossip = append(m.gossip, g)
+	}
+	return m, nil
+}
+
+// busLink 是到另一个节点总线端口的连接，出错后下次发送时重新建立。只由该节点的心跳协程使用。
+type busLink struct {
+	addr string
+	cli  *client.PipelineClient
+}
+
+// send 发送一条消息并等待 PONG。
+func (l *busLink) send(timeout time.Duration, m *message) (*message, error) {
+	if l.cli == nil {
+		cli, err := client.DialPipeline(l.addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		l.cli = cli
+	}
+	reply, err := exchange(l.cli, timeout, m)
+	if err != nil {
+		l.close()
+	}
+	return reply, err
+}
+
+func (l *busLink) close() {
+	if l.cli != nil {
+		_ = l.cli.Close()
+		l.cli = nil
+	}
+}
+
+// sendOnce 建立一次性连接发送消息，用于广播 FAIL。
+func sendOnce(addr string, timeout time.Duration, m *message) error {
+	cli, err := client.DialPipeline(addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	_, err = exchange(cli, timeout, m)
+	return err
+}
+
+func exchange(cli *client.PipelineClient, timeout time.Duration, m *message) (*message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ch, err := cli.ExecStream(ctx, []client.Command{{Args: m.encode()}})
+	if err != nil {
+		return nil, err
+	}
+	res, ok := <-ch
+	for range ch {
+	}
+	if !ok {
+		return nil, errors.New("no reply")
+	}
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	arr, isArr := res.Reply.(*resp.ArrayReply)
+	if !isArr {
+		return nil, fmt.Errorf("unexpected bus reply %q", res.Reply.ToBytes())
+	}
+	return decodeMessage(arr.Args)
+}
+
+// ServeBus 在 listener 上运行集群总线，并开始与已知节点交换心跳。
+func (c *Cluster) ServeBus(listener net.Listener) {
+	c.mu.Lock()
+	c.busListener = listener
+	for _, node := range c.nodes {
+		if node != c.myself {
+			c.startNodeLocked(node)
+		}
+	}
+	c.mu.Unlock()
+
+	c.wg.Add(2)
+	go c.cron()
+	go func() {
+		defer c.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-c.stopChan:
+				default:
+					log.Printf("[CLUSTER] bus accept failed: %v", err)
+				}
+				return
+			}
+			c.wg.Add(1)
+			go c.handleBusConn(conn)
+		}
+	}()
+}
+
+// handleBusConn 处理其它节点发来的消息，每条消息以本节点的 PONG 应答。
+func (c *Cluster) handleBusConn(conn net.Conn) {
+	defer c.wg.Done()
+	c.busConns.Store(conn, struct{}{})
+	defer func() {
+		c.busConns.Delete(conn)
+		_ = conn.Close()
+	}()
+	for payload := range parser.ParseStream(conn) {
+		if payload.Err != nil {
+			if payload.Err != io.EOF && payload.Err.Error() != "EOF" {
+				log.Printf("[CLUSTER] bus connection %s: %v", conn.RemoteAddr(), payload.Err)
+			}
+			return
+		}
+		var reply _interface.Reply
+		arr, ok := payload.Data.(*resp.ArrayReply)
+		if !ok {
+			reply = resp.MakeErrorReply("ERR invalid bus message")
+		} else if m, err := decodeMessage(arr.Args); err != nil {
+			reply = resp.MakeErrorReply("ERR " + err.Error())
+		} else {
+			c.processMessage(m, nil)
+			c.mu.Lock()
+			pong := c.buildMessageLocked(msgPong, nil)
+			c.mu.Unlock()
+			reply = resp.MakeArrayReply(pong.encode())
+		}
+		if _, err := conn.Write(reply.ToBytes()); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,524 @@
+package cluster
+
+import (
+	"MiddlewareSelf/redis/cluster/hashslot"
+	"MiddlewareSelf/redis/database"
+	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/resp"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options 为集群模式配置。
+type Options struct {
+	// ConfigFile 对应 cluster-config-file，保存节点 ID 与槽分配，为空时不持久化
+	ConfigFile string
+	// AnnounceIP 为本节点对外宣布的 IP（MOVED 应答与 CLUSTER NODES 中使用）
+	AnnounceIP string
+	// Port 为本节点的客户端端口
+	Port int
+	// BusPort 为集群总线端口，为 0 时取 Port+10000
+	BusPort int
+	// NodeTimeout 对应 cluster-node-timeout：超过该时间不可达的节点被标记为 PFAIL，为 0 时取 15 秒
+	NodeTimeout time.Duration
+}
+
+// Cluster 维护集群拓扑（节点与 16384 个槽的归属），并按槽校验命令的键。
+//
+// 集群模式下只有 DB 0；键不属于本节点的命令返回 -MOVED，多个键不在同一槽时返回 -CROSSSLOT。
+// 未分配的槽返回 -CLUSTERDOWN，其余槽照常服务（相当于 cluster-require-full-coverage no）。
+// 槽迁移期间，源节点对已迁走的键返回 -ASK，目标节点只接受带 ASKING 的请求。
+type Cluster struct {
+	db          *database.Db
+	configFile  string
+	nodeTimeout time.Duration
+
+	mu           sync.RWMutex
+	myself       *Node
+	nodes        map[string]*Node
+	slots        [SlotCount]*Node
+	currentEpoch int64
+	// migrating / importing 为 CLUSTER SETSLOT MIGRATING / IMPORTING 设置的迁移状态
+	migrating map[int]*Node
+	importing map[int]*Node
+
+	busListener net.Listener
+	busConns    sync.Map
+	stopChan    chan struct{}
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+}
+
+// New 启用集群模式：读取或创建 nodes.conf，并为 DB 建立槽索引。
+func New(db *database.Db, opts Options) (*Cluster, error) {
+	if opts.NodeTimeout <= 0 {
+		opts.NodeTimeout = 15 * time.Second
+	}
+	if opts.BusPort == 0 {
+		opts.BusPort = opts.Port + busPortOffset
+	}
+	c := &Cluster{
+		db:          db,
+		configFile:  opts.ConfigFile,
+		nodeTimeout: opts.NodeTimeout,
+		nodes:       make(map[string]*Node),
+		migrating:   make(map[int]*Node),
+		importing:   make(map[int]*Node),
+		stopChan:    make(chan struct{}),
+	}
+	if opts.ConfigFile != "" {
+		f, err := os.Open(opts.ConfigFile)
+		switch {
+		case err == nil:
+			err = c.loadConfig(f)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("load cluster config %s: %w", opts.ConfigFile, err)
+			}
+		case !os.IsNotExist(err):
+			return nil, err
+		}
+	}
+	if c.myself == nil {
+		c.myself = &Node{ID: newNodeID()}
+		c.nodes[c.myself.ID] = c.myself
+		log.Printf("[CLUSTER] no cluster config found, I'm %s", c.myself.ID)
+	} else {
+		log.Printf("[CLUSTER] node configuration loaded, I'm %s", c.myself.ID)
+	}
+	// 地址以当前启动参数为准
+	c.myself.IP = opts.AnnounceIP
+	if c.myself.IP == "" {
+		c.myself.IP = "127.0.0.1"
+	}
+	c.myself.Port = opts.Port
+	c.myself.BusPort = opts.BusPort
+
+	c.mu.Lock()
+	err := c.saveConfigLocked()
+	c.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("save cluster config: %w", err)
+	}
+	db.EnableSlotIndex(KeySlot)
+	db.RegisterInfoSection("Cluster", func(b *strings.Builder) {
+		b.WriteString("cluster_enabled:1\r\n")
+	})
+	return c, nil
+}
+
+// MyID 返回本节点 ID。
+func (c *Cluster) MyID() string {
+	return c.myself.ID
+}
+
+// Close 停止总线与心跳协程。
+func (c *Cluster) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stopChan)
+		c.mu.Lock()
+		if c.busListener != nil {
+			_ = c.busListener.Close()
+		}
+		c.mu.Unlock()
+		c.busConns.Range(func(key, _ interface{}) bool {
+			_ = key.(net.Conn).Close()
+			return true
+		})
+		c.wg.Wait()
+	})
+}
+
+// CheckKeys 校验命令的键是否都由本节点负责，cmd 为大写命令名，asking 表示该连接上一条命令为 ASKING。
+// 返回的错误为带错误码的 database.ReplyError（MOVED / ASK / TRYAGAIN / CROSSSLOT / CLUSTERDOWN）。
+func (c *Cluster) CheckKeys(cmd string, args [][]byte, asking bool) error {
+	if cmd == "SELECT" && len(args) == 2 && string(args[1]) != "0" {
+		return errors.New("SELECT is not allowed in cluster mode")
+	}
+	keys := hashslot.KeysOf(cmd, args)
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := KeySlot(string(keys[0]))
+	for _, key := range keys[1:] {
+		if KeySlot(string(key)) != slot {
+			return database.MakeReplyError("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+
+	// MIGRATE 发出的 RESTORE-ASKING 隐含 ASKING
+	asking = asking || cmd == "RESTORE-ASKING"
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	owner := c.slots[slot]
+	if owner == c.myself {
+		// 迁移中的槽：键已不在本地（已迁走或尚未创建）时让客户端去目标节点
+		if target := c.migrating[slot]; target != nil {
+			if missing := c.missingKeys(keys); missing == len(keys) {
+				return database.MakeReplyError(fmt.Sprintf("ASK %d %s", slot, target.Addr()))
+			} else if missing > 0 {
+				return database.MakeReplyError("TRYAGAIN Multiple keys request during rehashing of slot")
+			}
+		}
+		return nil
+	}
+	if asking && c.importing[slot] != nil {
+		if len(keys) > 1 && c.missingKeys(keys) > 0 {
+			return database.MakeReplyError("TRYAGAIN Multiple keys request during rehashing of slot")
+		}
+		return nil
+	}
+	if owner == nil {
+		return database.MakeReplyError("CLUSTERDOWN Hash slot not served")
+	}
+	return database.MakeReplyError(fmt.Sprintf("MOVED %d %s", slot, owner.Addr()))
+}
+
+// missingKeys 返回不在本地 DB 中的键数量。
+func (c *Cluster) missingKeys(keys [][]byte) int {
+	dict, _ := c.db.GetDict(0)
+	missing := 0
+	for _, key := range keys {
+		if _, ok := dict.Get(string(key)); !ok {
+			missing++
+		}
+	}
+	return missing
+}
+
+// Exec 实现 CLUSTER 子命令。
+func (c *Cluster) Exec(args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, errors.New("wrong number of arguments for 'cluster'")
+	}
+	sub := strings.ToUpper(string(args[1]))
+	args = args[2:]
+	switch sub {
+	case "MYID":
+		return []byte(c.myself.ID), nil
+	case "KEYSLOT":
+		if len(args) != 1 {
+			return nil, wrongArgs(sub)
+		}
+		return KeySlot(string(args[0])), nil
+	case "COUNTKEYSINSLOT":
+		if len(args) != 1 {
+			return nil, wrongArgs(sub)
+		}
+		slot, err := parseSlot(args[0])
+		if err != nil {
+			return nil, err
+		}
+		dict, _ := c.db.GetDict(0)
+		return dict.CountKeysInSlot(slot), nil
+	case "GETKEYSINSLOT":
+		if len(args) != 2 {
+			return nil, wrongArgs(sub)
+		}
+		slot, err := parseSlot(args[0])
+		if err != nil {
+			return nil, err
+		}
+		count, err := strconv.Atoi(string(args[1]))
+		if err != nil || count < 0 {
+			return nil, errors.New("Invalid number of keys")
+		}
+		dict, _ := c.db.GetDict(0)
+		keys := dict.KeysInSlot(slot, count)
+		out := make([][]byte, len(keys))
+		for i, key := range keys {
+			out[i] = []byte(key)
+		}
+		return resp.MakeArrayReply(out), nil
+	case "ADDSLOTS":
+		if len(args) == 0 {
+			return nil, wrongArgs(sub)
+		}
+		return c.addSlots(args)
+	case "SETSLOT":
+		if len(args) < 2 {
+			return nil, wrongArgs(sub)
+		}
+		return c.setSlot(args)
+	case "MEET":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, wrongArgs(sub)
+		}
+		return c.meet(args)
+	}
+
+	if len(args) != 0 {
+		return nil, wrongArgs(sub)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	switch sub {
+	case "NODES":
+		var b strings.Builder
+		for _, node := range c.sortedNodesLocked() {
+			b.WriteString(c.nodeLineLocked(node))
+			b.WriteString("\n")
+		}
+		return []byte(b.String()), nil
+	case "SLOTS":
+		return c.slotsReplyLocked(), nil
+	case "SHARDS":
+		return c.shardsReplyLocked(), nil
+	case "INFO":
+		return []byte(c.infoLocked()), nil
+	}
+	return nil, fmt.Errorf("unknown subcommand '%s'. Try CLUSTER HELP.", strings.ToLower(sub))
+}
+
+func wrongArgs(sub string) error {
+	return fmt.Errorf("wrong number of arguments for 'cluster|%s' command", strings.ToLower(sub))
+}
+
+func parseSlot(arg []byte) (int, error) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, errors.New("Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+// addSlots 实现 CLUSTER ADDSLOTS slot [slot ...]：任一槽非法或已被分配时整条命令失败。
+func (c *Cluster) addSlots(args [][]byte) (interface{}, error) {
+	slots := make([]int, 0, len(args))
+	seen := make(map[int]bool, len(args))
+	for _, arg := range args {
+		slot, err := parseSlot(arg)
+		if err != nil {
+			return nil, err
+		}
+		if seen[slot] {
+			return nil, fmt.Errorf("Slot %d specified multiple times", slot)
+		}
+		seen[slot] = true
+		slots = append(slots, slot)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, slot := range slots {
+		if c.slots[slot] != nil {
+			return nil, fmt.Errorf("Slot %d is already busy", slot)
+		}
+	}
+	for _, slot := range slots {
+		c.slots[slot] = c.myself
+	}
+	if err := c.saveConfigLocked(); err != nil {
+		log.Printf("[CLUSTER] save cluster config failed: %v", err)
+	}
+	return "OK", nil
+}
+
+// sortedNodesLocked 按 ID 排序返回所有节点，使 CLUSTER NODES 与 nodes.conf 输出稳定。
+func (c *Cluster) sortedNodesLocked() []*Node {
+	nodes := make([]*Node, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// replicasLocked 返回主节点 master 的副本。
+func (c *Cluster) replicasLocked(master *Node) []*Node {
+	var replicas []*Node
+	for _, node := range c.sortedNodesLocked() {
+		if node.MasterID == master.ID {
+			replicas = append(replicas, node)
+		}
+	}
+	return replicas
+}
+
+// slotsReplyLocked 生成 CLUSTER SLOTS 应答：每个连续槽区间一项 [start, end, [ip, port, id], 副本...]。
+func (c *Cluster) slotsReplyLocked() *resp.MultiBulkReply {
+	out := []_interface.Reply{}
+	for _, node := range c.sortedNodesLocked() {
+		if node.MasterID != "" {
+			continue
+		}
+		for _, r := range c.slotRangesLocked(node) {
+			item := []_interface.Reply{integer(int64(r[0])), integer(int64(r[1])), nodeEndpoint(node)}
+			for _, replica := range c.replicasLocked(node) {
+				item = append(item, nodeEndpoint(replica))
+			}
+			out = append(out, multi(item...))
+		}
+	}
+	return multi(out...)
+}
+
+func nodeEndpoint(node *Node) *resp.MultiBulkReply {
+	return multi(bulk(node.IP), integer(int64(node.Port)), bulk(node.ID))
+}
+
+// shardsReplyLocked 生成 CLUSTER SHARDS 应答：每个分片为 slots / nodes 两个字段，
+// slots 为区间端点的平铺列表，每个节点为字段-值平铺列表。
+func (c *Cluster) shardsReplyLocked() *resp.MultiBulkReply {
+	out := []_interface.Reply{}
+	for _, master := range c.sortedNodesLocked() {
+		if master.MasterID != "" {
+			continue
+		}
+		slots := []_interface.Reply{}
+		for _, r := range c.slotRangesLocked(master) {
+			slots = append(slots, integer(int64(r[0])), integer(int64(r[1])))
+		}
+		nodes := []_interface.Reply{shardNode(master, "master")}
+		for _, replica := range c.replicasLocked(master) {
+			nodes = append(nodes, shardNode(replica, "replica"))
+		}
+		out = append(out, multi(bulk("slots"), multi(slots...), bulk("nodes"), multi(nodes...)))
+	}
+	return multi(out...)
+}
+
+func shardNode(node *Node, role string) *resp.MultiBulkReply {
+	return multi(
+		bulk("id"), bulk(node.ID),
+		bulk("port"), integer(int64(node.Port)),
+		bulk("ip"), bulk(node.IP),
+		bulk("endpoint"), bulk(node.IP),
+		bulk("role"), bulk(role),
+		bulk("replication-offset"), integer(0),
+		bulk("health"), bulk("online"),
+	)
+}
+
+func (c *Cluster) infoLocked() string {
+	assigned, pfail, fail := 0, 0, 0
+	for _, owner := range c.slots {
+		switch {
+		case owner == nil:
+			continue
+		case owner.fail:
+			fail++
+		case owner.pfail:
+			pfail++
+		}
+		assigned++
+	}
+	state := "ok"
+	if assigned < SlotCount || fail > 0 {
+		state = "fail"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "cluster_state:%s\r\n", state)
+	fmt.Fprintf(&b, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(&b, "cluster_slots_ok:%d\r\n", assigned-pfail-fail)
+	fmt.Fprintf(&b, "cluster_slots_pfail:%d\r\n", pfail)
+	fmt.Fprintf(&b, "cluster_slots_fail:%d\r\n", fail)
+	fmt.Fprintf(&b, "cluster_known_nodes:%d\r\n", len(c.nodes))
+	fmt.Fprintf(&b, "cluster_size:%d\r\n", c.sizeLocked())
+	fmt.Fprintf(&b, "cluster_current_epoch:%d\r\n", c.currentEpoch)
+	fmt.Fprintf(&b, "cluster_my_epoch:%d\r\n", c.myself.ConfigEpoch)
+	return b.String()
+}
+
+// meet 实现 CLUSTER MEET ip port [bus-port]：以临时 ID 加入握手节点，收到对方 PONG 后换成其真实 ID。
+func (c *Cluster) meet(args [][]byte) (interface{}, error) {
+	ip := string(args[0])
+	if net.ParseIP(ip) == nil {
+		return nil, fmt.Errorf("Invalid node address specified: %s", ip)
+	}
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("Invalid base port specified: %s", args[1])
+	}
+	busPort := port + busPortOffset
+	if len(args) == 3 {
+		if busPort, err = strconv.Atoi(string(args[2])); err != nil || busPort <= 0 || busPort > 65535 {
+			return nil, fmt.Errorf("Invalid bus port specified: %s", args[2])
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.busListener == nil {
+		return nil, errors.New("cluster bus is not running")
+	}
+	c.addNodeLocked(newNodeID(), ip, port, busPort, true)
+	return "OK", nil
+}
+
+// setSlot 实现 CLUSTER SETSLOT slot IMPORTING node-id | MIGRATING node-id | NODE node-id | STABLE。
+// 迁移一个槽的流程与 redis-cli 相同：目标 IMPORTING，源 MIGRATING，逐批 MIGRATE 键，
+// 最后在目标与源上执行 NODE <目标>。目标执行 NODE 时递增 config epoch，新归属随心跳扩散到整个集群。
+func (c *Cluster) setSlot(args [][]byte) (interface{}, error) {
+	slot, err := parseSlot(args[0])
+	if err != nil {
+		return nil, err
+	}
+	action := strings.ToUpper(string(args[1]))
+	if (action == "STABLE") != (len(args) == 2) || len(args) > 3 {
+		return nil, errors.New("Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var node *Node
+	if len(args) == 3 {
+		if node = c.nodes[string(args[2])]; node == nil || node.handshake {
+			return nil, fmt.Errorf("I don't know about node %s", args[2])
+		}
+		if !node.isMaster() {
+			return nil, errors.New("Target node is not a master")
+		}
+	}
+	switch action {
+	case "STABLE":
+		delete(c.migrating, slot)
+		delete(c.importing, slot)
+	case "MIGRATING":
+		if c.slots[slot] != c.myself {
+			return nil, fmt.Errorf("I'm not the owner of hash slot %d", slot)
+		}
+		if node == c.myself {
+			return nil, errors.New("I'm already the owner of the target node")
+		}
+		c.migrating[slot] = node
+	case "IMPORTING":
+		if c.slots[slot] == c.myself {
+			return nil, fmt.Errorf("I'm already the owner of hash slot %d", slot)
+		}
+		if node == c.myself {
+			return nil, errors.New("Target node is myself")
+		}
+		c.importing[slot] = node
+	case "NODE":
+		dict, _ := c.db.GetDict(0)
+		if c.slots[slot] == c.myself && node != c.myself && dict.CountKeysInSlot(slot) > 0 {
+			return nil, fmt.Errorf("Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)
+		}
+		if node != c.myself {
+			delete(c.migrating, slot)
+		}
+		if node == c.myself && c.importing[slot] != nil {
+			delete(c.importing, slot)
+			// 不经投票直接递增 config epoch，使本节点对该槽的声明胜过原主节点
+			c.currentEpoch++
+			c.myself.ConfigEpoch = c.currentEpoch
+			log.Printf("[CLUSTER] configEpoch updated to %d after importing slot %d", c.currentEpoch, slot)
+		}
+		c.slots[slot] = node
+	default:
+		return nil, errors.New("Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+	if err := c.saveConfigLocked(); err != nil {
+		log.Printf("[CLUSTER] save cluster config failed: %v", err)
+	}
+	return "OK", nil
+}
@@ -0,0 +1,270 @@
+package cluster
+
+import (
+	"MiddlewareSelf/redis/database"
+	"MiddlewareSelf/redis/resp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	nodeA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	nodeB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	nodeC = "cccccccccccccccccccccccccccccccccccccccc"
+)
+
+// newTestCluster 以 nodes.conf 内容启动本节点（A，端口 7000）。
+func newTestCluster(t *testing.T, nodesConf string) (*Cluster, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nodes.conf")
+	if nodesConf != "" {
+		if err := os.WriteFile(path, []byte(nodesConf), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := New(database.MakeDbs(), Options{ConfigFile: path, AnnounceIP: "127.0.0.1", Port: 7000})
+	if err != nil {
+		t.Fatalf("new cluster: %v", err)
+	}
+	return c, path
+}
+
+const threeNodes = nodeA + " 127.0.0.1:7000@17000 myself,master - 0 0 1 connected 0-8191\n" +
+	nodeB + " 127.0.0.1:7001@17001 master - 0 0 2 connected 8192-16383\n" +
+	nodeC + " 127.0.0.1:7002@17002 slave " + nodeB + " 0 0 2 connected\n" +
+	"vars currentEpoch 2 lastVoteEpoch 0\n"
+
+func replyCode(err error) string {
+	var replyErr *database.ReplyError
+	if errors.As(err, &replyErr) {
+		return replyErr.Msg
+	}
+	if err != nil {
+		return "ERR " + err.Error()
+	}
+	return ""
+}
+
+func TestCheckKeys(t *testing.T) {
+	c, _ := newTestCluster(t, threeNodes)
+	cmd := func(s string) [][]byte {
+		return args(strings.Fields(s)...)
+	}
+
+	// foo -> 12182 属于 B
+	if got := replyCode(c.CheckKeys("GET", cmd("GET foo"), false)); got != "MOVED 12182 127.0.0.1:7001" {
+		t.Fatalf("GET foo: %q", got)
+	}
+	// bar -> 5061 属于本节点
+	if err := c.CheckKeys("SET", cmd("SET bar v"), false); err != nil {
+		t.Fatalf("SET bar: %v", err)
+	}
+	if err := c.CheckKeys("DEL", cmd("DEL {bar}1 {bar}2"), false); err != nil {
+		t.Fatalf("DEL with same hashtag: %v", err)
+	}
+	if got := replyCode(c.CheckKeys("DEL", cmd("DEL foo bar"), false)); !strings.HasPrefix(got, "CROSSSLOT") {
+		t.Fatalf("DEL foo bar: %q", got)
+	}
+	if err := c.CheckKeys("PING", cmd("PING"), false); err != nil {
+		t.Fatalf("PING: %v", err)
+	}
+	if got := replyCode(c.CheckKeys("SELECT", cmd("SELECT 1"), false)); got != "ERR SELECT is not allowed in cluster mode" {
+		t.Fatalf("SELECT 1: %q", got)
+	}
+}
+
+func TestClusterSlotsAndNodes(t *testing.T) {
+	c, path := newTestCluster(t, threeNodes)
+
+	reply, err := c.Exec(args("CLUSTER", "SLOTS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := func(port int, id string) string {
+		return fmt.Sprintf("*3\r\n$9\r\n127.0.0.1\r\n:%d\r\n$40\r\n%s\r\n", port, id)
+	}
+	want := "*2\r\n" +
+		"*3\r\n:0\r\n:8191\r\n" + endpoint(7000, nodeA) +
+		"*4\r\n:8192\r\n:16383\r\n" + endpoint(7001, nodeB) + endpoint(7002, nodeC)
+	if got := string(reply.(*resp.MultiBulkReply).ToBytes()); got != want {
+		t.Fatalf("CLUSTER SLOTS = %q, want %q", got, want)
+	}
+
+	nodes, err := c.Exec(args("CLUSTER", "NODES"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(nodes.([]byte))), "\n")
+	if len(lines) != 3 || lines[0] != nodeA+" 127.0.0.1:7000@17000 myself,master - 0 0 1 connected 0-8191" {
+		t.Fatalf("CLUSTER NODES = %q", lines)
+	}
+	if lines[2] != nodeC+" 127.0.0.1:7002@17002 slave "+nodeB+" 0 0 2 connected" {
+		t.Fatalf("replica line = %q", lines[2])
+	}
+
+	// nodes.conf 重新加载后拓扑不变
+	saved, _ := os.ReadFile(path)
+	if string(saved) != threeNodes {
+		t.Fatalf("nodes.conf = %q", saved)
+	}
+}
+
+func TestAddSlotsAndKeyIndex(t *testing.T) {
+	c, path := newTestCluster(t, "")
+	if len(c.MyID()) != 40 {
+		t.Fatalf("MYID = %q", c.MyID())
+	}
+	if _, err := c.Exec(args("CLUSTER", "ADDSLOTS", "1", "2", "1")); err == nil {
+		t.Fatal("expect error for duplicated slot")
+	}
+	if _, err := c.Exec(args("CLUSTER", "ADDSLOTS", "5061", "5062", "5063")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Exec(args("CLUSTER", "ADDSLOTS", "5062")); err == nil || !strings.Contains(err.Error(), "busy") {
+		t.Fatalf("ADDSLOTS busy slot: %v", err)
+	}
+	saved, _ := os.ReadFile(path)
+	if !strings.Contains(string(saved), "myself,master - 0 0 0 connected 5061-5063\n") {
+		t.Fatalf("nodes.conf = %q", saved)
+	}
+
+	for _, key := range []string{"{bar}1", "{bar}2", "bar"} {
+		if _, err := c.db.Exec(0, args("SET", key, "v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := c.Exec(args("CLUSTER", "COUNTKEYSINSLOT", "5061")); n != 3 {
+		t.Fatalf("COUNTKEYSINSLOT = %v", n)
+	}
+	keys, _ := c.Exec(args("CLUSTER", "GETKEYSINSLOT", "5061", "2"))
+	if got := string(keys.(interface{ ToBytes() []byte }).ToBytes()); got != "*2\r\n$3\r\nbar\r\n$6\r\n{bar}1\r\n" {
+		t.Fatalf("GETKEYSINSLOT = %q", got)
+	}
+	if _, err := c.db.Exec(0, args("DEL", "{bar}1")); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Exec(args("CLUSTER", "COUNTKEYSINSLOT", "5061")); n != 2 {
+		t.Fatalf("COUNTKEYSINSLOT after DEL = %v", n)
+	}
+	if _, err := c.db.Exec(0, args("FLUSHALL")); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Exec(args("CLUSTER", "COUNTKEYSINSLOT", "5061")); n != 0 {
+		t.Fatalf("COUNTKEYSINSLOT after FLUSHALL = %v", n)
+	}
+	info, _ := c.Exec(args("CLUSTER", "INFO"))
+	if !strings.Contains(string(info.([]byte)), "cluster_slots_assigned:3\r\n") {
+		t.Fatalf("CLUSTER INFO = %q", info)
+	}
+}
+
+func args(parts ...string) [][]byte {
+	out := make([][]byte, len(parts))
+	for i, p := range parts {
+		out[i] = []byte(p)
+	}
+	return out
+}
+
+func TestSetSlotMigration(t *testing.T) {
+	c, path := newTestCluster(t, threeNodes)
+	setSlot := func(parts ...string) error {
+		_, err := c.Exec(args(append([]string{"CLUSTER", "SETSLOT"}, parts...)...))
+		return err
+	}
+	check := func(asking bool, parts ...string) string {
+		return replyCode(c.CheckKeys(strings.ToUpper(parts[0]), args(parts...), asking))
+	}
+
+	// 源节点：bar -> 5061 迁往 B
+	if err := setSlot("12182", "MIGRATING", nodeB); err == nil {
+		t.Fatal("MIGRATING a slot I don't own should fail")
+	}
+	if err := setSlot("5061", "MIGRATING", nodeB); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(false, "GET", "bar"); got != "ASK 5061 127.0.0.1:7001" {
+		t.Fatalf("GET missing key in migrating slot: %q", got)
+	}
+	if _, err := c.db.Exec(0, args("SET", "bar", "v")); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(false, "GET", "bar"); got != "" {
+		t.Fatalf("GET existing key in migrating slot: %q", got)
+	}
+	if got := check(false, "DEL", "bar", "{bar}1"); !strings.HasPrefix(got, "TRYAGAIN") {
+		t.Fatalf("DEL partially migrated keys: %q", got)
+	}
+	saved, _ := os.ReadFile(path)
+	if !strings.Contains(string(saved), " 0-8191 [5061->-"+nodeB+"]\n") {
+		t.Fatalf("nodes.conf = %q", saved)
+	}
+	if err := setSlot("5061", "NODE", nodeB); err == nil || !strings.Contains(err.Error(), "still hold keys") {
+		t.Fatalf("NODE with keys left: %v", err)
+	}
+	if _, err := c.db.Exec(0, args("DEL", "bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := setSlot("5061", "NODE", nodeB); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(false, "GET", "bar"); got != "MOVED 5061 127.0.0.1:7001" {
+		t.Fatalf("GET after slot moved: %q", got)
+	}
+
+	// 目标节点：foo -> 12182 从 B 导入，只接受 ASKING 请求
+	if err := setSlot("12182", "IMPORTING", nodeB); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(false, "GET", "foo"); got != "MOVED 12182 127.0.0.1:7001" {
+		t.Fatalf("GET without ASKING: %q", got)
+	}
+	if got := check(true, "GET", "foo"); got != "" {
+		t.Fatalf("GET with ASKING: %q", got)
+	}
+	if got := check(false, "RESTORE-ASKING", "foo", "0", "payload"); got != "" {
+		t.Fatalf("RESTORE-ASKING: %q", got)
+	}
+	if err := setSlot("12182", "NODE", nodeA); err != nil {
+		t.Fatal(err)
+	}
+	if c.currentEpoch != 3 || c.myself.ConfigEpoch != 3 {
+		t.Fatalf("epoch after import: current %d, config %d", c.currentEpoch, c.myself.ConfigEpoch)
+	}
+	if got := check(false, "GET", "foo"); got != "" {
+		t.Fatalf("GET after import: %q", got)
+	}
+	if err := setSlot("1", "NODE", "unknown"); err == nil {
+		t.Fatal("NODE with unknown id should fail")
+	}
+}
+
+func TestBusMessageRoundTrip(t *testing.T) {
+	c, _ := newTestCluster(t, threeNodes)
+	c.nodes[nodeB].pfail = true
+	m := c.buildMessageLocked(msgPing, nil)
+	got, err := decodeMessage(m.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.sender != nodeA || got.port != 7000 || got.busPort != 17000 || got.configEpoch != 1 || got.currentEpoch != 2 {
+		t.Fatalf("decoded header = %+v", got)
+	}
+	if !got.claims(0) || !got.claims(8191) || got.claims(8192) {
+		t.Fatal("slot bitmap mismatch")
+	}
+	flags := make(map[string]string)
+	for _, g := range got.gossip {
+		flags[g.id] = g.flags
+	}
+	if len(flags) != 2 || flags[nodeB] != "pfail" || flags[nodeC] != "" {
+		t.Fatalf("gossip = %+v", got.gossip)
+	}
+	if _, err := decodeMessage(args("PING", nodeA)); err == nil {
+		t.Fatal("expect error for truncated message")
+	}
+}
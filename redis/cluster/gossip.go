@@ -0,0 +1,387 @@
+package cluster
+
+import (
+	"log"
+	"math/rand"
+	"time"
+)
+
+// 心跳与故障检测，规则与 Redis 一致：
+// - 每个已知节点一个心跳协程，定期发送 PING（握手中的节点发送 MEET），对方以 PONG 应答；
+// - 超过 node timeout 未收到对方消息即标记 PFAIL，并在 gossip 中告知其它节点；
+// - 主节点在 gossip 中报告的 PFAIL/FAIL 记为故障报告，有效报告（含自己）达到持有槽的主节点多数时
+//   标记 FAIL 并广播 FAIL 消息；
+// - 消息头携带发送方的 config epoch 与槽位图，槽归属以 config epoch 较大者为准，
+//   两个主节点 config epoch 相同时 ID 较小者递增自己的 epoch。
+
+// cronPeriod 为故障检测周期，对应 Redis clusterCron 的 10Hz。
+const cronPeriod = 100 * time.Millisecond
+
+func (c *Cluster) pingInterval() time.Duration {
+	if interval := c.nodeTimeout / 4; interval < time.Second {
+		return interval
+	}
+	return time.Second
+}
+
+// addNodeLocked 加入一个节点，总线已启动时立即开始心跳。
+func (c *Cluster) addNodeLocked(id, ip string, port, busPort int, handshake bool) *Node {
+	node := &Node{ID: id, IP: ip, Port: port, BusPort: busPort, handshake: handshake}
+	c.nodes[id] = node
+	if c.busListener != nil {
+		c.startNodeLocked(node)
+	}
+	return node
+}
+
+func (c *Cluster) startNodeLocked(node *Node) {
+	now := time.Now()
+	node.created = now
+	node.pongReceived = now
+	node.failReports = make(map[string]time.Time)
+	node.stop = make(chan struct{})
+	c.wg.Add(1)
+	go c.runNodeLink(node)
+}
+
+func (c *Cluster) removeNodeLocked(node *Node) {
+	delete(c.nodes, node.ID)
+	if node.stop != nil {
+		close(node.stop)
+	}
+	for slot, owner := range c.slots {
+		if owner == node {
+			c.slots[slot] = nil
+		}
+	}
+}
+
+// runNodeLink 定期向 node 发送 PING / MEET 并处理其 PONG，直到节点被移除或集群关闭。
+func (c *Cluster) runNodeLink(node *Node) {
+	defer c.wg.Done()
+	l := &busLink{}
+	defer l.close()
+	ticker := time.NewTicker(c.pingInterval())
+	defer ticker.Stop()
+	for {
+		c.mu.Lock()
+		typ := msgPing
+		if node.handshake {
+			typ = msgMeet
+		}
+		if addr := node.busAddr(); addr != l.addr {
+			l.close()
+			l.addr = addr
+		}
+		m := c.buildMessageLocked(typ, node)
+		c.mu.Unlock()
+
+		if reply, err := l.send(c.nodeTimeout/2, m); err == nil {
+			c.processMessage(reply, node)
+		}
+
+		select {
+		case <-node.stop:
+			return
+		case <-c.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// buildMessageLocked 生成本节点的消息头与 gossip 区。gossip 随机选取最多 max(3, N/10) 个节点，
+// 处于 PFAIL/FAIL 的节点总是包含在内，使故障报告尽快扩散。
+func (c *Cluster) buildMessageLocked(typ string, target *Node) *message {
+	m := &message{
+		typ:          typ,
+		sender:       c.myself.ID,
+		ip:           c.myself.IP,
+		port:         c.myself.Port,
+		busPort:      c.myself.BusPort,
+		masterID:     c.myself.MasterID,
+		configEpoch:  c.myself.ConfigEpoch,
+		currentEpoch: c.currentEpoch,
+		slots:        make([]byte, SlotCount/8),
+	}
+	for slot, owner := range c.slots {
+		if owner == c.myself {
+			m.slots[slot/8] |= 1 << (slot % 8)
+		}
+	}
+
+	var candidates []*Node
+	for _, node := range c.nodes {
+		if node != c.myself && node != target && !node.handshake {
+			candidates = append(candidates, node)
+		}
+	}
+	wanted := len(candidates) / 10
+	if wanted < 3 {
+		wanted = 3
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	for i, node := range candidates {
+		if i >= wanted && !node.pfail && !node.fail {
+			continue
+		}
+		flags := ""
+		switch {
+		case node.fail:
+			flags = "fail"
+		case node.pfail:
+			flags = "pfail"
+		}
+		m.gossip = append(m.gossip, gossipEntry{id: node.ID, ip: node.IP, port: node.Port, busPort: node.BusPort, flags: flags})
+	}
+	return m
+}
+
+// processMessage 处理收到的消息。linkNode 非空时 m 是 linkNode 对本节点 PING / MEET 的 PONG 应答。
+func (c *Cluster) processMessage(m *message, linkNode *Node) {
+	c.mu.Lock()
+	sender := c.nodes[m.sender]
+	if linkNode != nil && linkNode.handshake {
+		if _, stillKnown := c.nodes[linkNode.ID]; !stillKnown {
+			c.mu.Unlock()
+			return
+		}
+		if sender != nil || m.sender == c.myself.ID {
+			// 已经通过其它途径认识该节点（或 MEET 了自己），丢弃握手节点
+			c.removeNodeLocked(linkNode)
+		} else {
+			delete(c.nodes, linkNode.ID)
+			linkNode.ID = m.sender
+			linkNode.handshake = false
+			c.nodes[m.sender] = linkNode
+			sender = linkNode
+			log.Printf("[CLUSTER] handshake with node %s completed", m.sender)
+		}
+	}
+	if sender == nil && m.typ == msgMeet && m.sender != c.myself.ID {
+		sender = c.addNodeLocked(m.sender, m.ip, m.port, m.busPort, false)
+		log.Printf("[CLUSTER] node %s (%s:%d) joined via MEET", m.sender, m.ip, m.port)
+	}
+	// 未知节点的 PING 只应答不处理，等其它节点通过 gossip 介绍
+	if sender == nil || sender == c.myself {
+		c.mu.Unlock()
+		return
+	}
+
+	lost, changed := c.updateSenderLocked(sender, m)
+	if m.typ == msgFail {
+		if failing := c.nodes[m.failing]; failing != nil && failing != c.myself && !failing.fail {
+			log.Printf("[CLUSTER] FAIL message received from %s about %s", sender.ID, failing.ID)
+			failing.fail, failing.pfail = true, false
+			changed = true
+		}
+	}
+	c.processGossipLocked(sender, m.gossip)
+	if changed {
+		if err := c.saveConfigLocked(); err != nil {
+			log.Printf("[CLUSTER] save cluster config failed: %v", err)
+		}
+	}
+	c.mu.Unlock()
+	c.dropSlotKeys(lost)
+}
+
+// updateSenderLocked 用消息头更新发送方的状态与槽归属，返回本节点失去的槽以及配置是否变化。
+func (c *Cluster) updateSenderLocked(sender *Node, m *message) ([]int, bool) {
+	changed := false
+	sender.pongReceived = time.Now()
+	if sender.pfail || sender.fail {
+		log.Printf("[CLUSTER] clear FAIL state for node %s: it is reachable again", sender.ID)
+		sender.pfail, sender.fail = false, false
+		changed = true
+	}
+	if sender.IP != m.ip || sender.Port != m.port || sender.BusPort != m.busPort {
+		sender.IP, sender.Port, sender.BusPort = m.ip, m.port, m.busPort
+		changed = true
+	}
+	if m.currentEpoch > c.currentEpoch {
+		c.currentEpoch = m.currentEpoch
+		changed = true
+	}
+	if sender.MasterID != m.masterID || sender.ConfigEpoch != m.configEpoch {
+		sender.MasterID, sender.ConfigEpoch = m.masterID, m.configEpoch
+		changed = true
+	}
+	if !sender.isMaster() {
+		return nil, changed
+	}
+
+	var lost []int
+	for slot := 0; slot < SlotCount; slot++ {
+		if !m.claims(slot) || c.slots[slot] == sender {
+			continue
+		}
+		// 正在导入的槽由 CLUSTER SETSLOT 决定归属
+		if c.importing[slot] != nil {
+			continue
+		}
+		owner := c.slots[slot]
+		if owner != nil && owner.ConfigEpoch >= m.configEpoch {
+			continue
+		}
+		if owner == c.myself {
+			lost = append(lost, slot)
+			delete(c.migrating, slot)
+		}
+		c.slots[slot] = sender
+		changed = true
+	}
+	if len(lost) > 0 {
+		log.Printf("[CLUSTER] %d slots taken over by node %s (config epoch %d)", len(lost), sender.ID, m.configEpoch)
+	}
+
+	if c.myself.isMaster() && m.configEpoch == c.myself.ConfigEpoch && sender.ID > c.myself.ID {
+		c.currentEpoch++
+		c.myself.ConfigEpoch = c.currentEpoch
+		log.Printf("[CLUSTER] configEpoch collision with node %s, configEpoch set to %d", sender.ID, c.currentEpoch)
+		changed = true
+	}
+	return lost, changed
+}
+
+// processGossipLocked 处理 gossip 区：记录主节点的故障报告，并认识新节点。
+func (c *Cluster) processGossipLocked(sender *Node, entries []gossipEntry) {
+	now := time.Now()
+	for _, g := range entries {
+		if g.id == c.myself.ID {
+			continue
+		}
+		node := c.nodes[g.id]
+		if node == nil {
+			if g.flags == "" {
+				c.addNodeLocked(g.id, g.ip, g.port, g.busPort, false)
+				log.Printf("[CLUSTER] discovered node %s (%s:%d) from %s", g.id, g.ip, g.port, sender.ID)
+			}
+			continue
+		}
+		if node.handshake || node.failReports == nil || !sender.isMaster() {
+			continue
+		}
+		if g.flags != "" {
+			node.failReports[sender.ID] = now
+		} else {
+			delete(node.failReports, sender.ID)
+		}
+	}
+}
+
+// cron 定期检测节点超时并在故障报告足够时标记 FAIL。
+func (c *Cluster) cron() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(cronPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		var broadcasts []*message
+		var targets []string
+		c.mu.Lock()
+		changed := false
+		for _, node := range c.nodes {
+			if node == c.myself || node.failReports == nil {
+				continue
+			}
+			if node.handshake {
+				if now.Sub(node.created) > c.nodeTimeout {
+					log.Printf("[CLUSTER] handshake with %s timed out", node.busAddr())
+					c.removeNodeLocked(node)
+				}
+				continue
+			}
+			for reporter, at := range node.failReports {
+				if now.Sub(at) > 2*c.nodeTimeout {
+					delete(node.failReports, reporter)
+				}
+			}
+			if !node.pfail && !node.fail && now.Sub(node.pongReceived) > c.nodeTimeout {
+				log.Printf("[CLUSTER] *** NODE %s possibly failing", node.ID)
+				node.pfail = true
+			}
+			if node.pfail && !node.fail && c.failureQuorumLocked(node) {
+				log.Printf("[CLUSTER] marking node %s as failing (quorum reached)", node.ID)
+				node.fail, node.pfail = true, false
+				changed = true
+				m := c.buildMessageLocked(msgFail, nil)
+				m.failing = node.ID
+				broadcasts = append(broadcasts, m)
+			}
+		}
+		if len(broadcasts) > 0 {
+			for _, node := range c.nodes {
+				if node != c.myself && !node.handshake && !node.fail {
+					targets = append(targets, node.busAddr())
+				}
+			}
+		}
+		if changed {
+			if err := c.saveConfigLocked(); err != nil {
+				log.Printf("[CLUSTER] save cluster config failed: %v", err)
+			}
+		}
+		c.mu.Unlock()
+
+		for _, m := range broadcasts {
+			for _, addr := range targets {
+				c.wg.Add(1)
+				go func(addr string, m *message) {
+					defer c.wg.Done()
+					_ = sendOnce(addr, c.nodeTimeout/2, m)
+				}(addr, m)
+			}
+		}
+	}
+}
+
+// failureQuorumLocked 判断 node 的故障报告（含本节点，若为主节点）是否达到持有槽的主节点多数。
+func (c *Cluster) failureQuorumLocked(node *Node) bool {
+	failures := 0
+	if c.myself.isMaster() {
+		failures++
+	}
+	for reporter := range node.failReports {
+		if n := c.nodes[reporter]; n != nil && n.isMaster() {
+			failures++
+		}
+	}
+	return failures >= c.sizeLocked()/2+1
+}
+
+// sizeLocked 返回持有至少一个槽的主节点数。
+func (c *Cluster) sizeLocked() int {
+	masters := make(map[*Node]bool)
+	for _, owner := range c.slots {
+		if owner != nil {
+			masters[owner] = true
+		}
+	}
+	return len(masters)
+}
+
+// dropSlotKeys 删除本节点已失去的槽中残留的键。
+func (c *Cluster) dropSlotKeys(slots []int) {
+	dict, _ := c.db.GetDict(0)
+	for _, slot := range slots {
+		keys := dict.KeysInSlot(slot, dict.CountKeysInSlot(slot))
+		if len(keys) == 0 {
+			continue
+		}
+		log.Printf("[CLUSTER] deleting %d keys of slot %d that is no longer handled by me", len(keys), slot)
+		args := [][]byte{[]byte("DEL")}
+		for _, key := range keys {
+			args = append(args, []byte(key))
+		}
+		if _, err := c.db.Exec(0, args); err != nil {
+			log.Printf("[CLUSTER] delete keys of slot %d failed: %v", slot, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package hashslot
+
+import "strings"
+
+// keySpec 描述命令中键参数的位置：从 first 开始每隔 step 个参数一个键，last 为 -1 表示直到最后一个参数。
+type keySpec struct {
+	first, last, step int
+}
+
+// commandKeys 为涉及键的命令。不在表中的命令（PING、INFO 等）不需要按槽路由。
+var commandKeys = map[string]keySpec{
+	"GET":            {1, 1, 1},
+	"SET":            {1, 1, 1},
+	"SETWITHTTL":     {1, 1, 1},
+	"DEL":            {1, -1, 1},
+	"EXPIRE":         {1, 1, 1},
+	"PEXPIRE":        {1, 1, 1},
+	"EXPIREAT":       {1, 1, 1},
+	"PEXPIREAT":      {1, 1, 1},
+	"TTL":            {1, 1, 1},
+	"PTTL":           {1, 1, 1},
+	"INCRBYFLOAT":    {1, 1, 1},
+	"DUMP":           {1, 1, 1},
+	"RESTORE":        {1, 1, 1},
+	"RESTORE-ASKING": {1, 1, 1},
+}
+
+// KeysOf 返回命令中的键，cmd 为大写命令名。参数个数不足时返回已有的部分，交给命令本身报参数错误。
+func KeysOf(cmd string, args [][]byte) [][]byte {
+	if cmd == "MIGRATE" {
+		return migrateKeys(args)
+	}
+	spec, ok := commandKeys[cmd]
+	if !ok {
+		return nil
+	}
+	last := spec.last
+	if last < 0 || last >= len(args) {
+		last = len(args) - 1
+	}
+	var keys [][]byte
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// migrateKeys 返回 MIGRATE host port key|"" db timeout [...] [KEYS key ...] 中的键。
+func migrateKeys(args [][]byte) [][]byte {
+	if len(args) < 6 {
+		return nil
+	}
+	if len(args[3]) != 0 {
+		return args[3:4]
+	}
+	for i := 6; i < len(args); i++ {
+		if strings.EqualFold(string(args[i]), "KEYS") {
+			return args[i+1:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package hashslot
+
+import "strings"
+
+// SlotCount 为集群的哈希槽数量。
+const SlotCount = 16384
+
+// crc16Table 为 CRC16-CCITT (XMODEM, 多项式 0x1021) 查找表，与 Redis crc16.c 相同。
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^data[i]]
+	}
+	return crc
+}
+
+// KeySlot 计算键所属的槽。键中含有非空的 {hashtag} 时只对第一个 { 与其后第一个 } 之间的内容取哈希，
+// 使 {user1000}.following 与 {user1000}.followers 落在同一个槽。
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) & (SlotCount - 1))
+}
@@ -0,0 +1,42 @@
+package hashslot
+
+import "testing"
+
+func TestKeySlot(t *testing.T) {
+	// CRC16/XMODEM 标准校验值
+	if got := crc16("123456789"); got != 0x31C3 {
+		t.Fatalf("crc16(123456789) = %#x, want 0x31c3", got)
+	}
+	cases := map[string]int{
+		"foo":                  12182,
+		"bar":                  5061,
+		"{user1000}.following": KeySlot("user1000"),
+		"{user1000}.followers": KeySlot("user1000"),
+		// 空 hashtag 与没有闭合的 { 都对整个键取哈希
+		"foo{}{bar}": int(crc16("foo{}{bar}") & (SlotCount - 1)),
+		"foo{bar":    int(crc16("foo{bar") & (SlotCount - 1)),
+		// 只取第一个 {...}
+		"foo{{bar}}zap": KeySlot("{bar"),
+	}
+	for key, want := range cases {
+		if got := KeySlot(key); got != want {
+			t.Errorf("KeySlot(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestKeysOf(t *testing.T) {
+	args := [][]byte{[]byte("DEL"), []byte("a"), []byte("b"), []byte("c")}
+	if keys := KeysOf("DEL", args); len(keys) != 3 || string(keys[2]) != "c" {
+		t.Fatalf("KeysOf(DEL) = %q", keys)
+	}
+	if keys := KeysOf("SET", [][]byte{[]byte("SET"), []byte("k"), []byte("v")}); len(keys) != 1 || string(keys[0]) != "k" {
+		t.Fatalf("KeysOf(SET) = %q", keys)
+	}
+	if keys := KeysOf("GET", [][]byte{[]byte("GET")}); len(keys) != 0 {
+		t.Fatalf("KeysOf(GET) without key = %q", keys)
+	}
+	if keys := KeysOf("PING", [][]byte{[]byte("PING")}); keys != nil {
+		t.Fatalf("KeysOf(PING) = %q", keys)
+	}
+}
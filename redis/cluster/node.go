@@ -0,0 +1,268 @@
+package cluster
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// busPortOffset 为集群总线端口相对客户端端口的偏移，与 Redis 一致。
+const busPortOffset = 10000
+
+// Node 是集群中的一个节点。
+type Node struct {
+	ID      string
+	IP      string
+	Port    int
+	BusPort int
+	// MasterID 为副本所属主节点的 ID，主节点为空
+	MasterID    string
+	ConfigEpoch int64
+
+	// 以下为总线运行状态，由 Cluster.mu 保护
+	// handshake 为 CLUSTER MEET 后尚未收到对方 PONG 的节点，此时 ID 是临时的
+	handshake bool
+	// pfail 为本节点认为其不可达（超过 node timeout 未收到 PONG），fail 为多数主节点确认后的下线状态
+	pfail, fail  bool
+	created      time.Time
+	pongReceived time.Time
+	// failReports 为其它主节点在 gossip 中报告该节点 PFAIL/FAIL 的时间
+	failReports map[string]time.Time
+	stop        chan struct{}
+}
+
+func (n *Node) isMaster() bool {
+	return n.MasterID == ""
+}
+
+// busAddr 返回集群总线地址 ip:busport。
+func (n *Node) busAddr() string {
+	return net.JoinHostPort(n.IP, strconv.Itoa(n.BusPort))
+}
+
+// Addr 返回客户端地址 ip:port。
+func (n *Node) Addr() string {
+	return net.JoinHostPort(n.IP, strconv.Itoa(n.Port))
+}
+
+func newNodeID() string {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%040x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// slotRanges 把槽归属表中属于 node 的槽压缩为闭区间列表。
+func (c *Cluster) slotRangesLocked(node *Node) [][2]int {
+	var ranges [][2]int
+	for slot := 0; slot < SlotCount; slot++ {
+		if c.slots[slot] != node {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1][1] == slot-1 {
+			ranges[n-1][1] = slot
+		} else {
+			ranges = append(ranges, [2]int{slot, slot})
+		}
+	}
+	return ranges
+}
+
+// nodeLineLocked 生成 CLUSTER NODES / nodes.conf 中的一行：
+// <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func (c *Cluster) nodeLineLocked(node *Node) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s:%d@%d ", node.ID, node.IP, node.Port, node.BusPort)
+	if node == c.myself {
+		b.WriteString("myself,")
+	}
+	master := "-"
+	if node.MasterID != "" {
+		b.WriteString("slave")
+		master = node.MasterID
+	} else {
+		b.WriteString("master")
+	}
+	switch {
+	case node.fail:
+		b.WriteString(",fail")
+	case node.pfail:
+		b.WriteString(",fail?")
+	case node.handshake:
+		b.WriteString(",handshake")
+	}
+	linkState := "connected"
+	if node.pfail || node.fail {
+		linkState = "disconnected"
+	}
+	pong := int64(0)
+	if node != c.myself && !node.pongReceived.IsZero() {
+		pong = node.pongReceived.UnixMilli()
+	}
+	fmt.Fprintf(&b, " %s 0 %d %d %s", master, pong, node.ConfigEpoch, linkState)
+	for _, r := range c.slotRangesLocked(node) {
+		if r[0] == r[1] {
+			fmt.Fprintf(&b, " %d", r[0])
+		} else {
+			fmt.Fprintf(&b, " %d-%d", r[0], r[1])
+		}
+	}
+	// 与 Redis 一样在 myself 行末尾列出迁移中的槽
+	if node == c.myself {
+		for _, slot := range sortedSlots(c.migrating) {
+			fmt.Fprintf(&b, " [%d->-%s]", slot, c.migrating[slot].ID)
+		}
+		for _, slot := range sortedSlots(c.importing) {
+			fmt.Fprintf(&b, " [%d-<-%s]", slot, c.importing[slot].ID)
+		}
+	}
+	return b.String()
+}
+
+func sortedSlots(m map[int]*Node) []int {
+	slots := make([]int, 0, len(m))
+	for slot := range m {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+// parseNodeLine 解析 nodeLineLocked 的输出，返回节点、是否为 myself 以及其负责的槽。
+func parseNodeLine(line string) (*Node, bool, []int, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return nil, false, nil, errors.New("expect at least 8 fields")
+	}
+	node := &Node{ID: fields[0]}
+	addr, busPort, _ := strings.Cut(fields[1], "@")
+	// 地址中可能带有 ,hostname 后缀
+	addr, _, _ = strings.Cut(addr, ",")
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, false, nil, fmt.Errorf("invalid address %q", fields[1])
+	}
+	node.IP = host
+	if node.Port, err = strconv.Atoi(port); err != nil {
+		return nil, false, nil, fmt.Errorf("invalid port %q", port)
+	}
+	node.BusPort = node.Port + busPortOffset
+	if busPort != "" {
+		if node.BusPort, err = strconv.Atoi(busPort); err != nil {
+			return nil, false, nil, fmt.Errorf("invalid bus port %q", busPort)
+		}
+	}
+	myself := false
+	for _, flag := range strings.Split(fields[2], ",") {
+		if flag == "myself" {
+			myself = true
+		}
+	}
+	if fields[3] != "-" {
+		node.MasterID = fields[3]
+	}
+	if node.ConfigEpoch, err = strconv.ParseInt(fields[6], 10, 64); err != nil {
+		return nil, false, nil, fmt.Errorf("invalid config epoch %q", fields[6])
+	}
+
+	var slots []int
+	for _, field := range fields[8:] {
+		// 迁移状态 [slot->-id] / [slot-<-id] 不持久化
+		if strings.HasPrefix(field, "[") {
+			continue
+		}
+		from, to, isRange := strings.Cut(field, "-")
+		start, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, false, nil, fmt.Errorf("invalid slot %q", field)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(to); err != nil {
+				return nil, false, nil, fmt.Errorf("invalid slot range %q", field)
+			}
+		}
+		if start < 0 || end >= SlotCount || start > end {
+			return nil, false, nil, fmt.Errorf("slot out of range %q", field)
+		}
+		for slot := start; slot <= end; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	return node, myself, slots, nil
+}
+
+// loadConfig 读取 nodes.conf。文件格式与 Redis 相同：每个节点一行，最后一行为 vars。
+func (c *Cluster) loadConfig(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "vars ") {
+			fields := strings.Fields(line)[1:]
+			for i := 0; i+1 < len(fields); i += 2 {
+				if fields[i] == "currentEpoch" {
+					c.currentEpoch, _ = strconv.ParseInt(fields[i+1], 10, 64)
+				}
+			}
+			continue
+		}
+		node, myself, slots, err := parseNodeLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if _, dup := c.nodes[node.ID]; dup {
+			return fmt.Errorf("line %d: duplicate node %s", lineNo, node.ID)
+		}
+		c.nodes[node.ID] = node
+		if myself {
+			c.myself = node
+		}
+		for _, slot := range slots {
+			c.slots[slot] = node
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if c.myself == nil {
+		return errors.New("no node flagged as myself")
+	}
+	return nil
+}
+
+// saveConfigLocked 把当前拓扑写入 nodes.conf，先写临时文件再 rename，避免崩溃时留下半个文件。
+func (c *Cluster) saveConfigLocked() error {
+	if c.configFile == "" {
+		return nil
+	}
+	var b strings.Builder
+	for _, node := range c.sortedNodesLocked() {
+		if node.handshake {
+			continue
+		}
+		b.WriteString(c.nodeLineLocked(node))
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "vars currentEpoch %d lastVoteEpoch 0\n", c.currentEpoch)
+
+	tmp := c.configFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.configFile)
+}
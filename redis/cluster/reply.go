@@ -0,0 +1,19 @@
+package cluster
+
+import (
+	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/resp"
+)
+
+// multi 构造元素类型任意的数组应答，CLUSTER SLOTS / SHARDS 的应答中含有整数与嵌套数组。
+func multi(items ..._interface.Reply) *resp.MultiBulkReply {
+	return resp.MakeMultiBulkReply(items)
+}
+
+func bulk(s string) _interface.Reply {
+	return resp.MakeBulkReply([]byte(s))
+}
+
+func integer(n int64) _interface.Reply {
+	return resp.MakeIntegerReply(n)
+}
@@ -0,0 +1,11 @@
+package cluster
+
+import "MiddlewareSelf/redis/cluster/hashslot"
+
+// SlotCount 为集群的哈希槽数量。
+const SlotCount = hashslot.SlotCount
+
+// KeySlot 计算键所属的槽，规则见 hashslot.KeySlot。
+func KeySlot(key string) int {
+	return hashslot.KeySlot(key)
+}
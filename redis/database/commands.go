@@ -0,0 +1,335 @@
+package database
+
+import (
+	"MiddlewareSelf/redis/datastruct"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execRead 执行只读命令。
+func execRead(dict *datastruct.Dict, cmd string, args [][]byte) (interface{}, error) {
+	switch cmd {
+	case "GET":
+		if len(args) != 2 {
+			return nil, errors.New("wrong number of arguments for 'get'")
+		}
+		key := string(args[1])
+		val, ok := dict.Get(key)
+		if !ok {
+			return nil, nil
+		}
+		if dobj, ok := val.(*DataObject); ok {
+			return dobj.Bytes(), nil
+		}
+		return nil, nil
+
+	case "DUMP":
+		if len(args) != 2 {
+			return nil, errors.New("wrong number of arguments for 'dump'")
+		}
+		val, ok := dict.Get(string(args[1]))
+		if !ok {
+			return nil, nil
+		}
+		return DumpValue(val)
+
+	case "TTL", "PTTL":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("wrong number of arguments for '%s'", strings.ToLower(cmd))
+		}
+		_, expire, ok := dict.GetWithExpire(string(args[1]))
+		if !ok {
+			return -2, nil
+		}
+		if expire == 0 {
+			return -1, nil
+		}
+		remainMs := (expire - time.Now().UnixNano()) / 1e6
+		if cmd == "TTL" {
+			return (remainMs + 500) / 1000, nil
+		}
+		return remainMs, nil
+	}
+	return nil, fmt.Errorf("unknown command '%s'", cmd)
+}
+
+// execWrite 执行写命令，并在同一个临界区内把效果命令送入传播流。
+// AOF 落盘等待放在临界区外，SyncAlways 下并发写入仍能被组提交合并。
+// 追加或提交失败时内存修改被回滚、副本也收不到这条命令（见 settleLocked），客户端收到 MISCONF。
+func (db *Db) execWrite(index int, dict *datastruct.Dict, cmd string, args [][]byte) (interface{}, error) {
+	db.writeMu.Lock()
+	var undo func()
+	if db.aof != nil {
+		undo = db.captureUndo(dict, cmd, args)
+	}
+	var reply interface{}
+	var effects [][][]byte
+	var err error
+	if cmd == "FLUSHALL" {
+		reply, effects, err = db.flushAll(args)
+	} else {
+		reply, effects, err = applyWrite(dict, cmd, args)
+	}
+	if err != nil {
+		effects = nil
+	}
+	own, last := db.propagateLocked(index, effects, undo)
+	db.writeMu.Unlock()
+
+	if last != nil {
+		_ = db.waitSettled(last)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if own != nil {
+		// last 已有结果时更早追加的 own 也一定有结果
+		if _, commitErr := own.result(); commitErr != nil {
+			return nil, misconfError(commitErr)
+		}
+	}
+	return reply, nil
+}
+
+// applyWrite 修改内存，并返回需要传播的效果命令（可能为空，例如 DEL 不存在的键）。
+func applyWrite(dict *datastruct.Dict, cmd string, args [][]byte) (interface{}, [][][]byte, error) {
+	switch cmd {
+	case "SET":
+		return execSet(dict, args)
+
+	case "SETWITHTTL":
+		if len(args) != 4 {
+			return nil, nil, errors.New("wrong number of arguments for 'setwithttl'")
+		}
+		ttl, err := strconv.ParseInt(string(args[3]), 10, 64)
+		if err != nil {
+			return nil, nil, errors.New("invalid ttl argument")
+		}
+		if ttl <= 0 {
+			return execSet(dict, args[:3])
+		}
+		expireAtMs := time.Now().UnixMilli() + ttl
+		return execSet(dict, [][]byte{args[0], args[1], args[2], []byte("PXAT"), []byte(strconv.FormatInt(expireAtMs, 10))})
+
+	case "DEL":
+		if len(args) < 2 {
+			return nil, nil, errors.New("wrong number of arguments for 'del'")
+		}
+		deleted := [][]byte{[]byte("DEL")}
+		for i := 1; i < len(args); i++ {
+			key := string(args[i])
+			if _, ok := dict.Get(key); ok {
+				dict.Remove(key)
+				deleted = append(deleted, args[i])
+			}
+		}
+		if len(deleted) == 1 {
+			return 0, nil, nil
+		}
+		return len(deleted) - 1, [][][]byte{deleted}, nil
+
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT":
+		return execExpire(dict, cmd, args)
+
+	case "INCRBYFLOAT":
+		return execIncrByFloat(dict, args)
+
+	case "RESTORE", "RESTORE-ASKING":
+		return execRestore(dict, args)
+
+	case "FLUSHDB":
+		if len(args) != 1 {
+			return nil, nil, errors.New("wrong number of arguments for 'flushdb'")
+		}
+		dict.Clear()
+		return "OK", [][][]byte{{[]byte("FLUSHDB")}}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown command '%s'", cmd)
+}
+
+// flushAll 清空所有 DB；Clear 不触发删除钩子，传播一条 FLUSHALL 即可。
+func (db *Db) flushAll(args [][]byte) (interface{}, [][][]byte, error) {
+	if len(args) != 1 {
+		return nil, nil, errors.New("wrong number of arguments for 'flushall'")
+	}
+	for _, dict := range db.dicts {
+		dict.Clear()
+	}
+	return "OK", [][][]byte{{[]byte("FLUSHALL")}}, nil
+}
+
+// captureUndo 记录写命令将要修改的键的当前状态，返回把它们恢复原状的函数。
+// 新增写命令时需要在这里登记它修改的键，否则 AOF 提交失败时无法回滚。
+func (db *Db) captureUndo(dict *datastruct.Dict, cmd string, args [][]byte) func() {
+	var keys [][]byte
+	switch cmd {
+	case "FLUSHALL":
+		saved := make([][]datastruct.SnapshotItem, len(db.dicts))
+		for i, d := range db.dicts {
+			saved[i] = d.Snapshot()
+		}
+		return func() {
+			for i, d := range db.dicts {
+				restoreItems(d, saved[i])
+			}
+		}
+	case "FLUSHDB":
+		saved := dict.Snapshot()
+		return func() { restoreItems(dict, saved) }
+	case "DEL":
+		keys = args[1:]
+	case "SET", "SETWITHTTL", "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT", "INCRBYFLOAT", "RESTORE", "RESTORE-ASKING":
+		if len(args) > 1 {
+			keys = args[1:2]
+		}
+	default:
+		return nil
+	}
+
+	saved := make([]datastruct.SnapshotItem, 0, len(keys))
+	exists := make([]bool, 0, len(keys))
+	for _, key := range keys {
+		value, expire, ok := dict.GetWithExpire(string(key))
+		saved = append(saved, datastruct.SnapshotItem{Key: string(key), Value: value, ExpireAtNano: expire})
+		exists = append(exists, ok)
+	}
+	return func() {
+		// 逆序恢复，同一个键出现多次时以最早的状态为准
+		for i := len(saved) - 1; i >= 0; i-- {
+			if exists[i] {
+				dict.SetWithExpireAt(saved[i].Key, saved[i].Value, saved[i].ExpireAtNano)
+			} else {
+				dict.Remove(saved[i].Key)
+			}
+		}
+	}
+}
+
+// restoreItems 把快照项写回字典（FLUSHDB / FLUSHALL 回滚时字典已被清空）。
+func restoreItems(dict *datastruct.Dict, items []datastruct.SnapshotItem) {
+	for _, item := range items {
+		dict.SetWithExpireAt(item.Key, item.Value, item.ExpireAtNano)
+	}
+}
+
+// execSet 实现 SET key value [EX seconds|PX milliseconds|EXAT unix|PXAT unix-ms|KEEPTTL]。
+// 相对过期时间在这里换算成绝对时间，传播出去的总是 PXAT 形式。
+func execSet(dict *datastruct.Dict, args [][]byte) (interface{}, [][][]byte, error) {
+	if len(args) != 3 && len(args) != 4 && len(args) != 5 {
+		return nil, nil, errors.New("wrong number of arguments for 'set'")
+	}
+	key := string(args[1])
+	// args 指向连接读缓冲，存入字典的值必须拷贝
+	val := NewDataObject(bytes.Clone(args[2]))
+
+	if len(args) == 3 {
+		dict.Set(key, val)
+		return "OK", [][][]byte{{[]byte("SET"), args[1], args[2]}}, nil
+	}
+
+	option := strings.ToUpper(string(args[3]))
+	if option == "KEEPTTL" && len(args) == 4 {
+		dict.SetKeepTTL(key, val)
+		return "OK", [][][]byte{{[]byte("SET"), args[1], args[2], []byte("KEEPTTL")}}, nil
+	}
+	if len(args) != 5 {
+		return nil, nil, errors.New("syntax error")
+	}
+	n, err := strconv.ParseInt(string(args[4]), 10, 64)
+	if err != nil {
+		return nil, nil, errors.New("value is not an integer or out of range")
+	}
+	if n <= 0 {
+		return nil, nil, errors.New("invalid expire time in 'set' command")
+	}
+	var expireAtMs int64
+	switch option {
+	case "EX":
+		expireAtMs = time.Now().UnixMilli() + n*1000
+	case "PX":
+		expireAtMs = time.Now().UnixMilli() + n
+	case "EXAT":
+		expireAtMs = n * 1000
+	case "PXAT":
+		expireAtMs = n
+	default:
+		return nil, nil, errors.New("syntax error")
+	}
+
+	dict.SetWithExpireAt(key, val, expireAtMs*1e6)
+	return "OK", [][][]byte{{
+		[]byte("SET"), args[1], args[2], []byte("PXAT"), []byte(strconv.FormatInt(expireAtMs, 10)),
+	}}, nil
+}
+
+// execExpire 实现 EXPIRE / PEXPIRE / EXPIREAT / PEXPIREAT，统一传播为 PEXPIREAT。
+func execExpire(dict *datastruct.Dict, cmd string, args [][]byte) (interface{}, [][][]byte, error) {
+	if len(args) != 3 {
+		return nil, nil, fmt.Errorf("wrong number of arguments for '%s'", strings.ToLower(cmd))
+	}
+	n, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return nil, nil, errors.New("value is not an integer or out of range")
+	}
+	nowMs := time.Now().UnixMilli()
+	var expireAtMs int64
+	switch cmd {
+	case "EXPIRE":
+		expireAtMs = nowMs + n*1000
+	case "PEXPIRE":
+		expireAtMs = nowMs + n
+	case "EXPIREAT":
+		expireAtMs = n * 1000
+	case "PEXPIREAT":
+		expireAtMs = n
+	}
+
+	key := string(args[1])
+	if _, ok := dict.Get(key); !ok {
+		return 0, nil, nil
+	}
+	// 过期时间已过：直接删除并传播 DEL，副本无需关心时钟差异
+	if expireAtMs <= nowMs {
+		dict.Remove(key)
+		return 1, [][][]byte{{[]byte("DEL"), args[1]}}, nil
+	}
+	if !dict.ExpireAt(key, expireAtMs*1e6) {
+		return 0, nil, nil
+	}
+	return 1, [][][]byte{{[]byte("PEXPIREAT"), args[1], []byte(strconv.FormatInt(expireAtMs, 10))}}, nil
+}
+
+// execIncrByFloat 浮点累加结果依赖当前值与浮点实现，传播为 SET 结果值并保留 TTL。
+func execIncrByFloat(dict *datastruct.Dict, args [][]byte) (interface{}, [][][]byte, error) {
+	if len(args) != 3 {
+		return nil, nil, errors.New("wrong number of arguments for 'incrbyfloat'")
+	}
+	incr, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil {
+		return nil, nil, errors.New("value is not a valid float")
+	}
+	key := string(args[1])
+	current := 0.0
+	if val, ok := dict.Get(key); ok {
+		dobj, isObj := val.(*DataObject)
+		if !isObj {
+			return nil, nil, errors.New("value is not a valid float")
+		}
+		current, err = strconv.ParseFloat(dobj.String(), 64)
+		if err != nil {
+			return nil, nil, errors.New("value is not a valid float")
+		}
+	}
+	result := current + incr
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return nil, nil, errors.New("increment would produce NaN or Infinity")
+	}
+	formatted := []byte(strconv.FormatFloat(result, 'f', -1, 64))
+	dict.SetKeepTTL(key, NewDataObject(formatted))
+	return formatted, [][][]byte{{[]byte("SET"), args[1], formatted, []byte("KEEPTTL")}}, nil
+}
@@ -3,8 +3,6 @@ package database
 import (
 	"MiddlewareSelf/redis/aof"
 	"MiddlewareSelf/redis/datastruct"
-	"MiddlewareSelf/redis/parser"
-	"MiddlewareSelf/redis/resp"
 	"context"
 	"errors"
 	"fmt"
@@ -12,6 +10,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -19,13 +18,49 @@ import (
 
 const MaxNumber = 16
 
+// ReplyError 是带自定义错误码前缀（如 MISCONF）的错误，handler 会原样返回给客户端，
+// 而普通 error 会被加上 "ERR " 前缀。
+type ReplyError struct {
+	Msg string
+}
+
+func (e *ReplyError) Error() string {
+	return e.Msg
+}
+
+func MakeReplyError(msg string) *ReplyError {
+	return &ReplyError{Msg: msg}
+}
+
 type Db struct {
 	//index int64
 	dicts []*datastruct.Dict
 	aof   *aof.AOF
+
+	// writeMu 串行化“执行写命令 + 进入传播流”，保证 AOF/副本看到的顺序与内存修改顺序一致
+	writeMu     sync.Mutex
+	propagators []Propagator
+	// inflight 为已修改内存、等待 AOF 提交结果的写入，按追加顺序排列，见 propagate.go
+	inflight []*pendingWrite
+	// pendingRemovals 为 Dict 自动删除（过期/淘汰）后尚未传播的键，见 propagate.go
+	pendingMu       sync.Mutex
+	pendingRemovals []removal
+
+	// infoSections 为 INFO 命令的各个分区，其它模块可通过 RegisterInfoSection 追加
+	infoSections []infoSection
 }
 
 func MakeDbs() *Db {
+	db, err := MakeDbsWithOptions(aof.DefaultLoadOptions)
+	if err != nil {
+		log.Fatalf("[DB] load AOF failed: %v", err)
+	}
+	return db
+}
+
+// MakeDbsWithOptions 创建数据库并按 opts 回放 AOF。
+// 文件中部损坏且未强制加载时返回错误，调用方应拒绝启动。
+func MakeDbsWithOptions(opts aof.LoadOptions) (*Db, error) {
 	dicts := make([]*datastruct.Dict, MaxNumber)
 	for i := 0; i < MaxNumber; i++ {
 		dicts[i] = datastruct.MakeDict()
@@ -33,47 +68,45 @@ func MakeDbs() *Db {
 	db := &Db{
 		dicts: dicts,
 	}
-	loadAOF(db)
-	return db
+	for i, dict := range dicts {
+		dict.SetRemoveHook(db.removeHook(i))
+	}
+	db.registerDefaultInfoSections()
+	if err := loadAOF(db, opts); err != nil {
+		return nil, err
+	}
+	return db, nil
 }
 
-	// 不 return 到这里
-
-
-func loadAOF(db *Db) {
+func loadAOF(db *Db, opts aof.LoadOptions) error {
 	path := aof.AofName
-	file, err := os.Open(path)
-	if err != nil {
+	if _, err := os.Stat(path); err != nil {
 		// 兼容旧文件名 redis.aof
-		if legacyFile, legacyErr := os.Open("redis.aof"); legacyErr == nil {
-			file = legacyFile
-			path = "redis.aof"
-		} else {
-			return
+		if _, legacyErr := os.Stat("redis.aof"); legacyErr != nil {
+			return nil
 		}
+		path = "redis.aof"
 	}
-	defer file.Close()
 
-	ch := parser.ParseStream(file)
 	log.Printf("[DB] loading AOF from %s", path)
-	for payLoad := range ch {
-		if payLoad == nil {
-			continue
-		}
-		if payLoad.Err != nil {
-			if payLoad.Err.Error() == "EOF" {
-				break
+	// AOF 中通过 SELECT 切换 DB，回放时跟踪当前 DB
+	current := 0
+	result, err := aof.Load(path, opts, func(args [][]byte) {
+		if strings.EqualFold(string(args[0]), "SELECT") && len(args) == 2 {
+			if idx, err := strconv.Atoi(string(args[1])); err == nil && idx >= 0 && idx < MaxNumber {
+				current = idx
+				return
 			}
-			log.Printf("[DB] skip invalid AOF payload: %v", payLoad.Err)
-			continue
 		}
-		if arr, ok := payLoad.Data.(*resp.ArrayReply); ok {
-			_, exec := db.Exec(0, arr.Args)
-			if exec != nil {
-				log.Printf("[DB] replay command failed: %v", exec)
-			} // TODO 后续处理这个index问题
+		if _, err := db.Exec(current, args); err != nil {
+			log.Printf("[DB] replay command failed: %v", err)
 		}
+	})
+	if err != nil {
+		return fmt.Errorf("load %s: %w", path, err)
 	}
+	log.Printf("[DB] AOF loaded, commands=%d size=%d", result.Commands, result.ValidOffset)
+	return nil
 }
 
 //func (db *Db) Select(index int) bool {
@@ -91,7 +124,15 @@ func (db *Db) GetDict(index int) (*datastruct.Dict, error) {
 	return db.dicts[index], nil
 }
 
-// 让外层调用此函数的存储index状态
+// EnableSlotIndex 为所有 DB 启用槽 -> 键索引，集群模式使用。
+func (db *Db) EnableSlotIndex(slotOf func(key string) int) {
+	for _, dict := range db.dicts {
+		dict.EnableSlotIndex(slotOf)
+	}
+}
+
+// 让外层调用此函数的存储index状态。
+// args 只在调用期间有效（服务端会复用读缓冲），需要保留的参数必须拷贝。
 func (db *Db) Exec(index int, args [][]byte) (interface{}, error) {
 	//db.mu.Lock()
 	//defer db.mu.Unlock()
@@ -115,74 +156,46 @@ func (db *Db) Exec(index int, args [][]byte) (interface{}, error) {
 		return nil, errors.New("DB index out of range")
 	}
 
-	dict, err := db.GetDict(index)
-	if err != nil {
-		return nil, err
+	if cmd == "INFO" {
+		return db.execInfo(args)
 	}
-	var reply interface{}
 
-	switch cmd {
-	case "SET":
-		if len(args) != 3 {
-			return nil, errors.New("wrong number of arguments for 'set'")
+	if cmd == "PING" {
+		switch len(args) {
+		case 1:
+			return "PONG", nil
+		case 2:
+			return args[1], nil
 		}
-		key := string(args[1])
-		val := NewDataObject(args[2])
-		dict.Set(key, val)
-		reply = "OK" // Redis SET 返回 OK
-
-	case "SETWITHTTL":
-		if len(args) != 4 {
-			return nil, errors.New("wrong number of arguments for 'setwithttl'")
-		}
-		key := string(args[1])
-		val := NewDataObject(args[2])
-		ttl, err := strconv.ParseInt(string(args[3]), 10, 64)
-		if err != nil {
-			return nil, errors.New("invalid ttl argument")
-		}
-		dict.SetWithTTL(key, val, ttl)
-		reply = "OK"
+		return nil, errors.New("wrong number of arguments for 'ping'")
+	}
 
-	case "GET":
-		if len(args) != 2 {
-			return nil, errors.New("wrong number of arguments for 'get'")
-		}
-		key := string(args[1])
-		val, ok := dict.Get(key)
-		if !ok {
-			return nil, nil
-		}
-		if dobj, ok := val.(*DataObject); ok {
-			reply = dobj.Bytes()
+	// AOF 处于写错误状态时拒绝写命令，避免继续产生无法持久化的修改
+	if aof.IsWriteCmd(cmd) && db.aof != nil {
+		if err := db.aof.LastWriteErr(); err != nil {
+			return nil, misconfError(err)
 		}
+	}
 
-	case "DEL":
-		if len(args) < 2 {
-			return nil, errors.New("wrong number of arguments for 'del'")
-		}
-		count := 0
-		for i := 1; i < len(args); i++ {
-			key := string(args[i])
-			if _, ok := dict.Get(key); ok {
-				dict.Remove(key)
-				count++
-			}
-		}
-		reply = count
-	default:
-		return nil, fmt.Errorf("unknown command '%s'", cmd)
+	if cmd == "MIGRATE" {
+		return db.execMigrate(index, args)
 	}
 
+	dict, err := db.GetDict(index)
+	if err != nil {
+		return nil, err
+	}
 	if aof.IsWriteCmd(cmd) {
-		if db.aof != nil {
-			if err := db.aof.AppendCommand(args); err != nil {
-				return nil, err
-			}
-		}
+		return db.execWrite(index, dict, cmd, args)
 	}
+	reply, err := execRead(dict, cmd, args)
+	// 读命令触发的惰性过期同样需要以 DEL 传播出去
+	db.propagatePendingRemovals()
+	return reply, err
+}
 
-	return reply, nil
+func misconfError(err error) error {
+	return MakeReplyError("MISCONF Errors writing to the AOF file: " + err.Error())
 }
 
 // EnableAOF 启用 AOF 持久化并注册 rewrite 快照回调。
@@ -199,6 +212,11 @@ func (db *Db) EnableAOF(policy aof.SyncPolicy) error {
 	return nil
 }
 
+// AOF 返回当前启用的 AOF，未启用时为 nil。
+func (db *Db) AOF() *aof.AOF {
+	return db.aof
+}
+
 // RewriteAOF 触发一次后台重写流程。
 func (db *Db) RewriteAOF(ctx context.Context) error {
 	if db.aof == nil {
@@ -224,41 +242,47 @@ func (db *Db) Close() {
 }
 
 func (db *Db) snapshotForRewrite() ([]aof.RewriteCommand, error) {
-	now := int64(0)
-	now = time.Now().UnixNano()
+	cmds := db.snapshotCommands()
+	commands := make([]aof.RewriteCommand, 0, len(cmds))
+	for _, args := range cmds {
+		commands = append(commands, aof.RewriteCommand{Args: args})
+	}
+	return commands, nil
+}
 
-	commands := make([]aof.RewriteCommand, 0)
-	for _, dict := range db.dicts {
+// SnapshotForSync 生成全量同步用的快照命令流，并在同一个写临界区内调用 register。
+// register 中注册的 Propagator 恰好从快照之后的第一条写命令开始接收传播流，不重不漏。
+func (db *Db) SnapshotForSync(register func()) [][][]byte {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	// 先发出已发生的惰性删除，避免它们排在快照之后传给新副本；
+	// 快照只能包含已提交的写入，未提交的写入可能在快照之后被回滚
+	db.drainLocked()
+	cmds := db.snapshotCommands()
+	register()
+	return cmds
+}
+
+// snapshotCommands 把所有 DB 的数据转换为 SELECT + SET [PXAT] / RESTORE 命令序列。
+func (db *Db) snapshotCommands() [][][]byte {
+	commands := make([][][]byte, 0)
+	for i, dict := range db.dicts {
 		items := dict.Snapshot()
+		if len(items) == 0 {
+			continue
+		}
+		commands = append(commands, [][]byte{
+			[]byte("SELECT"),
+			[]byte(strconv.Itoa(i)),
+		})
 		for _, item := range items {
-			bytesGetter, ok := item.Value.(interface{ Bytes() []byte })
-			if !ok {
+			args := snapshotArgs(item.Key, item.Value, item.ExpireAtNano)
+			if args == nil {
+				log.Printf("[DB] skip key %q of unsupported type %T in snapshot", item.Key, item.Value)
 				continue
 			}
-			val := bytesGetter.Bytes()
-			valCopy := make([]byte, len(val))
-			copy(valCopy, val)
-
-			if item.ExpireAtNano > 0 {
-				ttlMs := (item.ExpireAtNano - now) / 1e6
-				if ttlMs <= 0 {
-					continue
-				}
-				commands = append(commands, aof.RewriteCommand{Args: [][]byte{
-					[]byte("SETWITHTTL"),
-					[]byte(item.Key),
-					valCopy,
-					[]byte(strconv.FormatInt(ttlMs, 10)),
-				}})
-			} else {
-				commands = append(commands, aof.RewriteCommand{Args: [][]byte{
-					[]byte("SET"),
-					[]byte(item.Key),
-					valCopy,
-				}})
-			}
+			commands = append(commands, args)
 		}
 	}
-
-	return commands, nil
+	return commands
 }
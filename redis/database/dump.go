@@ -0,0 +1,192 @@
+package database
+
+import (
+	"MiddlewareSelf/redis/datastruct"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DUMP 负载的布局与 Redis 相同，值的编码为本项目自定义：
+//
+//	<type:1> <value> <version:2 LE> <crc64:8 LE>
+//
+// 字符串的 value 为 uvarint 长度 + 内容。crc64 使用与 Redis 相同的 Jones 多项式，覆盖之前的所有字节，
+// 版本号高于本实现的负载一律拒绝，避免把看不懂的数据写进内存。
+const (
+	dumpVersion    = 1
+	dumpTypeString = 0
+	dumpFooterLen  = 2 + 8
+)
+
+var errBadPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// crc64Table 为 crc-64-jones（反射多项式 0x95ac9329ac4bc9b5，初值 0，无最终异或）查找表。
+var crc64Table = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ 0x95ac9329ac4bc9b5
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func crc64(crc uint64, data []byte) uint64 {
+	for _, b := range data {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
+
+// DumpValue 把 Dict 中的值序列化为 DUMP 负载。
+func DumpValue(v datastruct.Value) ([]byte, error) {
+	obj, ok := v.(*DataObject)
+	if !ok {
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64+obj.Len()+dumpFooterLen)
+	buf = append(buf, dumpTypeString)
+	buf = binary.AppendUvarint(buf, uint64(obj.Len()))
+	buf = append(buf, obj.Bytes()...)
+	buf = binary.LittleEndian.AppendUint16(buf, dumpVersion)
+	return binary.LittleEndian.AppendUint64(buf, crc64(0, buf)), nil
+}
+
+// LoadDumpPayload 校验版本与校验和，并反序列化 DUMP 负载。
+func LoadDumpPayload(payload []byte) (datastruct.Value, error) {
+	if len(payload) < 1+dumpFooterLen {
+		return nil, errBadPayload
+	}
+	body, footer := payload[:len(payload)-8], payload[len(payload)-8:]
+	if binary.LittleEndian.Uint64(footer) != crc64(0, body) {
+		return nil, errBadPayload
+	}
+	if binary.LittleEndian.Uint16(body[len(body)-2:]) > dumpVersion {
+		return nil, errBadPayload
+	}
+	body = body[:len(body)-2]
+
+	switch body[0] {
+	case dumpTypeString:
+		n, size := binary.Uvarint(body[1:])
+		if size <= 0 || uint64(len(body)-1-size) != n {
+			return nil, errors.New("Bad data format")
+		}
+		val := make([]byte, n)
+		copy(val, body[1+size:])
+		return NewDataObject(val), nil
+	}
+	return nil, errors.New("Bad data format")
+}
+
+// execRestore 实现 RESTORE key ttl payload [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+// （MIGRATE 使用的 RESTORE-ASKING 相同）。ttl 为毫秒，0 表示不过期，带 ABSTTL 时为绝对 Unix 毫秒时间戳。
+// 淘汰策略固定为 LRU：IDLETIME 大于 0 的键放到 LRU 尾部，FREQ 只做校验（Redis 在非 LFU 策略下同样忽略）。
+// 传播为等价的 SET [PXAT]。
+func execRestore(dict *datastruct.Dict, args [][]byte) (interface{}, [][][]byte, error) {
+	if len(args) < 4 {
+		return nil, nil, fmt.Errorf("wrong number of arguments for '%s'", strings.ToLower(string(args[0])))
+	}
+	replace, absTTL := false, false
+	idle, freq := int64(-1), int64(-1)
+	for i := 4; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); opt {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		case "IDLETIME", "FREQ":
+			// IDLETIME 与 FREQ 互斥
+			if i+1 >= len(args) || idle >= 0 || freq >= 0 {
+				return nil, nil, errors.New("syntax error")
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, nil, errors.New("value is not an integer or out of range")
+			}
+			if opt == "IDLETIME" {
+				if n < 0 {
+					return nil, nil, errors.New("Invalid IDLETIME value, must be >= 0")
+				}
+				idle = n
+			} else {
+				if n < 0 || n > 255 {
+					return nil, nil, errors.New("Invalid FREQ value, must be >= 0 and <= 255")
+				}
+				freq = n
+			}
+			i++
+		default:
+			return nil, nil, errors.New("syntax error")
+		}
+	}
+	ttl, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return nil, nil, errors.New("value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return nil, nil, errors.New("Invalid TTL value, must be >= 0")
+	}
+	key := string(args[1])
+	_, exists := dict.Get(key)
+	if exists && !replace {
+		return nil, nil, MakeReplyError("BUSYKEY Target key name already exists.")
+	}
+	value, err := LoadDumpPayload(args[3])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expireAtMs := int64(0)
+	if ttl > 0 {
+		expireAtMs = ttl
+		if !absTTL {
+			expireAtMs += time.Now().UnixMilli()
+		}
+		// 已经过期：不写入，REPLACE 时删除旧值
+		if expireAtMs <= time.Now().UnixMilli() {
+			if exists {
+				dict.Remove(key)
+				return "OK", [][][]byte{{[]byte("DEL"), args[1]}}, nil
+			}
+			return "OK", nil, nil
+		}
+	}
+	dict.SetWithExpireAt(key, value, expireAtMs*1e6)
+	if idle > 0 {
+		dict.MarkIdle(key)
+	}
+	return "OK", [][][]byte{snapshotArgs(key, value, expireAtMs*1e6)}, nil
+}
+
+// snapshotArgs 生成在空库中重建该键的命令，AOF 重写、全量同步与 RESTORE 的传播共用。
+// 字符串使用 SET [PXAT] 以保持 AOF 可读，其它类型使用 DUMP 负载的 RESTORE ... ABSTTL。
+// expireAtNano 为绝对过期时间（UnixNano），0 表示永不过期。
+func snapshotArgs(key string, value datastruct.Value, expireAtNano int64) [][]byte {
+	if obj, ok := value.(*DataObject); ok {
+		val := make([]byte, obj.Len())
+		copy(val, obj.Bytes())
+		args := [][]byte{[]byte("SET"), []byte(key), val}
+		if expireAtNano > 0 {
+			// 使用绝对过期时间，重放时间与快照时间不同也能得到相同结果
+			args = append(args, []byte("PXAT"), []byte(strconv.FormatInt(expireAtNano/1e6, 10)))
+		}
+		return args
+	}
+	payload, err := DumpValue(value)
+	if err != nil {
+		return nil
+	}
+	return [][]byte{[]byte("RESTORE"), []byte(key), []byte(strconv.FormatInt(expireAtNano/1e6, 10)), payload,
+		[]byte("REPLACE"), []byte("ABSTTL")}
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"MiddlewareSelf/redis/aof"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrc64Jones(t *testing.T) {
+	// Redis crc64.c 的校验值
+	if got := crc64(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("crc64(123456789) = %#x", got)
+	}
+}
+
+func TestDumpPayloadRoundTrip(t *testing.T) {
+	payload, err := DumpValue(NewDataObject([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := LoadDumpPayload(payload)
+	if err != nil {
+		t.Fatalf("load payload: %v", err)
+	}
+	if got := value.(*DataObject).String(); got != "hello" {
+		t.Fatalf("round trip = %q", got)
+	}
+
+	corrupted := append([]byte(nil), payload...)
+	corrupted[2] ^= 0xff
+	if _, err := LoadDumpPayload(corrupted); err != errBadPayload {
+		t.Fatalf("corrupted payload: %v", err)
+	}
+	if _, err := LoadDumpPayload(payload[:5]); err != errBadPayload {
+		t.Fatalf("truncated payload: %v", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	db, err := MakeDbsWithOptions(aof.DefaultLoadOptions)
+	if err != nil {
+		t.Fatalf("MakeDbsWithOptions failed: %v", err)
+	}
+	rec := &recordingPropagator{}
+	db.AddPropagator(rec)
+	payload, _ := DumpValue(NewDataObject([]byte("v1")))
+	restore := func(args ...string) error {
+		cmd := [][]byte{[]byte("RESTORE"), []byte("k"), []byte(args[0]), payload}
+		for _, arg := range args[1:] {
+			cmd = append(cmd, []byte(arg))
+		}
+		_, err := db.Exec(0, cmd)
+		return err
+	}
+
+	if err := restore("0"); err != nil {
+		t.Fatalf("RESTORE: %v", err)
+	}
+	if err := restore("0"); err == nil || !strings.HasPrefix(err.Error(), "BUSYKEY") {
+		t.Fatalf("RESTORE existing key: %v", err)
+	}
+	if err := restore("60000", "REPLACE"); err != nil {
+		t.Fatalf("RESTORE REPLACE: %v", err)
+	}
+	if ttl := mustExec(t, db, 0, "PTTL k").(int64); ttl <= 0 || ttl > 60000 {
+		t.Fatalf("PTTL after RESTORE = %d", ttl)
+	}
+	got := rec.take()
+	if len(got) != 2 || got[0] != "SET k v1" || !strings.HasPrefix(got[1], "SET k v1 PXAT ") {
+		t.Fatalf("RESTORE should propagate as SET, got %q", got)
+	}
+}
+
+func TestDumpCommandAndRestoreOptions(t *testing.T) {
+	db, err := MakeDbsWithOptions(aof.DefaultLoadOptions)
+	if err != nil {
+		t.Fatalf("MakeDbsWithOptions failed: %v", err)
+	}
+	rec := &recordingPropagator{}
+	db.AddPropagator(rec)
+	if got := mustExec(t, db, 0, "DUMP missing"); got != nil {
+		t.Fatalf("DUMP missing key = %v", got)
+	}
+	mustExec(t, db, 0, "SET src hello")
+	payload := mustExec(t, db, 0, "DUMP src").([]byte)
+	rec.take()
+
+	restore := func(key string, opts ...string) error {
+		cmd := [][]byte{[]byte("RESTORE"), []byte(key), []byte(opts[0]), payload}
+		for _, opt := range opts[1:] {
+			cmd = append(cmd, []byte(opt))
+		}
+		_, err := db.Exec(0, cmd)
+		return err
+	}
+
+	// ABSTTL：ttl 为绝对毫秒时间戳
+	expireAt := time.Now().Add(time.Minute).UnixMilli()
+	if err := restore("k1", strconv.FormatInt(expireAt, 10), "ABSTTL", "IDLETIME", "100"); err != nil {
+		t.Fatalf("RESTORE ABSTTL IDLETIME: %v", err)
+	}
+	if got := rec.take(); len(got) != 1 || got[0] != "SET k1 hello PXAT "+strconv.FormatInt(expireAt, 10) {
+		t.Fatalf("RESTORE ABSTTL propagated %q", got)
+	}
+	if got := string(mustExec(t, db, 0, "GET k1").([]byte)); got != "hello" {
+		t.Fatalf("GET k1 = %q", got)
+	}
+
+	// 已经过期的绝对时间：不写入，REPLACE 时删除旧值
+	if err := restore("k1", "1000", "ABSTTL", "REPLACE"); err != nil {
+		t.Fatalf("RESTORE expired ABSTTL: %v", err)
+	}
+	if got := mustExec(t, db, 0, "GET k1"); got != nil {
+		t.Fatalf("GET k1 after expired RESTORE = %q", got)
+	}
+	if got := rec.take(); len(got) != 1 || got[0] != "DEL k1" {
+		t.Fatalf("expired RESTORE REPLACE propagated %q", got)
+	}
+
+	for _, c := range []struct {
+		opts []string
+		err  string
+	}{
+		{[]string{"0", "IDLETIME", "1", "FREQ", "1"}, "syntax error"},
+		{[]string{"0", "FREQ", "256"}, "Invalid FREQ value, must be >= 0 and <= 255"},
+		{[]string{"0", "IDLETIME", "-1"}, "Invalid IDLETIME value, must be >= 0"},
+		{[]string{"0", "IDLETIME"}, "syntax error"},
+		{[]string{"0", "NOPE"}, "syntax error"},
+	} {
+		if err := restore("k2", c.opts...); err == nil || err.Error() != c.err {
+			t.Errorf("RESTORE %q: %v, want %q", c.opts, err, c.err)
+		}
+	}
+	if err := restore("k2", "0", "FREQ", "5"); err != nil {
+		t.Fatalf("RESTORE FREQ: %v", err)
+	}
+}
+
+func TestLoadDumpPayloadRejectsNewerVersion(t *testing.T) {
+	payload, _ := DumpValue(NewDataObject([]byte("v")))
+	body := append([]byte(nil), payload[:len(payload)-dumpFooterLen]...)
+	body = binary.LittleEndian.AppendUint16(body, dumpVersion+1)
+	body = binary.LittleEndian.AppendUint64(body, crc64(0, body))
+	if _, err := LoadDumpPayload(body); err != errBadPayload {
+		t.Fatalf("newer version payload: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// infoSection 是 INFO 输出中的一个分区（如 # Persistence）。
+type infoSection struct {
+	name string
+	// fill 追加 "key:value" 形式的行
+	fill func(b *strings.Builder)
+}
+
+// RegisterInfoSection 注册一个 INFO 分区，name 不区分大小写，按注册顺序输出。
+func (db *Db) RegisterInfoSection(name string, fill func(b *strings.Builder)) {
+	db.infoSections = append(db.infoSections, infoSection{name: name, fill: fill})
+}
+
+func (db *Db) registerDefaultInfoSections() {
+	db.RegisterInfoSection("Persistence", db.persistenceInfo)
+	db.RegisterInfoSection("Keyspace", db.keyspaceInfo)
+}
+
+// execInfo 实现 INFO [section]。
+func (db *Db) execInfo(args [][]byte) (interface{}, error) {
+	if len(args) > 2 {
+		return nil, fmt.Errorf("wrong number of arguments for 'info'")
+	}
+	want := "default"
+	if len(args) == 2 {
+		want = strings.ToLower(string(args[1]))
+	}
+	all := want == "default" || want == "all" || want == "everything"
+
+	var b strings.Builder
+	for _, section := range db.infoSections {
+		if !all && strings.ToLower(section.name) != want {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + section.name + "\r\n")
+		section.fill(&b)
+	}
+	return []byte(b.String()), nil
+}
+
+// WriteInfoField 以 INFO 格式写入一行 key:value。
+func WriteInfoField(b *strings.Builder, key string, value interface{}) {
+	fmt.Fprintf(b, "%s:%v\r\n", key, value)
+}
+
+func (db *Db) persistenceInfo(b *strings.Builder) {
+	if db.aof == nil {
+		WriteInfoField(b, "aof_enabled", 0)
+		return
+	}
+	st := db.aof.Status()
+	WriteInfoField(b, "aof_enabled", 1)
+	WriteInfoField(b, "aof_rewrite_in_progress", boolToInt(st.Rewriting))
+	WriteInfoField(b, "aof_last_bgrewrite_status", statusString(st.LastRewriteErr))
+	WriteInfoField(b, "aof_last_write_status", statusString(st.LastWriteErr))
+	if st.LastWriteErr != nil {
+		WriteInfoField(b, "aof_last_write_error", st.LastWriteErr.Error())
+	}
+	WriteInfoField(b, "aof_current_size", st.CurrentSize)
+	WriteInfoField(b, "aof_base_size", st.BaseSize)
+	WriteInfoField(b, "aof_buffer_length", st.BufferLength)
+}
+
+func (db *Db) keyspaceInfo(b *strings.Builder) {
+	for i, dict := range db.dicts {
+		if n := dict.Len(); n > 0 {
+			WriteInfoField(b, fmt.Sprintf("db%d", i), fmt.Sprintf("keys=%d", n))
+		}
+	}
+}
+
+func statusString(err error) string {
+	if err != nil {
+		return "err"
+	}
+	return "ok"
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"MiddlewareSelf/redis/client"
+	"MiddlewareSelf/redis/resp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execMigrate 实现 MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [KEYS key [key ...]]。
+// 键以 DUMP 格式序列化后通过 RESTORE-ASKING 写入目标实例（目标正在导入该槽时无需 ASKING），
+// 目标确认后（非 COPY）在本地删除并传播 DEL。网络操作不持有写锁，迁移期间其它命令照常执行。
+func (db *Db) execMigrate(index int, args [][]byte) (interface{}, error) {
+	if len(args) < 6 {
+		return nil, errors.New("wrong number of arguments for 'migrate'")
+	}
+	host, port := string(args[1]), string(args[2])
+	destDB, err := strconv.Atoi(string(args[4]))
+	if err != nil {
+		return nil, errors.New("value is not an integer or out of range")
+	}
+	timeoutMs, err := strconv.ParseInt(string(args[5]), 10, 64)
+	if err != nil {
+		return nil, errors.New("value is not an integer or out of range")
+	}
+	if timeoutMs <= 0 {
+		timeoutMs = 1000
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+
+	copyOnly, replace := false, false
+	keys := [][]byte{args[3]}
+	for i := 6; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "COPY":
+			copyOnly = true
+		case "REPLACE":
+			replace = true
+		case "KEYS":
+			if len(args[3]) != 0 {
+				return nil, errors.New("When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			keys = args[i+1:]
+			i = len(args)
+		default:
+			return nil, errors.New("syntax error")
+		}
+	}
+
+	dict, err := db.GetDict(index)
+	if err != nil {
+		return nil, err
+	}
+	commands := []client.Command{client.NewCommand("SELECT", strconv.Itoa(destDB))}
+	var migrated [][]byte
+	now := time.Now().UnixNano()
+	for _, key := range keys {
+		value, expire, ok := dict.GetWithExpire(string(key))
+		if !ok {
+			continue
+		}
+		payload, err := DumpValue(value)
+		if err != nil {
+			return nil, err
+		}
+		ttl := int64(0)
+		if expire > 0 {
+			if ttl = (expire - now) / 1e6; ttl < 1 {
+				ttl = 1
+			}
+		}
+		cmd := [][]byte{[]byte("RESTORE-ASKING"), key, []byte(strconv.FormatInt(ttl, 10)), payload}
+		if replace {
+			cmd = append(cmd, []byte("REPLACE"))
+		}
+		commands = append(commands, client.Command{Args: cmd})
+		migrated = append(migrated, key)
+	}
+	if len(migrated) == 0 {
+		return "NOKEY", nil
+	}
+
+	restored, err := restoreOnTarget(net.JoinHostPort(host, port), timeout, commands)
+	if !copyOnly && len(restored) > 0 {
+		del := append([][]byte{[]byte("DEL")}, restored...)
+		if _, delErr := db.Exec(index, del); delErr != nil && err == nil {
+			err = delErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return "OK", nil
+}
+
+// restoreOnTarget 以 pipeline 发送 SELECT + RESTORE-ASKING，返回目标确认写入的键。
+func restoreOnTarget(addr string, timeout time.Duration, commands []client.Command) ([][]byte, error) {
+	cli, err := client.DialPipeline(addr, timeout)
+	if err != nil {
+		return nil, MakeReplyError(fmt.Sprintf("IOERR error or timeout connecting to the client: %v", err))
+	}
+	defer cli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	results, err := cli.ExecStream(ctx, commands)
+	if err != nil {
+		return nil, MakeReplyError("IOERR error or timeout writing to target instance")
+	}
+
+	var restored [][]byte
+	var replyErr error
+	for res := range results {
+		if res.Err != nil {
+			replyErr = MakeReplyError("IOERR error or timeout reading to target instance")
+			continue
+		}
+		if e, ok := res.Reply.(*resp.ErrorReply); ok {
+			if replyErr == nil {
+				replyErr = fmt.Errorf("Target instance replied with error: %s", e.Error)
+			}
+			continue
+		}
+		// 第一条应答对应 SELECT
+		if i := res.ResponseIndex - 1; i > 0 {
+			restored = append(restored, commands[i].Args[1])
+		}
+	}
+	return restored, replyErr
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"MiddlewareSelf/redis/aof"
+	"MiddlewareSelf/redis/datastruct"
+	"log"
+)
+
+// 传播层：位于命令执行与 AOF/副本之间。
+//
+// 写命令执行后不再原样记录客户端参数，而是记录“效果命令”，保证重放得到相同状态：
+// - SETWITHTTL / SET EX|PX  -> SET key value PXAT <绝对毫秒>
+// - EXPIRE / PEXPIRE / EXPIREAT -> PEXPIREAT key <绝对毫秒>（已过期则为 DEL）
+// - INCRBYFLOAT -> SET key <结果> KEEPTTL
+// - 惰性过期、容量淘汰 -> 合成的 DEL
+// DB 切换由下游各自插入 SELECT（AOF 见 aof.Enqueue）。
+
+// Propagator 消费写命令传播流（如主从复制）。
+// cmds 为一次命令执行产生的效果命令；调用发生在 Db 的写临界区内，实现方应尽快返回。
+type Propagator interface {
+	Propagate(dbIndex int, cmds [][][]byte)
+}
+
+// AddPropagator 注册一个传播流消费者。
+func (db *Db) AddPropagator(p Propagator) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.propagators = append(db.propagators, p)
+}
+
+// RemovePropagator 注销传播流消费者。
+func (db *Db) RemovePropagator(p Propagator) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	for i, existing := range db.propagators {
+		if existing == p {
+			db.propagators = append(db.propagators[:i], db.propagators[i+1:]...)
+			return
+		}
+	}
+}
+
+// removal 是被 Dict 自动删除、需要以 DEL 传播的键。
+type removal struct {
+	dbIndex int
+	key     string
+	reason  datastruct.RemoveReason
+}
+
+// removeHook 在 Dict 锁内被调用，只记录不传播，由后续的 propagateLocked 统一发出。
+func (db *Db) removeHook(dbIndex int) func(key string, reason datastruct.RemoveReason) {
+	return func(key string, reason datastruct.RemoveReason) {
+		db.pendingMu.Lock()
+		db.pendingRemovals = append(db.pendingRemovals, removal{dbIndex: dbIndex, key: key, reason: reason})
+		db.pendingMu.Unlock()
+	}
+}
+
+func (db *Db) takeRemovals(reason datastruct.RemoveReason) []removal {
+	db.pendingMu.Lock()
+	defer db.pendingMu.Unlock()
+	var taken []removal
+	kept := db.pendingRemovals[:0]
+	for _, r := range db.pendingRemovals {
+		if r.reason == reason {
+			taken = append(taken, r)
+		} else {
+			kept = append(kept, r)
+		}
+	}
+	db.pendingRemovals = kept
+	return taken
+}
+
+// pendingWrite 是进入传播流的一组效果命令。先追加到 AOF，提交成功后才按顺序发给各个 Propagator；
+// 提交失败时用 undo 回滚对应的内存修改（惰性删除、淘汰等没有 undo，只是不再传播）。
+type pendingWrite struct {
+	dbIndex int
+	cmds    [][][]byte
+	commit  aof.Commit
+	// err 为 AOF 拒绝追加的原因
+	err  error
+	undo func()
+}
+
+// result 不阻塞地返回提交是否已有结果及其错误。
+func (w *pendingWrite) result() (bool, error) {
+	if w.err != nil {
+		return true, w.err
+	}
+	return w.commit.Poll()
+}
+
+func (w *pendingWrite) wait() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.commit.Wait()
+}
+
+// propagateLocked 把一次写命令的效果送入传播流，调用方需持有 db.writeMu。
+// undo 回滚本命令的内存修改，AOF 追加或提交失败时调用。
+//
+// 顺序：先发出此前惰性过期产生的 DEL（它们发生在本命令之前），再发本命令的效果，
+// 最后发本命令执行过程中触发的淘汰 DEL。返回决定本命令结果的写入 own 与传播流队尾 last，
+// 等待 last 即覆盖全部。own 为本命令效果对应的写入；没有效果的命令（如 DEL 不存在的键）
+// 的应答同样基于此前尚未提交的写入，own 取队尾，传播流为空时为 nil。
+func (db *Db) propagateLocked(dbIndex int, effects [][][]byte, undo func()) (own, last *pendingWrite) {
+	emit := func(idx int, cmds [][][]byte, undo func()) *pendingWrite {
+		if len(cmds) == 0 {
+			return nil
+		}
+		w := &pendingWrite{dbIndex: idx, cmds: cmds, undo: undo}
+		if db.aof != nil {
+			// 以上一次追加为 after：它失败后的追加都失败，失败的写入总在队尾，可以逆序回滚
+			var after aof.Commit
+			if n := len(db.inflight); n > 0 {
+				after, w.err = db.inflight[n-1].commit, db.inflight[n-1].err
+			}
+			if w.err == nil {
+				w.commit, w.err = db.aof.Enqueue(after, idx, cmds)
+			}
+		}
+		db.inflight = append(db.inflight, w)
+		return w
+	}
+
+	for _, r := range db.takeRemovals(datastruct.RemoveExpired) {
+		emit(r.dbIndex, [][][]byte{{[]byte("DEL"), []byte(r.key)}}, nil)
+	}
+	own = emit(dbIndex, effects, undo)
+	for _, r := range db.takeRemovals(datastruct.RemoveEvicted) {
+		emit(r.dbIndex, [][][]byte{{[]byte("DEL"), []byte(r.key)}}, nil)
+	}
+	if n := len(db.inflight); n > 0 {
+		last = db.inflight[n-1]
+		if own == nil {
+			own = last
+		}
+	}
+	db.settleLocked()
+	return own, last
+}
+
+// settleLocked 处理传播流中已有提交结果的写入，调用方需持有 db.writeMu：
+// 队首已落盘的写入按顺序发给各个 Propagator，队尾提交失败的写入从后往前回滚。
+// 失败写入之后追加的写入必然也失败（见 aof.Enqueue），所以失败的写入总是连续位于队尾。
+func (db *Db) settleLocked() {
+	for len(db.inflight) > 0 {
+		w := db.inflight[0]
+		if done, err := w.result(); !done || err != nil {
+			break
+		}
+		for _, p := range db.propagators {
+			p.Propagate(w.dbIndex, w.cmds)
+		}
+		db.inflight[0] = nil
+		db.inflight = db.inflight[1:]
+	}
+	for n := len(db.inflight); n > 0; n-- {
+		w := db.inflight[n-1]
+		if done, err := w.result(); !done || err == nil {
+			break
+		}
+		if w.undo != nil {
+			w.undo()
+		}
+		db.inflight[n-1] = nil
+		db.inflight = db.inflight[:n-1]
+	}
+}
+
+// waitSettled 等待 w 有提交结果并处理传播流，返回 w 的提交结果。
+func (db *Db) waitSettled(w *pendingWrite) error {
+	err := w.wait()
+	db.writeMu.Lock()
+	db.settleLocked()
+	db.writeMu.Unlock()
+	return err
+}
+
+// drainLocked 发出积压的惰性删除，并等待传播流中的写入全部处理完：成功的已发给各个 Propagator，
+// 失败的已回滚。调用方需持有 db.writeMu，等待期间会暂时释放。
+func (db *Db) drainLocked() {
+	for {
+		db.propagateLocked(0, nil, nil)
+		if len(db.inflight) == 0 {
+			return
+		}
+		last := db.inflight[len(db.inflight)-1]
+		db.writeMu.Unlock()
+		_ = last.wait()
+		db.writeMu.Lock()
+		db.settleLocked()
+	}
+}
+
+// propagatePendingRemovals 发出读命令期间惰性过期产生的 DEL。
+func (db *Db) propagatePendingRemovals() {
+	db.pendingMu.Lock()
+	empty := len(db.pendingRemovals) == 0
+	db.pendingMu.Unlock()
+	if empty {
+		return
+	}
+
+	db.writeMu.Lock()
+	_, last := db.propagateLocked(0, nil, nil)
+	db.writeMu.Unlock()
+	if last == nil {
+		return
+	}
+	if err := db.waitSettled(last); err != nil {
+		log.Printf("[DB] propagate expired keys failed: %v", err)
+	}
+}
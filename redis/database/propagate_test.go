@@ -0,0 +1,235 @@
+package database
+
+import (
+	"MiddlewareSelf/redis/aof"
+	"MiddlewareSelf/redis/datastruct"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingPropagator 记录传播流，测试中用来代替 AOF / 副本。
+type recordingPropagator struct {
+	mu   sync.Mutex
+	cmds []string
+}
+
+func (r *recordingPropagator) Propagate(dbIndex int, cmds [][][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, args := range cmds {
+		parts := make([]string, 0, len(args))
+		for _, arg := range args {
+			parts = append(parts, string(arg))
+		}
+		r.cmds = append(r.cmds, strings.Join(parts, " "))
+	}
+}
+
+func (r *recordingPropagator) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := r.cmds
+	r.cmds = nil
+	return out
+}
+
+func toArgs(line string) [][]byte {
+	var args [][]byte
+	for _, f := range strings.Fields(line) {
+		args = append(args, []byte(f))
+	}
+	return args
+}
+
+func mustExec(t *testing.T, db *Db, index int, line string) interface{} {
+	t.Helper()
+	reply, err := db.Exec(index, toArgs(line))
+	if err != nil {
+		t.Fatalf("%s failed: %v", line, err)
+	}
+	return reply
+}
+
+func TestPropagateEffectiveCommands(t *testing.T) {
+	db, err := MakeDbsWithOptions(aof.DefaultLoadOptions)
+	if err != nil {
+		t.Fatalf("MakeDbsWithOptions failed: %v", err)
+	}
+	rec := &recordingPropagator{}
+	db.AddPropagator(rec)
+
+	mustExec(t, db, 0, "SETWITHTTL k v 100000")
+	got := rec.take()
+	if len(got) != 1 || !strings.HasPrefix(got[0], "SET k v PXAT ") {
+		t.Fatalf("SETWITHTTL should propagate as SET PXAT, got %q", got)
+	}
+
+	mustExec(t, db, 0, "EXPIRE k 100")
+	if got := rec.take(); len(got) != 1 || !strings.HasPrefix(got[0], "PEXPIREAT k ") {
+		t.Fatalf("EXPIRE should propagate as PEXPIREAT, got %q", got)
+	}
+
+	mustExec(t, db, 0, "INCRBYFLOAT n 1.5")
+	mustExec(t, db, 0, "INCRBYFLOAT n 1.5")
+	if got := rec.take(); len(got) != 2 || got[1] != "SET n 3 KEEPTTL" {
+		t.Fatalf("INCRBYFLOAT should propagate as SET KEEPTTL, got %q", got)
+	}
+
+	if reply := mustExec(t, db, 0, "DEL missing"); reply != 0 {
+		t.Fatalf("DEL missing should return 0, got %v", reply)
+	}
+	if got := rec.take(); len(got) != 0 {
+		t.Fatalf("DEL of a missing key should not propagate, got %q", got)
+	}
+
+	// 惰性过期产生合成 DEL
+	mustExec(t, db, 3, "SET tmp v PX 1")
+	rec.take()
+	time.Sleep(5 * time.Millisecond)
+	if reply := mustExec(t, db, 3, "GET tmp"); reply != nil {
+		t.Fatalf("expired key should be gone, got %v", reply)
+	}
+	if got := rec.take(); len(got) != 1 || got[0] != "DEL tmp" {
+		t.Fatalf("lazy expiry should propagate DEL, got %q", got)
+	}
+}
+
+func TestPropagateEviction(t *testing.T) {
+	db, err := MakeDbsWithOptions(aof.DefaultLoadOptions)
+	if err != nil {
+		t.Fatalf("MakeDbsWithOptions failed: %v", err)
+	}
+	// 容量只够放下一个键，第二次 SET 会淘汰第一个
+	db.dicts[0] = datastruct.MakeDictWithCapacity(3)
+	db.dicts[0].SetRemoveHook(db.removeHook(0))
+	rec := &recordingPropagator{}
+	db.AddPropagator(rec)
+
+	mustExec(t, db, 0, "SET a 1")
+	mustExec(t, db, 0, "SET b 2")
+	got := rec.take()
+	if len(got) != 3 || got[1] != "SET b 2" || got[2] != "DEL a" {
+		t.Fatalf("eviction should propagate DEL after the command, got %q", got)
+	}
+}
+
+func requireMisconf(t *testing.T, err error, what string) {
+	t.Helper()
+	var replyErr *ReplyError
+	if !errors.As(err, &replyErr) || !strings.HasPrefix(replyErr.Msg, "MISCONF") {
+		t.Fatalf("%s: expected MISCONF, got %v", what, err)
+	}
+}
+
+// AOF 提交失败时写命令不生效：客户端收到 MISCONF，内存回到执行前的状态，副本也收不到。
+// 并发写入中，失败批次之后的写入一并失败并按逆序回滚。
+func TestWriteRolledBackOnCommitFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), aof.AofName)
+	a, err := aof.NewAOFWithFile(aof.SyncAlways, path)
+	if err != nil {
+		t.Fatalf("NewAOFWithFile failed: %v", err)
+	}
+	db, err := MakeDbsWithOptions(aof.DefaultLoadOptions)
+	if err != nil {
+		t.Fatalf("MakeDbsWithOptions failed: %v", err)
+	}
+	db.aof = a
+	rec := &recordingPropagator{}
+	db.AddPropagator(rec)
+
+	const keys = 20
+	for i := 0; i < keys; i++ {
+		mustExec(t, db, 0, fmt.Sprintf("SET k%d old", i))
+	}
+	mustExec(t, db, 1, "SET other old")
+	if got := rec.take(); len(got) != keys+1 {
+		t.Fatalf("committed writes should reach the propagator, got %q", got)
+	}
+	before, err := aof.Check(path)
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	// 用只读句柄模拟磁盘不可写，Close 时关闭的是它
+	writable := a.File
+	defer writable.Close()
+	readonly, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open readonly failed: %v", err)
+	}
+	a.File = readonly
+	defer db.Close()
+
+	lines := []string{"FLUSHALL"}
+	for i := 0; i < keys; i++ {
+		lines = append(lines, []string{"SET k%d new", "DEL k%d", "EXPIRE k%d 100", "SET k%d new PX 100000"}[i%4])
+		lines[len(lines)-1] = fmt.Sprintf(lines[len(lines)-1], i)
+	}
+	lines = append(lines, "FLUSHDB")
+	var wg sync.WaitGroup
+	errs := make([]error, len(lines))
+	for i, line := range lines {
+		wg.Add(1)
+		go func(i int, line string) {
+			defer wg.Done()
+			_, errs[i] = db.Exec(0, toArgs(line))
+		}(i, line)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		requireMisconf(t, err, lines[i])
+	}
+
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if got := mustExec(t, db, 0, "GET "+key); string(got.([]byte)) != "old" {
+			t.Fatalf("GET %s = %q after failed writes", key, got)
+		}
+		if ttl := mustExec(t, db, 0, "TTL "+key); ttl != -1 {
+			t.Fatalf("TTL %s = %v after failed writes", key, ttl)
+		}
+	}
+	if got := mustExec(t, db, 1, "GET other"); string(got.([]byte)) != "old" {
+		t.Fatalf("GET other = %q after failed FLUSHALL", got)
+	}
+	if got := rec.take(); len(got) != 0 {
+		t.Fatalf("failed writes should not be propagated, got %q", got)
+	}
+	after, err := aof.Check(path)
+	if err != nil || after.Commands != before.Commands {
+		t.Fatalf("failed writes should be discarded from the AOF: before=%+v after=%+v err=%v", before, after, err)
+	}
+}
+
+// AOF 拒绝追加（已关闭）时同样回滚内存修改。
+func TestWriteRolledBackOnEnqueueFailure(t *testing.T) {
+	a, err := aof.NewAOFWithFile(aof.SyncEverySec, filepath.Join(t.TempDir(), aof.AofName))
+	if err != nil {
+		t.Fatalf("NewAOFWithFile failed: %v", err)
+	}
+	db, err := MakeDbsWithOptions(aof.DefaultLoadOptions)
+	if err != nil {
+		t.Fatalf("MakeDbsWithOptions failed: %v", err)
+	}
+	db.aof = a
+	rec := &recordingPropagator{}
+	db.AddPropagator(rec)
+	mustExec(t, db, 0, "SET k old")
+	rec.take()
+
+	a.Close()
+	_, err = db.Exec(0, toArgs("SET k new"))
+	requireMisconf(t, err, "SET after Close")
+	if got := mustExec(t, db, 0, "GET k"); string(got.([]byte)) != "old" {
+		t.Fatalf("GET k = %q after a rejected write", got)
+	}
+	if got := rec.take(); len(got) != 0 {
+		t.Fatalf("rejected write should not be propagated, got %q", got)
+	}
+}
@@ -14,8 +14,20 @@ type Dict struct {
 	mu       sync.RWMutex
 	data     map[string]*entity
 	ll       *list.List
+	// removeHook 在键被自动删除（惰性过期、容量淘汰）时回调，用于向 AOF/副本传播 DEL
+	removeHook func(key string, reason RemoveReason)
+	// slots 为集群模式下的槽 -> 键索引，未启用时为 nil
+	slots *slotIndex
 }
 
+// RemoveReason 表示键被 Dict 自动删除的原因。
+type RemoveReason int
+
+const (
+	RemoveExpired RemoveReason = iota // 惰性删除过期键
+	RemoveEvicted                     // 超出容量被 LRU 淘汰
+)
+
 type Value interface {
 	Len() int
 }
@@ -51,36 +63,90 @@ func MakeDictWithCapacity(capacity int64) *Dict {
 	}
 }
 
+// SetRemoveHook 注册自动删除回调。回调在持有 Dict 锁时执行，不能再调用 Dict 的方法。
+func (d *Dict) SetRemoveHook(hook func(key string, reason RemoveReason)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.removeHook = hook
+}
+
 func (d *Dict) Get(key string) (Value, bool) {
+	value, _, ok := d.GetWithExpire(key)
+	return value, ok
+}
+
+// GetWithExpire 返回值及其绝对过期时间（UnixNano，0 表示永不过期）。
+func (d *Dict) GetWithExpire(key string) (Value, int64, bool) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	if v, ok := d.data[key]; ok {
 		//惰性删除
 		if v.expire > 0 && time.Now().UnixNano() > v.expire {
-			d.ll.Remove(v.listElem)
-			delete(d.data, v.key)
-			d.nbytes -= int64(len(v.key)) + int64(v.value.Len())
+			d.removeLocked(v)
 			//delete(d.data, key)
-			return nil, false
+			if d.removeHook != nil {
+				d.removeHook(v.key, RemoveExpired)
+			}
+			return nil, 0, false
 		} else {
 			d.ll.MoveToFront(v.listElem)
-			return v.value, true
+			return v.value, v.expire, true
 		}
 	}
-	return nil, false
+	return nil, 0, false
 }
 
 func (d *Dict) SetWithTTL(key string, value Value, ttl int64) {
 	//ttl: ms
-	d.mu.Lock()
-	defer d.mu.Unlock()
 	var expire int64
 	if ttl > 0 {
 		expire = time.Now().UnixNano() + ttl*1e6
 	} else {
 		expire = 0
 	}
+	d.SetWithExpireAt(key, value, expire)
+}
+
+// SetWithExpireAt 写入键并设置绝对过期时间（UnixNano，0 表示永不过期）。
+// 传播给 AOF/副本的命令使用绝对时间，重放时不受执行时刻影响。
+func (d *Dict) SetWithExpireAt(key string, value Value, expire int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.setLocked(key, value, expire)
+}
+
+// SetKeepTTL 写入键但保留原有的过期时间。
+func (d *Dict) SetKeepTTL(key string, value Value) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var expire int64
+	if v, ok := d.data[key]; ok {
+		expire = v.expire
+	}
+	d.setLocked(key, value, expire)
+}
+
+// ExpireAt 修改已有键的绝对过期时间，键不存在时返回 false。
+func (d *Dict) ExpireAt(key string, expire int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, ok := d.data[key]
+	if !ok {
+		return false
+	}
+	if v.expire > 0 && time.Now().UnixNano() > v.expire {
+		d.removeLocked(v)
+		if d.removeHook != nil {
+			d.removeHook(v.key, RemoveExpired)
+		}
+		return false
+	}
+	v.expire = expire
+	return true
+}
+
+func (d *Dict) setLocked(key string, value Value, expire int64) {
 	if v, ok := d.data[key]; ok {
 		// 已有 Key
 		delta := int64(value.Len() - v.value.Len())
@@ -98,6 +164,9 @@ func (d *Dict) SetWithTTL(key string, value Value, ttl int64) {
 		ent.listElem = d.ll.PushFront(ent)
 		d.data[key] = ent
 		d.nbytes += int64(len(key)) + int64(value.Len())
+		if d.slots != nil {
+			d.slots.add(key)
+		}
 		//v.expire = expire
 	}
 	for d.capacity > 0 && d.nbytes > d.capacity {
@@ -105,6 +174,17 @@ func (d *Dict) SetWithTTL(key string, value Value, ttl int64) {
 	}
 }
 
+// MarkIdle 把已有键移到 LRU 链表尾部，使其最先被淘汰，键不存在时返回 false。
+func (d *Dict) MarkIdle(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v, ok := d.data[key]
+	if ok {
+		d.ll.MoveToBack(v.listElem)
+	}
+	return ok
+}
+
 func (d *Dict) Set(key string, value Value) {
 	d.SetWithTTL(key, value, 0)
 }
@@ -112,10 +192,11 @@ func (d *Dict) Set(key string, value Value) {
 func (d *Dict) RemoveOldest() {
 	elem := d.ll.Back()
 	if elem != nil {
-		d.ll.Remove(elem)
 		ent := elem.Value.(*entity)
-		delete(d.data, ent.key)
-		d.nbytes -= int64(len(ent.key)) + int64(ent.value.Len())
+		d.removeLocked(ent)
+		if d.removeHook != nil {
+			d.removeHook(ent.key, RemoveEvicted)
+		}
 	}
 }
 
@@ -123,9 +204,17 @@ func (d *Dict) Remove(key string) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	if v, ok := d.data[key]; ok {
-		d.ll.Remove(v.listElem)
-		delete(d.data, v.key)
-		d.nbytes -= int64(len(v.key)) + int64(v.value.Len())
+		d.removeLocked(v)
+	}
+}
+
+// removeLocked 从数据、LRU 链表与槽索引中删除一项，不触发 removeHook。
+func (d *Dict) removeLocked(v *entity) {
+	d.ll.Remove(v.listElem)
+	delete(d.data, v.key)
+	d.nbytes -= int64(len(v.key)) + int64(v.value.Len())
+	if d.slots != nil {
+		d.slots.remove(v.key)
 	}
 }
 
@@ -141,6 +230,9 @@ func (d *Dict) Clear() {
 	d.data = make(map[string]*entity)
 	d.ll.Init()
 	d.nbytes = 0
+	if d.slots != nil {
+		d.slots = newSlotIndex(d.slots.slotOf)
+	}
 }
 
 // Snapshot 返回当前字典的只读快照切片。
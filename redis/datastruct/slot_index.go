@@ -0,0 +1,73 @@
+package datastruct
+
+import "sort"
+
+// slotIndex 记录每个槽中的键，集群模式下用于 CLUSTER COUNTKEYSINSLOT / GETKEYSINSLOT
+// 以及按槽迁移键，避免为此扫描整个 Dict。由 Dict.mu 保护。
+type slotIndex struct {
+	slotOf func(key string) int
+	slots  map[int]map[string]struct{}
+}
+
+func newSlotIndex(slotOf func(key string) int) *slotIndex {
+	return &slotIndex{slotOf: slotOf, slots: make(map[int]map[string]struct{})}
+}
+
+func (idx *slotIndex) add(key string) {
+	slot := idx.slotOf(key)
+	keys, ok := idx.slots[slot]
+	if !ok {
+		keys = make(map[string]struct{})
+		idx.slots[slot] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (idx *slotIndex) remove(key string) {
+	slot := idx.slotOf(key)
+	if keys, ok := idx.slots[slot]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(idx.slots, slot)
+		}
+	}
+}
+
+// EnableSlotIndex 启用槽索引，slotOf 计算键所属的槽。已有的键会立即加入索引。
+func (d *Dict) EnableSlotIndex(slotOf func(key string) int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.slots = newSlotIndex(slotOf)
+	for key := range d.data {
+		d.slots.add(key)
+	}
+}
+
+// CountKeysInSlot 返回槽中的键数量，未启用槽索引时返回 0。
+// 与 Redis 一样，已过期但尚未被惰性删除的键也会计入。
+func (d *Dict) CountKeysInSlot(slot int) int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.slots == nil {
+		return 0
+	}
+	return len(d.slots.slots[slot])
+}
+
+// KeysInSlot 按字典序返回槽中最多 count 个键。
+func (d *Dict) KeysInSlot(slot int, count int) []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.slots == nil || count <= 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(d.slots.slots[slot]))
+	for key := range d.slots.slots[slot] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) > count {
+		keys = keys[:count]
+	}
+	return keys
+}
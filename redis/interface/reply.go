@@ -1,5 +1,9 @@
 package _interface
 
+import "io"
+
 type Reply interface {
 	ToBytes() []byte
+	// WriteTo 把编码直接写入 w（如连接的输出缓冲），不生成中间的 []byte
+	WriteTo(w io.Writer) (int64, error)
 }
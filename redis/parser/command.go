@@ -0,0 +1,73 @@
+package parser
+
+import "bytes"
+
+// ParseCommand 从 buf 开头解析一条完整的命令，供事件循环等不能阻塞读取的场景使用。
+// 参数直接指向 buf（追加到 args[:0]，复用其空间），n 为消耗的字节数；
+// 数据还不完整时 n 为 0，调用方应在收到更多数据后从同一位置重新解析。
+// 空行、*0 等不是命令的内容返回空参数与 n > 0。限制与错误同 Reader。
+func ParseCommand(buf []byte, args [][]byte, maxBulkLen int64) (_ [][]byte, n int, err error) {
+	args = args[:0]
+	line, pos, ok := cutLine(buf, 0)
+	if !ok {
+		if len(buf) > MaxInlineSize+2 {
+			if buf[0] == '*' {
+				return args, 0, errTooBigMultiBulk
+			}
+			return args, 0, errTooBigInline
+		}
+		return args, 0, nil
+	}
+	if len(line) == 0 {
+		return args, pos, nil
+	}
+	if line[0] != '*' {
+		inline, err := splitInline(line)
+		return inline, pos, err
+	}
+
+	count, ok := parseLen(line[1:])
+	if !ok || count > MaxMultiBulkLen {
+		return args, 0, errInvalidMultiBulkLen
+	}
+	for i := int64(0); i < count; i++ {
+		line, next, ok := cutLine(buf, pos)
+		if !ok {
+			if len(buf)-pos > MaxInlineSize+2 {
+				return args[:0], 0, errTooBigBulkCount
+			}
+			return args[:0], 0, nil
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return args[:0], 0, errExpectedBulk(line)
+		}
+		size, ok := parseLen(line[1:])
+		if !ok || size < 0 || size > maxBulkLen {
+			return args[:0], 0, errInvalidBulkLen
+		}
+		// 只比较长度，数据未到齐时重新解析的代价与参数个数成正比，与数据大小无关
+		end := next + int(size)
+		if len(buf) < end+2 {
+			return args[:0], 0, nil
+		}
+		if buf[end] != '\r' || buf[end+1] != '\n' {
+			return args[:0], 0, errMissingCRLF
+		}
+		args = append(args, buf[next:end:end])
+		pos = end + 2
+	}
+	return args, pos, nil
+}
+
+// cutLine 返回 buf[from:] 中的第一行（去掉 \n 或 \r\n）与下一行的起始位置，没有完整的行时 ok 为 false。
+func cutLine(buf []byte, from int) (line []byte, next int, ok bool) {
+	i := bytes.IndexByte(buf[from:], '\n')
+	if i < 0 {
+		return nil, 0, false
+	}
+	line = buf[from : from+i]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, from + i + 1, true
+}
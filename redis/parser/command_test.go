@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// 数据逐字节到达：不完整时不消耗任何字节，到齐后得到与 Reader 相同的结果。
+func TestParseCommandIncremental(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$4\r\na\r\nb\r\n" +
+		"\r\n*0\r\n" +
+		"PING\n" +
+		"*2\r\n$3\r\nGET\r\n$0\r\n\r\n" +
+		"set 'a b' \"c\\x41\"\r\n"
+	want := [][]string{
+		{"SET", "k", "a\r\nb"},
+		{"PING"},
+		{"GET", ""},
+		{"set", "a b", "cA"},
+	}
+
+	var got [][]string
+	var args [][]byte
+	buf := []byte{}
+	for i := 0; i < len(input); i++ {
+		buf = append(buf, input[i])
+		for len(buf) > 0 {
+			var n int
+			var err error
+			args, n, err = ParseCommand(buf, args, DefaultMaxBulkLen)
+			if err != nil {
+				t.Fatalf("offset %d: %v", i, err)
+			}
+			if n == 0 {
+				break
+			}
+			if len(args) > 0 {
+				cmd := make([]string, len(args))
+				for j, arg := range args {
+					cmd[j] = string(arg)
+				}
+				got = append(got, cmd)
+			}
+			buf = buf[n:]
+		}
+	}
+	if len(buf) != 0 {
+		t.Fatalf("unconsumed input %q", buf)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("commands = %q, want %q", got, want)
+	}
+	for i := range want {
+		if strings.Join(got[i], "|") != strings.Join(want[i], "|") {
+			t.Fatalf("command %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseCommandLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"multibulk too long", "*1048577\r\n", "ERR Protocol error: invalid multibulk length"},
+		{"bulk too long", "*1\r\n$1025\r\n", "ERR Protocol error: invalid bulk length"},
+		{"not a bulk", "*1\r\n:1\r\n", "ERR Protocol error: expected '$', got ':'"},
+		{"missing CRLF", "*1\r\n$3\r\nfooXX", "ERR Protocol error: missing CRLF after bulk string"},
+		{"too big mbulk count", "*" + strings.Repeat("1", MaxInlineSize+8), "ERR Protocol error: too big mbulk count string"},
+		{"too big bulk count", "*1\r\n$" + strings.Repeat("1", MaxInlineSize+8), "ERR Protocol error: too big bulk count string"},
+		{"too big inline", strings.Repeat("a", MaxInlineSize+8), "ERR Protocol error: too big inline request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ParseCommand([]byte(tt.input), nil, 1024)
+			var protoErr *ProtocolError
+			if !errors.As(err, &protoErr) || err.Error() != tt.want {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// MaxInlineSize 为单行（inline 命令或 RESP 头部）的最大长度，与 Redis 的 PROTO_INLINE_MAX_SIZE 一致。
+const MaxInlineSize = 64 * 1024
+
+var (
+	errTooBigInline     = &ProtocolError{"too big inline request"}
+	errUnbalancedQuotes = &ProtocolError{"unbalanced quotes in request"}
+)
+
+// readQueryLine 读取一行并去掉行尾的 \n 或 \r\n（inline 命令允许只以 \n 结尾），
+// 超过 MaxInlineSize 仍未读到换行时返回 errTooBigInline，避免无换行的数据无限占用内存。
+func readQueryLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > MaxInlineSize+2 {
+			return nil, errTooBigInline
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		line = line[:len(line)-1]
+		return bytes.TrimSuffix(line, []byte{'\r'}), nil
+	}
+}
+
+// splitInline 按 Redis 的 sdssplitargs 规则切分 inline 命令：空白分隔参数，
+// 双引号内支持 \n \r \t \b \a \\ \" 与 \xHH 转义，单引号内只支持 \'；
+// 右引号后必须是空白或行尾，否则与引号未闭合一样返回 errUnbalancedQuotes。
+func splitInline(line []byte) ([][]byte, error) {
+	var args [][]byte
+	p := 0
+	for {
+		for p < len(line) && isSpace(line[p]) {
+			p++
+		}
+		if p == len(line) {
+			return args, nil
+		}
+
+		inDouble, inSingle, done := false, false, false
+		current := []byte{}
+		for !done {
+			switch {
+			case inDouble:
+				if p == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				c := line[p]
+				switch {
+				case c == '\\' && p+3 < len(line) && line[p+1] == 'x' && isHex(line[p+2]) && isHex(line[p+3]):
+					current = append(current, hexValue(line[p+2])<<4|hexValue(line[p+3]))
+					p += 3
+				case c == '\\' && p+1 < len(line):
+					p++
+					current = append(current, unescape(line[p]))
+				case c == '"':
+					if p+1 < len(line) && !isSpace(line[p+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				default:
+					current = append(current, c)
+				}
+			case inSingle:
+				if p == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				c := line[p]
+				switch {
+				case c == '\\' && p+1 < len(line) && line[p+1] == '\'':
+					p++
+					current = append(current, '\'')
+				case c == '\'':
+					if p+1 < len(line) && !isSpace(line[p+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				default:
+					current = append(current, c)
+				}
+			default:
+				if p == len(line) {
+					done = true
+					break
+				}
+				switch c := line[p]; {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inDouble = true
+				case c == '\'':
+					inSingle = true
+				default:
+					current = append(current, c)
+				}
+			}
+			if p < len(line) {
+				p++
+			}
+		}
+		args = append(args, current)
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+	return c
+}
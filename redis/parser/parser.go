@@ -30,7 +30,7 @@ func parse(rawReader io.Reader, ch chan *Payload) {
 	defer close(ch)
 	reader := bufio.NewReader(rawReader)
 	for {
-		line, err := reader.ReadBytes('\n')
+		line, err := readQueryLine(reader)
 		if err != nil {
 			if err == io.EOF {
 				ch <- &Payload{Err: errors.New("EOF")}
@@ -38,12 +38,14 @@ func parse(rawReader io.Reader, ch chan *Payload) {
 			if errors.Is(err, os.ErrDeadlineExceeded) {
 				ch <- &Payload{Err: errors.New("os.ErrDeadlineExceeded")}
 			}
+			if err == errTooBigInline {
+				ch <- &Payload{Err: err}
+			}
 			//close(ch)
 			return
 		}
-		line = bytes.TrimSuffix(line, []byte{'\r', '\n'})
 		if len(line) == 0 {
-			ch <- &Payload{Err: errors.New("empty line")}
+			// 与 Redis 一致，空行（如 telnet 中直接回车）忽略
 			continue
 		}
 		switch line[0] {
@@ -72,69 +74,39 @@ func parse(rawReader io.Reader, ch chan *Payload) {
 			parseBulk(reader, ch, line)
 		case '*':
 			parseArray(reader, ch, line)
+		case '#':
+			// RESP3 boolean 为 #t / #f，其余为 AOF 注解行（如 #TS:1700000000），不是命令，直接跳过
+			if !isResp3Bool(line) {
+				continue
+			}
+			parseResp3(reader, ch, line)
+		case '_', ',', '(', '=', '!', '%', '~', '|', '>':
+			parseResp3(reader, ch, line)
 		default:
-			ch <- &Payload{Err: errors.New("error pattern.please write again")}
+			// 不以 RESP 类型字节开头的行按 inline 命令解析（telnet / nc 直接输入 PING、SET a b）
+			args, err := splitInline(line)
+			if err != nil {
+				// 协议错误后无法确定下一条命令的边界，应答错误后结束解析
+				ch <- &Payload{Err: err}
+				return
+			}
+			if len(args) == 0 {
+				continue
+			}
+			ch <- &Payload{Data: resp.MakeArrayReply(args)}
 		}
 	}
 }
 
+// parseArray 解析数组。元素全部为 bulk string（或 nil）时为 ArrayReply，
+// 否则（整数、错误、嵌套数组等）为逐元素解析的 MultiBulkReply。
 func parseArray(reader *bufio.Reader, ch chan<- *Payload, header []byte) {
-	nStrs, err := strconv.ParseInt(string(header[1:]), 10, 64)
-	if err != nil || nStrs < -1 {
-		ch <- &Payload{Err: errors.New("invalid array format")}
-		return
-	} else if nStrs == -1 {
-		// Null Array
-		ch <- &Payload{
-			Data: resp.MakeArrayReply(nil),
-		}
-		return
-	} else if nStrs == 0 {
-		// Empty Array
-		ch <- &Payload{
-			Data: resp.MakeArrayReply([][]byte{}), // 空切片
-		}
+	reply, err := readValue(reader, header)
+	if err != nil {
+		ch <- &Payload{Err: err}
 		return
 	}
-
-	lines := make([][]byte, 0, int(nStrs))
-	for i := int64(0); i < nStrs; i++ {
-		line, err := reader.ReadBytes('\n')
-		if err != nil {
-			ch <- &Payload{Err: errors.New("invalid array length")}
-			return
-		}
-		line = bytes.TrimSuffix(line, []byte{'\r', '\n'})
-
-		// 简单检查格式，必须以 $ 开头
-		if len(line) < 2 || line[0] != '$' {
-			ch <- &Payload{Err: errors.New("invalid array element header")}
-			return
-		}
-
-		strLenStr := string(line[1:])
-		strLen, err := strconv.ParseInt(strLenStr, 10, 64)
-
-		if err != nil || strLen < -1 {
-			ch <- &Payload{Err: errors.New("invalid array bulk length")}
-			return
-		}
-
-		if strLen == -1 {
-			lines = append(lines, nil)
-		} else {
-			body := make([]byte, strLen+2)
-			_, err := io.ReadFull(reader, body)
-			if err != nil {
-				ch <- &Payload{Err: errors.New("invalid array parse")}
-				return
-			}
-			lines = append(lines, body[:strLen])
-		}
-	}
-	ch <- &Payload{
-		Data: resp.MakeArrayReply(lines),
-	}
+	ch <- &Payload{Data: reply}
 }
 
 func parseBulk(reader *bufio.Reader, ch chan *Payload, line []byte) {
@@ -111,7 +111,9 @@ func TestParser(t *testing.T) {
 		{"null array", "*-1\r\n", false},
 		{"empty array", "*0\r\n", false},
 		{"array", "*2\r\n$5\r\nhello\r\n$5\r\nworld\r\n", false},
-		{"invalid format", "invalid\r\n", true},
+		{"aof annotation skipped", "#TS:1700000000\r\n+OK\r\n", false},
+		{"inline command", "PING\r\n", false},
+		{"unbalanced quotes", "SET \"a b\r\n", true},
 		{"incomplete", "+OK", true}, // 缺少\r\n
 	}
 
@@ -125,3 +127,142 @@ func TestParser(t *testing.T) {
 		})
 	}
 }
+
+func TestParseStream_Resp3(t *testing.T) {
+	input := "_\r\n#t\r\n#TS:1700000000\r\n#f\r\n,1.5\r\n,-inf\r\n(3492890328409238509324850943850943825024385\r\n" +
+		"=15\r\ntxt:Some string\r\n!21\r\nSYNTAX invalid syntax\r\n" +
+		"%2\r\n+first\r\n:1\r\n$6\r\nsecond\r\n~2\r\n:1\r\n#f\r\n" +
+		"~3\r\n+a\r\n:1\r\n_\r\n" +
+		"|1\r\n+key-popularity\r\n%1\r\n$1\r\na\r\n,0.19\r\n*2\r\n$1\r\nx\r\n$1\r\ny\r\n" +
+		">2\r\n$7\r\nmessage\r\n$2\r\nhi\r\n"
+	want := []string{
+		"_\r\n",
+		"#t\r\n",
+		"#f\r\n",
+		",1.5\r\n",
+		",-inf\r\n",
+		"(3492890328409238509324850943850943825024385\r\n",
+		"=15\r\ntxt:Some string\r\n",
+		"-SYNTAX invalid syntax\r\n",
+		"%2\r\n+first\r\n:1\r\n$6\r\nsecond\r\n~2\r\n:1\r\n#f\r\n",
+		"~3\r\n+a\r\n:1\r\n_\r\n",
+		"|1\r\n+key-popularity\r\n%1\r\n$1\r\na\r\n,0.19\r\n*2\r\n$1\r\nx\r\n$1\r\ny\r\n",
+		">2\r\n$7\r\nmessage\r\n$2\r\nhi\r\n",
+	}
+
+	ch := ParseStream(strings.NewReader(input))
+	for i, w := range want {
+		p := <-ch
+		if p == nil || p.Err != nil {
+			t.Fatalf("#%d: unexpected payload %+v", i, p)
+		}
+		if got := string(p.Data.ToBytes()); got != w {
+			t.Fatalf("#%d: got %q, want %q", i, got, w)
+		}
+	}
+}
+
+func TestResp3DowngradeToResp2(t *testing.T) {
+	m := resp.MakeMapReply([]_interface.Reply{
+		resp.MakeBulkReply([]byte("f")), resp.MakeBoolReply(true),
+		resp.MakeBulkReply([]byte("g")), resp.MakeDoubleReply(2.5),
+	})
+	tests := []struct {
+		reply        _interface.Reply
+		resp2, resp3 string
+	}{
+		{m, "*4\r\n$1\r\nf\r\n:1\r\n$1\r\ng\r\n$3\r\n2.5\r\n", "%2\r\n$1\r\nf\r\n#t\r\n$1\r\ng\r\n,2.5\r\n"},
+		{resp.MakeNullReply(), "$-1\r\n", "_\r\n"},
+		{resp.MakeBulkReply(nil), "$-1\r\n", "_\r\n"},
+		{resp.MakeArrayReply([][]byte{[]byte("a"), nil}), "*2\r\n$1\r\na\r\n$-1\r\n", "*2\r\n$1\r\na\r\n_\r\n"},
+		{resp.MakeVerbatimReply("txt", []byte("hi")), "$2\r\nhi\r\n", "=6\r\ntxt:hi\r\n"},
+		{resp.MakeAttributeReply(nil, resp.MakeIntegerReply(1)), ":1\r\n", "|0\r\n:1\r\n"},
+	}
+	for i, tt := range tests {
+		if got := string(resp.Encode(tt.reply, resp.RESP2)); got != tt.resp2 {
+			t.Errorf("#%d RESP2: got %q, want %q", i, got, tt.resp2)
+		}
+		if got := string(resp.Encode(tt.reply, resp.RESP3)); got != tt.resp3 {
+			t.Errorf("#%d RESP3: got %q, want %q", i, got, tt.resp3)
+		}
+	}
+}
+
+func TestParseStream_NestedArray(t *testing.T) {
+	// EXEC 风格：整数、错误、nil、嵌套数组混合
+	input := "*5\r\n:1\r\n-ERR wrong type\r\n$-1\r\n*2\r\n$1\r\na\r\n*1\r\n:7\r\n*-1\r\n" +
+		"*2\r\n$1\r\na\r\n_\r\n"
+	ch := ParseStream(strings.NewReader(input))
+
+	p := <-ch
+	multi, ok := p.Data.(*resp.MultiBulkReply)
+	if p.Err != nil || !ok || len(multi.Replies) != 5 {
+		t.Fatalf("expect MultiBulkReply with 5 elements, got %#v (err %v)", p.Data, p.Err)
+	}
+	if _, ok := multi.Replies[3].(*resp.MultiBulkReply); !ok {
+		t.Fatalf("element #4 should be a nested MultiBulkReply, got %T", multi.Replies[3])
+	}
+	if want := input[:len(input)-len("*2\r\n$1\r\na\r\n_\r\n")]; string(multi.ToBytes()) != want {
+		t.Fatalf("round trip: got %q, want %q", multi.ToBytes(), want)
+	}
+
+	// 元素全部为 bulk 或 null 时仍是 ArrayReply，命令解析路径不变
+	p = <-ch
+	assertReplyEqual(t, p.Data, resp.MakeArrayReply([][]byte{[]byte("a"), nil}))
+}
+
+func TestParseStream_Inline(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"simple", "PING\r\n", []string{"PING"}},
+		{"lf only", "SET a b\n", []string{"SET", "a", "b"}},
+		{"extra spaces", "  SET\t a   b  \r\n", []string{"SET", "a", "b"}},
+		{"double quotes", `SET k "hello world"` + "\r\n", []string{"SET", "k", "hello world"}},
+		{"escapes", `SET k "a\nb\t\"c\"\x41\\"` + "\r\n", []string{"SET", "k", "a\nb\t\"c\"A\\"}},
+		{"single quotes", `SET k 'it\'s "raw" \n'` + "\r\n", []string{"SET", "k", `it's "raw" \n`}},
+		{"empty quoted arg", `SET k ""` + "\r\n", []string{"SET", "k", ""}},
+		{"empty lines skipped", "\r\n\n  \r\nPING\r\n", []string{"PING"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := <-ParseStream(strings.NewReader(tt.input))
+			if p.Err != nil {
+				t.Fatalf("unexpected error: %v", p.Err)
+			}
+			args := p.Data.(*resp.ArrayReply).Args
+			if len(args) != len(tt.want) {
+				t.Fatalf("got %q, want %q", args, tt.want)
+			}
+			for i := range args {
+				if string(args[i]) != tt.want[i] {
+					t.Fatalf("arg #%d: got %q, want %q", i, args[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	// 右引号后紧跟非空白字符、引号未闭合：返回协议错误并结束解析
+	for _, input := range []string{`SET k "a"b` + "\r\n", `SET k 'a` + "\r\n"} {
+		ch := ParseStream(strings.NewReader(input + "PING\r\n"))
+		if p := <-ch; p.Err == nil || p.Err.Error() != "ERR Protocol error: unbalanced quotes in request" {
+			t.Fatalf("%q: expect unbalanced quotes error, got %+v", input, p)
+		}
+		if _, ok := <-ch; ok {
+			t.Fatalf("%q: parser should stop after a protocol error", input)
+		}
+	}
+
+	// 超过 MaxInlineSize 仍没有换行
+	p := <-ParseStream(strings.NewReader(strings.Repeat("a", MaxInlineSize+10)))
+	if p.Err == nil || p.Err.Error() != "ERR Protocol error: too big inline request" {
+		t.Fatalf("expect too big inline request, got %+v", p)
+	}
+
+	// RESP 多条批量请求与 inline 命令可以混用
+	ch := ParseStream(strings.NewReader("*1\r\n$4\r\nPING\r\nECHO hi\r\n"))
+	assertReplyEqual(t, (<-ch).Data, resp.MakeArrayReply([][]byte{[]byte("PING")}))
+	assertReplyEqual(t, (<-ch).Data, resp.MakeArrayReply([][]byte{[]byte("ECHO"), []byte("hi")}))
+}
@@ -0,0 +1,224 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+const (
+	// DefaultMaxBulkLen 为单个 bulk 参数的默认上限，与 Redis proto-max-bulk-len 默认值一致。
+	DefaultMaxBulkLen = 512 * 1024 * 1024
+	// MaxMultiBulkLen 为一条命令的最大参数个数，与 Redis 一致。
+	MaxMultiBulkLen = 1024 * 1024
+
+	// readerBufSize 为连接读缓冲大小，与 Redis 的 PROTO_IOBUF_LEN 一致
+	readerBufSize = 16 * 1024
+	// bulkChunk 为读取大 bulk 时每次扩容的步长：按实际到达的数据增长，
+	// 而不是按客户端声明的长度一次性分配
+	bulkChunk = 64 * 1024
+	// maxRetainArena / maxRetainArgs 以上的缓冲在下一条命令前丢弃，避免个别大命令让连接长期占用内存
+	maxRetainArena = 64 * 1024
+	maxRetainArgs  = 1024
+)
+
+// ProtocolError 表示客户端发送的数据不符合协议。出现后无法再确定下一条命令的边界，
+// 服务端应答该错误后关闭连接。
+type ProtocolError struct {
+	msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "ERR Protocol error: " + e.msg
+}
+
+var (
+	errInvalidMultiBulkLen = &ProtocolError{"invalid multibulk length"}
+	errInvalidBulkLen      = &ProtocolError{"invalid bulk length"}
+	errTooBigMultiBulk     = &ProtocolError{"too big mbulk count string"}
+	errTooBigBulkCount     = &ProtocolError{"too big bulk count string"}
+	errMissingCRLF         = &ProtocolError{"missing CRLF after bulk string"}
+)
+
+// Reader 是服务端热路径上的同步命令读取器：不启动 goroutine、不经过 channel，
+// 读缓冲、参数切片与存放参数内容的 arena 在命令之间复用。
+// 只有 * 开头的 multibulk 按 RESP 解析，其它内容按 inline 命令解析（与 Redis 一致）。
+type Reader struct {
+	br         *bufio.Reader
+	maxBulkLen int64
+
+	// arena 依次存放当前命令的所有参数，ends 为每个参数在 arena 中的结束位置；
+	// arena 读取过程中可能扩容，所以全部读完后再切出 args
+	arena []byte
+	ends  []int
+	args  [][]byte
+	// line 为超过读缓冲的行的拼接缓冲
+	line []byte
+}
+
+func NewReader(rd io.Reader) *Reader {
+	return &Reader{
+		br:         bufio.NewReaderSize(rd, readerBufSize),
+		maxBulkLen: DefaultMaxBulkLen,
+	}
+}
+
+// SetMaxBulkLen 设置单个 bulk 参数的最大字节数（proto-max-bulk-len）。
+func (r *Reader) SetMaxBulkLen(n int64) {
+	r.maxBulkLen = n
+}
+
+// ReadCommand 读取下一条命令。返回的参数只在下一次调用 ReadCommand 之前有效，
+// 需要保留的参数调用方必须自行拷贝。协议错误返回 *ProtocolError，其它为连接读错误。
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	if cap(r.arena) > maxRetainArena {
+		r.arena = nil
+	}
+	if cap(r.ends) > maxRetainArgs {
+		r.ends, r.args = nil, nil
+	}
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			if err == errTooBigInline && len(line) > 0 && line[0] == '*' {
+				return nil, errTooBigMultiBulk
+			}
+			return nil, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '*' {
+			args, err := splitInline(line)
+			if err != nil {
+				return nil, err
+			}
+			if len(args) == 0 {
+				continue
+			}
+			return args, nil
+		}
+
+		n, ok := parseLen(line[1:])
+		if !ok || n > MaxMultiBulkLen {
+			return nil, errInvalidMultiBulkLen
+		}
+		if n <= 0 {
+			// *0 与 *-1 不是命令，忽略
+			continue
+		}
+		r.arena = r.arena[:0]
+		r.ends = r.ends[:0]
+		for i := int64(0); i < n; i++ {
+			if err := r.readBulk(); err != nil {
+				return nil, err
+			}
+		}
+		r.args = r.args[:0]
+		start := 0
+		for _, end := range r.ends {
+			r.args = append(r.args, r.arena[start:end:end])
+			start = end
+		}
+		return r.args, nil
+	}
+}
+
+// readBulk 读取一个 $<len>\r\n<data>\r\n 参数并追加到 arena。
+func (r *Reader) readBulk() error {
+	line, err := r.readLine()
+	if err != nil {
+		if err == errTooBigInline {
+			return errTooBigBulkCount
+		}
+		return err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return errExpectedBulk(line)
+	}
+	n, ok := parseLen(line[1:])
+	if !ok || n < 0 || n > r.maxBulkLen {
+		return errInvalidBulkLen
+	}
+
+	for remaining := int(n); remaining > 0; {
+		chunk := min(remaining, bulkChunk)
+		start := len(r.arena)
+		if cap(r.arena)-start < chunk {
+			grown := make([]byte, start, max(2*cap(r.arena), start+chunk))
+			copy(grown, r.arena)
+			r.arena = grown
+		}
+		r.arena = r.arena[:start+chunk]
+		if _, err := io.ReadFull(r.br, r.arena[start:]); err != nil {
+			return err
+		}
+		remaining -= chunk
+	}
+	crlf, err := r.br.Peek(2)
+	if err != nil {
+		return err
+	}
+	if crlf[0] != '\r' || crlf[1] != '\n' {
+		return errMissingCRLF
+	}
+	_, _ = r.br.Discard(2)
+	r.ends = append(r.ends, len(r.arena))
+	return nil
+}
+
+func errExpectedBulk(line []byte) error {
+	got := byte('\r')
+	if len(line) > 0 {
+		got = line[0]
+	}
+	return &ProtocolError{fmt.Sprintf("expected '$', got '%c'", got)}
+}
+
+// readLine 读取一行并去掉行尾的 \n 或 \r\n。返回值通常直接指向读缓冲，只在下一次读取前有效；
+// 超过 MaxInlineSize 仍未读到换行时返回 errTooBigInline，此时返回已读到的开头供调用方区分类型。
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		r.line = append(r.line[:0], line...)
+		for err == bufio.ErrBufferFull {
+			line, err = r.br.ReadSlice('\n')
+			if len(r.line)+len(line) > MaxInlineSize+2 {
+				return r.line[:1], errTooBigInline
+			}
+			r.line = append(r.line, line...)
+		}
+		line = r.line
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, nil
+}
+
+// parseLen 不分配内存地解析十进制长度，允许前导负号。
+func parseLen(b []byte) (int64, bool) {
+	neg := len(b) > 0 && b[0] == '-'
+	if neg {
+		b = b[1:]
+	}
+	// 18 位以内不会溢出 int64
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+	var n int64
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int64(c-'0')
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReaderReadCommand(t *testing.T) {
+	big := strings.Repeat("v", 3*bulkChunk+5)
+	input := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$4\r\na\r\nb\r\n" +
+		"\r\n*0\r\n*-1\r\n" +
+		"PING\n" +
+		"*2\r\n$3\r\nGET\r\n$0\r\n\r\n" +
+		"*3\r\n$3\r\nSET\r\n$3\r\nbig\r\n$" + "196613" + "\r\n" + big + "\r\n" +
+		"set 'a b' \"c\\x41\"\r\n"
+	r := NewReader(strings.NewReader(input))
+
+	want := [][]string{
+		{"SET", "k", "a\r\nb"},
+		{"PING"},
+		{"GET", ""},
+		{"SET", "big", big},
+		{"set", "a b", "cA"},
+	}
+	for i, w := range want {
+		args, err := r.ReadCommand()
+		if err != nil {
+			t.Fatalf("command %d: %v", i, err)
+		}
+		if len(args) != len(w) {
+			t.Fatalf("command %d = %q, want %q", i, args, w)
+		}
+		for j := range w {
+			if string(args[j]) != w[j] {
+				t.Fatalf("command %d arg %d = %.20q, want %.20q", i, j, args[j], w[j])
+			}
+		}
+	}
+	if _, err := r.ReadCommand(); err != io.EOF {
+		t.Fatalf("expect EOF at end of stream, got %v", err)
+	}
+}
+
+func TestReaderLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"multibulk too long", "*1048577\r\n", "ERR Protocol error: invalid multibulk length"},
+		{"multibulk not a number", "*abc\r\n", "ERR Protocol error: invalid multibulk length"},
+		{"bulk too long", "*1\r\n$1025\r\n", "ERR Protocol error: invalid bulk length"},
+		{"negative bulk", "*1\r\n$-1\r\n", "ERR Protocol error: invalid bulk length"},
+		{"not a bulk", "*1\r\n:1\r\n", "ERR Protocol error: expected '$', got ':'"},
+		{"missing CRLF", "*1\r\n$3\r\nfooXX", "ERR Protocol error: missing CRLF after bulk string"},
+		{"too big bulk count", "*1\r\n$" + strings.Repeat("1", MaxInlineSize+8), "ERR Protocol error: too big bulk count string"},
+		{"too big inline", strings.Repeat("a", MaxInlineSize+8), "ERR Protocol error: too big inline request"},
+		{"unbalanced quotes", "SET \"a\r\n", "ERR Protocol error: unbalanced quotes in request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.input))
+			r.SetMaxBulkLen(1024)
+			_, err := r.ReadCommand()
+			var protoErr *ProtocolError
+			if !errors.As(err, &protoErr) || err.Error() != tt.want {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// 客户端声明了很大的 bulk 却不发送数据时，只按实际到达的数据分配内存。
+func TestReaderDoesNotTrustDeclaredLength(t *testing.T) {
+	r := NewReader(strings.NewReader("*1\r\n$536870912\r\nhello"))
+	if _, err := r.ReadCommand(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("err = %v, want unexpected EOF", err)
+	}
+	if cap(r.arena) > bulkChunk {
+		t.Fatalf("arena grew to %d bytes", cap(r.arena))
+	}
+}
+
+func TestReaderReusesBuffers(t *testing.T) {
+	cmd := []byte("*3\r\n$3\r\nSET\r\n$6\r\nkey:01\r\n$16\r\nvalue-0123456789\r\n")
+	r := NewReader(&repeatReader{data: cmd})
+	allocs := testing.AllocsPerRun(1000, func() {
+		if _, err := r.ReadCommand(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("ReadCommand allocates %.1f times per command", allocs)
+	}
+}
+
+// repeatReader 无限重复同一段数据。
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.data[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.data)
+	}
+	return n, nil
+}
+
+// pipelinedSets 生成 n 条 SET 命令组成的流，模拟 pipeline 写入。
+func pipelinedSets(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		buf.WriteString("*3\r\n$3\r\nSET\r\n$6\r\nkey:01\r\n$16\r\nvalue-0123456789\r\n")
+	}
+	return buf.Bytes()
+}
+
+const benchCommands = 1000
+
+func BenchmarkParseStream(b *testing.B) {
+	input := pipelinedSets(benchCommands)
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ch := ParseStream(bytes.NewReader(input))
+		for payload := range ch {
+			if payload.Err != nil && payload.Err.Error() != "EOF" {
+				b.Fatal(payload.Err)
+			}
+		}
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	input := pipelinedSets(benchCommands)
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := NewReader(bytes.NewReader(input))
+		for {
+			if _, err := r.ReadCommand(); err != nil {
+				if err != io.EOF {
+					b.Fatal(err)
+				}
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package parser
+
+import (
+	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/resp"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+)
+
+// isResp3Bool 区分 RESP3 boolean（#t / #f）与 AOF 注解行（#TS:...）。
+func isResp3Bool(line []byte) bool {
+	return len(line) == 2 && (line[1] == 't' || line[1] == 'f')
+}
+
+// parseResp3 解析一条 RESP3 类型的值并投递。
+func parseResp3(reader *bufio.Reader, ch chan<- *Payload, header []byte) {
+	reply, err := readValue(reader, header)
+	if err != nil {
+		ch <- &Payload{Err: err}
+		return
+	}
+	ch <- &Payload{Data: reply}
+}
+
+// readValue 按 header 的类型字节解析一个完整的值，聚合类型递归解析元素。
+func readValue(reader *bufio.Reader, header []byte) (_interface.Reply, error) {
+	if len(header) == 0 {
+		return nil, errors.New("empty line")
+	}
+	body := string(header[1:])
+	switch header[0] {
+	case '+':
+		return resp.MakeSimpleReply(body), nil
+	case '-':
+		return resp.MakeErrorReply(body), nil
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid integer")
+		}
+		return resp.MakeIntegerReply(n), nil
+	case '_':
+		return resp.MakeNullReply(), nil
+	case '#':
+		if !isResp3Bool(header) {
+			return nil, errors.New("invalid boolean")
+		}
+		return resp.MakeBoolReply(header[1] == 't'), nil
+	case ',':
+		v, err := resp.ParseDouble(body)
+		if err != nil {
+			return nil, errors.New("invalid double")
+		}
+		return resp.MakeDoubleReply(v), nil
+	case '(':
+		v, ok := new(big.Int).SetString(body, 10)
+		if !ok {
+			return nil, errors.New("invalid big number")
+		}
+		return resp.MakeBigNumberReply(v), nil
+	case '$', '=', '!':
+		blob, err := readBlob(reader, header)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case header[0] == '$' || blob == nil:
+			return resp.MakeBulkReply(blob), nil
+		case header[0] == '!':
+			return resp.MakeErrorReply(string(blob)), nil
+		case len(blob) < 4 || blob[3] != ':':
+			return nil, errors.New("invalid verbatim string")
+		}
+		return resp.MakeVerbatimReply(string(blob[:3]), blob[4:]), nil
+	case '*':
+		elems, err := readElements(reader, header, 1)
+		if err != nil {
+			return nil, err
+		}
+		if elems == nil {
+			return resp.MakeArrayReply(nil), nil
+		}
+		args := make([][]byte, len(elems))
+		for i, elem := range elems {
+			switch e := elem.(type) {
+			case *resp.BulkReply:
+				args[i] = e.Arg
+			case *resp.NullReply:
+			default:
+				return resp.MakeMultiBulkReply(elems), nil
+			}
+		}
+		return resp.MakeArrayReply(args), nil
+	case '%', '|':
+		pairs, err := readElements(reader, header, 2)
+		if err != nil {
+			return nil, err
+		}
+		if header[0] == '%' {
+			return resp.MakeMapReply(pairs), nil
+		}
+		// attribute 之后紧跟它所修饰的值
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := readValue(reader, line)
+		if err != nil {
+			return nil, err
+		}
+		return resp.MakeAttributeReply(pairs, reply), nil
+	case '~', '>':
+		elems, err := readElements(reader, header, 1)
+		if err != nil {
+			return nil, err
+		}
+		if header[0] == '~' {
+			return resp.MakeSetReply(elems), nil
+		}
+		return resp.MakePushReply(elems), nil
+	}
+	return nil, fmt.Errorf("unknown type byte %q", header[0])
+}
+
+// readElements 读取聚合类型的元素，数量为头部计数乘以 per（map / attribute 每项含键和值两个元素）。
+// 计数为 -1 时返回 nil。
+func readElements(reader *bufio.Reader, header []byte, per int) ([]_interface.Reply, error) {
+	n, err := strconv.ParseInt(string(header[1:]), 10, 64)
+	if err != nil || n < -1 {
+		return nil, errors.New("invalid multibulk length")
+	}
+	if n == -1 {
+		return nil, nil
+	}
+	elems := make([]_interface.Reply, 0, n*int64(per))
+	for i := int64(0); i < n*int64(per); i++ {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := readValue(reader, line)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// readBlob 读取 $ / = / ! 的定长内容，长度为 -1 时返回 nil。
+func readBlob(reader *bufio.Reader, header []byte) ([]byte, error) {
+	n, err := strconv.ParseInt(string(header[1:]), 10, 64)
+	if err != nil || n < -1 {
+		return nil, errors.New("invalid blob length")
+	}
+	if n == -1 {
+		return nil, nil
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, err
+	}
+	if !bytes.HasSuffix(buf, []byte{'\r', '\n'}) {
+		return nil, errors.New("invalid blob: missing CRLF")
+	}
+	return buf[:n], nil
+}
+
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(line, []byte{'\r', '\n'}), nil
+}
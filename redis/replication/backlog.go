@@ -0,0 +1,53 @@
+package replication
+
+// backlog 是复制流的环形缓冲，保存最近 size 字节，供断线重连的副本做部分同步。
+// 偏移与 Redis 一致从 1 开始：firstByteOffset 为缓冲中最早一个字节在复制流中的位置。
+type backlog struct {
+	buf []byte
+	// idx 为下一次写入的位置
+	idx             int
+	histlen         int64
+	firstByteOffset int64
+}
+
+// newBacklog 创建空的 backlog，nextOffset 为下一个写入字节的偏移（当前复制偏移 + 1）。
+func newBacklog(size int, nextOffset int64) *backlog {
+	return &backlog{
+		buf:             make([]byte, size),
+		firstByteOffset: nextOffset,
+	}
+}
+
+func (b *backlog) size() int {
+	return len(b.buf)
+}
+
+func (b *backlog) write(data []byte) {
+	for len(data) > 0 {
+		n := copy(b.buf[b.idx:], data)
+		b.idx = (b.idx + n) % len(b.buf)
+		b.histlen += int64(n)
+		data = data[n:]
+	}
+	if over := b.histlen - int64(len(b.buf)); over > 0 {
+		b.firstByteOffset += over
+		b.histlen = int64(len(b.buf))
+	}
+}
+
+// readFrom 返回从 offset 开始到末尾的数据；offset 已被覆盖或超出末尾时返回 false。
+func (b *backlog) readFrom(offset int64) ([]byte, bool) {
+	if offset < b.firstByteOffset || offset > b.firstByteOffset+b.histlen {
+		return nil, false
+	}
+	skip := offset - b.firstByteOffset
+	n := int(b.histlen - skip)
+	out := make([]byte, n)
+	start := (b.idx - int(b.histlen) + int(skip)) % len(b.buf)
+	if start < 0 {
+		start += len(b.buf)
+	}
+	copied := copy(out, b.buf[start:])
+	copy(out[copied:], b.buf)
+	return out, true
+}
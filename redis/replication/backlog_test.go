@@ -0,0 +1,41 @@
+package replication
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBacklogWrapAround(t *testing.T) {
+	b := newBacklog(8, 1)
+	b.write([]byte("abcde"))
+	if data, ok := b.readFrom(3); !ok || string(data) != "cde" {
+		t.Fatalf("readFrom(3) = %q, %v", data, ok)
+	}
+	if data, ok := b.readFrom(6); !ok || len(data) != 0 {
+		t.Fatalf("readFrom at the end should return empty data, got %q, %v", data, ok)
+	}
+
+	// 写满后最早的字节被覆盖
+	b.write([]byte("fghijk"))
+	if b.firstByteOffset != 4 || b.histlen != 8 {
+		t.Fatalf("unexpected window first=%d histlen=%d", b.firstByteOffset, b.histlen)
+	}
+	if _, ok := b.readFrom(3); ok {
+		t.Fatal("overwritten offset should not be readable")
+	}
+	if data, ok := b.readFrom(4); !ok || string(data) != "defghijk" {
+		t.Fatalf("readFrom(4) = %q, %v", data, ok)
+	}
+	if _, ok := b.readFrom(13); ok {
+		t.Fatal("offset beyond the end should not be readable")
+	}
+
+	// 单次写入超过容量只保留末尾
+	b.write(bytes.Repeat([]byte("x"), 20))
+	if data, ok := b.readFrom(b.firstByteOffset); !ok || string(data) != "xxxxxxxx" {
+		t.Fatalf("large write should keep the tail, got %q, %v", data, ok)
+	}
+	if b.firstByteOffset != 31-8+1 {
+		t.Fatalf("unexpected first byte offset %d", b.firstByteOffset)
+	}
+}
@@ -0,0 +1,352 @@
+package replication
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Peer 是主库一侧的副本连接。
+// 握手阶段由 handler 正常读写；PSYNC 之后连接的写方向由 Peer 的发送协程独占。
+type Peer struct {
+	conn net.Conn
+	// listeningPort 由副本通过 REPLCONF listening-port 告知
+	listeningPort int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// buf 为待发送的复制流；全量同步开始前传播来的命令先暂存在这里
+	buf     []byte
+	started bool
+	closed  bool
+	// softSince 为待发送数据开始超过软限制的时间，未超过时为零值
+	softSince time.Time
+	// ackOffset / ackTime 为副本最近一次 REPLCONF ACK 上报的已处理偏移与时间
+	ackOffset int64
+	ackTime   time.Time
+}
+
+// NewPeer 为一个客户端连接创建副本状态，handler 在收到 REPLCONF/PSYNC 时调用。
+func NewPeer(conn net.Conn) *Peer {
+	p := &Peer{conn: conn}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// enqueue 追加复制流，超过输出缓冲限制时返回 false。
+func (p *Peer) enqueue(data []byte, opts *Options) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	pending := int64(len(p.buf) + len(data))
+	if opts.OutputHardLimit > 0 && pending > opts.OutputHardLimit {
+		return false
+	}
+	if opts.OutputSoftLimit > 0 && pending > opts.OutputSoftLimit {
+		if p.softSince.IsZero() {
+			p.softSince = time.Now()
+		} else if time.Since(p.softSince) > time.Duration(opts.OutputSoftSeconds)*time.Second {
+			return false
+		}
+	} else {
+		p.softSince = time.Time{}
+	}
+	p.buf = append(p.buf, data...)
+	p.cond.Signal()
+	return true
+}
+
+// start 把同步头部（全量快照或部分同步的补发数据）放在暂存命令之前，然后启动发送协程。
+// startOffset 为副本同步完成后所处的复制偏移。
+func (p *Peer) start(prefix []byte, startOffset int64, onError func(error)) {
+	p.mu.Lock()
+	p.buf = append(prefix, p.buf...)
+	p.started = true
+	// 与 Redis 一样从同步开始计算 lag，避免刚连上的副本立即被当作不健康
+	p.ackOffset = startOffset
+	p.ackTime = time.Now()
+	p.mu.Unlock()
+	go p.writeLoop(onError)
+}
+
+func (p *Peer) writeLoop(onError func(error)) {
+	for {
+		p.mu.Lock()
+		for len(p.buf) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		data := p.buf
+		p.buf = nil
+		p.mu.Unlock()
+
+		_ = p.conn.SetWriteDeadline(time.Now().Add(replTimeout))
+		if _, err := p.conn.Write(data); err != nil {
+			onError(err)
+			return
+		}
+	}
+}
+
+// Close 停止发送并关闭连接。
+func (p *Peer) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	_ = p.conn.Close()
+}
+
+// ack 记录副本上报的已处理偏移。
+func (p *Peer) ack(offset int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if offset > p.ackOffset {
+		p.ackOffset = offset
+	}
+	p.ackTime = time.Now()
+}
+
+// ackState 返回副本是否在线、已确认的偏移以及距上次 ACK 的时间。
+func (p *Peer) ackState() (online bool, offset int64, lag time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.started || p.closed {
+		return false, 0, 0
+	}
+	return true, p.ackOffset, time.Since(p.ackTime)
+}
+
+// infoLine 生成 INFO 中的 slaveN 行。offset 为副本最近 ACK 的偏移，lag 为距上次 ACK 的秒数。
+func (p *Peer) infoLine() string {
+	host, _, _ := net.SplitHostPort(p.conn.RemoteAddr().String())
+	online, offset, lag := p.ackState()
+	state := "wait_bgsave"
+	if online {
+		state = "online"
+	}
+	return fmt.Sprintf("ip=%s,port=%d,state=%s,offset=%d,lag=%d", host, p.listeningPort, state, offset, int(lag.Seconds()))
+}
+
+// ExecReplconf 实现 REPLCONF：握手阶段的 listening-port / capa，以及同步后副本定期发送的 ACK <offset>。
+// ACK 与 GETACK 不需要应答，handler 在副本连接上不会写回结果。
+func (s *Server) ExecReplconf(p *Peer, args [][]byte) (interface{}, error) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return nil, errors.New("wrong number of arguments for 'replconf'")
+	}
+	for i := 1; i < len(args); i += 2 {
+		option := strings.ToLower(string(args[i]))
+		switch option {
+		case "listening-port":
+			port, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, errors.New("value is not an integer or out of range")
+			}
+			p.listeningPort = port
+		case "capa":
+			// 部分同步与 replid2 总是可用，能力声明仅做兼容
+		case "ack":
+			offset, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, errors.New("value is not an integer or out of range")
+			}
+			p.ack(offset)
+			s.notifyAck()
+		case "getack":
+			// 只有副本需要响应 GETACK，它来自复制流而不是这里
+		default:
+			return nil, fmt.Errorf("Unrecognized REPLCONF option: %s", option)
+		}
+	}
+	return "OK", nil
+}
+
+// ExecPSync 处理 PSYNC/SYNC：能部分同步时补发 backlog，否则生成快照做全量同步，然后把 p 加入副本列表。
+// 返回 nil 后该连接的写方向归 Peer 所有，handler 不得再写应答。
+func (s *Server) ExecPSync(p *Peer, args [][]byte) error {
+	cmd := strings.ToUpper(string(args[0]))
+	if cmd == "PSYNC" && len(args) != 3 {
+		return errors.New("wrong number of arguments for 'psync'")
+	}
+	if s.IsReplica() && !s.linkUp() {
+		return errors.New("Can't SYNC while not connected with my master")
+	}
+
+	if cmd == "PSYNC" {
+		offset, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil {
+			return errors.New("value is not an integer or out of range")
+		}
+		if s.tryPartialResync(p, string(args[1]), offset) {
+			return nil
+		}
+	}
+	s.fullResync(p, cmd == "PSYNC")
+	return nil
+}
+
+// tryPartialResync 在 replid 匹配且 offset 仍在 backlog 中时回复 +CONTINUE 并补发缺失数据。
+// 读取 backlog 与注册副本在同一个 s.mu 临界区内，补发数据与后续传播之间不重不漏。
+func (s *Server) tryPartialResync(p *Peer, replID string, psyncOffset int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if replID == "?" {
+		return false
+	}
+	if replID != s.replID && (replID != s.replID2 || psyncOffset > s.secondReplOffset) {
+		s.syncPartialErr++
+		log.Printf("[REPL] partial resync from %s not accepted: replid %s mismatch (ours %s, %s up to %d)",
+			p.conn.RemoteAddr(), replID, s.replID, s.replID2, s.secondReplOffset)
+		return false
+	}
+	if s.backlog == nil {
+		s.syncPartialErr++
+		return false
+	}
+	data, ok := s.backlog.readFrom(psyncOffset)
+	if !ok {
+		s.syncPartialErr++
+		log.Printf("[REPL] partial resync from %s not accepted: offset %d out of backlog [%d, %d]",
+			p.conn.RemoteAddr(), psyncOffset, s.backlog.firstByteOffset, s.backlog.firstByteOffset+s.backlog.histlen)
+		return false
+	}
+
+	s.replicas[p] = struct{}{}
+	s.syncPartialOK++
+	prefix := append([]byte(fmt.Sprintf("+CONTINUE %s\r\n", s.replID)), data...)
+	log.Printf("[REPL] partial resync with replica %s accepted, sending %d bytes of backlog",
+		p.conn.RemoteAddr(), len(data))
+	p.start(prefix, psyncOffset-1, s.replicaWriteFailed(p))
+	return true
+}
+
+// fullResync 生成快照并注册副本。副本上的快照与转发流由 applyMu 保证处于同一偏移。
+func (s *Server) fullResync(p *Peer, psync bool) {
+	s.applyMu.Lock()
+	var replID string
+	var offset int64
+	var selected int
+	snapshot := s.db.SnapshotForSync(func() {
+		s.mu.Lock()
+		s.replicas[p] = struct{}{}
+		s.syncFull++
+		if s.backlog == nil {
+			s.backlog = newBacklog(s.opts.BacklogSize, s.masterOffset+1)
+		}
+		replID, offset, selected = s.replID, s.masterOffset, s.selectedDB
+		s.mu.Unlock()
+	})
+	s.applyMu.Unlock()
+
+	var payload []byte
+	for _, args := range snapshot {
+		payload = append(payload, encodeCommand(args)...)
+	}
+	// 快照末尾切回复制流当前所在的 DB，后续不带 SELECT 的命令才能落在正确的 DB
+	if selected >= 0 {
+		payload = append(payload, encodeCommand([][]byte{[]byte("SELECT"), []byte(strconv.Itoa(selected))})...)
+	}
+	var prefix []byte
+	if psync {
+		prefix = append(prefix, fmt.Sprintf("+FULLRESYNC %s %d\r\n", replID, offset)...)
+	}
+	prefix = append(prefix, fmt.Sprintf("$%d\r\n", len(payload))...)
+	prefix = append(prefix, payload...)
+
+	log.Printf("[REPL] full resync requested by replica %s, snapshot %d commands, %d bytes",
+		p.conn.RemoteAddr(), len(snapshot), len(payload))
+	p.start(prefix, offset, s.replicaWriteFailed(p))
+}
+
+func (s *Server) replicaWriteFailed(p *Peer) func(error) {
+	return func(err error) {
+		log.Printf("[REPL] write to replica %s failed: %v", p.conn.RemoteAddr(), err)
+		s.RemoveReplica(p)
+	}
+}
+
+// RemoveReplica 在副本连接断开时由 handler 调用。
+func (s *Server) RemoveReplica(p *Peer) {
+	s.mu.Lock()
+	_, ok := s.replicas[p]
+	delete(s.replicas, p)
+	s.mu.Unlock()
+	p.Close()
+	if ok {
+		log.Printf("[REPL] connection with replica %s lost", p.conn.RemoteAddr())
+	}
+}
+
+// Propagate 实现 database.Propagator，在 Db 写临界区内把效果命令追加到复制流。
+// 副本不产生自己的复制流（主库的流由 feedFromMaster 原样转发），这里直接忽略。
+func (s *Server) Propagate(dbIndex int, cmds [][][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.link != nil {
+		return
+	}
+	s.feedLocked(dbIndex, cmds)
+}
+
+// feedLocked 写入复制流，dbIndex < 0 表示与 DB 无关的命令（如 PING），不插入 SELECT。
+func (s *Server) feedLocked(dbIndex int, cmds [][][]byte) {
+	// 还没有副本连接过时不维护复制流，偏移保持不变
+	if s.backlog == nil {
+		return
+	}
+	var buf []byte
+	if dbIndex >= 0 && dbIndex != s.selectedDB {
+		buf = append(buf, encodeCommand([][]byte{[]byte("SELECT"), []byte(strconv.Itoa(dbIndex))})...)
+		s.selectedDB = dbIndex
+	}
+	for _, args := range cmds {
+		buf = append(buf, encodeCommand(args)...)
+	}
+	s.writeStreamLocked(buf)
+}
+
+// writeStreamLocked 把一段复制流写入 backlog 并发给所有副本。
+func (s *Server) writeStreamLocked(buf []byte) {
+	s.masterOffset += int64(len(buf))
+	s.backlog.write(buf)
+	for p := range s.replicas {
+		if !p.enqueue(buf, &s.opts) {
+			log.Printf("[REPL] replica %s exceeded output buffer limit, closing", p.conn.RemoteAddr())
+			delete(s.replicas, p)
+			go p.Close()
+		}
+	}
+}
+
+// pingLoop 定期向副本发送 PING，副本据此判断主库是否存活。
+func (s *Server) pingLoop() {
+	ticker := time.NewTicker(replPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			// 副本转发主库的 PING，自己不再额外发送，以免复制流与主库不一致
+			if s.link == nil && len(s.replicas) > 0 {
+				s.feedLocked(-1, [][][]byte{{[]byte("PING")}})
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package replication
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeerOutputLimit(t *testing.T) {
+	p := NewPeer(nil)
+	opts := &Options{OutputHardLimit: 100, OutputSoftLimit: 10, OutputSoftSeconds: 1}
+	if !p.enqueue(make([]byte, 8), opts) {
+		t.Fatal("data under the soft limit should be queued")
+	}
+	// 首次超过软限制只开始计时
+	if !p.enqueue(make([]byte, 8), opts) || p.softSince.IsZero() {
+		t.Fatal("exceeding the soft limit should start the timer")
+	}
+	p.softSince = time.Now().Add(-2 * time.Second)
+	if p.enqueue(make([]byte, 1), opts) {
+		t.Fatal("staying over the soft limit longer than soft seconds should fail")
+	}
+
+	p = NewPeer(nil)
+	if p.enqueue(make([]byte, 101), opts) {
+		t.Fatal("exceeding the hard limit should fail")
+	}
+	if !p.enqueue(make([]byte, 101), &Options{}) {
+		t.Fatal("zero limits should not restrict the buffer")
+	}
+}
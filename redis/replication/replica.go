@@ -0,0 +1,449 @@
+package replication
+
+import (
+	"MiddlewareSelf/redis/aof"
+	"MiddlewareSelf/redis/database"
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// linkState 为副本到主库链接的状态。
+type linkState int
+
+const (
+	linkConnect linkState = iota
+	linkConnecting
+	linkSync
+	linkConnected
+)
+
+// masterLink 是副本一侧到主库的链接，断开后自动重连并重新同步。
+type masterLink struct {
+	host string
+	port int
+
+	stopChan chan struct{}
+	done     chan struct{}
+
+	mu    sync.Mutex
+	conn  net.Conn
+	state linkState
+	// lastIO 为最近一次收到主库数据的时间
+	lastIO  time.Time
+	stopped bool
+}
+
+type linkStatus struct {
+	state  linkState
+	lastIO time.Time
+}
+
+func newMasterLink(host string, port int) *masterLink {
+	return &masterLink{
+		host:     host,
+		port:     port,
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (l *masterLink) addr() string {
+	return net.JoinHostPort(l.host, strconv.Itoa(l.port))
+}
+
+func (l *masterLink) status() linkStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return linkStatus{state: l.state, lastIO: l.lastIO}
+}
+
+func (l *masterLink) setState(state linkState) {
+	l.mu.Lock()
+	l.state = state
+	l.mu.Unlock()
+}
+
+func (l *masterLink) touch() {
+	l.mu.Lock()
+	l.lastIO = time.Now()
+	l.mu.Unlock()
+}
+
+// setConn 记录当前连接，链接已关闭时返回 false。
+func (l *masterLink) setConn(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return false
+	}
+	l.conn = conn
+	return true
+}
+
+// close 停止后台同步协程并等待其退出。
+func (l *masterLink) close() {
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		return
+	}
+	l.stopped = true
+	close(l.stopChan)
+	if l.conn != nil {
+		_ = l.conn.Close()
+	}
+	l.mu.Unlock()
+	<-l.done
+}
+
+func (s *Server) linkUp() bool {
+	s.mu.Lock()
+	link := s.link
+	s.mu.Unlock()
+	return link != nil && link.status().state == linkConnected
+}
+
+// runLink 持续与主库保持同步，链接断开后每秒重试一次。
+func (s *Server) runLink(l *masterLink) {
+	defer close(l.done)
+	for {
+		err := s.syncWithMaster(l)
+		select {
+		case <-l.stopChan:
+			return
+		default:
+		}
+		log.Printf("[REPL] link with MASTER %s lost: %v", l.addr(), err)
+		l.setState(linkConnect)
+		select {
+		case <-l.stopChan:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// syncWithMaster 完成一次握手 + 同步（优先部分同步），然后持续应用复制流，直到链接出错。
+func (s *Server) syncWithMaster(l *masterLink) error {
+	l.setState(linkConnecting)
+	conn, err := net.DialTimeout("tcp", l.addr(), replTimeout)
+	if err != nil {
+		return err
+	}
+	if s.opts.TLSConfig != nil {
+		tlsConn := tls.Client(conn, s.opts.TLSConfig)
+		_ = conn.SetDeadline(time.Now().Add(replTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return fmt.Errorf("tls handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+	defer conn.Close()
+	if !l.setConn(conn) {
+		return errors.New("link closed")
+	}
+	reader := bufio.NewReader(conn)
+
+	if err := handshake(conn, reader, s.opts.Port, s.opts.MasterAuth); err != nil {
+		return fmt.Errorf("handshake: %w", err)
+	}
+
+	l.setState(linkSync)
+	// 用当前复制历史尝试部分同步；从未同步过的实例 replid 不会被主库认识，自然转为全量同步
+	s.mu.Lock()
+	cachedID, psyncOffset := s.replID, s.masterOffset+1
+	s.mu.Unlock()
+	_ = conn.SetDeadline(time.Now().Add(replTimeout))
+	if err := writeCommand(conn, "PSYNC", cachedID, strconv.FormatInt(psyncOffset, 10)); err != nil {
+		return err
+	}
+	line, err := readLine(reader)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 3 && fields[0] == "+FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid PSYNC offset %q", fields[2])
+		}
+		if err := s.fullSyncFromMaster(reader, fields[1], offset); err != nil {
+			return fmt.Errorf("full sync: %w", err)
+		}
+		log.Printf("[REPL] MASTER <-> REPLICA sync: finished with success, replid=%s offset=%d", fields[1], offset)
+	case len(fields) >= 1 && fields[0] == "+CONTINUE":
+		newID := cachedID
+		if len(fields) == 2 {
+			newID = fields[1]
+		}
+		s.continueFromMaster(newID)
+		log.Printf("[REPL] successful partial resynchronization with master, replid=%s offset=%d", newID, psyncOffset-1)
+	default:
+		return fmt.Errorf("unexpected PSYNC reply %q", line)
+	}
+	l.touch()
+	l.setState(linkConnected)
+
+	return s.streamFromMaster(conn, reader, l)
+}
+
+// fullSyncFromMaster 采用主库的复制历史并加载快照。
+// 本地数据整体被替换，下游副本也需要重新全量同步，因此全部断开。
+func (s *Server) fullSyncFromMaster(reader *bufio.Reader, replID string, offset int64) error {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	s.mu.Lock()
+	s.replID = replID
+	s.masterOffset = offset
+	s.replID2 = ""
+	s.secondReplOffset = -1
+	s.selectedDB = -1
+	s.backlog = newBacklog(s.opts.BacklogSize, offset+1)
+	s.dropReplicasLocked()
+	s.mu.Unlock()
+
+	db, err := s.loadSnapshot(reader)
+	if err != nil {
+		// 数据只加载了一部分，放弃这段复制历史，下次重连必须全量同步
+		s.mu.Lock()
+		s.replID = newReplID()
+		s.backlog = nil
+		s.mu.Unlock()
+		return err
+	}
+	// 快照以复制流当前所在 DB 的 SELECT 结尾，转发给下游时据此保持一致
+	s.mu.Lock()
+	s.selectedDB = db
+	s.mu.Unlock()
+	return nil
+}
+
+// continueFromMaster 处理 +CONTINUE。主库换了复制 ID（例如它刚被提升）时跟随切换，
+// 旧 ID 作为 replid2 保留，并断开下游让它们用新 ID 重新部分同步。
+func (s *Server) continueFromMaster(newID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if newID != s.replID {
+		s.replID2 = s.replID
+		s.secondReplOffset = s.masterOffset + 1
+		s.replID = newID
+		s.dropReplicasLocked()
+		log.Printf("[REPL] master replication ID changed to %s", newID)
+	}
+	if s.backlog == nil {
+		s.backlog = newBacklog(s.opts.BacklogSize, s.masterOffset+1)
+	}
+}
+
+// handshake 依次发送 AUTH（配置了 masterauth 时）、PING 与 REPLCONF，每一步都要求主库正常应答。
+func handshake(conn net.Conn, reader *bufio.Reader, port int, password string) error {
+	steps := [][]string{
+		{"PING"},
+		{"REPLCONF", "listening-port", strconv.Itoa(port)},
+		{"REPLCONF", "capa", "psync2"},
+	}
+	if password != "" {
+		steps = append([][]string{{"AUTH", password}}, steps...)
+	}
+	for _, step := range steps {
+		_ = conn.SetDeadline(time.Now().Add(replTimeout))
+		if err := writeCommand(conn, step...); err != nil {
+			return err
+		}
+		line, err := readLine(reader)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "-") {
+			return fmt.Errorf("%s: %s", step[0], line[1:])
+		}
+	}
+	return nil
+}
+
+// loadSnapshot 读取 $<len>\r\n<payload>，清空本地数据后应用快照命令流，返回快照结束时所在的 DB。
+func (s *Server) loadSnapshot(reader *bufio.Reader) (int, error) {
+	var line string
+	var err error
+	// 主库生成快照期间可能发送空行保活
+	for line == "" {
+		if line, err = readLine(reader); err != nil {
+			return 0, err
+		}
+	}
+	if line[0] != '$' {
+		return 0, fmt.Errorf("unexpected snapshot header %q", line)
+	}
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid snapshot size %q", line)
+	}
+
+	// 快照与后续复制流一样经过 Db.Exec，清空与重建都会进入本实例的 AOF
+	if _, err := s.db.Exec(0, [][]byte{[]byte("FLUSHALL")}); err != nil {
+		return 0, err
+	}
+	payload := aof.NewCommandReader(io.LimitReader(reader, size))
+	applier := &commandApplier{db: s.db}
+	for {
+		args, err := payload.ReadCommand()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		applier.apply(args)
+	}
+	if payload.Offset() != size {
+		return 0, fmt.Errorf("snapshot truncated at %d of %d bytes", payload.Offset(), size)
+	}
+	return applier.current, nil
+}
+
+// streamFromMaster 持续读取并应用主库传播的命令，同时原样转发给下游副本并写入 backlog。
+// 期间每秒向主库发送 REPLCONF ACK，收到 REPLCONF GETACK 时立即发送。
+func (s *Server) streamFromMaster(conn net.Conn, reader *bufio.Reader, l *masterLink) error {
+	_ = conn.SetWriteDeadline(time.Time{})
+	stream := aof.NewCommandReader(reader)
+	s.mu.Lock()
+	applier := &commandApplier{db: s.db, current: s.selectedDB}
+	s.mu.Unlock()
+	if applier.current < 0 {
+		applier.current = 0
+	}
+
+	acker := &ackSender{conn: conn}
+	done := make(chan struct{})
+	defer close(done)
+	go s.ackLoop(acker, done)
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(replTimeout))
+		args, err := stream.ReadCommand()
+		if err != nil {
+			return err
+		}
+		l.touch()
+		if !s.feedFromMaster(l, applier, args) {
+			return errors.New("link closed")
+		}
+		if isGetAck(args) {
+			if err := acker.send(s.replOffset()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ackSender 串行化心跳协程与 GETACK 应答对连接的写入。
+type ackSender struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (a *ackSender) send(offset int64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_ = a.conn.SetWriteDeadline(time.Now().Add(replTimeout))
+	return writeCommand(a.conn, "REPLCONF", "ACK", strconv.FormatInt(offset, 10))
+}
+
+// ackLoop 同步完成后立即并每秒上报一次已处理偏移，写失败时关闭连接让读循环退出重连。
+func (s *Server) ackLoop(acker *ackSender, done <-chan struct{}) {
+	ticker := time.NewTicker(replAckPeriod)
+	defer ticker.Stop()
+	for {
+		if err := acker.send(s.replOffset()); err != nil {
+			_ = acker.conn.Close()
+			return
+		}
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) replOffset() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.masterOffset
+}
+
+func isGetAck(args [][]byte) bool {
+	return len(args) == 3 && strings.EqualFold(string(args[0]), "REPLCONF") && strings.EqualFold(string(args[1]), "GETACK")
+}
+
+// feedFromMaster 应用一条主库命令并转发。主库的复制流总是规范的 RESP 编码，
+// 重新编码得到的字节与收到的完全一致，偏移因此与主库保持相同。
+func (s *Server) feedFromMaster(l *masterLink, applier *commandApplier, args [][]byte) bool {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+	// 已切换主库或被提升的旧链接不得再写入复制流
+	s.mu.Lock()
+	current := s.link == l
+	s.mu.Unlock()
+	if !current {
+		return false
+	}
+
+	applier.apply(args)
+	s.mu.Lock()
+	s.selectedDB = applier.current
+	s.writeStreamLocked(encodeCommand(args))
+	s.mu.Unlock()
+	return true
+}
+
+// commandApplier 按复制流中的 SELECT 跟踪当前 DB 并执行命令。
+type commandApplier struct {
+	db      *database.Db
+	current int
+}
+
+func (a *commandApplier) apply(args [][]byte) {
+	// REPLCONF 是复制协议本身的命令（如 GETACK），只转发不执行
+	if strings.EqualFold(string(args[0]), "REPLCONF") {
+		return
+	}
+	if strings.EqualFold(string(args[0]), "SELECT") && len(args) == 2 {
+		if idx, err := strconv.Atoi(string(args[1])); err == nil && idx >= 0 && idx < database.MaxNumber {
+			a.current = idx
+			return
+		}
+	}
+	if _, err := a.db.Exec(a.current, args); err != nil {
+		log.Printf("[REPL] apply command from master failed: %v", err)
+	}
+}
+
+func writeCommand(conn net.Conn, args ...string) error {
+	raw := make([][]byte, len(args))
+	for i, arg := range args {
+		raw[i] = []byte(arg)
+	}
+	_, err := conn.Write(encodeCommand(raw))
+	return err
+}
+
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,308 @@
+package replication
+
+import (
+	"MiddlewareSelf/redis/aof"
+	"MiddlewareSelf/redis/database"
+	"MiddlewareSelf/redis/resp"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 主从复制。
+//
+// 流程（与 Redis 一致）：
+// 1) 副本执行 REPLICAOF host port 后连接主库，依次发送 PING、REPLCONF、PSYNC；
+// 2) 主库回复 +FULLRESYNC <replid> <offset>，随后以 $<len>\r\n<payload> 发送快照，
+//    payload 是由 Dict.Snapshot 生成的 SELECT + SET [PXAT] / RESTORE 命令流（与 AOF 同格式）；
+// 3) 之后主库把传播层产生的每条效果命令原样转发给副本，副本通过 Db.Exec 应用。
+// 副本默认只读，客户端写命令返回 READONLY。
+//
+// 部分同步（PSYNC2）：
+// - 复制流同时写入环形 backlog，(replid, offset) 唯一确定流中的一个位置；
+// - 副本自身不产生复制流，而是把主库的流原样转发给下游并写入自己的 backlog，
+//   因此整条复制链上的 replid 与 offset 完全一致；
+// - 断线重连时副本发送 PSYNC <replid> <offset+1>，偏移仍在 backlog 中则回复 +CONTINUE 只补发缺失部分；
+// - 副本被提升为主库时旧 replid 保存为 replid2，原来的兄弟副本凭旧 replid 仍可部分同步。
+
+const (
+	// replTimeout 对应 Redis repl-timeout：超过该时间没有收到主库数据即认为链接断开
+	replTimeout = 60 * time.Second
+	// replPingPeriod 对应 repl-ping-replica-period：主库定期向副本发送 PING 以维持链接
+	replPingPeriod = 10 * time.Second
+	// replAckPeriod 为副本发送 REPLCONF ACK 的间隔
+	replAckPeriod = time.Second
+	// DefaultBacklogSize 对应 repl-backlog-size 默认值
+	DefaultBacklogSize = 1 << 20
+)
+
+// Options 为复制相关配置。
+type Options struct {
+	// Port 为本实例的监听端口，作为副本时通过 REPLCONF listening-port 告知主库
+	Port int
+	// ReadOnly 对应 replica-read-only
+	ReadOnly bool
+	// BacklogSize 对应 repl-backlog-size，<= 0 时使用 DefaultBacklogSize
+	BacklogSize int
+	// MinReplicasToWrite 为 0 时不限制；否则健康副本（lag 不超过 MinReplicasMaxLag 秒）不足时拒绝写入
+	MinReplicasToWrite int
+	MinReplicasMaxLag  int
+	// OutputHardLimit / OutputSoftLimit / OutputSoftSeconds 对应 client-output-buffer-limit replica：
+	// 副本待发送的复制流超过硬限制，或持续 OutputSoftSeconds 秒超过软限制时断开该副本，由其重新同步。0 表示不限制
+	OutputHardLimit   int64
+	OutputSoftLimit   int64
+	OutputSoftSeconds int
+	// TLSConfig 非 nil 时通过 TLS 连接主库（tls-replication yes），此时 Port 应为本实例的 TLS 端口
+	TLSConfig *tls.Config
+	// MasterAuth 对应 masterauth：主库设置了 requirepass 时，握手前先以该密码 AUTH
+	MasterAuth string
+}
+
+// Server 保存一个实例的复制状态：作为主库时的副本列表，作为副本时到主库的链接。
+// Server 自身注册为 Db 的 Propagator，把写命令转发给所有副本。
+type Server struct {
+	db   *database.Db
+	opts Options
+
+	// applyMu 串行化副本“应用并转发主库命令”与下游全量同步的快照，保证快照与偏移一致
+	applyMu sync.Mutex
+
+	mu sync.Mutex
+	// replID 为当前复制历史的 ID，masterOffset 为该历史中已写入复制流的字节数
+	replID       string
+	masterOffset int64
+	// replID2 为上一段复制历史的 ID，偏移不超过 secondReplOffset 的 PSYNC 仍可接受
+	replID2          string
+	secondReplOffset int64
+	// selectedDB 为复制流当前所处的 DB，-1 表示下一条命令前必须插入 SELECT
+	selectedDB int
+	backlog    *backlog
+	replicas   map[*Peer]struct{}
+	// link 非 nil 表示本实例是副本
+	link *masterLink
+
+	syncFull, syncPartialOK, syncPartialErr int64
+	// ackChanged 在收到任意副本 ACK 时被关闭并替换，用于唤醒 WAIT
+	ackChanged chan struct{}
+
+	stopChan  chan struct{}
+	closeOnce sync.Once
+}
+
+// NewServer 创建复制服务并注册到 db 的传播层。
+func NewServer(db *database.Db, opts Options) *Server {
+	if opts.BacklogSize <= 0 {
+		opts.BacklogSize = DefaultBacklogSize
+	}
+	s := &Server{
+		db:               db,
+		opts:             opts,
+		replID:           newReplID(),
+		secondReplOffset: -1,
+		selectedDB:       -1,
+		replicas:         make(map[*Peer]struct{}),
+		ackChanged:       make(chan struct{}),
+		stopChan:         make(chan struct{}),
+	}
+	db.AddPropagator(s)
+	db.RegisterInfoSection("Replication", s.replicationInfo)
+	go s.pingLoop()
+	return s
+}
+
+func newReplID() string {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		// 极少发生，退化为时间戳保证唯一性即可
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// IsReplica 返回本实例当前是否为副本。
+func (s *Server) IsReplica() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.link != nil
+}
+
+// CheckWrite 在只读副本上、或主库健康副本不足 min-replicas-to-write 时拒绝客户端写命令。
+// 来自主库的复制流不经过这里。
+func (s *Server) CheckWrite(cmd string) error {
+	if !aof.IsWriteCmd(cmd) {
+		return nil
+	}
+	if s.opts.ReadOnly && s.IsReplica() {
+		return database.MakeReplyError("READONLY You can't write against a read only replica.")
+	}
+	return s.checkMinReplicas()
+}
+
+// ExecReplicaOf 实现 REPLICAOF host port / REPLICAOF NO ONE（SLAVEOF 同义）。
+func (s *Server) ExecReplicaOf(args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errors.New("wrong number of arguments for 'replicaof'")
+	}
+	host, portStr := string(args[1]), string(args[2])
+	if strings.EqualFold(host, "no") && strings.EqualFold(portStr, "one") {
+		s.PromoteToMaster()
+		return "OK", nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, errors.New("Invalid master port")
+	}
+	if !s.ReplicaOf(host, port) {
+		return "OK Already connected to specified master", nil
+	}
+	return "OK", nil
+}
+
+// ReplicaOf 切换为 host:port 的副本，返回 false 表示已经是该主库的副本。
+// 连接与同步在后台进行，与 Redis 一样命令本身立即返回。
+func (s *Server) ReplicaOf(host string, port int) bool {
+	s.mu.Lock()
+	old := s.link
+	if old != nil && old.host == host && old.port == port {
+		s.mu.Unlock()
+		return false
+	}
+	link := newMasterLink(host, port)
+	s.link = link
+	// 下游副本需要跟随新的复制历史重新同步
+	s.dropReplicasLocked()
+	s.mu.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+	log.Printf("[REPL] connecting to MASTER %s", link.addr())
+	go s.runLink(link)
+	return true
+}
+
+// PromoteToMaster 断开与主库的链接，保留现有数据转为主库。
+// 旧的复制历史保存为 replid2，原来的兄弟副本连过来时仍可部分同步。
+func (s *Server) PromoteToMaster() {
+	s.mu.Lock()
+	old := s.link
+	s.mu.Unlock()
+	if old == nil {
+		return
+	}
+	// 先停掉同步协程，确保之后不会再有主库命令写入复制流
+	old.close()
+
+	s.mu.Lock()
+	if s.link == old {
+		s.link = nil
+		s.shiftReplIDLocked()
+		s.dropReplicasLocked()
+	}
+	s.mu.Unlock()
+	log.Printf("[REPL] MASTER MODE enabled, link with %s closed", old.addr())
+}
+
+// shiftReplIDLocked 开启新的复制历史，当前历史保存为 replid2。
+func (s *Server) shiftReplIDLocked() {
+	s.replID2 = s.replID
+	s.secondReplOffset = s.masterOffset + 1
+	s.replID = newReplID()
+	log.Printf("[REPL] replication ID changed to %s, previous %s valid up to offset %d",
+		s.replID, s.replID2, s.secondReplOffset)
+}
+
+// dropReplicasLocked 断开所有下游副本，它们会自动重连并按新的复制历史同步。
+func (s *Server) dropReplicasLocked() {
+	for p := range s.replicas {
+		delete(s.replicas, p)
+		go p.Close()
+	}
+}
+
+// Close 断开主库链接与所有副本。
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stopChan)
+		s.mu.Lock()
+		link := s.link
+		s.link = nil
+		s.dropReplicasLocked()
+		s.mu.Unlock()
+		if link != nil {
+			link.close()
+		}
+	})
+}
+
+func (s *Server) replicationInfo(b *strings.Builder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.link == nil {
+		database.WriteInfoField(b, "role", "master")
+	} else {
+		st := s.link.status()
+		database.WriteInfoField(b, "role", "slave")
+		database.WriteInfoField(b, "master_host", s.link.host)
+		database.WriteInfoField(b, "master_port", s.link.port)
+		linkStatus := "down"
+		if st.state == linkConnected {
+			linkStatus = "up"
+		}
+		database.WriteInfoField(b, "master_link_status", linkStatus)
+		lastIO := -1
+		if !st.lastIO.IsZero() {
+			lastIO = int(time.Since(st.lastIO).Seconds())
+		}
+		database.WriteInfoField(b, "master_last_io_seconds_ago", lastIO)
+		database.WriteInfoField(b, "master_sync_in_progress", boolToInt(st.state == linkSync))
+		database.WriteInfoField(b, "slave_repl_offset", s.masterOffset)
+		database.WriteInfoField(b, "slave_read_only", boolToInt(s.opts.ReadOnly))
+	}
+	database.WriteInfoField(b, "connected_slaves", len(s.replicas))
+	if s.opts.MinReplicasToWrite > 0 && s.link == nil {
+		database.WriteInfoField(b, "min_slaves_good_slaves", s.goodReplicasLocked())
+	}
+	i := 0
+	for p := range s.replicas {
+		database.WriteInfoField(b, "slave"+strconv.Itoa(i), p.infoLine())
+		i++
+	}
+	database.WriteInfoField(b, "master_replid", s.replID)
+	replID2 := s.replID2
+	if replID2 == "" {
+		replID2 = strings.Repeat("0", 40)
+	}
+	database.WriteInfoField(b, "master_replid2", replID2)
+	database.WriteInfoField(b, "master_repl_offset", s.masterOffset)
+	database.WriteInfoField(b, "second_repl_offset", s.secondReplOffset)
+	if s.backlog == nil {
+		database.WriteInfoField(b, "repl_backlog_active", 0)
+		database.WriteInfoField(b, "repl_backlog_size", s.opts.BacklogSize)
+	} else {
+		database.WriteInfoField(b, "repl_backlog_active", 1)
+		database.WriteInfoField(b, "repl_backlog_size", s.backlog.size())
+		database.WriteInfoField(b, "repl_backlog_first_byte_offset", s.backlog.firstByteOffset)
+		database.WriteInfoField(b, "repl_backlog_histlen", s.backlog.histlen)
+	}
+	database.WriteInfoField(b, "sync_full", s.syncFull)
+	database.WriteInfoField(b, "sync_partial_ok", s.syncPartialOK)
+	database.WriteInfoField(b, "sync_partial_err", s.syncPartialErr)
+}
+
+func encodeCommand(args [][]byte) []byte {
+	return resp.MakeArrayReply(args).ToBytes()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
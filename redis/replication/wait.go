@@ -0,0 +1,113 @@
+package replication
+
+import (
+	"MiddlewareSelf/redis/database"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// 副本每秒发送 REPLCONF ACK <offset> 上报已处理的复制偏移，主库据此：
+// - 实现 WAIT numreplicas timeout：阻塞到足够多的副本确认了调用时刻之前的全部写入；
+// - 实现 min-replicas-to-write / min-replicas-max-lag：健康副本不足时拒绝写命令。
+
+// notifyAck 唤醒所有等待 ACK 的 WAIT。
+func (s *Server) notifyAck() {
+	s.mu.Lock()
+	close(s.ackChanged)
+	s.ackChanged = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// countAckedLocked 返回已确认偏移不小于 offset 的在线副本数。
+func (s *Server) countAckedLocked(offset int64) int {
+	n := 0
+	for p := range s.replicas {
+		if online, acked, _ := p.ackState(); online && acked >= offset {
+			n++
+		}
+	}
+	return n
+}
+
+// ExecWait 实现 WAIT numreplicas timeout（毫秒，0 表示一直等待），返回已确认的副本数。
+// 调用方的写命令在返回前已进入复制流，因此目标偏移取调用时刻的 masterOffset。
+func (s *Server) ExecWait(args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errors.New("wrong number of arguments for 'wait'")
+	}
+	numReplicas, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return nil, errors.New("value is not an integer or out of range")
+	}
+	timeoutMs, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil || timeoutMs < 0 {
+		return nil, errors.New("timeout is not an integer or out of range")
+	}
+
+	s.mu.Lock()
+	if s.link != nil {
+		s.mu.Unlock()
+		return nil, errors.New("WAIT cannot be used with replica instances.")
+	}
+	target := s.masterOffset
+	acked := s.countAckedLocked(target)
+	if acked >= numReplicas {
+		s.mu.Unlock()
+		return acked, nil
+	}
+	// 请求副本立即 ACK，而不是等到下一次每秒心跳
+	s.feedLocked(-1, [][][]byte{{[]byte("REPLCONF"), []byte("GETACK"), []byte("*")}})
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if timeoutMs > 0 {
+		timer := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		s.mu.Lock()
+		acked = s.countAckedLocked(target)
+		changed := s.ackChanged
+		s.mu.Unlock()
+		if acked >= numReplicas {
+			return acked, nil
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return acked, nil
+		case <-s.stopChan:
+			return acked, nil
+		}
+	}
+}
+
+// goodReplicasLocked 返回最近 MinReplicasMaxLag 秒内发送过 ACK 的在线副本数。
+func (s *Server) goodReplicasLocked() int {
+	maxLag := time.Duration(s.opts.MinReplicasMaxLag) * time.Second
+	n := 0
+	for p := range s.replicas {
+		if online, _, lag := p.ackState(); online && lag <= maxLag {
+			n++
+		}
+	}
+	return n
+}
+
+// checkMinReplicas 在健康副本数低于 min-replicas-to-write 时拒绝写命令。
+func (s *Server) checkMinReplicas() error {
+	if s.opts.MinReplicasToWrite <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.link != nil {
+		return nil
+	}
+	if s.goodReplicasLocked() < s.opts.MinReplicasToWrite {
+		return database.MakeReplyError("NOREPLICAS Not enough good replicas to write.")
+	}
+	return nil
+}
@@ -1,8 +1,8 @@
 package resp
 
 import (
-	"bytes"
-	"strconv"
+	_interface "MiddlewareSelf/redis/interface"
+	"io"
 )
 
 var (
@@ -21,7 +21,15 @@ func MakeSimpleReply(status string) *SimpleReply {
 }
 
 func (reply *SimpleReply) ToBytes() []byte {
-	return []byte("+" + reply.Status + CRLF)
+	return Encode(reply, RESP2)
+}
+
+func (reply *SimpleReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, reply, RESP2)
+}
+
+func (reply *SimpleReply) writeProto(w *writer, _ int) {
+	w.line('+', reply.Status)
 }
 
 // -Error
@@ -36,7 +44,15 @@ func MakeErrorReply(error string) *ErrorReply {
 }
 
 func (reply *ErrorReply) ToBytes() []byte {
-	return []byte("-" + reply.Error + CRLF)
+	return Encode(reply, RESP2)
+}
+
+func (reply *ErrorReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, reply, RESP2)
+}
+
+func (reply *ErrorReply) writeProto(w *writer, _ int) {
+	w.line('-', reply.Error)
 }
 
 // : int
@@ -49,8 +65,22 @@ func MakeIntegerReply(code int64) *IntegerReply {
 		code: code,
 	}
 }
+
+// Code 返回整数值。
+func (reply *IntegerReply) Code() int64 {
+	return reply.code
+}
+
 func (reply *IntegerReply) ToBytes() []byte {
-	return []byte(":" + strconv.FormatInt(reply.code, 10) + CRLF)
+	return Encode(reply, RESP2)
+}
+
+func (reply *IntegerReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, reply, RESP2)
+}
+
+func (reply *IntegerReply) writeProto(w *writer, _ int) {
+	w.header(':', reply.code)
 }
 
 // $ string
@@ -65,10 +95,16 @@ func MakeBulkReply(arg []byte) *BulkReply {
 }
 
 func (r *BulkReply) ToBytes() []byte {
-	if r.Arg == nil {
-		return []byte("$-1" + CRLF)
-	}
-	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
+	return Encode(r, RESP2)
+}
+
+func (r *BulkReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r, RESP2)
+}
+
+// writeProto 中 nil 在 RESP2 下为 $-1，RESP3 下为 Null。
+func (r *BulkReply) writeProto(w *writer, proto int) {
+	w.bulk(r.Arg, proto)
 }
 
 // * multi
@@ -83,33 +119,58 @@ func MakeArrayReply(args [][]byte) *ArrayReply {
 }
 
 func (r *ArrayReply) ToBytes() []byte {
+	return Encode(r, RESP2)
+}
+
+func (r *ArrayReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r, RESP2)
+}
+
+// writeProto 中 nil 数组与 nil 元素在 RESP3 下编码为 Null。
+func (r *ArrayReply) writeProto(w *writer, proto int) {
 	if r.Args == nil {
-		return []byte("*-1" + CRLF)
+		if proto == RESP3 {
+			w.null(proto)
+			return
+		}
+		w.writeString("*-1" + CRLF)
+		return
+	}
+	w.header('*', int64(len(r.Args)))
+	for _, arg := range r.Args {
+		w.bulk(arg, proto)
 	}
+}
 
-	var buf bytes.Buffer
-	buf.Grow(len(r.Args) * 16)
+// * multi，元素可以是任意应答（整数、错误、nil、嵌套数组等）。ArrayReply 只能表示 bulk 字符串数组。
+// Replies 为 nil 时编码为 nil 数组。
+type MultiBulkReply struct {
+	Replies []_interface.Reply
+}
 
-	if len(r.Args) == 0 {
-		return []byte("*0" + CRLF)
+func MakeMultiBulkReply(replies []_interface.Reply) *MultiBulkReply {
+	return &MultiBulkReply{
+		Replies: replies,
 	}
+}
+
+func (r *MultiBulkReply) ToBytes() []byte {
+	return Encode(r, RESP2)
+}
 
-	buf.WriteString("*")
-	buf.WriteString(strconv.Itoa(len(r.Args)))
-	buf.WriteString(CRLF)
+func (r *MultiBulkReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r, RESP2)
+}
 
-	for _, arg := range r.Args {
-		if arg == nil {
-			buf.WriteString("$-1")
-			buf.WriteString(CRLF)
-		} else {
-			buf.WriteString("$")
-			buf.WriteString(strconv.Itoa(len(arg)))
-			buf.WriteString(CRLF)
-			buf.Write(arg)
-			buf.WriteString(CRLF)
+// writeProto 按协议版本递归编码元素，RESP3 下 nil 数组编码为 Null。
+func (r *MultiBulkReply) writeProto(w *writer, proto int) {
+	if r.Replies == nil {
+		if proto == RESP3 {
+			w.null(proto)
+			return
 		}
+		w.writeString("*-1" + CRLF)
+		return
 	}
-
-	return buf.Bytes()
+	w.aggregate('*', len(r.Replies), r.Replies, proto)
 }
@@ -0,0 +1,272 @@
+package resp
+
+import (
+	_interface "MiddlewareSelf/redis/interface"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// 协议版本，连接默认 RESP2，通过 HELLO 切换。
+// RESP3 类型的 ToBytes / WriteTo 为 RESP3 编码，RESP2 连接上降级为等价的 RESP2 类型。
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// _ null
+type NullReply struct{}
+
+func MakeNullReply() *NullReply {
+	return &NullReply{}
+}
+
+func (r *NullReply) ToBytes() []byte {
+	return Encode(r, RESP3)
+}
+
+func (r *NullReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r, RESP3)
+}
+
+func (r *NullReply) writeProto(w *writer, proto int) {
+	w.null(proto)
+}
+
+// # boolean，RESP2 下为整数 1 / 0
+type BoolReply struct {
+	Value bool
+}
+
+func MakeBoolReply(v bool) *BoolReply {
+	return &BoolReply{Value: v}
+}
+
+func (r *BoolReply) ToBytes() []byte {
+	return Encode(r, RESP3)
+}
+
+func (r *BoolReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r, RESP3)
+}
+
+func (r *BoolReply) writeProto(w *writer, proto int) {
+	switch {
+	case proto == RESP3 && r.Value:
+		w.writeString("#t" + CRLF)
+	case proto == RESP3:
+		w.writeString("#f" + CRLF)
+	case r.Value:
+		w.writeString(":1" + CRLF)
+	default:
+		w.writeString(":0" + CRLF)
+	}
+}
+
+// , double，RESP2 下为 bulk string
+type DoubleReply struct {
+	Value float64
+}
+
+func MakeDoubleReply(v float64) *DoubleReply {
+	return &DoubleReply{Value: v}
+}
+
+// FormatDouble 按 Redis 的写法格式化浮点数：inf / -inf / nan，其余为最短精确表示。
+func FormatDouble(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	case math.IsNaN(v):
+		return "nan"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ParseDouble 解析 FormatDouble 的输出，同时接受指数形式。
+func ParseDouble(s string) (float64, error) {
+	switch s {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func (r *DoubleReply) ToBytes() []byte {
+	return Encode(r, RESP3)
+}
+
+func (r *DoubleReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r, RESP3)
+}
+
+func (r *DoubleReply) writeProto(w *writer, proto int) {
+	s := FormatDouble(r.Value)
+	if proto == RESP3 {
+		w.line(',', s)
+		return
+	}
+	w.bulk([]byte(s), proto)
+}
+
+// ( big number，RESP2 下为 bulk string
+type BigNumberReply struct {
+	Value *big.Int
+}
+
+func MakeBigNumberReply(v *big.Int) *BigNumberReply {
+	return &BigNumberReply{Value: v}
+}
+
+func (r *BigNumberReply) ToBytes() []byte {
+	return Encode(r, RESP3)
+}
+
+func (r *BigNumberReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r, RESP3)
+}
+
+func (r *BigNumberReply) writeProto(w *writer, proto int) {
+	s := r.Value.String()
+	if proto == RESP3 {
+		w.line('(', s)
+		return
+	}
+	w.bulk([]byte(s), proto)
+}
+
+// = verbatim string，Format 为 3 个字符的格式（txt / mkd），RESP2 下为只含文本的 bulk string
+type VerbatimReply struct {
+	Format string
+	Text   []byte
+}
+
+func MakeVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{Format: format, Text: text}
+}
+
+func (r *VerbatimReply) ToBytes() []byte {
+	return Encode(r, RESP3)
+}
+
+func (r *VerbatimReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r, RESP3)
+}
+
+func (r *VerbatimReply) writeProto(w *writer, proto int) {
+	if proto != RESP3 {
+		w.bulk(r.Text, RESP2)
+		return
+	}
+	w.header('=', int64(len(r.Format)+1+len(r.Text)))
+	w.writeString(r.Format + ":")
+	w.write(r.Text)
+	w.writeString(CRLF)
+}
+
+// % map，Pairs 为交替排列的键和值，RESP2 下为长度翻倍的扁平数组
+type MapReply struct {
+	Pairs []_interface.Reply
+}
+
+func MakeMapReply(pairs []_interface.Reply) *MapReply {
+	return &MapReply{Pairs: pairs}
+}
+
+func (r *MapReply) ToBytes() []byte {
+	return Encode(r, RESP3)
+}
+
+func (r *MapReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r, RESP3)
+}
+
+func (r *MapReply) writeProto(w *writer, proto int) {
+	if proto == RESP3 {
+		w.aggregate('%', len(r.Pairs)/2, r.Pairs, proto)
+		return
+	}
+	w.aggregate('*', len(r.Pairs), r.Pairs, proto)
+}
+
+// ~ set，RESP2 下为数组
+type SetReply struct {
+	Elems []_interface.Reply
+}
+
+func MakeSetReply(elems []_interface.Reply) *SetReply {
+	return &SetReply{Elems: elems}
+}
+
+func (r *SetReply) ToBytes() []byte {
+	return Encode(r, RESP3)
+}
+
+func (r *SetReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r, RESP3)
+}
+
+func (r *SetReply) writeProto(w *writer, proto int) {
+	if proto == RESP3 {
+		w.aggregate('~', len(r.Elems), r.Elems, proto)
+		return
+	}
+	w.aggregate('*', len(r.Elems), r.Elems, proto)
+}
+
+// | attribute，附加在 Reply 之前的键值对元数据，RESP2 下丢弃只发送 Reply
+type AttributeReply struct {
+	Pairs []_interface.Reply
+	Reply _interface.Reply
+}
+
+func MakeAttributeReply(pairs []_interface.Reply, reply _interface.Reply) *AttributeReply {
+	return &AttributeReply{Pairs: pairs, Reply: reply}
+}
+
+func (r *AttributeReply) ToBytes() []byte {
+	return Encode(r, RESP3)
+}
+
+func (r *AttributeReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r, RESP3)
+}
+
+func (r *AttributeReply) writeProto(w *writer, proto int) {
+	if proto == RESP3 {
+		w.aggregate('|', len(r.Pairs)/2, r.Pairs, proto)
+	}
+	w.reply(r.Reply, proto)
+}
+
+// > push，服务端主动推送的带外数据，RESP2 下为数组
+type PushReply struct {
+	Elems []_interface.Reply
+}
+
+func MakePushReply(elems []_interface.Reply) *PushReply {
+	return &PushReply{Elems: elems}
+}
+
+func (r *PushReply) ToBytes() []byte {
+	return Encode(r, RESP3)
+}
+
+func (r *PushReply) WriteTo(w io.Writer) (int64, error) {
+	return WriteTo(w, r, RESP3)
+}
+
+func (r *PushReply) writeProto(w *writer, proto int) {
+	if proto == RESP3 {
+		w.aggregate('>', len(r.Elems), r.Elems, proto)
+		return
+	}
+	w.aggregate('*', len(r.Elems), r.Elems, proto)
+}
@@ -0,0 +1,121 @@
+package resp
+
+import (
+	_interface "MiddlewareSelf/redis/interface"
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// protoWriter 由本包的应答类型实现，按协议版本把编码写入 w。
+type protoWriter interface {
+	writeProto(w *writer, proto int)
+}
+
+// WriteTo 按连接的协议版本把应答写入 w。RESP3 下 nil bulk / nil 数组编码为 Null。
+func WriteTo(w io.Writer, r _interface.Reply, proto int) (int64, error) {
+	rw := writer{w: w}
+	rw.reply(r, proto)
+	return rw.n, rw.err
+}
+
+// Encode 按连接的协议版本编码应答。
+func Encode(r _interface.Reply, proto int) []byte {
+	var buf bytes.Buffer
+	_, _ = WriteTo(&buf, r, proto)
+	return buf.Bytes()
+}
+
+// availableBuffer 由 bufio.Writer / bytes.Buffer 实现，用来不分配内存地格式化长度头部
+type availableBuffer interface {
+	AvailableBuffer() []byte
+}
+
+// writer 依次写入应答的各部分，累计写入字节数并保留第一个错误，出错后的写入全部忽略。
+type writer struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *writer) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	w.err = err
+}
+
+func (w *writer) writeString(s string) {
+	if w.err != nil {
+		return
+	}
+	n, err := io.WriteString(w.w, s)
+	w.n += int64(n)
+	w.err = err
+}
+
+// header 写入 <prefix><n>\r\n，如 *3\r\n、$5\r\n。
+func (w *writer) header(prefix byte, n int64) {
+	var buf []byte
+	if ab, ok := w.w.(availableBuffer); ok {
+		buf = ab.AvailableBuffer()
+	}
+	buf = append(buf, prefix)
+	buf = strconv.AppendInt(buf, n, 10)
+	buf = append(buf, CRLF...)
+	w.write(buf)
+}
+
+// line 写入 <prefix><s>\r\n，用于简单字符串、错误、double 等单行类型。
+func (w *writer) line(prefix byte, s string) {
+	var buf []byte
+	if ab, ok := w.w.(availableBuffer); ok {
+		buf = ab.AvailableBuffer()
+	}
+	buf = append(buf, prefix)
+	buf = append(buf, s...)
+	buf = append(buf, CRLF...)
+	w.write(buf)
+}
+
+// bulk 写入 bulk string，nil 在 RESP2 下为 $-1，RESP3 下为 Null。
+func (w *writer) bulk(b []byte, proto int) {
+	if b == nil {
+		w.null(proto)
+		return
+	}
+	w.header('$', int64(len(b)))
+	w.write(b)
+	w.writeString(CRLF)
+}
+
+func (w *writer) null(proto int) {
+	if proto == RESP3 {
+		w.writeString("_" + CRLF)
+		return
+	}
+	w.writeString("$-1" + CRLF)
+}
+
+// aggregate 写入聚合类型的头部与元素，元素按同一协议版本递归编码。
+func (w *writer) aggregate(prefix byte, n int, elems []_interface.Reply, proto int) {
+	w.header(prefix, int64(n))
+	for _, elem := range elems {
+		w.reply(elem, proto)
+	}
+}
+
+func (w *writer) reply(r _interface.Reply, proto int) {
+	if pw, ok := r.(protoWriter); ok {
+		pw.writeProto(w, proto)
+		return
+	}
+	// 包外实现的应答不区分协议版本
+	if w.err == nil {
+		n, err := r.WriteTo(w.w)
+		w.n += n
+		w.err = err
+	}
+}
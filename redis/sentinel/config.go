@@ -0,0 +1,183 @@
+package sentinel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config 为 sentinel 配置。一个 sentinel 进程监控一个主库。
+type Config struct {
+	// Address 为本 sentinel 的监听地址（ip:port），其它 sentinel 通过它投票与交换配置
+	Address string
+	// MyID 为本 sentinel 的 run id，为空时随机生成
+	MyID string
+
+	MasterName string
+	MasterAddr string
+	// Quorum 为判定客观下线（ODOWN）所需的 sentinel 数量
+	Quorum int
+	// DownAfter 对应 down-after-milliseconds：超过该时间 PING 无有效应答即主观下线（SDOWN）
+	DownAfter time.Duration
+	// FailoverTimeout 对应 failover-timeout：单次故障转移各阶段的超时，失败后间隔 2 倍该时间再重试
+	FailoverTimeout time.Duration
+	// KnownSentinels 为其它 sentinel 的地址
+	KnownSentinels []string
+
+	// 以下周期为 0 时使用 Redis 的默认值，测试中可以调小
+	PingPeriod  time.Duration
+	InfoPeriod  time.Duration
+	HelloPeriod time.Duration
+}
+
+func (c *Config) setDefaults() {
+	if c.DownAfter <= 0 {
+		c.DownAfter = 30 * time.Second
+	}
+	if c.FailoverTimeout <= 0 {
+		c.FailoverTimeout = 3 * time.Minute
+	}
+	if c.PingPeriod <= 0 {
+		c.PingPeriod = time.Second
+	}
+	if c.InfoPeriod <= 0 {
+		c.InfoPeriod = 10 * time.Second
+	}
+	if c.HelloPeriod <= 0 {
+		c.HelloPeriod = 2 * time.Second
+	}
+	if c.Quorum <= 0 {
+		c.Quorum = 1
+	}
+}
+
+// LoadConfig 读取 sentinel.conf。
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg, err := ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("load sentinel config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig 解析 Redis sentinel.conf 的子集：
+//
+//	bind 127.0.0.1
+//	port 26379
+//	sentinel myid <id>
+//	sentinel monitor <name> <ip> <port> <quorum>
+//	sentinel down-after-milliseconds <name> <ms>
+//	sentinel failover-timeout <name> <ms>
+//	sentinel known-sentinel <name> <ip> <port> [runid]
+func ParseConfig(reader io.Reader) (*Config, error) {
+	cfg := &Config{}
+	bind, port := "", 26379
+	scanner := bufio.NewScanner(reader)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var err error
+		switch strings.ToLower(fields[0]) {
+		case "bind":
+			if len(fields) != 2 {
+				err = errors.New("expect 'bind <ip>'")
+			} else {
+				bind = fields[1]
+			}
+		case "port":
+			if len(fields) != 2 {
+				err = errors.New("expect 'port <port>'")
+			} else {
+				port, err = strconv.Atoi(fields[1])
+			}
+		case "sentinel":
+			err = cfg.parseSentinelLine(fields[1:])
+		default:
+			err = fmt.Errorf("unknown config '%s'", fields[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if cfg.MasterName == "" {
+		return nil, errors.New("missing 'sentinel monitor'")
+	}
+	cfg.Address = net.JoinHostPort(bind, strconv.Itoa(port))
+	return cfg, nil
+}
+
+func (c *Config) parseSentinelLine(args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing sentinel option")
+	}
+	option := strings.ToLower(args[0])
+	if option == "myid" {
+		if len(args) != 2 {
+			return errors.New("expect 'sentinel myid <id>'")
+		}
+		c.MyID = args[1]
+		return nil
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("missing master name for '%s'", option)
+	}
+	if option != "monitor" && args[1] != c.MasterName {
+		return fmt.Errorf("unknown master '%s'", args[1])
+	}
+	switch option {
+	case "monitor":
+		if len(args) != 5 {
+			return errors.New("expect 'sentinel monitor <name> <ip> <port> <quorum>'")
+		}
+		if c.MasterName != "" {
+			return errors.New("only one monitored master is supported")
+		}
+		quorum, err := strconv.Atoi(args[4])
+		if err != nil || quorum <= 0 {
+			return errors.New("quorum must be a positive integer")
+		}
+		c.MasterName = args[1]
+		c.MasterAddr = net.JoinHostPort(args[2], args[3])
+		c.Quorum = quorum
+	case "down-after-milliseconds", "failover-timeout":
+		if len(args) != 3 {
+			return fmt.Errorf("expect 'sentinel %s <name> <ms>'", option)
+		}
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil || ms <= 0 {
+			return errors.New("expect a positive number of milliseconds")
+		}
+		if option == "failover-timeout" {
+			c.FailoverTimeout = time.Duration(ms) * time.Millisecond
+		} else {
+			c.DownAfter = time.Duration(ms) * time.Millisecond
+		}
+	case "known-sentinel":
+		if len(args) != 4 && len(args) != 5 {
+			return errors.New("expect 'sentinel known-sentinel <name> <ip> <port> [runid]'")
+		}
+		c.KnownSentinels = append(c.KnownSentinels, net.JoinHostPort(args[2], args[3]))
+	default:
+		return fmt.Errorf("unknown sentinel option '%s'", option)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package sentinel
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"time"
+)
+
+type failoverState int
+
+const (
+	failoverNone failoverState = iota
+	// failoverWaitStart 已提升纪元并为自己投票，等待其它 sentinel 的选票
+	failoverWaitStart
+	// failoverWaitPromotion 已向选中的副本发送 REPLICAOF NO ONE，等待其 INFO 中角色变为 master
+	failoverWaitPromotion
+)
+
+// failoverInfo 为本 sentinel 发起的故障转移的进度。
+type failoverInfo struct {
+	state    failoverState
+	epoch    int64
+	start    time.Time
+	promoted string
+	// nextAttempt 之前不发起新的故障转移：上次失败、或本纪元已投票给其它 sentinel
+	nextAttempt time.Time
+}
+
+// electionTimeout 与 Redis 一样取 failover-timeout 与 10 秒中的较小值。
+func (s *Sentinel) electionTimeout() time.Duration {
+	if s.cfg.FailoverTimeout < 10*time.Second {
+		return s.cfg.FailoverTimeout
+	}
+	return 10 * time.Second
+}
+
+// failoverStepLocked 推进故障转移状态机，返回需要在锁外执行的网络操作。
+func (s *Sentinel) failoverStepLocked(now time.Time) []func() {
+	switch s.failover.state {
+	case failoverNone:
+		if !s.odown || now.Before(s.failover.nextAttempt) {
+			return nil
+		}
+		s.currentEpoch++
+		s.failover = failoverInfo{state: failoverWaitStart, epoch: s.currentEpoch, start: now}
+		s.leader, s.leaderEpoch = s.runID, s.currentEpoch
+		s.event(fmt.Sprintf("+new-epoch %d", s.currentEpoch))
+		s.event(fmt.Sprintf("+try-failover master %s", s.masterAddr))
+		return nil
+
+	case failoverWaitStart:
+		votes, needed := s.votesLocked(), s.votesNeeded()
+		if votes < needed {
+			if now.Sub(s.failover.start) > s.electionTimeout() {
+				s.abortFailoverLocked(now, fmt.Sprintf("-failover-abort-not-elected (%d/%d votes)", votes, needed))
+			}
+			return nil
+		}
+		s.event(fmt.Sprintf("+elected-leader epoch %d (%d/%d votes)", s.failover.epoch, votes, needed))
+		best := s.selectReplicaLocked(now)
+		if best == nil {
+			s.abortFailoverLocked(now, "-failover-abort-no-good-slave")
+			return nil
+		}
+		s.event("+selected-slave " + best.addr)
+		s.failover.state = failoverWaitPromotion
+		s.failover.promoted = best.addr
+		s.failover.start = now
+		addr := best.addr
+		return []func(){func() {
+			s.event("+promote-slave " + addr)
+			if _, err := sendCommand(addr, commandTimeout, "REPLICAOF", "NO", "ONE"); err != nil {
+				log.Printf("[SENTINEL] promote %s failed: %v", addr, err)
+			}
+		}}
+
+	case failoverWaitPromotion:
+		promoted := s.instances[s.failover.promoted]
+		if promoted.role == "master" && promoted.infoRefresh.After(s.failover.start) {
+			return s.finishFailoverLocked()
+		}
+		if now.Sub(s.failover.start) > s.cfg.FailoverTimeout {
+			s.abortFailoverLocked(now, "-failover-abort-slave-timeout "+s.failover.promoted)
+		}
+	}
+	return nil
+}
+
+func (s *Sentinel) abortFailoverLocked(now time.Time, msg string) {
+	s.event(msg)
+	s.failover = failoverInfo{nextAttempt: now.Add(2 * s.cfg.FailoverTimeout)}
+}
+
+// votesLocked 统计本纪元投给自己的票数（含自己的一票）。
+func (s *Sentinel) votesLocked() int {
+	votes := 0
+	if s.leader == s.runID && s.leaderEpoch == s.failover.epoch {
+		votes++
+	}
+	for _, p := range s.peers {
+		if p.leader == s.runID && p.leaderEpoch == s.failover.epoch {
+			votes++
+		}
+	}
+	return votes
+}
+
+// votesNeeded 为当选所需票数：所有 sentinel 的多数，且不少于 quorum。
+func (s *Sentinel) votesNeeded() int {
+	majority := (len(s.peers)+1)/2 + 1
+	if s.cfg.Quorum > majority {
+		return s.cfg.Quorum
+	}
+	return majority
+}
+
+// selectReplicaLocked 选择要提升的副本：在线且最近有 INFO 的副本中，复制偏移最大者优先，
+// 相同时取地址较小者以保证结果确定。
+func (s *Sentinel) selectReplicaLocked(now time.Time) *instance {
+	var candidates []*instance
+	for addr, inst := range s.instances {
+		if addr == s.masterAddr || inst.role != "slave" {
+			continue
+		}
+		if now.Sub(inst.lastPingOK) > 5*s.cfg.PingPeriod || now.Sub(inst.infoRefresh) > 5*s.cfg.PingPeriod {
+			continue
+		}
+		candidates = append(candidates, inst)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].replOffset != candidates[j].replOffset {
+			return candidates[i].replOffset > candidates[j].replOffset
+		}
+		return candidates[i].addr < candidates[j].addr
+	})
+	return candidates[0]
+}
+
+// finishFailoverLocked 在新主库就绪后切换配置，让其余副本跟随新主库，并立即向其它 sentinel 广播新配置。
+// 旧主库恢复后由 fixInstancesLocked 转为副本。
+func (s *Sentinel) finishFailoverLocked() []func() {
+	oldMaster, newMaster := s.masterAddr, s.failover.promoted
+	s.switchMasterLocked(newMaster, s.failover.epoch)
+	host, port, _ := net.SplitHostPort(newMaster)
+
+	var actions []func()
+	now := time.Now()
+	for addr, inst := range s.instances {
+		if addr == newMaster || addr == oldMaster {
+			continue
+		}
+		inst.lastReconf = now
+		addr := addr
+		actions = append(actions, func() {
+			s.event("+slave-reconf-sent " + addr)
+			if _, err := sendCommand(addr, commandTimeout, "REPLICAOF", host, port); err != nil {
+				log.Printf("[SENTINEL] reconfigure %s failed: %v", addr, err)
+			}
+		})
+	}
+	hello := s.helloMessageLocked()
+	for _, p := range s.peers {
+		addr := p.addr
+		actions = append(actions, func() {
+			_, _ = sendCommand(addr, commandTimeout, "SENTINEL", "HELLO", hello)
+		})
+	}
+	s.event("+failover-end master " + newMaster)
+	return actions
+}
+
+// voteLocked 处理 is-master-down-by-addr 中的拉票：每个纪元只投一票，先到先得。
+// 返回本纪元投出的 leader 及其纪元。
+func (s *Sentinel) voteLocked(runID string, epoch int64) (string, int64) {
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		s.event(fmt.Sprintf("+new-epoch %d", epoch))
+	}
+	if s.leaderEpoch < epoch && s.currentEpoch <= epoch {
+		s.leader, s.leaderEpoch = runID, epoch
+		s.event(fmt.Sprintf("+vote-for-leader %s %d", runID, epoch))
+		if runID != s.runID {
+			// 把本轮交给对方，一段时间内自己不再发起故障转移
+			s.failover.nextAttempt = time.Now().Add(2 * s.cfg.FailoverTimeout)
+		}
+	}
+	return s.leader, s.leaderEpoch
+}
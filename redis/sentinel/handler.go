@@ -0,0 +1,206 @@
+package sentinel
+
+import (
+	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/parser"
+	"MiddlewareSelf/redis/resp"
+	"context"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handle 实现 tcp.Handler，支持 PING 与 SENTINEL 子命令。
+func (s *Sentinel) Handle(ctx context.Context, conn net.Conn) {
+	select {
+	case <-s.stopChan:
+		_ = conn.Close()
+		return
+	default:
+	}
+	s.activeConn.Store(conn, struct{}{})
+	defer func() {
+		s.activeConn.Delete(conn)
+		_ = conn.Close()
+	}()
+
+	cmdCh := parser.ParseStream(conn)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case payload, ok := <-cmdCh:
+			if !ok || payload == nil {
+				return
+			}
+			if payload.Err != nil {
+				if payload.Err == io.EOF || payload.Err.Error() == "EOF" {
+					return
+				}
+				if _, err := conn.Write(resp.MakeErrorReply(payload.Err.Error()).ToBytes()); err != nil {
+					return
+				}
+				continue
+			}
+			arr, ok := payload.Data.(*resp.ArrayReply)
+			if !ok || len(arr.Args) == 0 {
+				_, _ = conn.Write(resp.MakeErrorReply("ERR protocol error: expected array command").ToBytes())
+				continue
+			}
+			if _, err := conn.Write(s.exec(arr.Args).ToBytes()); err != nil {
+				log.Printf("[SENTINEL] write reply to %s failed: %v", conn.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+}
+
+func (s *Sentinel) exec(args [][]byte) _interface.Reply {
+	switch strings.ToUpper(string(args[0])) {
+	case "PING":
+		return resp.MakeSimpleReply("PONG")
+	case "SENTINEL":
+		if len(args) < 2 {
+			return resp.MakeErrorReply("ERR wrong number of arguments for 'sentinel'")
+		}
+		return s.execSentinel(strings.ToLower(string(args[1])), args[2:])
+	default:
+		return resp.MakeErrorReply("ERR unknown command '" + string(args[0]) + "'")
+	}
+}
+
+// execSentinel 实现 SENTINEL 子命令：
+//
+//	get-master-addr-by-name <name>           -> [ip, port]，未知名称返回 nil
+//	master <name>                            -> 主库状态的 field/value 列表
+//	replicas|slaves <name>                   -> 已知副本地址列表
+//	sentinels <name>                         -> 其它 sentinel 地址列表
+//	myid                                     -> 本 sentinel 的 run id
+//	is-master-down-by-addr <ip> <port> <epoch> <runid|*> -> [down, leader, leader_epoch]
+//	hello <msg>                              -> OK，见 Sentinel.helloMessage
+//
+// 应答只使用 bulk 数组，Redis 中嵌套数组的应答在这里被展开为平铺列表。
+func (s *Sentinel) execSentinel(sub string, args [][]byte) _interface.Reply {
+	switch sub {
+	case "myid":
+		return resp.MakeBulkReply([]byte(s.runID))
+	case "is-master-down-by-addr":
+		if len(args) != 4 {
+			return wrongArgs(sub)
+		}
+		epoch, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil {
+			return resp.MakeErrorReply("ERR invalid epoch")
+		}
+		addr := net.JoinHostPort(string(args[0]), string(args[1]))
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		down := "0"
+		if addr == s.masterAddr && s.sdown {
+			down = "1"
+		}
+		leader, leaderEpoch := "*", int64(0)
+		if runID := string(args[3]); runID != "*" {
+			leader, leaderEpoch = s.voteLocked(runID, epoch)
+		}
+		return resp.MakeArrayReply([][]byte{[]byte(down), []byte(leader), []byte(strconv.FormatInt(leaderEpoch, 10))})
+	case "hello":
+		if len(args) != 1 {
+			return wrongArgs(sub)
+		}
+		if err := s.processHello(string(args[0])); err != nil {
+			return resp.MakeErrorReply("ERR " + err.Error())
+		}
+		return resp.MakeSimpleReply("OK")
+	}
+
+	if len(args) != 1 {
+		return wrongArgs(sub)
+	}
+	if string(args[0]) != s.cfg.MasterName {
+		if sub == "get-master-addr-by-name" {
+			return resp.MakeArrayReply(nil)
+		}
+		return resp.MakeErrorReply("ERR No such master with that name")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch sub {
+	case "get-master-addr-by-name":
+		host, port, _ := net.SplitHostPort(s.masterAddr)
+		return resp.MakeArrayReply([][]byte{[]byte(host), []byte(port)})
+	case "master":
+		return resp.MakeArrayReply(s.masterStateLocked())
+	case "replicas", "slaves":
+		var addrs [][]byte
+		for addr, inst := range s.instances {
+			if addr != s.masterAddr && inst.role == "slave" {
+				addrs = append(addrs, []byte(addr))
+			}
+		}
+		return resp.MakeArrayReply(sortedOrEmpty(addrs))
+	case "sentinels":
+		addrs := make([][]byte, 0, len(s.peers))
+		for _, p := range s.peers {
+			addrs = append(addrs, []byte(p.addr))
+		}
+		return resp.MakeArrayReply(addrs)
+	default:
+		return resp.MakeErrorReply("ERR unknown sentinel subcommand '" + sub + "'")
+	}
+}
+
+func (s *Sentinel) masterStateLocked() [][]byte {
+	host, port, _ := net.SplitHostPort(s.masterAddr)
+	flags := "master"
+	if s.sdown {
+		flags += ",s_down"
+	}
+	if s.odown {
+		flags += ",o_down"
+	}
+	if s.failover.state != failoverNone {
+		flags += ",failover_in_progress"
+	}
+	numReplicas := 0
+	for addr, inst := range s.instances {
+		if addr != s.masterAddr && inst.role == "slave" {
+			numReplicas++
+		}
+	}
+	pingAgo := time.Since(s.instances[s.masterAddr].lastPingOK).Milliseconds()
+	fields := []string{
+		"name", s.cfg.MasterName,
+		"ip", host,
+		"port", port,
+		"flags", flags,
+		"last-ok-ping-reply", strconv.FormatInt(pingAgo, 10),
+		"config-epoch", strconv.FormatInt(s.configEpoch, 10),
+		"num-slaves", strconv.Itoa(numReplicas),
+		"num-other-sentinels", strconv.Itoa(len(s.peers)),
+		"quorum", strconv.Itoa(s.cfg.Quorum),
+		"down-after-milliseconds", strconv.FormatInt(s.cfg.DownAfter.Milliseconds(), 10),
+		"failover-timeout", strconv.FormatInt(s.cfg.FailoverTimeout.Milliseconds(), 10),
+	}
+	out := make([][]byte, len(fields))
+	for i, f := range fields {
+		out[i] = []byte(f)
+	}
+	return out
+}
+
+func sortedOrEmpty(addrs [][]byte) [][]byte {
+	if addrs == nil {
+		return [][]byte{}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return string(addrs[i]) < string(addrs[j]) })
+	return addrs
+}
+
+func wrongArgs(sub string) _interface.Reply {
+	return resp.MakeErrorReply("ERR wrong number of arguments for 'sentinel " + sub + "'")
+}
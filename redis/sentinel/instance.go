@@ -0,0 +1,223 @@
+package sentinel
+
+import (
+	"MiddlewareSelf/redis/client"
+	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/resp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// link 是到一个实例（Redis 或其它 sentinel）的命令连接，出错后下次调用时重新建立。
+// 每个 link 只由一个协程使用。
+type link struct {
+	addr string
+	cli  *client.PipelineClient
+}
+
+func (l *link) do(timeout time.Duration, args ...string) (_interface.Reply, error) {
+	if l.cli == nil {
+		cli, err := client.DialPipeline(l.addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		l.cli = cli
+	}
+	reply, err := execOne(l.cli, timeout, args...)
+	if err != nil {
+		l.close()
+	}
+	return reply, err
+}
+
+func (l *link) close() {
+	if l.cli != nil {
+		_ = l.cli.Close()
+		l.cli = nil
+	}
+}
+
+// sendCommand 建立一次性连接执行命令，用于故障转移中的 REPLICAOF 等低频操作。
+func sendCommand(addr string, timeout time.Duration, args ...string) (_interface.Reply, error) {
+	cli, err := client.DialPipeline(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	return execOne(cli, timeout, args...)
+}
+
+// execOne 执行一条命令，-ERR 应答转换为 error。
+func execOne(cli *client.PipelineClient, timeout time.Duration, args ...string) (_interface.Reply, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ch, err := cli.ExecStream(ctx, []client.Command{client.NewCommand(args...)})
+	if err != nil {
+		return nil, err
+	}
+	res, ok := <-ch
+	for range ch {
+	}
+	if !ok {
+		return nil, errors.New("no reply")
+	}
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	if e, isErr := res.Reply.(*resp.ErrorReply); isErr {
+		return nil, errors.New(e.Error)
+	}
+	return res.Reply, nil
+}
+
+// instance 是被监控的一个 Redis 实例（主库或副本）的最新观测状态。
+// 字段由监控协程写入、主循环读取，均在 Sentinel.mu 保护下访问。
+type instance struct {
+	addr string
+	stop chan struct{}
+
+	// lastPingOK 为最近一次 PING 得到有效应答的时间，SDOWN 据此判断
+	lastPingOK time.Time
+	// 以下来自最近一次 INFO replication
+	infoRefresh time.Time
+	role        string
+	// masterAddr / masterLinkUp / replOffset 仅在 role 为 slave 时有效
+	masterAddr   string
+	masterLinkUp bool
+	replOffset   int64
+	// replicas 为 role 为 master 时其 INFO 中列出的副本地址
+	replicas []string
+	// lastReconf 为最近一次向该实例发送 REPLICAOF 的时间，用于限速
+	lastReconf time.Time
+}
+
+// replicationInfo 是 INFO replication 中 sentinel 关心的字段。
+type replicationInfo struct {
+	role         string
+	masterAddr   string
+	masterLinkUp bool
+	replOffset   int64
+	replicas     []string
+}
+
+// parseReplicationInfo 解析 INFO replication 的 key:value 行。
+func parseReplicationInfo(text string) replicationInfo {
+	var info replicationInfo
+	var masterHost, masterPort string
+	for _, line := range strings.Split(text, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		switch {
+		case key == "role":
+			info.role = value
+		case key == "master_host":
+			masterHost = value
+		case key == "master_port":
+			masterPort = value
+		case key == "master_link_status":
+			info.masterLinkUp = value == "up"
+		case key == "slave_repl_offset":
+			info.replOffset, _ = strconv.ParseInt(value, 10, 64)
+		case strings.HasPrefix(key, "slave") && strings.Contains(value, "ip="):
+			// slaveN:ip=127.0.0.1,port=6380,state=online,...
+			var ip, port string
+			for _, kv := range strings.Split(value, ",") {
+				k, v, _ := strings.Cut(kv, "=")
+				switch k {
+				case "ip":
+					ip = v
+				case "port":
+					port = v
+				}
+			}
+			if ip != "" && port != "" && port != "0" {
+				info.replicas = append(info.replicas, net.JoinHostPort(ip, port))
+			}
+		}
+	}
+	if masterHost != "" {
+		info.masterAddr = net.JoinHostPort(masterHost, masterPort)
+	}
+	return info
+}
+
+// monitor 定期向实例发送 PING 与 INFO replication，直到 inst.stop 关闭。
+func (s *Sentinel) monitor(inst *instance) {
+	defer s.wg.Done()
+	l := &link{addr: inst.addr}
+	defer l.close()
+	timeout := s.cfg.PingPeriod
+
+	ticker := time.NewTicker(s.cfg.PingPeriod)
+	defer ticker.Stop()
+	var lastInfo time.Time
+	for {
+		if reply, err := l.do(timeout, "PING"); err == nil && isPong(reply) {
+			s.mu.Lock()
+			inst.lastPingOK = time.Now()
+			s.mu.Unlock()
+		}
+		if time.Since(lastInfo) >= s.infoPeriod() {
+			lastInfo = time.Now()
+			if reply, err := l.do(timeout, "INFO", "replication"); err == nil {
+				if bulk, ok := reply.(*resp.BulkReply); ok {
+					s.updateInfo(inst, parseReplicationInfo(string(bulk.Arg)))
+				}
+			}
+		}
+
+		select {
+		case <-inst.stop:
+			return
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func isPong(reply _interface.Reply) bool {
+	simple, ok := reply.(*resp.SimpleReply)
+	return ok && simple.Status == "PONG"
+}
+
+// infoPeriod 与 Redis 一样，主库主观下线或故障转移期间把 INFO 周期缩短为 PING 周期。
+func (s *Sentinel) infoPeriod() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sdown || s.failover.state != failoverNone {
+		return s.cfg.PingPeriod
+	}
+	return s.cfg.InfoPeriod
+}
+
+// updateInfo 记录 INFO 结果，并把主库 INFO 中新发现的副本纳入监控。
+func (s *Sentinel) updateInfo(inst *instance, info replicationInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst.infoRefresh = time.Now()
+	if inst.role != "" && inst.role != info.role {
+		s.event(fmt.Sprintf("+role-change %s %s -> %s", inst.addr, inst.role, info.role))
+	}
+	inst.role = info.role
+	inst.masterAddr = info.masterAddr
+	inst.masterLinkUp = info.masterLinkUp
+	inst.replOffset = info.replOffset
+	inst.replicas = info.replicas
+	if inst.addr != s.masterAddr || info.role != "master" {
+		return
+	}
+	for _, addr := range info.replicas {
+		if _, ok := s.instances[addr]; !ok {
+			s.event("+slave " + addr)
+			s.addInstanceLocked(addr)
+		}
+	}
+}
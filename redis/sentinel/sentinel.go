@@ -0,0 +1,363 @@
+package sentinel
+
+import (
+	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/resp"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sentinel 监控一个主库及其副本，主库客观下线时与其它 sentinel 选出 leader 完成故障转移。
+//
+// 与 Redis Sentinel 的对应关系：
+//   - 每个实例定期 PING / INFO replication，超过 down-after 无有效应答即 SDOWN；
+//   - 主库 SDOWN 后通过 SENTINEL is-master-down-by-addr 询问其它 sentinel，
+//     认为下线的数量（含自己）达到 quorum 即 ODOWN；
+//   - ODOWN 后提升纪元并拉票（同一命令带上自己的 runid），得票过半且不少于 quorum 者成为 leader；
+//   - leader 选出复制偏移最大的副本执行 REPLICAOF NO ONE，再让其余副本 REPLICAOF 新主库；
+//   - 新配置（主库地址 + config epoch）通过 hello 消息扩散。本项目没有 Pub/Sub，
+//     hello 以 SENTINEL HELLO 命令直接发给已知的 sentinel，内容格式与 Redis 的 hello 频道一致。
+type Sentinel struct {
+	cfg   Config
+	runID string
+
+	mu           sync.Mutex
+	currentEpoch int64
+	masterAddr   string
+	configEpoch  int64
+	instances    map[string]*instance
+	peers        []*peer
+	sdown, odown bool
+	// leader / leaderEpoch 为本 sentinel 最近一次投出的票
+	leader      string
+	leaderEpoch int64
+	failover    failoverInfo
+
+	stopChan   chan struct{}
+	closeOnce  sync.Once
+	wg         sync.WaitGroup
+	activeConn sync.Map
+}
+
+// peer 是另一个 sentinel 及其最近一次 is-master-down-by-addr 应答。
+type peer struct {
+	addr          string
+	runID         string
+	masterDown    bool
+	downReplyTime time.Time
+	leader        string
+	leaderEpoch   int64
+}
+
+const (
+	// tickPeriod 为主循环周期，对应 Redis sentinel 定时器约 10Hz 的频率
+	tickPeriod = 100 * time.Millisecond
+	// commandTimeout 为故障转移中一次性命令（REPLICAOF 等）的超时
+	commandTimeout = 2 * time.Second
+)
+
+// New 创建 sentinel，调用 Start 后开始监控。
+func New(cfg Config) *Sentinel {
+	cfg.setDefaults()
+	runID := cfg.MyID
+	if runID == "" {
+		runID = newRunID()
+	}
+	s := &Sentinel{
+		cfg:        cfg,
+		runID:      runID,
+		masterAddr: cfg.MasterAddr,
+		instances:  make(map[string]*instance),
+		stopChan:   make(chan struct{}),
+	}
+	for _, addr := range cfg.KnownSentinels {
+		s.peers = append(s.peers, &peer{addr: addr})
+	}
+	return s
+}
+
+func newRunID() string {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// RunID 返回本 sentinel 的 run id。
+func (s *Sentinel) RunID() string {
+	return s.runID
+}
+
+// Start 启动实例监控、sentinel 间通信与主循环。
+func (s *Sentinel) Start() {
+	s.mu.Lock()
+	s.addInstanceLocked(s.masterAddr)
+	s.event(fmt.Sprintf("+monitor master %s %s quorum %d", s.cfg.MasterName, s.masterAddr, s.cfg.Quorum))
+	for _, p := range s.peers {
+		s.wg.Add(1)
+		go s.talkToPeer(p)
+	}
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// MasterAddr 返回当前认定的主库地址。
+func (s *Sentinel) MasterAddr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.masterAddr
+}
+
+func (s *Sentinel) addInstanceLocked(addr string) *instance {
+	if inst, ok := s.instances[addr]; ok {
+		return inst
+	}
+	// 从发现时开始计算下线时间，避免刚加入的实例立即被判为 SDOWN
+	inst := &instance{addr: addr, stop: make(chan struct{}), lastPingOK: time.Now()}
+	s.instances[addr] = inst
+	s.wg.Add(1)
+	go s.monitor(inst)
+	return inst
+}
+
+func (s *Sentinel) event(msg string) {
+	log.Printf("[SENTINEL] %s", msg)
+}
+
+func (s *Sentinel) loop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(tickPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			s.tick()
+		}
+	}
+}
+
+// tick 更新主库的 SDOWN / ODOWN 状态，推进故障转移并修正配置不一致的实例。
+func (s *Sentinel) tick() {
+	now := time.Now()
+	s.mu.Lock()
+	master := s.instances[s.masterAddr]
+	sdown := now.Sub(master.lastPingOK) > s.cfg.DownAfter
+	if sdown != s.sdown {
+		if sdown {
+			s.event("+sdown master " + s.masterAddr)
+		} else {
+			s.event("-sdown master " + s.masterAddr)
+		}
+		s.sdown = sdown
+	}
+	odown := sdown && s.downVotesLocked(now)+1 >= s.cfg.Quorum
+	if odown != s.odown {
+		if odown {
+			s.event(fmt.Sprintf("+odown master %s #quorum %d/%d", s.masterAddr, s.downVotesLocked(now)+1, s.cfg.Quorum))
+			// 随机推迟一点再发起选举，降低多个 sentinel 同时拉票导致平票的概率
+			if s.failover.nextAttempt.Before(now) {
+				s.failover.nextAttempt = now.Add(time.Duration(mathrand.Int63n(int64(10 * s.cfg.PingPeriod))))
+			}
+		} else {
+			s.event("-odown master " + s.masterAddr)
+		}
+		s.odown = odown
+	}
+	actions := s.failoverStepLocked(now)
+	actions = append(actions, s.fixInstancesLocked(now)...)
+	s.mu.Unlock()
+
+	for _, act := range actions {
+		s.wg.Add(1)
+		go func(act func()) {
+			defer s.wg.Done()
+			act()
+		}(act)
+	}
+}
+
+// downVotesLocked 返回最近认为主库下线的其它 sentinel 数量。
+func (s *Sentinel) downVotesLocked(now time.Time) int {
+	n := 0
+	for _, p := range s.peers {
+		if p.masterDown && now.Sub(p.downReplyTime) <= 5*s.cfg.PingPeriod {
+			n++
+		}
+	}
+	return n
+}
+
+// fixInstancesLocked 让配置与当前主库不一致的实例重新跟随主库：
+// 旧主库恢复后仍以 master 身份运行，或副本仍指向别的主库。故障转移期间和主库不可用时不做修正。
+func (s *Sentinel) fixInstancesLocked(now time.Time) []func() {
+	if s.failover.state != failoverNone || s.sdown {
+		return nil
+	}
+	host, port, _ := net.SplitHostPort(s.masterAddr)
+	var actions []func()
+	for addr, inst := range s.instances {
+		if addr == s.masterAddr || inst.role == "" {
+			continue
+		}
+		if now.Sub(inst.lastPingOK) > s.cfg.DownAfter || now.Sub(inst.lastReconf) < 4*s.cfg.InfoPeriod {
+			continue
+		}
+		switch {
+		case inst.role == "master":
+			s.event("+convert-to-slave " + addr)
+		case inst.role == "slave" && inst.masterAddr != s.masterAddr:
+			s.event(fmt.Sprintf("+fix-slave-config %s (following %s)", addr, inst.masterAddr))
+		default:
+			continue
+		}
+		inst.lastReconf = now
+		addr := addr
+		actions = append(actions, func() {
+			if _, err := sendCommand(addr, commandTimeout, "REPLICAOF", host, port); err != nil {
+				log.Printf("[SENTINEL] reconfigure %s failed: %v", addr, err)
+			}
+		})
+	}
+	return actions
+}
+
+// talkToPeer 与另一个 sentinel 通信：主库 SDOWN 时询问其判断（故障转移中同时拉票），并定期发送 hello。
+func (s *Sentinel) talkToPeer(p *peer) {
+	defer s.wg.Done()
+	l := &link{addr: p.addr}
+	defer l.close()
+	ticker := time.NewTicker(s.cfg.PingPeriod)
+	defer ticker.Stop()
+	var lastHello time.Time
+	for {
+		s.mu.Lock()
+		ask := s.sdown
+		epoch, runID := s.currentEpoch, "*"
+		if s.failover.state == failoverWaitStart {
+			epoch, runID = s.failover.epoch, s.runID
+		}
+		host, port, _ := net.SplitHostPort(s.masterAddr)
+		s.mu.Unlock()
+
+		if ask {
+			reply, err := l.do(s.cfg.PingPeriod, "SENTINEL", "is-master-down-by-addr",
+				host, port, strconv.FormatInt(epoch, 10), runID)
+			s.recordPeerReply(p, reply, err)
+		}
+		if time.Since(lastHello) >= s.cfg.HelloPeriod {
+			lastHello = time.Now()
+			_, _ = l.do(s.cfg.PingPeriod, "SENTINEL", "HELLO", s.helloMessage())
+		}
+
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordPeerReply 记录 is-master-down-by-addr 的应答 [down, leader, leader_epoch]。
+func (s *Sentinel) recordPeerReply(p *peer, reply _interface.Reply, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	arr, ok := reply.(*resp.ArrayReply)
+	if err != nil || !ok || len(arr.Args) != 3 {
+		p.masterDown = false
+		return
+	}
+	p.masterDown = string(arr.Args[0]) == "1"
+	p.downReplyTime = time.Now()
+	if leader := string(arr.Args[1]); leader != "*" {
+		p.leader = leader
+		p.leaderEpoch, _ = strconv.ParseInt(string(arr.Args[2]), 10, 64)
+	}
+}
+
+// helloMessage 生成与 Redis hello 频道相同格式的消息：
+// sentinel_ip,sentinel_port,sentinel_runid,current_epoch,master_name,master_ip,master_port,master_config_epoch
+func (s *Sentinel) helloMessage() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.helloMessageLocked()
+}
+
+func (s *Sentinel) helloMessageLocked() string {
+	host, port, _ := net.SplitHostPort(s.cfg.Address)
+	masterHost, masterPort, _ := net.SplitHostPort(s.masterAddr)
+	return strings.Join([]string{
+		host, port, s.runID, strconv.FormatInt(s.currentEpoch, 10),
+		s.cfg.MasterName, masterHost, masterPort, strconv.FormatInt(s.configEpoch, 10),
+	}, ",")
+}
+
+// processHello 处理其它 sentinel 的 hello：更新纪元，配置纪元更新时切换到其宣布的主库。
+func (s *Sentinel) processHello(msg string) error {
+	fields := strings.Split(msg, ",")
+	if len(fields) != 8 {
+		return fmt.Errorf("invalid hello message %q", msg)
+	}
+	currentEpoch, err1 := strconv.ParseInt(fields[3], 10, 64)
+	configEpoch, err2 := strconv.ParseInt(fields[7], 10, 64)
+	if err1 != nil || err2 != nil {
+		return fmt.Errorf("invalid hello message %q", msg)
+	}
+	peerAddr := net.JoinHostPort(fields[0], fields[1])
+	masterAddr := net.JoinHostPort(fields[5], fields[6])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.peers {
+		if p.addr == peerAddr {
+			p.runID = fields[2]
+		}
+	}
+	if currentEpoch > s.currentEpoch {
+		s.currentEpoch = currentEpoch
+		s.event(fmt.Sprintf("+new-epoch %d", currentEpoch))
+	}
+	if fields[4] == s.cfg.MasterName && configEpoch > s.configEpoch && masterAddr != s.masterAddr {
+		s.event(fmt.Sprintf("+config-update-from sentinel %s", peerAddr))
+		s.switchMasterLocked(masterAddr, configEpoch)
+	}
+	return nil
+}
+
+// switchMasterLocked 把 newAddr 作为主库，旧主库保留在实例表中，恢复后会被转为副本。
+func (s *Sentinel) switchMasterLocked(newAddr string, configEpoch int64) {
+	s.event(fmt.Sprintf("+switch-master %s %s %s", s.cfg.MasterName, s.masterAddr, newAddr))
+	s.addInstanceLocked(newAddr)
+	s.masterAddr = newAddr
+	s.configEpoch = configEpoch
+	s.sdown, s.odown = false, false
+	s.failover = failoverInfo{}
+	for _, p := range s.peers {
+		p.masterDown = false
+	}
+}
+
+// Close 停止所有后台协程并关闭客户端连接，实现 tcp.Handler。
+func (s *Sentinel) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stopChan)
+		s.activeConn.Range(func(key, _ interface{}) bool {
+			_ = key.(net.Conn).Close()
+			return true
+		})
+		s.wg.Wait()
+	})
+	return nil
+}
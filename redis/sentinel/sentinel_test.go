@@ -0,0 +1,234 @@
+package sentinel
+
+import (
+	"MiddlewareSelf/redis/database"
+	"MiddlewareSelf/redis/replication"
+	"MiddlewareSelf/redis/resp"
+	"MiddlewareSelf/tcp"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redisServer 是在回环地址随机端口上运行的一个 Redis 实例，可以中途停止以模拟主库宕机。
+type redisServer struct {
+	addr      string
+	closeChan chan struct{}
+	done      chan struct{}
+	stopped   bool
+}
+
+func startRedis(t *testing.T) *redisServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	db := database.MakeDbs()
+	handler := tcp.MakeRedisHandler(db)
+	handler.SetReplication(replication.NewServer(db, replication.Options{
+		Port:     listener.Addr().(*net.TCPAddr).Port,
+		ReadOnly: true,
+	}))
+	srv := &redisServer{addr: listener.Addr().String(), closeChan: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(srv.done)
+		tcp.ListenAndServe(listener, handler, srv.closeChan)
+	}()
+	t.Cleanup(srv.stop)
+	return srv
+}
+
+func (r *redisServer) stop() {
+	if !r.stopped {
+		r.stopped = true
+		close(r.closeChan)
+		<-r.done
+	}
+}
+
+// call 执行一条命令，返回 bulk / 简单字符串内容或数组元素以空格连接的结果。
+func call(t *testing.T, addr string, args ...string) string {
+	t.Helper()
+	reply, err := sendCommand(addr, 2*time.Second, args...)
+	if err != nil {
+		t.Fatalf("%s %v failed: %v", addr, args, err)
+	}
+	switch r := reply.(type) {
+	case *resp.BulkReply:
+		return string(r.Arg)
+	case *resp.SimpleReply:
+		return r.Status
+	case *resp.ArrayReply:
+		parts := make([]string, len(r.Args))
+		for i, arg := range r.Args {
+			parts[i] = string(arg)
+		}
+		return strings.Join(parts, " ")
+	default:
+		return string(reply.ToBytes())
+	}
+}
+
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func replicaOf(t *testing.T, replica, master string) {
+	host, port, _ := net.SplitHostPort(master)
+	call(t, replica, "REPLICAOF", host, port)
+}
+
+func infoField(t *testing.T, addr, field string) string {
+	info := parseReplicationInfo(call(t, addr, "INFO", "replication"))
+	switch field {
+	case "role":
+		return info.role
+	case "master":
+		if !info.masterLinkUp {
+			return ""
+		}
+		return info.masterAddr
+	}
+	return ""
+}
+
+// startSentinels 启动 n 个互相认识的 sentinel，使用较短的周期以便测试快速完成。
+func startSentinels(t *testing.T, n int, master string, quorum int) []*Sentinel {
+	t.Helper()
+	listeners := make([]net.Listener, n)
+	addrs := make([]string, n)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen failed: %v", err)
+		}
+		listeners[i], addrs[i] = l, l.Addr().String()
+	}
+	sentinels := make([]*Sentinel, n)
+	for i := range sentinels {
+		var known []string
+		for j, addr := range addrs {
+			if j != i {
+				known = append(known, addr)
+			}
+		}
+		s := New(Config{
+			Address:         addrs[i],
+			MasterName:      "mymaster",
+			MasterAddr:      master,
+			Quorum:          quorum,
+			DownAfter:       500 * time.Millisecond,
+			FailoverTimeout: 2 * time.Second,
+			KnownSentinels:  known,
+			PingPeriod:      100 * time.Millisecond,
+			InfoPeriod:      200 * time.Millisecond,
+			HelloPeriod:     200 * time.Millisecond,
+		})
+		s.Start()
+		closeChan, done := make(chan struct{}), make(chan struct{})
+		go func(l net.Listener) {
+			defer close(done)
+			tcp.ListenAndServe(l, s, closeChan)
+		}(listeners[i])
+		t.Cleanup(func() {
+			close(closeChan)
+			<-done
+		})
+		sentinels[i] = s
+	}
+	return sentinels
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig(strings.NewReader(`
+# sentinel.conf
+port 26380
+sentinel monitor mymaster 127.0.0.1 6379 2
+sentinel down-after-milliseconds mymaster 5000
+sentinel failover-timeout mymaster 60000
+sentinel known-sentinel mymaster 127.0.0.1 26381
+`))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if cfg.Address != ":26380" || cfg.MasterName != "mymaster" || cfg.MasterAddr != "127.0.0.1:6379" || cfg.Quorum != 2 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if cfg.DownAfter != 5*time.Second || cfg.FailoverTimeout != time.Minute || len(cfg.KnownSentinels) != 1 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if _, err := ParseConfig(strings.NewReader("sentinel down-after-milliseconds other 5000\n")); err == nil {
+		t.Fatal("expect error for unknown master")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	master, r1, r2 := startRedis(t), startRedis(t), startRedis(t)
+	replicaOf(t, r1.addr, master.addr)
+	replicaOf(t, r2.addr, master.addr)
+	call(t, master.addr, "SET", "k", "v1")
+	for _, r := range []*redisServer{r1, r2} {
+		addr := r.addr
+		waitFor(t, 5*time.Second, "replica "+addr+" in sync", func() bool {
+			return call(t, addr, "GET", "k") == "v1"
+		})
+	}
+
+	sentinels := startSentinels(t, 3, master.addr, 2)
+	masterHost, masterPort, _ := net.SplitHostPort(master.addr)
+	if got := call(t, sentinels[0].cfg.Address, "SENTINEL", "get-master-addr-by-name", "mymaster"); got != masterHost+" "+masterPort {
+		t.Fatalf("get-master-addr-by-name = %q", got)
+	}
+	// sentinel 从主库 INFO 中发现两个副本
+	waitFor(t, 5*time.Second, "replicas discovered", func() bool {
+		return call(t, sentinels[0].cfg.Address, "SENTINEL", "replicas", "mymaster") != ""
+	})
+
+	master.stop()
+
+	var newMaster string
+	waitFor(t, 15*time.Second, "sentinels agree on a new master", func() bool {
+		newMaster = sentinels[0].MasterAddr()
+		if newMaster == master.addr {
+			return false
+		}
+		for _, s := range sentinels[1:] {
+			if s.MasterAddr() != newMaster {
+				return false
+			}
+		}
+		return true
+	})
+	if newMaster != r1.addr && newMaster != r2.addr {
+		t.Fatalf("new master %s is not one of the replicas", newMaster)
+	}
+	other := r1.addr
+	if newMaster == r1.addr {
+		other = r2.addr
+	}
+	host, port, _ := net.SplitHostPort(newMaster)
+	if got := call(t, sentinels[1].cfg.Address, "SENTINEL", "get-master-addr-by-name", "mymaster"); got != host+" "+port {
+		t.Fatalf("get-master-addr-by-name = %q, want %s", got, newMaster)
+	}
+	if role := infoField(t, newMaster, "role"); role != "master" {
+		t.Fatalf("promoted instance role = %s", role)
+	}
+	waitFor(t, 5*time.Second, "other replica follows the new master", func() bool {
+		return infoField(t, other, "master") == newMaster
+	})
+
+	// 新主库可写，且写入同步到另一个副本
+	call(t, newMaster, "SET", "k", "v2")
+	waitFor(t, 5*time.Second, "write propagated from new master", func() bool {
+		return call(t, other, "GET", "k") == "v2"
+	})
+}
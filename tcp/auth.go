@@ -0,0 +1,66 @@
+package tcp
+
+import (
+	"MiddlewareSelf/redis/database"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"strings"
+)
+
+var (
+	errNoAuth    = database.MakeReplyError("NOAUTH Authentication required.")
+	errWrongPass = database.MakeReplyError("WRONGPASS invalid username-password pair or user is disabled.")
+	errNoPass    = errors.New("AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	errHelloAuth = database.MakeReplyError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+)
+
+// SetRequirePass 设置 requirepass：非空时新连接必须先通过 AUTH（或 HELLO AUTH）认证才能执行其它命令。
+func (h *RedisHandler) SetRequirePass(password string) {
+	if password == "" {
+		h.requirePass = nil
+		return
+	}
+	sum := sha256.Sum256([]byte(password))
+	h.requirePass = sum[:]
+}
+
+// authRequired 返回连接是否需要先认证；未认证时只允许 AUTH / HELLO / QUIT。
+func (h *RedisHandler) authRequired(client *RedisClient, cmd []byte) bool {
+	if client.authenticated || h.requirePass == nil {
+		return false
+	}
+	for _, name := range []string{"AUTH", "HELLO", "QUIT"} {
+		if strings.EqualFold(string(cmd), name) {
+			return false
+		}
+	}
+	return true
+}
+
+// execAuth 实现 AUTH [username] password，只有 default 用户。
+func (h *RedisHandler) execAuth(client *RedisClient, args [][]byte) error {
+	if len(args) != 2 && len(args) != 3 {
+		return errors.New("wrong number of arguments for 'auth' command")
+	}
+	if len(args) == 2 && h.requirePass == nil {
+		return errNoPass
+	}
+	return h.authenticate(client, args[1:len(args)-1], args[len(args)-1])
+}
+
+// authenticate 校验用户名与密码，成功后标记连接为已认证。
+// 密码比较前先取摘要，比较耗时与密码长度及内容无关。
+func (h *RedisHandler) authenticate(client *RedisClient, user [][]byte, password []byte) error {
+	if len(user) > 0 && string(user[0]) != "default" {
+		return errWrongPass
+	}
+	if h.requirePass != nil {
+		sum := sha256.Sum256(password)
+		if subtle.ConstantTimeCompare(sum[:], h.requirePass) != 1 {
+			return errWrongPass
+		}
+	}
+	client.authenticated = true
+	return nil
+}
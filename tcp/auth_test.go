@@ -0,0 +1,148 @@
+package tcp
+
+import (
+	"MiddlewareSelf/redis/client"
+	"MiddlewareSelf/redis/replication"
+	"MiddlewareSelf/redis/resp"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requirePass 为 serverOptions.setup，设置测试用的 requirepass。
+func requirePass(h *RedisHandler) {
+	h.SetRequirePass("s3cret")
+}
+
+func TestRequirePass(t *testing.T) {
+	for _, model := range ioModels {
+		t.Run(model, func(t *testing.T) {
+			srv, _ := startServerConfig(t, serverOptions{cfg: &Config{IOModel: model, EventLoops: 1}, setup: requirePass})
+			conn := dialTest(t, srv.addr)
+
+			for _, args := range [][]string{{"GET", "k"}, {"PING"}, {"SELECT", "1"}, {"REPLCONF", "listening-port", "1"}} {
+				if got := conn.do(args...); got != "-NOAUTH Authentication required." {
+					t.Fatalf("%v before AUTH = %q", args, got)
+				}
+			}
+			if got := conn.do("HELLO", "3"); !strings.HasPrefix(got, "-NOAUTH HELLO must be called with the client already authenticated") {
+				t.Fatalf("HELLO before AUTH = %q", got)
+			}
+			for _, args := range [][]string{{"AUTH", "wrong"}, {"AUTH", "default", "wrong"}, {"AUTH", "alice", "s3cret"}, {"HELLO", "2", "AUTH", "default", "wrong"}} {
+				if got := conn.do(args...); !strings.HasPrefix(got, "-WRONGPASS") {
+					t.Fatalf("%v = %q", args, got)
+				}
+			}
+			if got := conn.do("AUTH"); got != "-ERR wrong number of arguments for 'auth' command" {
+				t.Fatalf("AUTH without password = %q", got)
+			}
+			if got := conn.do("GET", "k"); got != "-NOAUTH Authentication required." {
+				t.Fatalf("GET after failed AUTH = %q", got)
+			}
+			if got := conn.do("AUTH", "s3cret"); got != "+OK" {
+				t.Fatalf("AUTH = %q", got)
+			}
+			if got := conn.do("SET", "k", "v"); got != "+OK" {
+				t.Fatalf("SET after AUTH = %q", got)
+			}
+
+			// HELLO AUTH 认证并切换协议
+			hello := dialTest(t, srv.addr)
+			if got := hello.do("HELLO", "3", "AUTH", "default", "s3cret"); got != "%7" {
+				t.Fatalf("HELLO 3 AUTH = %q", got)
+			}
+			other := dialTest(t, srv.addr)
+			if got := other.do("AUTH", "default", "s3cret"); got != "+OK" {
+				t.Fatalf("AUTH default = %q", got)
+			}
+			if got := other.do("GET", "k"); got != "v" {
+				t.Fatalf("GET = %q", got)
+			}
+
+			// QUIT 不需要认证，应答后关闭连接
+			quit := dialTest(t, srv.addr)
+			if got := quit.do("QUIT"); got != "+OK" {
+				t.Fatalf("QUIT = %q", got)
+			}
+			if _, err := quit.reader.ReadString('\n'); err == nil {
+				t.Fatal("connection should be closed after QUIT")
+			}
+		})
+	}
+}
+
+func TestAuthWithoutRequirePass(t *testing.T) {
+	srv := startTestServer(t)
+	conn := dialTest(t, srv.addr)
+	if got := conn.do("AUTH", "pw"); !strings.HasPrefix(got, "-ERR AUTH <password> called without any password configured") {
+		t.Fatalf("AUTH without requirepass = %q", got)
+	}
+	// default 用户无需密码，任意密码都能通过
+	if got := conn.do("AUTH", "default", "pw"); got != "+OK" {
+		t.Fatalf("AUTH default = %q", got)
+	}
+	if got := conn.do("QUIT"); got != "+OK" {
+		t.Fatalf("QUIT = %q", got)
+	}
+}
+
+func TestDialWithPassword(t *testing.T) {
+	srv, _ := startServerConfig(t, serverOptions{setup: requirePass})
+	ctx := context.Background()
+
+	_, err := client.DialPipelineWithOptions(srv.addr, client.DialOptions{Timeout: time.Second, Password: "wrong"})
+	var replyErr *client.ReplyError
+	if !errors.As(err, &replyErr) || !strings.HasPrefix(replyErr.Msg, "WRONGPASS") {
+		t.Fatalf("dial with a wrong password: %v", err)
+	}
+	cli, err := client.DialPipelineWithOptions(srv.addr, client.DialOptions{Timeout: time.Second, Password: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	ch, err := cli.ExecStream(ctx, []client.Command{client.NewCommand("PING")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := <-ch; res.Err != nil || string(res.Reply.ToBytes()) != "+PONG\r\n" {
+		t.Fatalf("PING = %v, %v", res.Reply, res.Err)
+	}
+
+	// 连接池每条新连接都先认证，RESP3 在认证之后协商
+	pool := client.NewPool(client.PoolOptions{Addr: srv.addr, Password: "s3cret", Protocol: resp.RESP3, MaxRetries: -1})
+	defer pool.Close()
+	if err := pool.Set(ctx, "k", "v", 0); err != nil {
+		t.Fatalf("SET through pool: %v", err)
+	}
+	bad := client.NewPool(client.PoolOptions{Addr: srv.addr, Password: "wrong", MaxRetries: 3})
+	defer bad.Close()
+	if err := bad.Ping(ctx); !errors.As(err, &replyErr) {
+		t.Fatalf("PING through pool with a wrong password: %v", err)
+	}
+}
+
+func TestReplicationMasterAuth(t *testing.T) {
+	master, _ := startServerConfig(t, serverOptions{setup: requirePass})
+	replica, _ := startServerConfig(t, serverOptions{repl: &replication.Options{ReadOnly: true, MasterAuth: "s3cret"}})
+	m := dialTest(t, master.addr)
+	m.do("AUTH", "s3cret")
+	r := dialTest(t, replica.addr)
+
+	m.do("SET", "k", "v")
+	r.do("REPLICAOF", "127.0.0.1", strconv.Itoa(master.port))
+	waitFor(t, "sync with masterauth", func() bool { return r.do("GET", "k") == "v" })
+
+	// 没有配置 masterauth 的副本无法完成握手
+	stranger := dialTest(t, startTestServer(t).addr)
+	stranger.do("REPLICAOF", "127.0.0.1", strconv.Itoa(master.port))
+	time.Sleep(300 * time.Millisecond)
+	if got := stranger.do("GET", "k"); got != "(nil)" {
+		t.Fatalf("replica without masterauth synced: GET = %q", got)
+	}
+	if got := infoField(m.do("INFO", "replication"), "connected_slaves"); got != "1" {
+		t.Fatalf("connected_slaves = %q", got)
+	}
+}
@@ -0,0 +1,266 @@
+package tcp
+
+import (
+	"MiddlewareSelf/redis/client"
+	"MiddlewareSelf/redis/cluster"
+	"MiddlewareSelf/redis/database"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clusterTestNode 是开启集群模式的测试实例，总线监听在随机端口上。
+type clusterTestNode struct {
+	*testServer
+	busPort int
+}
+
+func startClusterNode(t *testing.T) *clusterTestNode {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	busListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen bus failed: %v", err)
+	}
+	busPort := busListener.Addr().(*net.TCPAddr).Port
+	db := database.MakeDbs()
+	c, err := cluster.New(db, cluster.Options{
+		ConfigFile:  filepath.Join(t.TempDir(), "nodes.conf"),
+		AnnounceIP:  "127.0.0.1",
+		Port:        listener.Addr().(*net.TCPAddr).Port,
+		BusPort:     busPort,
+		NodeTimeout: 500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("enable cluster: %v", err)
+	}
+	c.ServeBus(busListener)
+	handler := MakeRedisHandler(db)
+	handler.SetCluster(c)
+	return &clusterTestNode{testServer: serveTest(t, listener, handler), busPort: busPort}
+}
+
+func TestClusterCommands(t *testing.T) {
+	srv := startClusterNode(t)
+	c := dialTest(t, srv.addr)
+
+	if got := c.do("SET", "foo", "bar"); got != "-CLUSTERDOWN Hash slot not served" {
+		t.Fatalf("SET before ADDSLOTS: %q", got)
+	}
+	if got := c.do("CLUSTER", "KEYSLOT", "foo"); got != ":12182" {
+		t.Fatalf("CLUSTER KEYSLOT foo: %q", got)
+	}
+	if got := c.do("CLUSTER", "ADDSLOTS", "12182"); got != "+OK" {
+		t.Fatalf("CLUSTER ADDSLOTS: %q", got)
+	}
+	if got := c.do("SET", "foo", "bar"); got != "+OK" {
+		t.Fatalf("SET after ADDSLOTS: %q", got)
+	}
+	if got := c.do("GET", "foo"); got != "bar" {
+		t.Fatalf("GET foo: %q", got)
+	}
+	if got := c.do("DEL", "foo", "bar"); got != "-CROSSSLOT Keys in request don't hash to the same slot" {
+		t.Fatalf("DEL across slots: %q", got)
+	}
+	if got := c.do("CLUSTER", "COUNTKEYSINSLOT", strconv.Itoa(cluster.KeySlot("foo"))); got != ":1" {
+		t.Fatalf("CLUSTER COUNTKEYSINSLOT: %q", got)
+	}
+	if got := c.do("SELECT", "1"); got != "-ERR SELECT is not allowed in cluster mode" {
+		t.Fatalf("SELECT 1: %q", got)
+	}
+	if got := c.do("CLUSTER", "SLOTS"); got != "*1" {
+		t.Fatalf("CLUSTER SLOTS header: %q", got)
+	}
+}
+
+func TestClusterGossipMigrationAndFailover(t *testing.T) {
+	nodes := []*clusterTestNode{startClusterNode(t), startClusterNode(t), startClusterNode(t)}
+	conns := make([]*testConn, len(nodes))
+	ids := make([]string, len(nodes))
+	for i, node := range nodes {
+		conns[i] = dialTest(t, node.addr)
+		ids[i] = conns[i].do("CLUSTER", "MYID")
+	}
+	a, b, c := conns[0], conns[1], conns[2]
+	// foo -> 12182 属于 A，bar -> 5061 属于 B
+	for i, slot := range []string{"12182", "5061", "0"} {
+		if got := conns[i].do("CLUSTER", "ADDSLOTS", slot); got != "+OK" {
+			t.Fatalf("ADDSLOTS %s: %q", slot, got)
+		}
+	}
+	// A 只 MEET B，C 只 MEET A：B 与 C 通过 gossip 互相认识
+	if got := a.do("CLUSTER", "MEET", "127.0.0.1", strconv.Itoa(nodes[1].port), strconv.Itoa(nodes[1].busPort)); got != "+OK" {
+		t.Fatalf("CLUSTER MEET: %q", got)
+	}
+	if got := c.do("CLUSTER", "MEET", "127.0.0.1", strconv.Itoa(nodes[0].port), strconv.Itoa(nodes[0].busPort)); got != "+OK" {
+		t.Fatalf("CLUSTER MEET: %q", got)
+	}
+	for i, conn := range conns {
+		waitFor(t, fmt.Sprintf("node %d to see the whole cluster", i), func() bool {
+			return infoField(conn.do("CLUSTER", "INFO"), "cluster_size") == "3"
+		})
+	}
+	movedToA := "-MOVED 12182 " + nodes[0].addr
+	if got := b.do("GET", "foo"); got != movedToA {
+		t.Fatalf("GET foo on B: %q", got)
+	}
+
+	// 把 12182 从 A 迁到 B
+	if got := a.do("SET", "foo", "v1"); got != "+OK" {
+		t.Fatalf("SET foo: %q", got)
+	}
+	if got := b.do("CLUSTER", "SETSLOT", "12182", "IMPORTING", ids[0]); got != "+OK" {
+		t.Fatalf("SETSLOT IMPORTING: %q", got)
+	}
+	if got := a.do("CLUSTER", "SETSLOT", "12182", "MIGRATING", ids[1]); got != "+OK" {
+		t.Fatalf("SETSLOT MIGRATING: %q", got)
+	}
+	if got := a.do("GET", "foo"); got != "v1" {
+		t.Fatalf("GET foo before MIGRATE: %q", got)
+	}
+	if got := a.do("MIGRATE", "127.0.0.1", strconv.Itoa(nodes[1].port), "foo", "0", "1000"); got != "+OK" {
+		t.Fatalf("MIGRATE: %q", got)
+	}
+	askB := "-ASK 12182 " + nodes[1].addr
+	if got := a.do("GET", "foo"); got != askB {
+		t.Fatalf("GET foo after MIGRATE: %q", got)
+	}
+	if got := b.do("GET", "foo"); got != movedToA {
+		t.Fatalf("GET foo on B without ASKING: %q", got)
+	}
+	if got := b.do("ASKING"); got != "+OK" {
+		t.Fatalf("ASKING: %q", got)
+	}
+	if got := b.do("GET", "foo"); got != "v1" {
+		t.Fatalf("GET foo on B with ASKING: %q", got)
+	}
+	if got := b.do("GET", "foo"); got != movedToA {
+		t.Fatalf("ASKING should only apply to the next command: %q", got)
+	}
+	for i, conn := range []*testConn{b, a} {
+		if got := conn.do("CLUSTER", "SETSLOT", "12182", "NODE", ids[1]); got != "+OK" {
+			t.Fatalf("SETSLOT NODE on node %d: %q", i, got)
+		}
+	}
+	movedToB := "-MOVED 12182 " + nodes[1].addr
+	if got := a.do("GET", "foo"); got != movedToB {
+		t.Fatalf("GET foo on A after migration: %q", got)
+	}
+	if got := b.do("GET", "foo"); got != "v1" {
+		t.Fatalf("GET foo on B after migration: %q", got)
+	}
+	waitFor(t, "C to learn the new owner of 12182", func() bool {
+		return c.do("GET", "foo") == movedToB
+	})
+
+	// 停掉 C：A、B 都判定其 PFAIL 后达到多数，标记 FAIL
+	nodes[2].stop()
+	for _, conn := range []*testConn{a, b} {
+		waitFor(t, "C to be marked as failing", func() bool {
+			for _, line := range strings.Split(conn.do("CLUSTER", "NODES"), "\n") {
+				if strings.HasPrefix(line, ids[2]) {
+					return strings.Contains(line, ",fail ")
+				}
+			}
+			return false
+		})
+		if got := infoField(conn.do("CLUSTER", "INFO"), "cluster_slots_fail"); got != "1" {
+			t.Fatalf("cluster_slots_fail = %q", got)
+		}
+	}
+}
+
+// slotRange 返回 [from, to] 区间内所有槽号的字符串形式，用于 CLUSTER ADDSLOTS。
+func slotRange(from, to int) []string {
+	out := make([]string, 0, to-from+1)
+	for slot := from; slot <= to; slot++ {
+		out = append(out, strconv.Itoa(slot))
+	}
+	return out
+}
+
+func TestClusterClientRoutingAndRedirects(t *testing.T) {
+	nodes := []*clusterTestNode{startClusterNode(t), startClusterNode(t)}
+	a, b := dialTest(t, nodes[0].addr), dialTest(t, nodes[1].addr)
+	idA, idB := a.do("CLUSTER", "MYID"), b.do("CLUSTER", "MYID")
+	// bar -> 5061 属于 A，foo -> 12182 属于 B
+	if got := a.do(append([]string{"CLUSTER", "ADDSLOTS"}, slotRange(0, 8191)...)...); got != "+OK" {
+		t.Fatalf("ADDSLOTS on A: %q", got)
+	}
+	if got := b.do(append([]string{"CLUSTER", "ADDSLOTS"}, slotRange(8192, 16383)...)...); got != "+OK" {
+		t.Fatalf("ADDSLOTS on B: %q", got)
+	}
+	a.do("CLUSTER", "MEET", "127.0.0.1", strconv.Itoa(nodes[1].port), strconv.Itoa(nodes[1].busPort))
+	for _, conn := range []*testConn{a, b} {
+		waitFor(t, "both nodes to know the whole slot map", func() bool {
+			return infoField(conn.do("CLUSTER", "INFO"), "cluster_state") == "ok"
+		})
+	}
+
+	cli, err := client.NewClusterClient(client.ClusterOptions{Addrs: []string{nodes[0].addr}})
+	if err != nil {
+		t.Fatalf("new cluster client: %v", err)
+	}
+	defer cli.Close()
+	exec := func(cmds ...client.Command) []string {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ch, err := cli.ExecStream(ctx, cmds)
+		if err != nil {
+			t.Fatalf("ExecStream: %v", err)
+		}
+		var out []string
+		for res := range ch {
+			if res.ResponseIndex != len(out)+1 {
+				t.Fatalf("ResponseIndex = %d, want %d", res.ResponseIndex, len(out)+1)
+			}
+			if res.Err != nil {
+				t.Fatalf("command #%d: %v", res.ResponseIndex, res.Err)
+			}
+			out = append(out, strings.TrimRight(string(res.Reply.ToBytes()), "\r\n"))
+		}
+		return out
+	}
+
+	// 同一个 Pipeline 中的命令分别发往两个节点，结果仍按原顺序返回
+	got := exec(
+		client.NewCommand("SET", "foo", "1"),
+		client.NewCommand("SET", "bar", "2"),
+		client.NewCommand("GET", "foo"),
+		client.NewCommand("PING"),
+		client.NewCommand("GET", "bar"),
+	)
+	want := []string{"+OK", "+OK", "$1\r\n1", "+PONG", "$1\r\n2"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("pipeline replies = %q, want %q", got, want)
+	}
+	if v := b.do("GET", "foo"); v != "1" {
+		t.Fatalf("foo should be stored on B, got %q", v)
+	}
+
+	// 迁移 12182 期间，客户端先被 B 以 ASK 重定向到 A
+	a.do("CLUSTER", "SETSLOT", "12182", "IMPORTING", idB)
+	b.do("CLUSTER", "SETSLOT", "12182", "MIGRATING", idA)
+	if r := b.do("MIGRATE", "127.0.0.1", strconv.Itoa(nodes[0].port), "foo", "0", "1000"); r != "+OK" {
+		t.Fatalf("MIGRATE: %q", r)
+	}
+	if got := exec(client.NewCommand("GET", "foo")); got[0] != "$1\r\n1" {
+		t.Fatalf("GET foo during migration: %q", got)
+	}
+
+	// 迁移完成后 B 返回 MOVED，客户端更新槽表
+	a.do("CLUSTER", "SETSLOT", "12182", "NODE", idA)
+	b.do("CLUSTER", "SETSLOT", "12182", "NODE", idA)
+	if got := exec(client.NewCommand("GET", "foo"), client.NewCommand("GET", "bar")); got[0] != "$1\r\n1" || got[1] != "$1\r\n2" {
+		t.Fatalf("GET after migration: %q", got)
+	}
+}
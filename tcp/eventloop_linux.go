@@ -0,0 +1,591 @@
+//go:build linux
+
+package tcp
+
+import (
+	"MiddlewareSelf/redis/parser"
+	"MiddlewareSelf/redis/resp"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// eventLoopReadSize 为每个事件循环共用的读缓冲大小，每次可读事件最多读取这么多
+	eventLoopReadSize = 64 * 1024
+	// maxRetainInput 以上的未解析输入缓冲在消费完后释放
+	maxRetainInput = 64 * 1024
+	maxEpollEvents = 256
+)
+
+var errEventConnRead = errors.New("read on event loop connection")
+
+// ListenAndServeEventLoop 以事件循环模式服务：cfg.EventLoops 个 goroutine 各自持有一个 epoll 实例，
+// 连接的 socket 设为非阻塞后交给其中一个，按可读 / 可写事件读取、解析、执行并写出应答，
+// 空闲连接不再占用 goroutine 与读缓冲。EventLoops <= 0 时使用 CPU 个数。
+// 执行 WAIT、PSYNC 等会阻塞或接管连接的命令时，该连接脱离事件循环，转为 goroutine 模式；
+// TLS 连接需要在用户态加解密，始终按 goroutine 模式处理。
+// 连接数上限、空闲超时与 keepalive 与 Serve 相同。
+func ListenAndServeEventLoop(listeners []net.Listener, handler *RedisHandler, cfg *Config, closeChan <-chan struct{}) error {
+	loops := cfg.EventLoops
+	if loops <= 0 {
+		loops = runtime.NumCPU()
+	}
+	srv := &eventServer{handler: handler, cfg: cfg, stats: handler.Stats()}
+	srv.stats.MaxClients.Store(cfg.MaxConnect)
+	for i := 0; i < loops; i++ {
+		l, err := newEventLoop(srv)
+		if err != nil {
+			for _, l := range srv.loops {
+				l.closeFds()
+			}
+			closeAll(listeners)
+			return err
+		}
+		srv.loops = append(srv.loops, l)
+	}
+	for _, l := range srv.loops {
+		srv.wait.Add(1)
+		go func() {
+			defer srv.wait.Done()
+			l.run()
+		}()
+	}
+
+	acceptAll(listeners, handler, cfg, srv.stats, closeChan, srv.serve)
+	_ = handler.Close()
+	srv.stop()
+	srv.wait.Wait()
+	return nil
+}
+
+type eventServer struct {
+	handler *RedisHandler
+	cfg     *Config
+	stats   *Stats
+	loops   []*eventLoop
+	// next 为下一个连接分配到的事件循环
+	next atomic.Uint32
+	// wait 等待所有事件循环与脱离事件循环的连接结束
+	wait sync.WaitGroup
+}
+
+// serve 把 admit 后的连接交给一个事件循环，不能直接读写 fd 的连接（TLS）交给 goroutine。
+func (s *eventServer) serve(conn net.Conn) {
+	if s.handler.closing.Get() {
+		_ = conn.Close()
+		s.stats.Connected.Add(-1)
+		return
+	}
+	if _, ok := conn.(syscall.Conn); !ok {
+		s.wait.Add(1)
+		go func() {
+			defer s.wait.Done()
+			defer s.stats.Connected.Add(-1)
+			s.handler.Handle(context.Background(), withIdleTimeout(conn, s.cfg, s.stats))
+		}()
+		return
+	}
+	l := s.loops[s.next.Add(1)%uint32(len(s.loops))]
+	if err := l.add(conn); err != nil {
+		log.Printf("[EVENTLOOP] add connection %s: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		s.stats.Connected.Add(-1)
+	}
+}
+
+func (s *eventServer) stop() {
+	for _, l := range s.loops {
+		l.wake()
+	}
+}
+
+// eventLoop 为一个事件循环，它管理的连接只在 run 所在的 goroutine 中读写。
+type eventLoop struct {
+	srv  *eventServer
+	h    *RedisHandler
+	epfd int
+	// wakeR / wakeW 为唤醒 run 退出用的管道
+	wakeR, wakeW int
+
+	mu    sync.Mutex
+	conns map[int]*eventConn
+	// done 为 run 已退出、fd 已关闭
+	done bool
+
+	// buf 为所有连接共用的读缓冲；args 为复用的参数切片
+	buf        []byte
+	args       [][]byte
+	maxBulkLen int64
+}
+
+func newEventLoop(srv *eventServer) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+	var pipe [2]int
+	if err := syscall.Pipe2(pipe[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		_ = syscall.Close(epfd)
+		return nil, os.NewSyscallError("pipe2", err)
+	}
+	l := &eventLoop{
+		srv:        srv,
+		h:          srv.handler,
+		epfd:       epfd,
+		wakeR:      pipe[0],
+		wakeW:      pipe[1],
+		conns:      make(map[int]*eventConn),
+		buf:        make([]byte, eventLoopReadSize),
+		maxBulkLen: srv.handler.protoMaxBulkLen,
+	}
+	if l.maxBulkLen <= 0 {
+		l.maxBulkLen = parser.DefaultMaxBulkLen
+	}
+	if err := l.ctl(syscall.EPOLL_CTL_ADD, l.wakeR, syscall.EPOLLIN); err != nil {
+		l.closeFds()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *eventLoop) ctl(op, fd int, events uint32) error {
+	ev := syscall.EpollEvent{Events: events, Fd: int32(fd)}
+	return os.NewSyscallError("epoll_ctl", syscall.EpollCtl(l.epfd, op, fd, &ev))
+}
+
+func (l *eventLoop) wake() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.done {
+		_, _ = syscall.Write(l.wakeW, []byte{1})
+	}
+}
+
+func (l *eventLoop) closeFds() {
+	_ = syscall.Close(l.epfd)
+	_ = syscall.Close(l.wakeR)
+	_ = syscall.Close(l.wakeW)
+}
+
+// add 接管 Accept 得到的连接：复制出 fd 后关闭原连接（使其脱离 Go 的 netpoller），
+// 之后只由本事件循环读写。
+func (l *eventLoop) add(conn net.Conn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errors.New("connection does not expose its file descriptor")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	fd := -1
+	var dupErr error
+	if err := raw.Control(func(s uintptr) {
+		r, _, errno := syscall.Syscall(syscall.SYS_FCNTL, s, syscall.F_DUPFD_CLOEXEC, 0)
+		if errno != 0 {
+			dupErr = os.NewSyscallError("fcntl", errno)
+			return
+		}
+		fd = int(r)
+	}); err != nil {
+		return err
+	}
+	if dupErr != nil {
+		return dupErr
+	}
+	c := &eventConn{loop: l, fd: fd, local: conn.LocalAddr(), remote: conn.RemoteAddr(), lastActive: time.Now()}
+	_ = conn.Close()
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return os.NewSyscallError("setnonblock", err)
+	}
+
+	c.client = l.h.openClient(c)
+	l.mu.Lock()
+	l.conns[fd] = c
+	l.mu.Unlock()
+	if err := l.ctl(syscall.EPOLL_CTL_ADD, fd, syscall.EPOLLIN); err != nil {
+		l.mu.Lock()
+		delete(l.conns, fd)
+		l.mu.Unlock()
+		l.h.closeClient(c.client)
+		_ = syscall.Close(fd)
+		return err
+	}
+	return nil
+}
+
+func (l *eventLoop) run() {
+	defer func() {
+		l.mu.Lock()
+		l.done = true
+		l.closeFds()
+		l.mu.Unlock()
+	}()
+	events := make([]syscall.EpollEvent, maxEpollEvents)
+	// 配置了空闲超时时 epoll_wait 定期返回，检查空闲连接
+	msec, interval := -1, idleCheckInterval(l.srv.cfg.Timeout)
+	if interval > 0 {
+		msec = int(interval / time.Millisecond)
+	}
+	lastCheck := time.Now()
+	for {
+		if interval > 0 && time.Since(lastCheck) >= interval {
+			l.closeIdle()
+			lastCheck = time.Now()
+		}
+		n, err := syscall.EpollWait(l.epfd, events, msec)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			log.Printf("[EVENTLOOP] epoll_wait: %v", err)
+			l.closeAll()
+			return
+		}
+		for i := 0; i < n; i++ {
+			fd := int(events[i].Fd)
+			if fd == l.wakeR {
+				l.closeAll()
+				return
+			}
+			l.mu.Lock()
+			c := l.conns[fd]
+			l.mu.Unlock()
+			if c == nil {
+				continue
+			}
+			if events[i].Events&syscall.EPOLLOUT != 0 && !c.writePending() {
+				l.closeConn(c)
+				continue
+			}
+			if events[i].Events&(syscall.EPOLLIN|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+				l.read(c)
+			}
+		}
+	}
+}
+
+// idleCheckInterval 为检查空闲连接的间隔，timeout <= 0 时返回 0 表示不检查。
+func idleCheckInterval(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return 0
+	}
+	return min(max(timeout/4, 10*time.Millisecond), time.Second)
+}
+
+// closeIdle 关闭超过空闲超时没有收到数据的连接。
+func (l *eventLoop) closeIdle() {
+	deadline := time.Now().Add(-l.srv.cfg.Timeout)
+	l.mu.Lock()
+	var idle []*eventConn
+	for _, c := range l.conns {
+		if c.lastActive.Before(deadline) {
+			idle = append(idle, c)
+		}
+	}
+	l.mu.Unlock()
+	for _, c := range idle {
+		l.srv.stats.TimedOut.Add(1)
+		l.closeConn(c)
+	}
+}
+
+func (l *eventLoop) closeAll() {
+	l.mu.Lock()
+	conns := make([]*eventConn, 0, len(l.conns))
+	for _, c := range l.conns {
+		conns = append(conns, c)
+	}
+	l.mu.Unlock()
+	for _, c := range conns {
+		l.closeConn(c)
+	}
+}
+
+// read 处理可读事件：读取一次数据，与之前未解析完的输入拼接后执行其中所有完整的命令，再写出应答。
+// epoll 为水平触发，一次没读完的数据会在下一轮继续通知，连接之间公平轮转。
+func (l *eventLoop) read(c *eventConn) {
+	n, err := syscall.Read(c.fd, l.buf)
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		return
+	}
+	if err != nil || n <= 0 {
+		l.closeConn(c)
+		return
+	}
+	c.lastActive = time.Now()
+	data := l.buf[:n]
+	if len(c.in) > 0 {
+		c.in = append(c.in, data...)
+		data = c.in
+	}
+
+	consumed, state := l.process(c, data)
+	switch state {
+	case connDetached:
+		return
+	case connClosing:
+		l.closeConn(c)
+		return
+	}
+	// 未解析完的命令留到下一次可读事件，读缓冲是共用的，剩余部分要拷贝出来
+	rest := data[consumed:]
+	switch {
+	case len(rest) == 0:
+		c.in = c.in[:0]
+		if cap(c.in) > maxRetainInput {
+			c.in = nil
+		}
+	case len(c.in) > 0:
+		c.in = c.in[:copy(c.in, rest)]
+	default:
+		c.in = append(c.in[:0], rest...)
+	}
+	if err := l.h.flush(c.client); err != nil {
+		l.closeConn(c)
+	}
+}
+
+const (
+	connOpen = iota
+	connClosing
+	connDetached
+)
+
+// process 依次执行 data 中所有完整的命令，返回消耗的字节数与连接之后的状态。
+func (l *eventLoop) process(c *eventConn, data []byte) (int, int) {
+	off := 0
+	for off < len(data) {
+		args, n, err := parser.ParseCommand(data[off:], l.args, l.maxBulkLen)
+		if err != nil {
+			// 协议错误后无法确定下一条命令的边界，应答后关闭连接
+			_ = l.h.writeReply(c.client, resp.MakeErrorReply(err.Error()))
+			_ = l.h.flush(c.client)
+			return off, connClosing
+		}
+		if n == 0 {
+			break
+		}
+		l.args = args[:0]
+		off += n
+		if len(args) == 0 {
+			continue
+		}
+		if l.h.blocking(args) {
+			l.detach(c, args, data[off:])
+			return off, connDetached
+		}
+		if !l.h.exec(c.client, args) || c.client.out.err != nil {
+			return off, connClosing
+		}
+	}
+	return off, connOpen
+}
+
+// detach 让连接脱离事件循环：从 epoll 中移除，fd 转为 net.Conn 后由新的 goroutine 执行 args
+// 以及剩余的输入，之后与 goroutine 模式的连接一样处理。
+func (l *eventLoop) detach(c *eventConn, args [][]byte, rest []byte) {
+	// args 与 rest 指向共用的读缓冲，需要拷贝
+	cmd := make([][]byte, len(args))
+	for i, arg := range args {
+		cmd[i] = bytes.Clone(arg)
+	}
+	leftover := bytes.Clone(rest)
+
+	_ = l.ctl(syscall.EPOLL_CTL_DEL, c.fd, 0)
+	l.mu.Lock()
+	delete(l.conns, c.fd)
+	l.mu.Unlock()
+
+	f := os.NewFile(uintptr(c.fd), "")
+	conn, err := net.FileConn(f)
+	if err == nil {
+		conn = withIdleTimeout(conn, l.srv.cfg, l.srv.stats)
+	}
+	c.mu.Lock()
+	// FileConn 复制了 fd，原 fd 随 f 关闭
+	_ = f.Close()
+	c.fdClosed = true
+	c.detached = conn
+	c.mu.Unlock()
+	if err != nil {
+		log.Printf("[EVENTLOOP] detach %s: %v", c.remote, err)
+		l.srv.stats.Connected.Add(-1)
+		l.h.closeClient(c.client)
+		return
+	}
+	pending := c.pending
+	c.pending = nil
+
+	l.srv.wait.Add(1)
+	go func() {
+		defer l.srv.wait.Done()
+		defer l.srv.stats.Connected.Add(-1)
+		defer l.h.closeClient(c.client)
+		if len(pending) > 0 {
+			_ = conn.SetWriteDeadline(time.Now().Add(replyWriteTimeout))
+			if _, err := conn.Write(pending); err != nil {
+				return
+			}
+		}
+		if !l.h.exec(c.client, cmd) {
+			return
+		}
+		rd := io.MultiReader(bytes.NewReader(leftover), conn)
+		l.h.serve(context.Background(), c.client, l.h.newReader(flushReader{h: l.h, client: c.client, r: rd}))
+	}()
+}
+
+// closeConn 写出剩余应答后关闭连接并释放 fd。
+func (l *eventLoop) closeConn(c *eventConn) {
+	_ = l.ctl(syscall.EPOLL_CTL_DEL, c.fd, 0)
+	l.mu.Lock()
+	delete(l.conns, c.fd)
+	l.mu.Unlock()
+
+	l.h.closeClient(c.client)
+	c.mu.Lock()
+	c.fdClosed = true
+	_ = syscall.Close(c.fd)
+	c.mu.Unlock()
+	l.srv.stats.Connected.Add(-1)
+}
+
+// eventConn 是事件循环管理的连接，fd 为非阻塞 socket，只由所属事件循环读写与关闭。
+// 它实现 net.Conn 供 RedisHandler 使用：Write 尽量立即写出，写不完的部分在 fd 可写时由事件循环写出；
+// 脱离事件循环后读写都交给 detached。
+type eventConn struct {
+	loop          *eventLoop
+	fd            int
+	local, remote net.Addr
+	client        *RedisClient
+
+	// in 为未解析完的输入，pending 为未写出的输出
+	in      []byte
+	pending []byte
+	// lastActive 为最近一次收到数据的时间，用于空闲超时
+	lastActive time.Time
+
+	// mu 保护 fdClosed 与 detached，使其它 goroutine 中的 Close 不会操作已关闭（可能已被复用）的 fd
+	mu       sync.Mutex
+	fdClosed bool
+	detached net.Conn
+}
+
+func (c *eventConn) Read(p []byte) (int, error) {
+	if c.detached != nil {
+		return c.detached.Read(p)
+	}
+	return 0, errEventConnRead
+}
+
+func (c *eventConn) Write(p []byte) (int, error) {
+	if c.detached != nil {
+		return c.detached.Write(p)
+	}
+	total := len(p)
+	if len(c.pending) == 0 {
+		n, err := writeFd(c.fd, p)
+		if err != nil {
+			return 0, err
+		}
+		p = p[n:]
+		if len(p) == 0 {
+			return total, nil
+		}
+		if err := c.loop.ctl(syscall.EPOLL_CTL_MOD, c.fd, syscall.EPOLLIN|syscall.EPOLLOUT); err != nil {
+			return 0, err
+		}
+	}
+	c.pending = append(c.pending, p...)
+	return total, nil
+}
+
+// writePending 在 fd 可写时写出积压的输出，全部写完后不再关注可写事件。
+func (c *eventConn) writePending() bool {
+	n, err := writeFd(c.fd, c.pending)
+	if err != nil {
+		return false
+	}
+	c.pending = c.pending[:copy(c.pending, c.pending[n:])]
+	if len(c.pending) > 0 {
+		return true
+	}
+	if cap(c.pending) > maxRetainOutput {
+		c.pending = nil
+	}
+	return c.loop.ctl(syscall.EPOLL_CTL_MOD, c.fd, syscall.EPOLLIN) == nil
+}
+
+// writeFd 非阻塞地写出尽可能多的数据，socket 缓冲已满时返回已写出的字节数。
+func writeFd(fd int, p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := syscall.Write(fd, p[written:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			break
+		}
+		if err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Buffered 返回尚未写出的字节数，计入输出缓冲限制。
+func (c *eventConn) Buffered() int {
+	return len(c.pending)
+}
+
+// Close 可以在任意 goroutine 中调用：只 shutdown socket，事件循环读到 EOF 后释放 fd。
+func (c *eventConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.detached != nil {
+		return c.detached.Close()
+	}
+	if !c.fdClosed {
+		_ = syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+	}
+	return nil
+}
+
+func (c *eventConn) LocalAddr() net.Addr  { return c.local }
+func (c *eventConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *eventConn) SetDeadline(t time.Time) error {
+	if c.detached != nil {
+		return c.detached.SetDeadline(t)
+	}
+	return nil
+}
+
+func (c *eventConn) SetReadDeadline(t time.Time) error {
+	if c.detached != nil {
+		return c.detached.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// SetWriteDeadline 在事件循环中没有意义：Write 从不阻塞，慢客户端由输出缓冲限制处理。
+func (c *eventConn) SetWriteDeadline(t time.Time) error {
+	if c.detached != nil {
+		return c.detached.SetWriteDeadline(t)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+//go:build linux
+
+package tcp
+
+import (
+	"MiddlewareSelf/redis/database"
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventLoopCommands(t *testing.T) {
+	srv, _ := startServerConfig(t, serverOptions{cfg: &Config{IOModel: IOModelEpoll, EventLoops: 2}})
+	conn := dialTest(t, srv.addr)
+
+	// 超过读缓冲的值分多次到达，命令跨多次可读事件拼接
+	big := strings.Repeat("v", 3*eventLoopReadSize+7)
+	if got := conn.do("SET", "big", big); got != "+OK" {
+		t.Fatalf("SET big = %q", got)
+	}
+	// 应答超过 socket 发送缓冲时，剩余部分在可写事件中写出
+	huge := strings.Repeat("h", 8<<20)
+	conn.do("SET", "huge", huge)
+	if got := conn.do("GET", "huge"); got != huge {
+		t.Fatalf("GET huge returned %d bytes", len(got))
+	}
+	if got := conn.do("GET", "big"); got != big {
+		t.Fatalf("GET big returned %d bytes", len(got))
+	}
+
+	_ = conn.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.conn.Write([]byte("PING\r\n*1\r\n$4\r\nPING\r\n*2\r\n$3\r\nGET\r\n$7\r\nmissing\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"+PONG\r\n", "+PONG\r\n", "$-1\r\n"} {
+		if line, err := conn.reader.ReadString('\n'); err != nil || line != want {
+			t.Fatalf("pipelined reply = %q, %v, want %q", line, err, want)
+		}
+	}
+	if _, err := conn.conn.Write([]byte("*1\r\n:1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line, _ := conn.reader.ReadString('\n'); line != "-ERR Protocol error: expected '$', got ':'\r\n" {
+		t.Fatalf("protocol error reply = %q", line)
+	}
+	if _, err := conn.reader.ReadString('\n'); err == nil {
+		t.Fatal("connection should be closed after a protocol error")
+	}
+}
+
+// WAIT 与 PSYNC 让连接脱离事件循环，之后的命令在 goroutine 中继续执行。
+func TestEventLoopDetachesBlockingCommands(t *testing.T) {
+	master, _ := startServerConfig(t, serverOptions{cfg: &Config{IOModel: IOModelEpoll, EventLoops: 2}})
+	replica := startTestServer(t)
+	m := dialTest(t, master.addr)
+	r := dialTest(t, replica.addr)
+
+	r.do("REPLICAOF", "127.0.0.1", strconv.Itoa(master.port))
+	waitFor(t, "replica online", func() bool {
+		return strings.Contains(m.do("INFO", "replication"), "state=online")
+	})
+
+	// WAIT 后面紧跟的命令与 WAIT 一起到达，也要在脱离后执行
+	_ = m.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := m.conn.Write([]byte("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n*3\r\n$4\r\nWAIT\r\n$1\r\n1\r\n$1\r\n0\r\nPING\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"+OK\r\n", ":1\r\n", "+PONG\r\n"} {
+		if line, err := m.reader.ReadString('\n'); err != nil || line != want {
+			t.Fatalf("reply = %q, %v, want %q", line, err, want)
+		}
+	}
+	if got := r.do("GET", "k"); got != "v" {
+		t.Fatalf("replicated GET = %q", got)
+	}
+	if got := m.do("SET", "after", "1"); got != "+OK" {
+		t.Fatalf("SET on detached connection = %q", got)
+	}
+	waitFor(t, "stream after detach", func() bool { return r.do("GET", "after") == "1" })
+}
+
+func TestEventLoopClose(t *testing.T) {
+	srv, _ := startServerConfig(t, serverOptions{cfg: &Config{IOModel: IOModelEpoll, EventLoops: 2}})
+	conn := dialTest(t, srv.addr)
+	if got := conn.do("PING"); got != "+PONG" {
+		t.Fatalf("PING = %q", got)
+	}
+	srv.stop()
+	_ = conn.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.reader.ReadString('\n'); err == nil {
+		t.Fatal("connection should be closed when the server stops")
+	}
+}
+
+const benchIdleConns = 2000
+
+// BenchmarkIdleConnMemory 比较两种模式下每个空闲连接占用的内存（堆 + 栈）。
+// 测试客户端在同一进程中，其开销两种模式相同。
+func BenchmarkIdleConnMemory(b *testing.B) {
+	for _, model := range []string{IOModelGoroutine, IOModelEpoll} {
+		b.Run(model, func(b *testing.B) {
+			srv, handler := startServerConfig(b, serverOptions{cfg: &Config{IOModel: model, EventLoops: 2}})
+			var perConn float64
+			for i := 0; i < b.N; i++ {
+				// 等上一轮的连接在服务端释放完再取基线
+				for activeConns(handler) > 0 {
+					time.Sleep(time.Millisecond)
+				}
+				before := memInUse()
+				conns := make([]net.Conn, 0, benchIdleConns)
+				for j := 0; j < benchIdleConns; j++ {
+					conn, err := net.Dial("tcp", srv.addr)
+					if err != nil {
+						b.Fatal(err)
+					}
+					conns = append(conns, conn)
+				}
+				// 每个连接执行一条命令，确认服务端已接管连接
+				for _, conn := range conns {
+					if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+						b.Fatal(err)
+					}
+					if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+						b.Fatal(err)
+					}
+				}
+				perConn = float64(memInUse()-before) / benchIdleConns
+				for _, conn := range conns {
+					_ = conn.Close()
+				}
+			}
+			b.ReportMetric(perConn, "bytes/conn")
+		})
+	}
+}
+
+func activeConns(h *RedisHandler) int {
+	n := 0
+	h.activeConn.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func memInUse() int64 {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int64(ms.HeapInuse + ms.StackInuse)
+}
+
+// BenchmarkThroughput 比较两种模式下 50 个客户端以 16 条命令为一批 pipeline 的吞吐量。
+func BenchmarkThroughput(b *testing.B) {
+	batch := strings.Repeat("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n", 8) +
+		strings.Repeat("*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n", 8)
+	for _, model := range []string{IOModelGoroutine, IOModelEpoll} {
+		b.Run(model, func(b *testing.B) {
+			srv, _ := startServerConfig(b, serverOptions{cfg: &Config{IOModel: model, EventLoops: 2}})
+			b.SetParallelism(50)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				conn, err := net.Dial("tcp", srv.addr)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for pb.Next() {
+					if _, err := io.WriteString(conn, batch); err != nil {
+						b.Error(err)
+						return
+					}
+					for i := 0; i < 16; i++ {
+						line, err := reader.ReadString('\n')
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						if line[0] == '$' {
+							_, _ = reader.ReadString('\n')
+						}
+					}
+				}
+			})
+			b.ReportMetric(float64(b.N*16)/b.Elapsed().Seconds(), "cmds/s")
+		})
+	}
+}
+
+func ExampleListenAndServeEventLoop() {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	closeChan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = ListenAndServeEventLoop([]net.Listener{listener}, MakeRedisHandler(database.MakeDbs()), &Config{EventLoops: 1}, closeChan)
+	}()
+	conn, _ := net.Dial("tcp", listener.Addr().String())
+	_, _ = conn.Write([]byte("PING\r\n"))
+	line, _ := bufio.NewReader(conn).ReadString('\n')
+	fmt.Printf("%q\n", line)
+	close(closeChan)
+	<-done
+	// Output: "+PONG\r\n"
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package tcp
+
+import (
+	"errors"
+	"net"
+)
+
+// ListenAndServeEventLoop 依赖 epoll，只在 Linux 上可用。
+func ListenAndServeEventLoop(listeners []net.Listener, handler *RedisHandler, cfg *Config, closeChan <-chan struct{}) error {
+	closeAll(listeners)
+	return errors.New("io-model epoll is only supported on linux")
+}
@@ -0,0 +1,86 @@
+package tcp
+
+import (
+	"MiddlewareSelf/redis/database"
+	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/resp"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ServerVersion 为 HELLO 中报告的兼容 Redis 版本。
+const ServerVersion = "7.0.0"
+
+// execHello 实现 HELLO [protover [AUTH username password] [SETNAME clientname]]。
+// 所有参数校验通过且连接已认证后才切换协议与设置名字，应答按切换后的协议编码。
+func (h *RedisHandler) execHello(client *RedisClient, args [][]byte) (_interface.Reply, error) {
+	proto := client.Protocol
+	name, setName := "", false
+	var auth [][]byte
+	if len(args) > 1 {
+		v, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return nil, errors.New("Protocol version is not an integer or out of range")
+		}
+		if v != resp.RESP2 && v != resp.RESP3 {
+			return nil, database.MakeReplyError("NOPROTO unsupported protocol version")
+		}
+		proto = v
+	}
+	for i := 2; i < len(args); i++ {
+		opt := strings.ToUpper(string(args[i]))
+		switch {
+		case opt == "AUTH" && i+2 < len(args):
+			auth = args[i+1 : i+3]
+			i += 2
+		case opt == "SETNAME" && i+1 < len(args):
+			if !validClientName(args[i+1]) {
+				return nil, errors.New("Client names cannot contain spaces, newlines or special characters.")
+			}
+			name, setName = string(args[i+1]), true
+			i++
+		default:
+			return nil, errors.New("Syntax error in HELLO option '" + string(args[i]) + "'")
+		}
+	}
+	if auth != nil {
+		if err := h.authenticate(client, auth[:1], auth[1]); err != nil {
+			return nil, err
+		}
+	}
+	if !client.authenticated && h.requirePass != nil {
+		return nil, errHelloAuth
+	}
+
+	client.Protocol = proto
+	if setName {
+		client.Name = name
+	}
+	mode, role := "standalone", "master"
+	if h.cluster != nil {
+		mode = "cluster"
+	}
+	if h.repl != nil && h.repl.IsReplica() {
+		role = "replica"
+	}
+	return resp.MakeMapReply([]_interface.Reply{
+		resp.MakeBulkReply([]byte("server")), resp.MakeBulkReply([]byte("redis")),
+		resp.MakeBulkReply([]byte("version")), resp.MakeBulkReply([]byte(ServerVersion)),
+		resp.MakeBulkReply([]byte("proto")), resp.MakeIntegerReply(int64(proto)),
+		resp.MakeBulkReply([]byte("id")), resp.MakeIntegerReply(client.ID),
+		resp.MakeBulkReply([]byte("mode")), resp.MakeBulkReply([]byte(mode)),
+		resp.MakeBulkReply([]byte("role")), resp.MakeBulkReply([]byte(role)),
+		resp.MakeBulkReply([]byte("modules")), resp.MakeArrayReply([][]byte{}),
+	}), nil
+}
+
+// validClientName 与 Redis 一致：名字只能包含 '!' 到 '~' 之间的可见字符。
+func validClientName(name []byte) bool {
+	for _, c := range name {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
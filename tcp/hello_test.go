@@ -0,0 +1,89 @@
+package tcp
+
+import (
+	"MiddlewareSelf/redis/client"
+	"MiddlewareSelf/redis/resp"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHelloSwitchesProtocol(t *testing.T) {
+	srv := startTestServer(t)
+	conn := dialTest(t, srv.addr)
+
+	if got := conn.do("HELLO", "4"); got != "-NOPROTO unsupported protocol version" {
+		t.Fatalf("HELLO 4 = %q", got)
+	}
+	if got := conn.do("HELLO", "3", "SETNAME", "bad name"); !strings.HasPrefix(got, "-ERR Client names cannot contain spaces") {
+		t.Fatalf("HELLO with invalid name = %q", got)
+	}
+	if got := conn.do("HELLO", "3", "AUTH", "alice", "pw"); !strings.HasPrefix(got, "-WRONGPASS") {
+		t.Fatalf("HELLO AUTH unknown user = %q", got)
+	}
+	if got := conn.do("HELLO", "3", "FOO"); got != "-ERR Syntax error in HELLO option 'FOO'" {
+		t.Fatalf("HELLO bad option = %q", got)
+	}
+	// 参数错误时协议保持 RESP2
+	if got := conn.do("GET", "missing"); got != "(nil)" {
+		t.Fatalf("GET missing key under RESP2 = %q", got)
+	}
+
+	cli, err := client.DialPipeline(srv.addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	exec := func(args ...string) []byte {
+		t.Helper()
+		ch, err := cli.ExecStream(context.Background(), []client.Command{client.NewCommand(args...)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := <-ch
+		if res.Err != nil {
+			t.Fatalf("%v: %v", args, res.Err)
+		}
+		return res.Reply.ToBytes()
+	}
+
+	// RESP2 下 HELLO 的应答为扁平数组，RESP3 下为 map，且按切换后的协议编码
+	if got := exec("HELLO"); !strings.HasPrefix(string(got), "*14\r\n$6\r\nserver\r\n") {
+		t.Fatalf("HELLO under RESP2 = %q", got)
+	}
+	got := string(exec("HELLO", "3", "AUTH", "default", "any", "SETNAME", "conn-1"))
+	if !strings.HasPrefix(got, "%7\r\n") || !strings.Contains(got, "$5\r\nproto\r\n:3\r\n") || !strings.Contains(got, "$4\r\nmode\r\n$10\r\nstandalone\r\n") {
+		t.Fatalf("HELLO 3 = %q", got)
+	}
+	if got := exec("GET", "missing"); string(got) != "_\r\n" {
+		t.Fatalf("GET missing key under RESP3 = %q", got)
+	}
+	if got := exec("HELLO", "2"); !strings.HasPrefix(string(got), "*14\r\n") {
+		t.Fatalf("HELLO 2 = %q", got)
+	}
+	if got := exec("GET", "missing"); string(got) != "$-1\r\n" {
+		t.Fatalf("GET missing key after switching back = %q", got)
+	}
+}
+
+func TestPoolNegotiatesResp3(t *testing.T) {
+	srv := startTestServer(t)
+	pool := client.NewPool(client.PoolOptions{Addr: srv.addr, Protocol: resp.RESP3})
+	defer pool.Close()
+
+	v, err := pool.Do(context.Background(), "HELLO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		t.Fatalf("HELLO over RESP3 should decode to a map, got %T", v)
+	}
+	if m["proto"] != int64(3) || string(m["server"].([]byte)) != "redis" {
+		t.Fatalf("HELLO fields = %v", m)
+	}
+	if _, err := pool.Get(context.Background(), "missing"); err != client.ErrNil {
+		t.Fatalf("Get missing key over RESP3: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package tcp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInlineCommands(t *testing.T) {
+	srv := startTestServer(t)
+	conn := dialTest(t, srv.addr)
+	inline := func(line string) string {
+		t.Helper()
+		_ = conn.conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.conn.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := conn.reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimRight(reply, "\r\n")
+	}
+
+	if got := inline("PING\r\n"); got != "+PONG" {
+		t.Fatalf("inline PING = %q", got)
+	}
+	if got := inline("\r\nSET greeting \"hello world\"\n"); got != "+OK" {
+		t.Fatalf("inline SET = %q", got)
+	}
+	if got := conn.do("GET", "greeting"); got != "hello world" {
+		t.Fatalf("GET after inline SET = %q", got)
+	}
+	// 协议错误后服务端应答错误并断开连接
+	if got := inline("SET k \"unterminated\r\n"); got != "-ERR Protocol error: unbalanced quotes in request" {
+		t.Fatalf("unbalanced quotes = %q", got)
+	}
+	if _, err := conn.reader.ReadString('\n'); err == nil {
+		t.Fatal("connection should be closed after a protocol error")
+	}
+}
@@ -0,0 +1,130 @@
+package tcp
+
+import (
+	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/resp"
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	// replyFlushThreshold 为输出缓冲的写出阈值：超过后立即写出，否则等输入读空后再写
+	replyFlushThreshold = 64 * 1024
+	// maxRetainOutput 以上的输出缓冲在写出后释放，避免个别大应答让连接长期占用内存
+	maxRetainOutput   = 1 << 20
+	replyWriteTimeout = 5 * time.Second
+)
+
+var errOutputLimit = errors.New("client output buffer limit reached")
+
+// outputLimit 对应 client-output-buffer-limit normal，0 表示不限制。
+type outputLimit struct {
+	hard       int64
+	soft       int64
+	softPeriod time.Duration
+}
+
+// outputBuffer 为连接的输出缓冲。应答先追加到 buf，在输入读空（pipeline 中已到达的命令都已执行）
+// 或超过 replyFlushThreshold 时一次写出，pipeline 的多条应答合并为一次系统调用。
+type outputBuffer struct {
+	buf   bytes.Buffer
+	limit outputLimit
+	// softSince 为待发送数据开始超过软限制的时间，未超过时为零值
+	softSince time.Time
+	// err 非 nil 后连接不再写出任何数据，由 handler 关闭
+	err error
+}
+
+// writeReply 把应答追加到连接的输出缓冲，超过阈值时立即写出。
+func (h *RedisHandler) writeReply(client *RedisClient, r _interface.Reply) error {
+	out := &client.out
+	if out.err != nil {
+		return out.err
+	}
+	_, _ = resp.WriteTo(&out.buf, r, client.Protocol)
+	if err := out.checkLimit(connBuffered(client.Conn)); err != nil {
+		log.Printf("[RedisHandler] client %s: %v (%d bytes pending), closing", client.Conn.RemoteAddr(), err, out.buf.Len())
+		out.err = err
+		return err
+	}
+	if out.buf.Len() >= replyFlushThreshold {
+		return h.flush(client)
+	}
+	return nil
+}
+
+// flush 写出输出缓冲中的全部数据。待发送数据超过软限制时，写出必须在软限制期限内完成，
+// 否则视为消费过慢的客户端。
+func (h *RedisHandler) flush(client *RedisClient) error {
+	out := &client.out
+	if out.err != nil || out.buf.Len() == 0 {
+		return out.err
+	}
+	client.Waiting.Add(1)
+	defer client.Waiting.Done()
+
+	deadline := time.Now().Add(replyWriteTimeout)
+	if !out.softSince.IsZero() {
+		if d := out.softSince.Add(out.limit.softPeriod); d.Before(deadline) {
+			deadline = d
+		}
+	}
+	_ = client.Conn.SetWriteDeadline(deadline)
+	if _, err := out.buf.WriteTo(client.Conn); err != nil {
+		out.err = err
+		return err
+	}
+	if out.buf.Cap() > maxRetainOutput {
+		out.buf = bytes.Buffer{}
+	}
+	if connBuffered(client.Conn) == 0 {
+		out.softSince = time.Time{}
+	}
+	return nil
+}
+
+// checkLimit 检查待发送的数据是否超过限制，extra 为连接自身还缓存着的字节数。
+func (o *outputBuffer) checkLimit(extra int) error {
+	pending := int64(o.buf.Len() + extra)
+	if o.limit.hard > 0 && pending > o.limit.hard {
+		return errOutputLimit
+	}
+	if o.limit.soft > 0 && pending > o.limit.soft {
+		if o.softSince.IsZero() {
+			o.softSince = time.Now()
+		} else if time.Since(o.softSince) > o.limit.softPeriod {
+			return errOutputLimit
+		}
+	} else {
+		o.softSince = time.Time{}
+	}
+	return nil
+}
+
+// connBuffered 返回连接自身缓存、尚未写出的字节数：事件循环模式下 Write 写不完的部分留在连接里，
+// 同样计入输出缓冲限制。
+func connBuffered(conn net.Conn) int {
+	if b, ok := conn.(interface{ Buffered() int }); ok {
+		return b.Buffered()
+	}
+	return 0
+}
+
+// flushReader 在从连接读取前写出输出缓冲。parser.Reader 只在读缓冲已空、需要新数据时才读连接，
+// 此时已到达的命令都已执行完毕，它们的应答合并为一次写出。
+type flushReader struct {
+	h      *RedisHandler
+	client *RedisClient
+	r      io.Reader
+}
+
+func (r flushReader) Read(p []byte) (int, error) {
+	if err := r.h.flush(r.client); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,74 @@
+package tcp
+
+import (
+	"MiddlewareSelf/redis/database"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordConn 从 in 读取请求，记录每一次 Write。
+type recordConn struct {
+	net.Conn
+	in     io.Reader
+	writes []string
+}
+
+func (c *recordConn) Read(p []byte) (int, error) { return c.in.Read(p) }
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, string(p))
+	return len(p), nil
+}
+
+func (c *recordConn) Close() error                       { return nil }
+func (c *recordConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *recordConn) SetWriteDeadline(_ time.Time) error { return nil }
+
+func TestPipelinedRepliesCoalesced(t *testing.T) {
+	input := strings.Repeat("*1\r\n$4\r\nPING\r\n", 100) + "*2\r\n$3\r\nGET\r\n$7\r\nmissing\r\n"
+	conn := &recordConn{in: strings.NewReader(input)}
+	MakeRedisHandler(database.MakeDbs()).Handle(context.Background(), conn)
+
+	want := strings.Repeat("+PONG\r\n", 100) + "$-1\r\n"
+	if len(conn.writes) != 1 || conn.writes[0] != want {
+		t.Fatalf("pipelined replies should be written once, got %d writes", len(conn.writes))
+	}
+}
+
+func TestOutputBufferLimit(t *testing.T) {
+	srv, _ := startServerConfig(t, serverOptions{setup: func(h *RedisHandler) { h.SetOutputBufferLimit(1024, 0, 0) }})
+	conn := dialTest(t, srv.addr)
+
+	if got := conn.do("SET", "big", strings.Repeat("x", 2048)); got != "+OK" {
+		t.Fatalf("SET = %q", got)
+	}
+	if got := conn.do("SET", "small", "v"); got != "+OK" {
+		t.Fatalf("SET = %q", got)
+	}
+	// 应答超过硬限制，连接被直接关闭，不发送任何数据
+	_ = conn.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.conn.Write([]byte("*2\r\n$3\r\nGET\r\n$3\r\nbig\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(conn.reader); err != nil || len(data) != 0 {
+		t.Fatalf("expect connection closed without reply, got %q, %v", data, err)
+	}
+	if got := dialTest(t, srv.addr).do("GET", "small"); got != "v" {
+		t.Fatalf("other clients are not affected, GET = %q", got)
+	}
+}
+
+func BenchmarkPipelinedReplies(b *testing.B) {
+	input := bytes.Repeat([]byte("*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n"), 1000)
+	handler := MakeRedisHandler(database.MakeDbs())
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		handler.Handle(context.Background(), &recordConn{in: bytes.NewReader(input)})
+	}
+}
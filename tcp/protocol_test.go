@@ -0,0 +1,48 @@
+package tcp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// 读缓冲在命令之间复用，pipeline 中先写入的值不能被后续命令覆盖。
+func TestPipelinedSetsKeepValues(t *testing.T) {
+	srv := startTestServer(t)
+	conn := dialTest(t, srv.addr)
+	_ = conn.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.conn.Write([]byte("*3\r\n$3\r\nSET\r\n$2\r\nk1\r\n$6\r\nvalue1\r\n*3\r\n$3\r\nSET\r\n$2\r\nk2\r\n$6\r\nVALUE2\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if line, err := conn.reader.ReadString('\n'); err != nil || line != "+OK\r\n" {
+			t.Fatalf("SET reply %d = %q, %v", i, line, err)
+		}
+	}
+	if got := conn.do("GET", "k1"); got != "value1" {
+		t.Fatalf("GET k1 = %q", got)
+	}
+	if got := conn.do("GET", "k2"); got != "VALUE2" {
+		t.Fatalf("GET k2 = %q", got)
+	}
+}
+
+func TestProtoMaxBulkLen(t *testing.T) {
+	srv, _ := startServerConfig(t, serverOptions{setup: func(h *RedisHandler) { h.SetProtoMaxBulkLen(16) }})
+	conn := dialTest(t, srv.addr)
+
+	if got := conn.do("SET", "k", strings.Repeat("x", 16)); got != "+OK" {
+		t.Fatalf("SET at the limit = %q", got)
+	}
+	// 超过上限的 bulk 在读取数据前就被拒绝，应答后断开连接
+	_ = conn.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.conn.Write([]byte("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$17\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line, _ := conn.reader.ReadString('\n'); line != "-ERR Protocol error: invalid bulk length\r\n" {
+		t.Fatalf("oversized bulk = %q", line)
+	}
+	if _, err := conn.reader.ReadString('\n'); err == nil {
+		t.Fatal("connection should be closed after a protocol error")
+	}
+}
@@ -2,36 +2,108 @@ package tcp
 
 import (
 	_interface "MiddlewareSelf/redis/interface"
+	"MiddlewareSelf/redis/cluster"
 	"MiddlewareSelf/redis/database"
 	"MiddlewareSelf/redis/parser"
+	"MiddlewareSelf/redis/replication"
 	"MiddlewareSelf/redis/resp"
 	"MiddlewareSelf/util/atomic"
 	"MiddlewareSelf/util/wait"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	syncatomic "sync/atomic"
 	"time"
 )
 
 type RedisClient struct {
 	Conn    net.Conn
 	Waiting wait.Wait
+	// ID 为连接的唯一编号，Name 为 HELLO SETNAME 设置的名字
+	ID   int64
+	Name string
+	// Protocol 为应答使用的协议版本（resp.RESP2 / resp.RESP3），由 HELLO 切换
+	Protocol int
+
+	out outputBuffer
+	// currentDB 为 SELECT 选中的库；asking 为上一条命令是否为 ASKING，只对紧随其后的一条命令生效
+	currentDB int
+	asking    bool
+	// authenticated 为连接是否已通过 AUTH / HELLO AUTH 认证，未设置 requirepass 时不检查
+	authenticated bool
+	// peer 为该连接的副本状态；PSYNC 成功后 replicaMode 为 true，连接的写方向交给复制流
+	peer        *replication.Peer
+	replicaMode bool
 }
 
 type RedisHandler struct {
 	db *database.Db
+	// repl 为 nil 时不支持复制相关命令
+	repl *replication.Server
+	// cluster 为 nil 时未启用集群模式
+	cluster *cluster.Cluster
 
 	activeConn sync.Map
 	closing    atomic.Boolean
+	// nextClientID 为最近分配的连接编号
+	nextClientID syncatomic.Int64
+	// protoMaxBulkLen 为单个 bulk 参数的最大字节数，0 表示使用 parser.DefaultMaxBulkLen
+	protoMaxBulkLen int64
+	// outputLimit 为普通客户端的输出缓冲限制
+	outputLimit outputLimit
+	// stats 为服务本 handler 的连接统计
+	stats Stats
+	// requirePass 为 requirepass 的 SHA-256 摘要，nil 表示无需认证
+	requirePass []byte
 }
 
 func MakeRedisHandler(db *database.Db) *RedisHandler {
-	return &RedisHandler{db: db}
+	h := &RedisHandler{db: db}
+	if db != nil {
+		db.RegisterInfoSection("Clients", h.clientsInfo)
+	}
+	return h
+}
+
+// Stats 返回连接统计，Serve / ListenAndServeEventLoop 把连接计入其中。
+func (h *RedisHandler) Stats() *Stats {
+	return &h.stats
+}
+
+func (h *RedisHandler) clientsInfo(b *strings.Builder) {
+	database.WriteInfoField(b, "connected_clients", h.stats.Connected.Load())
+	database.WriteInfoField(b, "maxclients", h.stats.MaxClients.Load())
+	database.WriteInfoField(b, "total_connections_received", h.stats.Received.Load())
+	database.WriteInfoField(b, "rejected_connections", h.stats.Rejected.Load())
+	database.WriteInfoField(b, "timedout_connections", h.stats.TimedOut.Load())
+}
+
+// SetReplication 启用复制命令（REPLICAOF / REPLCONF / PSYNC）与只读副本检查。
+func (h *RedisHandler) SetReplication(repl *replication.Server) {
+	h.repl = repl
+}
+
+// SetProtoMaxBulkLen 设置单个 bulk 参数的最大字节数（proto-max-bulk-len）。
+func (h *RedisHandler) SetProtoMaxBulkLen(n int64) {
+	h.protoMaxBulkLen = n
+}
+
+// SetOutputBufferLimit 设置普通客户端的输出缓冲限制（client-output-buffer-limit normal）：
+// 待发送的应答超过 hard 字节，或持续 softSeconds 秒超过 soft 字节时断开连接，0 表示不限制。
+func (h *RedisHandler) SetOutputBufferLimit(hard, soft int64, softSeconds int) {
+	h.outputLimit = outputLimit{hard: hard, soft: soft, softPeriod: time.Duration(softSeconds) * time.Second}
+}
+
+// SetCluster 启用集群模式：按槽校验键并支持 CLUSTER 命令。
+func (h *RedisHandler) SetCluster(c *cluster.Cluster) {
+	h.cluster = c
 }
 
 func (h *RedisHandler) Close() error {
@@ -42,6 +114,12 @@ func (h *RedisHandler) Close() error {
 		return true
 	})
 
+	if h.repl != nil {
+		h.repl.Close()
+	}
+	if h.cluster != nil {
+		h.cluster.Close()
+	}
 	if h.db != nil {
 		h.db.Close()
 	}
@@ -58,98 +136,249 @@ func (h *RedisHandler) Handle(ctx context.Context, conn net.Conn) {
 		_ = conn.Close()
 		return
 	}
+	client := h.openClient(conn)
+	defer h.closeClient(client)
+	// 命令在本 goroutine 中同步读取，args 在读取下一条命令前有效；
+	// 应答在读取新数据前统一写出
+	h.serve(ctx, client, h.newReader(flushReader{h: h, client: client, r: conn}))
+}
 
-	client := &RedisClient{Conn: conn}
+// openClient 为新连接创建客户端状态并登记，Close 时统一关闭。
+func (h *RedisHandler) openClient(conn net.Conn) *RedisClient {
+	client := &RedisClient{Conn: conn, ID: h.nextClientID.Add(1), Protocol: resp.RESP2}
+	client.out.limit = h.outputLimit
 	h.activeConn.Store(client, struct{}{})
-	defer func() {
-		h.activeConn.Delete(client)
-		if err := closeRedisClient(client); err != nil {
-			log.Printf("[RedisHandler] close client error: %v", err)
-		}
-	}()
+	return client
+}
 
-	cmdCh := parser.ParseStream(conn)
-	currentDB := 0
+// closeClient 写出剩余的应答后关闭连接。
+func (h *RedisHandler) closeClient(client *RedisClient) {
+	_ = h.flush(client)
+	if client.replicaMode {
+		h.repl.RemoveReplica(client.peer)
+	}
+	h.activeConn.Delete(client)
+	if err := closeRedisClient(client); err != nil {
+		log.Printf("[RedisHandler] close client error: %v", err)
+	}
+}
 
+func (h *RedisHandler) newReader(rd io.Reader) *parser.Reader {
+	reader := parser.NewReader(rd)
+	if h.protoMaxBulkLen > 0 {
+		reader.SetMaxBulkLen(h.protoMaxBulkLen)
+	}
+	return reader
+}
+
+// serve 在当前 goroutine 中循环读取并执行命令，直到连接关闭或出错。
+func (h *RedisHandler) serve(ctx context.Context, client *RedisClient, reader *parser.Reader) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case payload, ok := <-cmdCh:
-			if !ok {
-				return
+		default:
+		}
+		args, err := reader.ReadCommand()
+		if err != nil {
+			// 协议错误后无法确定下一条命令的边界，应答后关闭连接；读错误（EOF 等）直接关闭
+			var protoErr *parser.ProtocolError
+			if errors.As(err, &protoErr) {
+				_ = h.writeReply(client, resp.MakeErrorReply(protoErr.Error()))
 			}
-			if payload == nil {
-				continue
+			return
+		}
+		if !h.exec(client, args) {
+			return
+		}
+	}
+}
+
+// blockingCommands 会阻塞当前连接（WAIT）或接管连接的写方向（PSYNC / SYNC），
+// 事件循环中遇到这些命令时连接转为 goroutine 模式。
+var blockingCommands = []string{"WAIT", "PSYNC", "SYNC"}
+
+func (h *RedisHandler) blocking(args [][]byte) bool {
+	if h.repl == nil {
+		return false
+	}
+	for _, name := range blockingCommands {
+		if strings.EqualFold(string(args[0]), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// exec 执行一条命令并把应答写入输出缓冲，返回 false 时应关闭连接。
+// args 只在调用期间有效。
+func (h *RedisHandler) exec(client *RedisClient, args [][]byte) bool {
+	if client.replicaMode {
+		// 副本连接上只处理 REPLCONF ACK，且不再应答任何命令
+		if strings.EqualFold(string(args[0]), "REPLCONF") {
+			if _, err := h.repl.ExecReplconf(client.peer, args); err != nil {
+				log.Printf("[RedisHandler] replica %s: %v", client.Conn.RemoteAddr(), err)
 			}
-			if payload.Err != nil {
-				if payload.Err == io.EOF || payload.Err.Error() == "EOF" {
-					return
-				}
-				_ = h.writeReply(client, resp.MakeErrorReply(payload.Err.Error()))
-				continue
+		}
+		return true
+	}
+	if h.authRequired(client, args[0]) {
+		_ = h.writeReply(client, errorReply(errNoAuth))
+		return true
+	}
+	switch {
+	case strings.EqualFold(string(args[0]), "AUTH"):
+		if err := h.execAuth(client, args); err != nil {
+			_ = h.writeReply(client, errorReply(err))
+			return true
+		}
+		if err := h.writeReply(client, resp.MakeSimpleReply("OK")); err != nil {
+			return false
+		}
+		return true
+	case strings.EqualFold(string(args[0]), "QUIT"):
+		// 应答 OK 后关闭连接，剩余应答在关闭前写出
+		_ = h.writeReply(client, resp.MakeSimpleReply("OK"))
+		return false
+	}
+	if strings.EqualFold(string(args[0]), "HELLO") {
+		reply, err := h.execHello(client, args)
+		if err != nil {
+			_ = h.writeReply(client, errorReply(err))
+			return true
+		}
+		if err := h.writeReply(client, reply); err != nil {
+			return false
+		}
+		return true
+	}
+	if h.cluster != nil {
+		cmd := strings.ToUpper(string(args[0]))
+		wasAsking := client.asking
+		client.asking = false
+		if cmd == "ASKING" {
+			client.asking = true
+			if err := h.writeReply(client, resp.MakeSimpleReply("OK")); err != nil {
+				return false
 			}
-
-			arr, ok := payload.Data.(*resp.ArrayReply)
-			if !ok {
-				_ = h.writeReply(client, resp.MakeErrorReply("ERR protocol error: expected array command"))
-				continue
+			return true
+		}
+		if cmd == "CLUSTER" {
+			result, err := h.cluster.Exec(args)
+			if err != nil {
+				_ = h.writeReply(client, errorReply(err))
+				return true
 			}
-			if len(arr.Args) == 0 {
-				_ = h.writeReply(client, resp.MakeErrorReply("ERR empty command"))
-				continue
+			if err := h.writeReply(client, toReply(result)); err != nil {
+				return false
 			}
-
-			if strings.EqualFold(string(arr.Args[0]), "SELECT") {
-				if len(arr.Args) != 2 {
-					_ = h.writeReply(client, resp.MakeErrorReply("ERR wrong number of arguments for 'select'"))
-					continue
-				}
-				nextIdx, err := strconv.Atoi(string(arr.Args[1]))
-				if err != nil {
-					_ = h.writeReply(client, resp.MakeErrorReply("ERR invalid index argument"))
-					continue
-				}
-				result, err := h.db.Exec(currentDB, arr.Args)
-				if err != nil {
-					_ = h.writeReply(client, resp.MakeErrorReply("ERR "+err.Error()))
-					continue
-				}
-				currentDB = nextIdx
-				r := toReply(result)
-				if err := h.writeReply(client, r); err != nil {
-					return
+			return true
+		}
+		if err := h.cluster.CheckKeys(cmd, args, wasAsking); err != nil {
+			_ = h.writeReply(client, errorReply(err))
+			return true
+		}
+	}
+	if h.repl != nil {
+		cmd := strings.ToUpper(string(args[0]))
+		if err := h.repl.CheckWrite(cmd); err != nil {
+			_ = h.writeReply(client, errorReply(err))
+			return true
+		}
+		if client.peer == nil && (cmd == "REPLCONF" || cmd == "PSYNC" || cmd == "SYNC") {
+			client.peer = replication.NewPeer(client.Conn)
+		}
+		switch cmd {
+		case "PSYNC", "SYNC":
+			// 之后连接的写方向交给复制流，先写出此前的应答
+			if err := h.flush(client); err != nil {
+				return false
+			}
+			if err := h.repl.ExecPSync(client.peer, args); err != nil {
+				_ = h.writeReply(client, errorReply(err))
+				return true
+			}
+			client.replicaMode = true
+			return true
+		case "REPLCONF", "REPLICAOF", "SLAVEOF", "WAIT":
+			var result interface{}
+			var err error
+			switch cmd {
+			case "REPLCONF":
+				result, err = h.repl.ExecReplconf(client.peer, args)
+			case "WAIT":
+				// 阻塞当前连接直到足够多的副本确认或超时，其它连接不受影响；阻塞前写出此前的应答
+				if err := h.flush(client); err != nil {
+					return false
 				}
-				continue
+				result, err = h.repl.ExecWait(args)
+			default:
+				result, err = h.repl.ExecReplicaOf(args)
 			}
-
-			result, err := h.db.Exec(currentDB, arr.Args)
 			if err != nil {
-				_ = h.writeReply(client, resp.MakeErrorReply("ERR "+err.Error()))
-				continue
+				_ = h.writeReply(client, errorReply(err))
+				return true
 			}
-
-			reply := toReply(result)
-			if err := h.writeReply(client, reply); err != nil {
-				return
+			if err := h.writeReply(client, toReply(result)); err != nil {
+				return false
 			}
+			return true
 		}
 	}
-}
 
-func (h *RedisHandler) writeReply(client *RedisClient, r _interface.Reply) error {
-	client.Waiting.Add(1)
-	defer client.Waiting.Done()
+	if strings.EqualFold(string(args[0]), "SELECT") {
+		if len(args) != 2 {
+			_ = h.writeReply(client, resp.MakeErrorReply("ERR wrong number of arguments for 'select'"))
+			return true
+		}
+		nextIdx, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			_ = h.writeReply(client, resp.MakeErrorReply("ERR invalid index argument"))
+			return true
+		}
+		result, err := h.db.Exec(client.currentDB, args)
+		if err != nil {
+			_ = h.writeReply(client, errorReply(err))
+			return true
+		}
+		client.currentDB = nextIdx
+		r := toReply(result)
+		if err := h.writeReply(client, r); err != nil {
+			return false
+		}
+		return true
+	}
+
+	result, err := h.db.Exec(client.currentDB, args)
+	if err != nil {
+		_ = h.writeReply(client, errorReply(err))
+		return true
+	}
+
+	reply := toReply(result)
+	if err := h.writeReply(client, reply); err != nil {
+		return false
+	}
+	return true
+}
 
-	_ = client.Conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-	_, err := client.Conn.Write(r.ToBytes())
-	return err
+// errorReply 把 Exec 返回的错误转换为 RESP 错误；自带错误码的 ReplyError 不再加 ERR 前缀。
+func errorReply(err error) _interface.Reply {
+	var replyErr *database.ReplyError
+	if errors.As(err, &replyErr) {
+		return resp.MakeErrorReply(replyErr.Msg)
+	}
+	return resp.MakeErrorReply("ERR " + err.Error())
 }
 
+// toReply 把命令结果转换为应答。切片转换为数组（元素递归转换），map 转换为按键排序的 MapReply，
+// 在 RESP2 连接上编码为键值交替的扁平数组；error 元素转换为错误应答（如 EXEC 中失败的命令）。
 func toReply(v interface{}) _interface.Reply {
 	switch val := v.(type) {
 	case nil:
 		return resp.MakeBulkReply(nil)
+	case _interface.Reply:
+		return val
 	case string:
 		return resp.MakeSimpleReply(val)
 	case []byte:
@@ -158,6 +387,49 @@ func toReply(v interface{}) _interface.Reply {
 		return resp.MakeIntegerReply(int64(val))
 	case int64:
 		return resp.MakeIntegerReply(val)
+	case bool:
+		return resp.MakeBoolReply(val)
+	case float64:
+		return resp.MakeDoubleReply(val)
+	case error:
+		return errorReply(val)
+	case [][]byte:
+		return resp.MakeArrayReply(val)
+	case []string:
+		args := make([][]byte, len(val))
+		for i, s := range val {
+			args[i] = []byte(s)
+		}
+		return resp.MakeArrayReply(args)
+	case []int64:
+		replies := make([]_interface.Reply, len(val))
+		for i, n := range val {
+			replies[i] = resp.MakeIntegerReply(n)
+		}
+		return resp.MakeMultiBulkReply(replies)
+	case []interface{}:
+		replies := make([]_interface.Reply, len(val))
+		for i, elem := range val {
+			replies[i] = toReply(elem)
+		}
+		return resp.MakeMultiBulkReply(replies)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]_interface.Reply, 0, 2*len(keys))
+		for _, k := range keys {
+			pairs = append(pairs, resp.MakeBulkReply([]byte(k)), toReply(val[k]))
+		}
+		return resp.MakeMapReply(pairs)
+	case map[string][]byte:
+		m := make(map[string]interface{}, len(val))
+		for k, b := range val {
+			m[k] = b
+		}
+		return toReply(m)
 	default:
 		return resp.MakeErrorReply(fmt.Sprintf("ERR unsupported reply type %T", v))
 	}
@@ -0,0 +1,465 @@
+package tcp
+
+import (
+	"MiddlewareSelf/redis/database"
+	"MiddlewareSelf/redis/replication"
+	"MiddlewareSelf/redis/resp"
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer 是在回环地址随机端口上运行的一个实例。
+type testServer struct {
+	addr      string
+	port      int
+	closeChan chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// stop 关闭实例并等待其退出，可重复调用。
+func (srv *testServer) stop() {
+	srv.stopOnce.Do(func() {
+		close(srv.closeChan)
+		<-srv.done
+	})
+}
+
+// startTestServer 以默认配置（只读副本）启动实例。
+func startTestServer(t *testing.T) *testServer {
+	t.Helper()
+	srv, _ := startServerConfig(t, serverOptions{})
+	return srv
+}
+
+// serverOptions 为 startServerConfig 的可选配置，零值即默认配置。
+type serverOptions struct {
+	// cfg 为服务端配置（IO 模型、连接限制等），nil 时使用默认配置
+	cfg *Config
+	// repl 为复制选项，nil 时为只读副本；Port 为 0 时取实例的普通端口
+	repl *replication.Options
+	// listeners 为普通端口之外同时服务的监听（如 TLS 端口、Unix socket）
+	listeners []net.Listener
+	// setup 非 nil 时在开始服务前配置 handler
+	setup func(*RedisHandler)
+}
+
+// startServerConfig 按 opts 启动实例，返回实例与其 handler，测试结束时关闭。
+func startServerConfig(tb testing.TB, opts serverOptions) (*testServer, *RedisHandler) {
+	tb.Helper()
+	cfg := opts.cfg
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	if cfg.IOModel == IOModelEpoll && runtime.GOOS != "linux" {
+		tb.Skip("io-model epoll is only supported on linux")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("listen failed: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	db := database.MakeDbs()
+	handler := MakeRedisHandler(db)
+	repl := replication.Options{ReadOnly: true}
+	if opts.repl != nil {
+		repl = *opts.repl
+	}
+	if repl.Port == 0 {
+		repl.Port = port
+	}
+	handler.SetReplication(replication.NewServer(db, repl))
+	if opts.setup != nil {
+		opts.setup(handler)
+	}
+	listeners := append([]net.Listener{listener}, opts.listeners...)
+	srv := &testServer{
+		addr:      listener.Addr().String(),
+		port:      port,
+		closeChan: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go func() {
+		defer close(srv.done)
+		if cfg.IOModel == IOModelEpoll {
+			if err := ListenAndServeEventLoop(listeners, handler, cfg, srv.closeChan); err != nil {
+				tb.Errorf("event loop: %v", err)
+			}
+			return
+		}
+		Serve(listeners, handler, cfg, srv.closeChan)
+	}()
+	tb.Cleanup(srv.stop)
+	return srv, handler
+}
+
+// serveTest 在 listener 上运行 handler，测试结束时关闭。
+func serveTest(tb testing.TB, listener net.Listener, handler Handler) *testServer {
+	tb.Helper()
+	srv := &testServer{
+		addr:      listener.Addr().String(),
+		port:      listener.Addr().(*net.TCPAddr).Port,
+		closeChan: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go func() {
+		defer close(srv.done)
+		ListenAndServe(listener, handler, srv.closeChan)
+	}()
+	tb.Cleanup(srv.stop)
+	return srv
+}
+
+// testConn 是一个最简单的同步 RESP 客户端，只处理单行应答与 bulk 应答。
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialTest(t *testing.T, addr string) *testConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial %s failed: %v", addr, err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// do 发送命令并返回应答：简单字符串/错误/整数返回首行（含类型前缀），bulk 返回内容，nil bulk 返回 "(nil)"。
+func (c *testConn) do(args ...string) string {
+	c.t.Helper()
+	raw := make([][]byte, len(args))
+	for i, arg := range args {
+		raw[i] = []byte(arg)
+	}
+	_ = c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.conn.Write(resp.MakeArrayReply(raw).ToBytes()); err != nil {
+		c.t.Fatalf("write %v failed: %v", args, err)
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("read reply of %v failed: %v", args, err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line[0] != '$' {
+		return line
+	}
+	size, _ := strconv.Atoi(line[1:])
+	if size < 0 {
+		return "(nil)"
+	}
+	buf := make([]byte, size+2)
+	if _, err := io.ReadFull(c.reader, buf); err != nil {
+		c.t.Fatalf("read bulk failed: %v", err)
+	}
+	return string(buf[:size])
+}
+
+// waitFor 轮询直到 cond 成立。
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestReplicationFullSyncAndStream(t *testing.T) {
+	master := startTestServer(t)
+	replica := startTestServer(t)
+	m := dialTest(t, master.addr)
+	r := dialTest(t, replica.addr)
+
+	// 同步前已有的数据通过全量快照到达副本
+	m.do("SET", "before", "1")
+	m.do("SET", "ttl", "v", "EX", "100")
+	m.do("SELECT", "2")
+	m.do("SET", "db2", "x")
+	m.do("SELECT", "0")
+	r.do("SET", "stale", "gone")
+
+	if reply := r.do("REPLICAOF", "127.0.0.1", strconv.Itoa(master.port)); reply != "+OK" {
+		t.Fatalf("REPLICAOF failed: %s", reply)
+	}
+	waitFor(t, "full sync", func() bool { return r.do("GET", "before") == "1" })
+	if got := r.do("GET", "stale"); got != "(nil)" {
+		t.Fatalf("full sync should drop the replica's old data, got %q", got)
+	}
+	if got := r.do("TTL", "ttl"); got == ":-1" || got == ":-2" {
+		t.Fatalf("TTL should be replicated, got %s", got)
+	}
+	r.do("SELECT", "2")
+	if got := r.do("GET", "db2"); got != "x" {
+		t.Fatalf("db2 should be replicated, got %q", got)
+	}
+	r.do("SELECT", "0")
+
+	// 全量同步之后的写命令持续传播
+	m.do("SET", "after", "2")
+	m.do("DEL", "before")
+	m.do("INCRBYFLOAT", "n", "1.5")
+	m.do("SELECT", "5")
+	m.do("SET", "db5", "y")
+	waitFor(t, "streamed commands", func() bool {
+		r.do("SELECT", "5")
+		ok := r.do("GET", "db5") == "y"
+		r.do("SELECT", "0")
+		return ok
+	})
+	if got := r.do("GET", "after"); got != "2" {
+		t.Fatalf("SET after sync should be replicated, got %q", got)
+	}
+	if got := r.do("GET", "before"); got != "(nil)" {
+		t.Fatalf("DEL should be replicated, got %q", got)
+	}
+	if got := r.do("GET", "n"); got != "1.5" {
+		t.Fatalf("INCRBYFLOAT should be replicated, got %q", got)
+	}
+
+	if got := r.do("SET", "k", "v"); !strings.HasPrefix(got, "-READONLY") {
+		t.Fatalf("replica should reject writes, got %s", got)
+	}
+	info := r.do("INFO", "replication")
+	if !strings.Contains(info, "role:slave") || !strings.Contains(info, "master_link_status:up") {
+		t.Fatalf("unexpected replica INFO:\n%s", info)
+	}
+	info = m.do("INFO", "replication")
+	if !strings.Contains(info, "connected_slaves:1") || !strings.Contains(info, fmt.Sprintf("port=%d", replica.port)) {
+		t.Fatalf("unexpected master INFO:\n%s", info)
+	}
+
+	// 提升为主库后保留数据并接受写入
+	if reply := r.do("REPLICAOF", "NO", "ONE"); reply != "+OK" {
+		t.Fatalf("REPLICAOF NO ONE failed: %s", reply)
+	}
+	if got := r.do("SET", "k", "v"); got != "+OK" {
+		t.Fatalf("promoted replica should accept writes, got %s", got)
+	}
+	if got := r.do("GET", "after"); got != "2" {
+		t.Fatalf("promoted replica should keep data, got %q", got)
+	}
+	waitFor(t, "master to drop the replica", func() bool {
+		return strings.Contains(m.do("INFO", "replication"), "connected_slaves:0")
+	})
+}
+
+// infoField 从 INFO 输出中取出 key 对应的值。
+func infoField(info, key string) string {
+	for _, line := range strings.Split(info, "\r\n") {
+		if v, ok := strings.CutPrefix(line, key+":"); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// cutProxy 把连接转发到 target，cut 可以随时切断已有连接，用来模拟网络抖动。
+type cutProxy struct {
+	listener net.Listener
+	target   string
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+func startCutProxy(t *testing.T, target string) *cutProxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	p := &cutProxy{listener: listener, target: target}
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				_ = client.Close()
+				continue
+			}
+			p.mu.Lock()
+			p.conns = append(p.conns, client, upstream)
+			p.mu.Unlock()
+			go func() { _, _ = io.Copy(upstream, client); _ = upstream.Close() }()
+			go func() { _, _ = io.Copy(client, upstream); _ = client.Close() }()
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		p.cut()
+	})
+	return p
+}
+
+func (p *cutProxy) port() int {
+	return p.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (p *cutProxy) cut() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		_ = c.Close()
+	}
+	p.conns = nil
+}
+
+func TestReplicationPartialResync(t *testing.T) {
+	master := startTestServer(t)
+	replica := startTestServer(t)
+	proxy := startCutProxy(t, master.addr)
+	m := dialTest(t, master.addr)
+	r := dialTest(t, replica.addr)
+
+	m.do("SET", "a", "1")
+	r.do("REPLICAOF", "127.0.0.1", strconv.Itoa(proxy.port()))
+	waitFor(t, "full sync", func() bool { return r.do("GET", "a") == "1" })
+
+	// 断线期间的写入进入 backlog，重连后通过 +CONTINUE 补发
+	proxy.cut()
+	m.do("SELECT", "3")
+	m.do("SET", "b", "2")
+	m.do("SELECT", "0")
+	m.do("SET", "c", "3")
+	waitFor(t, "partial resync", func() bool { return r.do("GET", "c") == "3" })
+	r.do("SELECT", "3")
+	if got := r.do("GET", "b"); got != "2" {
+		t.Fatalf("write during the outage should be resent, got %q", got)
+	}
+
+	info := m.do("INFO", "replication")
+	if infoField(info, "sync_full") != "1" || infoField(info, "sync_partial_ok") != "1" {
+		t.Fatalf("expected one full and one partial sync:\n%s", info)
+	}
+	waitFor(t, "offsets to converge", func() bool {
+		mi, ri := m.do("INFO", "replication"), r.do("INFO", "replication")
+		return infoField(mi, "master_repl_offset") == infoField(ri, "master_repl_offset") &&
+			infoField(mi, "master_replid") == infoField(ri, "master_replid")
+	})
+}
+
+func TestReplicationPromotedReplicaServesSiblings(t *testing.T) {
+	master := startTestServer(t)
+	r1srv := startTestServer(t)
+	r2srv := startTestServer(t)
+	m := dialTest(t, master.addr)
+	r1 := dialTest(t, r1srv.addr)
+	r2 := dialTest(t, r2srv.addr)
+
+	r1.do("REPLICAOF", "127.0.0.1", strconv.Itoa(master.port))
+	r2.do("REPLICAOF", "127.0.0.1", strconv.Itoa(master.port))
+	m.do("SET", "k", "v")
+	m.do("SELECT", "7")
+	m.do("SET", "k7", "v7")
+	waitFor(t, "both replicas in sync", func() bool {
+		off := infoField(m.do("INFO", "replication"), "master_repl_offset")
+		return infoField(r1.do("INFO", "replication"), "master_repl_offset") == off &&
+			infoField(r2.do("INFO", "replication"), "master_repl_offset") == off &&
+			infoField(r2.do("INFO", "replication"), "master_link_status") == "up"
+	})
+	oldID := infoField(m.do("INFO", "replication"), "master_replid")
+
+	// 主库故障：提升 r1，r2 改为跟随 r1，凭旧 replid 部分同步
+	r1.do("REPLICAOF", "NO", "ONE")
+	info := r1.do("INFO", "replication")
+	if infoField(info, "master_replid2") != oldID || infoField(info, "master_replid") == oldID {
+		t.Fatalf("promotion should shift the replication ID:\n%s", info)
+	}
+	r2.do("REPLICAOF", "127.0.0.1", strconv.Itoa(r1srv.port))
+	waitFor(t, "r2 to follow r1", func() bool {
+		return infoField(r2.do("INFO", "replication"), "master_link_status") == "up"
+	})
+	info = r1.do("INFO", "replication")
+	if infoField(info, "sync_partial_ok") != "1" || infoField(info, "sync_full") != "0" {
+		t.Fatalf("sibling should partially resync with the promoted replica:\n%s", info)
+	}
+
+	// 新的写入继续流向 r2，且落在正确的 DB
+	r1.do("SET", "after", "failover")
+	waitFor(t, "write on the new master", func() bool { return r2.do("GET", "after") == "failover" })
+	if got := r2.do("GET", "k"); got != "v" {
+		t.Fatalf("data before failover should be kept, got %q", got)
+	}
+	if id := infoField(r2.do("INFO", "replication"), "master_replid"); id != infoField(r1.do("INFO", "replication"), "master_replid") {
+		t.Fatalf("r2 should adopt the new replication ID, got %s", id)
+	}
+}
+
+func TestReplicationWait(t *testing.T) {
+	master := startTestServer(t)
+	replica := startTestServer(t)
+	m := dialTest(t, master.addr)
+	r := dialTest(t, replica.addr)
+
+	if got := m.do("WAIT", "1", "100"); got != ":0" {
+		t.Fatalf("WAIT without replicas should return 0, got %s", got)
+	}
+	r.do("REPLICAOF", "127.0.0.1", strconv.Itoa(master.port))
+	waitFor(t, "replica online", func() bool {
+		return strings.Contains(m.do("INFO", "replication"), "state=online")
+	})
+
+	m.do("SET", "k", "v")
+	start := time.Now()
+	if got := m.do("WAIT", "1", "0"); got != ":1" {
+		t.Fatalf("WAIT 1 should be satisfied by the replica, got %s", got)
+	}
+	// GETACK 让副本立即确认，不必等每秒一次的心跳
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("WAIT took %v, GETACK should make the replica ack immediately", elapsed)
+	}
+	if got := r.do("GET", "k"); got != "v" {
+		t.Fatalf("acknowledged write should be visible on the replica, got %q", got)
+	}
+
+	start = time.Now()
+	if got := m.do("WAIT", "2", "200"); got != ":1" {
+		t.Fatalf("WAIT 2 should time out with 1 replica, got %s", got)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("WAIT returned after %v, before its timeout", elapsed)
+	}
+	if got := r.do("WAIT", "1", "10"); !strings.HasPrefix(got, "-ERR WAIT cannot be used with replica") {
+		t.Fatalf("WAIT on a replica should fail, got %s", got)
+	}
+}
+
+func TestMinReplicasToWrite(t *testing.T) {
+	master, _ := startServerConfig(t, serverOptions{repl: &replication.Options{MinReplicasToWrite: 1, MinReplicasMaxLag: 10}})
+	replica := startTestServer(t)
+	m := dialTest(t, master.addr)
+	r := dialTest(t, replica.addr)
+
+	if got := m.do("SET", "k", "v"); !strings.HasPrefix(got, "-NOREPLICAS") {
+		t.Fatalf("write without good replicas should be rejected, got %s", got)
+	}
+	if got := m.do("GET", "k"); got != "(nil)" {
+		t.Fatalf("reads should still be served, got %q", got)
+	}
+
+	r.do("REPLICAOF", "127.0.0.1", strconv.Itoa(master.port))
+	waitFor(t, "good replica", func() bool { return m.do("SET", "k", "v") == "+OK" })
+	if got := infoField(m.do("INFO", "replication"), "min_slaves_good_slaves"); got != "1" {
+		t.Fatalf("expected one good replica, got %q", got)
+	}
+
+	r.do("REPLICAOF", "NO", "ONE")
+	waitFor(t, "replica to disconnect", func() bool {
+		return strings.HasPrefix(m.do("SET", "k", "v2"), "-NOREPLICAS")
+	})
+}
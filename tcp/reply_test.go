@@ -0,0 +1,42 @@
+package tcp
+
+import (
+	"MiddlewareSelf/redis/database"
+	"MiddlewareSelf/redis/resp"
+	"errors"
+	"testing"
+)
+
+func TestToReplySlicesAndMaps(t *testing.T) {
+	tests := []struct {
+		name         string
+		value        interface{}
+		resp2, resp3 string
+	}{
+		{"strings", []string{"a", "b"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"bytes with nil", [][]byte{[]byte("a"), nil}, "*2\r\n$1\r\na\r\n$-1\r\n", "*2\r\n$1\r\na\r\n_\r\n"},
+		{"integers", []int64{1, -2}, "*2\r\n:1\r\n:-2\r\n", "*2\r\n:1\r\n:-2\r\n"},
+		{
+			"exec style",
+			[]interface{}{"OK", int64(3), nil, errors.New("value is not an integer"), database.MakeReplyError("WRONGTYPE bad"), []interface{}{[]byte("x")}},
+			"*6\r\n+OK\r\n:3\r\n$-1\r\n-ERR value is not an integer\r\n-WRONGTYPE bad\r\n*1\r\n$1\r\nx\r\n",
+			"*6\r\n+OK\r\n:3\r\n_\r\n-ERR value is not an integer\r\n-WRONGTYPE bad\r\n*1\r\n$1\r\nx\r\n",
+		},
+		{
+			"map sorted by key",
+			map[string]interface{}{"b": int64(2), "a": []byte("1")},
+			"*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n:2\r\n",
+			"%2\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n:2\r\n",
+		},
+		{"empty slice", []interface{}{}, "*0\r\n", "*0\r\n"},
+	}
+	for _, tt := range tests {
+		reply := toReply(tt.value)
+		if got := string(resp.Encode(reply, resp.RESP2)); got != tt.resp2 {
+			t.Errorf("%s RESP2: got %q, want %q", tt.name, got, tt.resp2)
+		}
+		if got := string(resp.Encode(reply, resp.RESP3)); got != tt.resp3 {
+			t.Errorf("%s RESP3: got %q, want %q", tt.name, got, tt.resp3)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -15,50 +17,244 @@ import (
 
 // Config 做tcp服务器配置
 type Config struct {
-	Address    string        `yaml:"address"`
-	MaxConnect uint32        `yaml:"max-connect"`
-	Timeout    time.Duration `yaml:"timeout"`
+	// Address 为普通（明文）端口的监听地址，为空时不监听
+	Address string `yaml:"address"`
+	// TLSAddress 非空时在该地址上监听 TLS 连接，证书由 TLS 提供，收到 SIGHUP 时重新读取
+	TLSAddress string `yaml:"tls-address"`
+	TLS        *TLSContext
+	// UnixSocket 非空时同时在该路径上监听 Unix socket，UnixSocketPerm 非 0 时设置 socket 文件权限
+	UnixSocket     string      `yaml:"unixsocket"`
+	UnixSocketPerm os.FileMode `yaml:"unixsocketperm"`
+	// MaxConnect 为最大连接数，达到后新连接收到 -ERR max number of clients reached 后被关闭，0 表示不限制
+	MaxConnect uint32 `yaml:"max-connect"`
+	// Timeout 为空闲超时：超过该时间没有收到任何数据的连接被关闭，0 表示不限制
+	Timeout time.Duration `yaml:"timeout"`
+	// KeepAlive 为 TCP keepalive 探测间隔，0 使用系统默认，负数表示关闭 keepalive
+	KeepAlive time.Duration `yaml:"keepalive"`
+	// IOModel 为 IOModelGoroutine（默认，每个连接一个 goroutine）或 IOModelEpoll（事件循环，仅 Linux）
+	IOModel string `yaml:"io-model"`
+	// EventLoops 为事件循环个数，<= 0 时使用 CPU 个数
+	EventLoops int `yaml:"event-loops"`
 }
 
-var ClientCounter int32
+const (
+	IOModelGoroutine = "goroutine"
+	IOModelEpoll     = "epoll"
+)
+
+const maxClientsReply = "-ERR max number of clients reached\r\n"
 
 type Handler interface {
 	Handle(ctx context.Context, conn net.Conn)
 	Close() error
 }
 
+// Stats 为一个服务端实例的连接统计，由 INFO clients 展示。
+type Stats struct {
+	// MaxClients 为开始服务时的 Config.MaxConnect
+	MaxClients atomic.Uint32
+	// Connected 为当前连接数，Received 为累计接受的连接数
+	Connected atomic.Int64
+	Received  atomic.Int64
+	// Rejected 为因达到 MaxConnect 被拒绝的连接数，TimedOut 为因空闲超时被关闭的连接数
+	Rejected atomic.Int64
+	TimedOut atomic.Int64
+}
+
+// statsOf 返回 handler 自己的连接统计（RedisHandler 用于 INFO clients），没有时使用一份新的统计。
+func statsOf(handler Handler) *Stats {
+	if p, ok := handler.(interface{ Stats() *Stats }); ok {
+		return p.Stats()
+	}
+	return &Stats{}
+}
+
+// admit 为新连接设置 keepalive 并检查连接数上限。返回 false 时连接已被拒绝（在后台应答并关闭），
+// 返回 true 时连接已计入 Connected，调用方在连接关闭后减去。
+func (s *Stats) admit(conn net.Conn, cfg *Config) bool {
+	raw := conn
+	if tc, ok := conn.(*tls.Conn); ok {
+		raw = tc.NetConn()
+	}
+	if tc, ok := raw.(*net.TCPConn); ok && cfg.KeepAlive != 0 {
+		if cfg.KeepAlive < 0 {
+			_ = tc.SetKeepAlive(false)
+		} else {
+			_ = tc.SetKeepAlive(true)
+			_ = tc.SetKeepAlivePeriod(cfg.KeepAlive)
+		}
+	}
+	// 多个 listener 并发 accept，用 CAS 保证不超过上限
+	for {
+		n := s.Connected.Load()
+		if cfg.MaxConnect > 0 && n >= int64(cfg.MaxConnect) {
+			s.Rejected.Add(1)
+			// TLS 连接写应答前要先握手，放到单独的协程里，慢客户端不会阻塞 accept
+			go func() {
+				_ = conn.SetDeadline(time.Now().Add(time.Second))
+				_, _ = conn.Write([]byte(maxClientsReply))
+				_ = conn.Close()
+			}()
+			return false
+		}
+		if s.Connected.CompareAndSwap(n, n+1) {
+			break
+		}
+	}
+	s.Received.Add(1)
+	return true
+}
+
+// idleConn 在每次读之前设置读超时：超过 timeout 没有收到数据时读返回超时错误，handler 随即关闭连接。
+// 执行 WAIT 等阻塞命令时不读连接，不会因此超时。
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+	stats   *Stats
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	n, err := c.Conn.Read(p)
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		c.stats.TimedOut.Add(1)
+	}
+	return n, err
+}
+
+// withIdleTimeout 在配置了空闲超时时包装连接。
+func withIdleTimeout(conn net.Conn, cfg *Config, stats *Stats) net.Conn {
+	if cfg.Timeout <= 0 {
+		return conn
+	}
+	return &idleConn{Conn: conn, timeout: cfg.Timeout, stats: stats}
+}
+
+// ListenAndServe 不限制连接数、不断开空闲连接地服务 listener。
 func ListenAndServe(listener net.Listener, handler Handler, closeChan <-chan struct{}) {
+	Serve([]net.Listener{listener}, handler, &Config{}, closeChan)
+}
+
+// Serve 接受 listeners（如普通端口与 TLS 端口）上的连接，每个连接一个 goroutine 交给 handler 处理，
+// 按 cfg 限制连接数、断开空闲连接并设置 keepalive。closeChan 关闭后停止服务并等待所有连接结束。
+func Serve(listeners []net.Listener, handler Handler, cfg *Config, closeChan <-chan struct{}) {
+	stats := statsOf(handler)
+	stats.MaxClients.Store(cfg.MaxConnect)
+	var wait sync.WaitGroup
+	acceptAll(listeners, handler, cfg, stats, closeChan, func(conn net.Conn) {
+		wait.Add(1)
+		go func() {
+			defer stats.Connected.Add(-1)
+			defer wait.Done()
+			handler.Handle(context.Background(), withIdleTimeout(conn, cfg, stats))
+		}()
+	})
+	_ = handler.Close()
+	wait.Wait()
+}
+
+// acceptAll 在每个 listener 上循环 Accept，通过 admit 的连接交给 serve。closeChan 关闭或任一 listener 出错后
+// 关闭所有 listener 与 handler，所有 Accept 循环退出后返回。
+func acceptAll(listeners []net.Listener, handler Handler, cfg *Config, stats *Stats, closeChan <-chan struct{}, serve func(net.Conn)) {
 	// 监听关闭通知
 	go func() {
 		<-closeChan
 		log.Println("close listener")
-		_ = listener.Close()
+		closeAll(listeners)
 		_ = handler.Close()
 	}()
 
-	defer func() {
-		//atomic.AddInt32(&ClientCounter, -1)
-		_ = listener.Close()
-		_ = handler.Close()
-	}()
+	var accepting sync.WaitGroup
+	for _, listener := range listeners {
+		accepting.Add(1)
+		go func() {
+			defer accepting.Done()
+			defer closeAll(listeners)
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				if stats.admit(conn, cfg) {
+					serve(conn)
+				}
+			}
+		}()
+	}
+	accepting.Wait()
+}
 
-	var wait sync.WaitGroup
-	for {
-		conn, err := listener.Accept()
+// listen 按 cfg 监听普通端口与 TLS 端口。
+func listen(cfg *Config) ([]net.Listener, error) {
+	var listeners []net.Listener
+	if cfg.Address != "" {
+		listener, err := net.Listen("tcp", cfg.Address)
 		if err != nil {
-			break
+			return nil, err
 		}
-		wait.Add(1)
-		go func() {
+		log.Println(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
+		listeners = append(listeners, listener)
+	}
+	if cfg.TLSAddress != "" {
+		if cfg.TLS == nil {
+			closeAll(listeners)
+			return nil, errors.New("tls address configured without certificates")
+		}
+		listener, err := net.Listen("tcp", cfg.TLSAddress)
+		if err != nil {
+			closeAll(listeners)
+			return nil, err
+		}
+		log.Println(fmt.Sprintf("bind: %s (tls), start listening...", cfg.TLSAddress))
+		listeners = append(listeners, tls.NewListener(listener, cfg.TLS.ServerConfig()))
+	}
+	if cfg.UnixSocket != "" {
+		listener, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketPerm)
+		if err != nil {
+			closeAll(listeners)
+			return nil, err
+		}
+		log.Println(fmt.Sprintf("bind: %s (unix), start listening...", cfg.UnixSocket))
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("no address to listen on")
+	}
+	return listeners, nil
+}
 
-			atomic.AddInt32(&ClientCounter, 1)
-			defer atomic.AddInt32(&ClientCounter, -1)
-			defer wait.Done()
-			handler.Handle(context.Background(), conn)
-		}()
+// listenUnix 监听 Unix socket，关闭 listener 时删除 socket 文件。path 上异常退出残留的 socket 文件先删除；
+// 仍有进程在监听或不是 socket 文件时报错。perm 非 0 时设置 socket 文件权限。
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
 
+func closeAll(listeners []net.Listener) {
+	for _, listener := range listeners {
+		_ = listener.Close()
 	}
-	wait.Wait()
 }
 
 func ListenAndServeWithSignal(cfg *Config, handler Handler) error {
@@ -66,18 +262,43 @@ func ListenAndServeWithSignal(cfg *Config, handler Handler) error {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
-		sig := <-sigCh
-		log.Println("receive signal", sig)
-		switch sig {
-		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
-			closeChan <- struct{}{}
+		for sig := range sigCh {
+			log.Println("receive signal", sig)
+			switch sig {
+			case syscall.SIGHUP:
+				// 重新读取 TLS 证书，用于证书轮换
+				if cfg.TLS != nil {
+					if err := cfg.TLS.Reload(); err != nil {
+						log.Printf("reload tls certificates failed: %v", err)
+					} else {
+						log.Println("tls certificates reloaded")
+					}
+				}
+			case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+				close(closeChan)
+				return
+			}
 		}
 	}()
-	listener, err := net.Listen("tcp", cfg.Address)
+	var redisHandler *RedisHandler
+	switch cfg.IOModel {
+	case "", IOModelGoroutine:
+	case IOModelEpoll:
+		h, ok := handler.(*RedisHandler)
+		if !ok {
+			return fmt.Errorf("io-model %s only supports the redis handler", cfg.IOModel)
+		}
+		redisHandler = h
+	default:
+		return fmt.Errorf("unknown io-model '%s'", cfg.IOModel)
+	}
+	listeners, err := listen(cfg)
 	if err != nil {
 		return err
 	}
-	log.Println(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
-	ListenAndServe(listener, handler, closeChan)
+	if redisHandler != nil {
+		return ListenAndServeEventLoop(listeners, redisHandler, cfg, closeChan)
+	}
+	Serve(listeners, handler, cfg, closeChan)
 	return nil
 }
@@ -0,0 +1,182 @@
+package tcp
+
+import (
+	"MiddlewareSelf/redis/client"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ioModels = []string{IOModelGoroutine, IOModelEpoll}
+
+func TestMaxClients(t *testing.T) {
+	for _, model := range ioModels {
+		t.Run(model, func(t *testing.T) {
+			srv, _ := startServerConfig(t, serverOptions{cfg: &Config{IOModel: model, EventLoops: 1, MaxConnect: 2}})
+			first := dialTest(t, srv.addr)
+			second := dialTest(t, srv.addr)
+			for _, c := range []*testConn{first, second} {
+				if got := c.do("PING"); got != "+PONG" {
+					t.Fatalf("PING = %q", got)
+				}
+			}
+
+			rejected, err := net.Dial("tcp", srv.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = rejected.SetDeadline(time.Now().Add(5 * time.Second))
+			got, err := io.ReadAll(rejected)
+			_ = rejected.Close()
+			if err != nil || string(got) != maxClientsReply {
+				t.Fatalf("rejected connection got %q, %v", got, err)
+			}
+
+			info := first.do("INFO", "clients")
+			for key, want := range map[string]string{
+				"connected_clients":          "2",
+				"maxclients":                 "2",
+				"total_connections_received": "2",
+				"rejected_connections":       "1",
+			} {
+				if v := infoField(info, key); v != want {
+					t.Fatalf("%s = %q, want %q in\n%s", key, v, want, info)
+				}
+			}
+
+			// 连接关闭后名额释放
+			_ = second.conn.Close()
+			waitFor(t, "connection released", func() bool {
+				return infoField(first.do("INFO", "clients"), "connected_clients") == "1"
+			})
+			if got := dialTest(t, srv.addr).do("PING"); got != "+PONG" {
+				t.Fatalf("PING after release = %q", got)
+			}
+		})
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	for _, model := range ioModels {
+		t.Run(model, func(t *testing.T) {
+			srv, handler := startServerConfig(t, serverOptions{cfg: &Config{IOModel: model, EventLoops: 1, Timeout: timeout}})
+			idle := dialTest(t, srv.addr)
+			active := dialTest(t, srv.addr)
+			idle.do("PING")
+
+			start := time.Now()
+			for time.Since(start) < 3*timeout {
+				if got := active.do("PING"); got != "+PONG" {
+					t.Fatalf("active client PING = %q", got)
+				}
+				time.Sleep(timeout / 4)
+			}
+			_ = idle.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := idle.reader.ReadString('\n'); err != io.EOF {
+				t.Fatalf("idle client read err = %v, want EOF", err)
+			}
+			if got := infoField(active.do("INFO", "clients"), "timedout_connections"); got != "1" {
+				t.Fatalf("timedout_connections = %q", got)
+			}
+			waitFor(t, "idle connection released", func() bool { return handler.Stats().Connected.Load() == 1 })
+		})
+	}
+}
+
+// 阻塞在 WAIT 中的客户端不读连接，不因空闲超时被断开。
+func TestIdleTimeoutSkipsBlockedClients(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	for _, model := range ioModels {
+		t.Run(model, func(t *testing.T) {
+			srv, _ := startServerConfig(t, serverOptions{cfg: &Config{IOModel: model, EventLoops: 1, Timeout: timeout}})
+			conn := dialTest(t, srv.addr)
+			if got := conn.do("WAIT", "1", "600"); got != ":0" {
+				t.Fatalf("WAIT = %q", got)
+			}
+			if got := conn.do("PING"); got != "+PONG" {
+				t.Fatalf("PING after WAIT = %q", got)
+			}
+		})
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	for _, model := range ioModels {
+		t.Run(model, func(t *testing.T) {
+			if model == IOModelEpoll && runtime.GOOS != "linux" {
+				t.Skip("io-model epoll is only supported on linux")
+			}
+			path := filepath.Join(t.TempDir(), "redis.sock")
+			// 上次异常退出残留的 socket 文件
+			stale, err := net.Listen("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stale.(*net.UnixListener).SetUnlinkOnClose(false)
+			_ = stale.Close()
+
+			cfg := &Config{IOModel: model, EventLoops: 1, UnixSocket: path, UnixSocketPerm: 0o700}
+			listeners, err := listen(cfg)
+			if err != nil {
+				t.Fatalf("listen: %v", err)
+			}
+			if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o700 {
+				t.Fatalf("socket file mode = %v, %v", fi.Mode(), err)
+			}
+			// 已有实例在监听时不能抢占
+			if _, err := listen(cfg); err == nil {
+				t.Fatal("listen on a socket in use should fail")
+			}
+
+			srv, _ := startServerConfig(t, serverOptions{cfg: cfg, listeners: listeners})
+
+			cli, err := client.DialUnix(path, time.Second)
+			if err != nil {
+				t.Fatalf("DialUnix: %v", err)
+			}
+			ch, err := cli.ExecStream(context.Background(), []client.Command{
+				client.NewCommand("SET", "k", "v"),
+				client.NewCommand("GET", "k"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var replies []string
+			for res := range ch {
+				if res.Err != nil {
+					t.Fatal(res.Err)
+				}
+				replies = append(replies, string(res.Reply.ToBytes()))
+			}
+			if strings.Join(replies, "") != "+OK\r\n$1\r\nv\r\n" {
+				t.Fatalf("replies = %q", replies)
+			}
+			_ = cli.Close()
+
+			srv.stop()
+			if _, err := os.Lstat(path); !os.IsNotExist(err) {
+				t.Fatalf("socket file should be removed on shutdown, stat err = %v", err)
+			}
+		})
+	}
+}
+
+func TestUnixSocketRefusesRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	if err := os.WriteFile(path, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen(&Config{UnixSocket: path}); err == nil {
+		t.Fatal("listen should refuse to replace a regular file")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Fatalf("regular file was modified: %q, %v", data, err)
+	}
+}
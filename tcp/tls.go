@@ -0,0 +1,107 @@
+package tcp
+
+import (
+	"MiddlewareSelf/redis/client"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// TLSOptions 对应 tls-cert-file / tls-key-file / tls-ca-cert-file / tls-auth-clients。
+type TLSOptions struct {
+	CertFile string
+	KeyFile  string
+	// CAFile 用于校验客户端证书与（复制链接中）主库的证书
+	CAFile string
+	// AuthClients 为 yes（默认，客户端必须出示 CA 签发的证书）、no 或 optional（出示了才校验）
+	AuthClients string
+}
+
+// TLSContext 持有当前的证书与 CA。Reload 重新读取文件，之后的握手立即使用新证书，已建立的连接不受影响。
+type TLSContext struct {
+	opts       TLSOptions
+	clientAuth tls.ClientAuthType
+	state      atomic.Pointer[tlsState]
+}
+
+type tlsState struct {
+	cert tls.Certificate
+	// pool 为 tls-ca-cert-file 中的 CA，未配置时为 nil（使用系统根证书）
+	pool *x509.CertPool
+}
+
+func NewTLSContext(opts TLSOptions) (*TLSContext, error) {
+	c := &TLSContext{opts: opts}
+	switch strings.ToLower(opts.AuthClients) {
+	case "", "yes":
+		c.clientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		c.clientAuth = tls.VerifyClientCertIfGiven
+	case "no":
+		c.clientAuth = tls.NoClientCert
+	default:
+		return nil, fmt.Errorf("invalid tls-auth-clients '%s'", opts.AuthClients)
+	}
+	if c.clientAuth != tls.NoClientCert && opts.CAFile == "" {
+		return nil, fmt.Errorf("tls-auth-clients %s requires tls-ca-cert-file", opts.AuthClients)
+	}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload 重新读取证书、私钥与 CA 文件，任一文件有误时保留原来的配置。
+func (c *TLSContext) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.opts.CertFile, c.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load tls certificate: %w", err)
+	}
+	st := &tlsState{cert: cert}
+	if c.opts.CAFile != "" {
+		pem, err := os.ReadFile(c.opts.CAFile)
+		if err != nil {
+			return fmt.Errorf("load tls ca: %w", err)
+		}
+		st.pool = x509.NewCertPool()
+		if !st.pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("load tls ca: no certificate found in %s", c.opts.CAFile)
+		}
+	}
+	c.state.Store(st)
+	return nil
+}
+
+// ServerConfig 返回监听端使用的配置：每次握手取当前的证书与 CA。
+func (c *TLSContext) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			st := c.state.Load()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{st.cert},
+				ClientCAs:    st.pool,
+				ClientAuth:   c.clientAuth,
+			}, nil
+		},
+	}
+}
+
+// ClientConfig 返回本实例主动连接其它实例（副本连接主库）时使用的配置：出示本实例的证书，
+// 用同一 CA 校验对端证书链，不校验主机名。
+func (c *TLSContext) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &c.state.Load().cert, nil
+		},
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return client.VerifyChain(cs, c.state.Load().pool)
+		},
+	}
+}
@@ -0,0 +1,299 @@
+package tcp
+
+import (
+	"MiddlewareSelf/redis/client"
+	"MiddlewareSelf/redis/replication"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA 是测试时生成的自签名 CA，用于签发服务端与客户端证书。
+type testCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// file 为 CA 证书的 PEM 文件
+	file string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	ca := &testCA{t: t, dir: t.TempDir()}
+	ca.key = newTestKey(t)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &ca.key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	ca.file = filepath.Join(ca.dir, "ca.pem")
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// issue 签发 CommonName 为 name 的证书（同时可用于服务端与客户端），写入 <name>.pem / <name>.key。
+func (ca *testCA) issue(name string) (certFile, keyFile string) {
+	ca.t.Helper()
+	key := newTestKey(ca.t)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(ca.dir, name+".pem"), filepath.Join(ca.dir, name+".key")
+	writePEM(ca.t, certFile, "CERTIFICATE", der)
+	writePEM(ca.t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startTLSServer 在普通端口与 TLS 端口上启动实例，返回实例（普通端口）与 TLS 地址。
+// 复制选项中的端口为 TLS 端口，副本通过 TLS 连接主库时向主库宣布该端口。
+func startTLSServer(t *testing.T, model string, tlsCtx *TLSContext, opts replication.Options) (*testServer, string) {
+	t.Helper()
+	secure, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 实例被跳过（如非 Linux 上的 epoll）时也要关闭监听
+	t.Cleanup(func() { _ = secure.Close() })
+	opts.Port = secure.Addr().(*net.TCPAddr).Port
+	srv, _ := startServerConfig(t, serverOptions{
+		cfg:       &Config{IOModel: model, EventLoops: 1},
+		repl:      &opts,
+		listeners: []net.Listener{tls.NewListener(secure, tlsCtx.ServerConfig())},
+	})
+	return srv, secure.Addr().String()
+}
+
+func newTestTLSContext(t *testing.T, ca *testCA, authClients string) *TLSContext {
+	t.Helper()
+	certFile, keyFile := ca.issue("server")
+	tlsCtx, err := NewTLSContext(TLSOptions{CertFile: certFile, KeyFile: keyFile, CAFile: ca.file, AuthClients: authClients})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tlsCtx
+}
+
+// tlsPool 创建通过 TLS 连接 addr 的连接池，certFile 为空时不出示客户端证书。
+func tlsPool(t *testing.T, ca *testCA, addr, certFile, keyFile string) *client.Pool {
+	t.Helper()
+	tlsConfig, err := client.LoadTLSConfig(ca.file, certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := client.NewPool(client.PoolOptions{Addr: addr, TLSConfig: tlsConfig, MaxRetries: -1})
+	t.Cleanup(func() { _ = pool.Close() })
+	return pool
+}
+
+func TestTLSListener(t *testing.T) {
+	for _, model := range ioModels {
+		t.Run(model, func(t *testing.T) {
+			ca := newTestCA(t)
+			srv, tlsAddr := startTLSServer(t, model, newTestTLSContext(t, ca, "yes"), replication.Options{})
+			ctx := context.Background()
+
+			certFile, keyFile := ca.issue("client")
+			pool := tlsPool(t, ca, tlsAddr, certFile, keyFile)
+			if err := pool.Set(ctx, "k", "over tls", 0); err != nil {
+				t.Fatalf("SET over tls: %v", err)
+			}
+			// 普通端口与 TLS 端口访问同一份数据
+			if got := dialTest(t, srv.addr).do("GET", "k"); got != "over tls" {
+				t.Fatalf("GET on plain port = %q", got)
+			}
+
+			// tls-auth-clients yes：没有客户端证书的连接被拒绝
+			if err := tlsPool(t, ca, tlsAddr, "", "").Ping(ctx); err == nil {
+				t.Fatal("connection without client certificate should be rejected")
+			}
+			// 不信任服务端 CA 的客户端拒绝连接
+			if err := tlsPool(t, newTestCA(t), tlsAddr, certFile, keyFile).Ping(ctx); err == nil {
+				t.Fatal("server certificate from an unknown CA should be rejected")
+			}
+		})
+	}
+}
+
+func TestTLSAuthClientsOptional(t *testing.T) {
+	ca := newTestCA(t)
+	_, tlsAddr := startTLSServer(t, IOModelGoroutine, newTestTLSContext(t, ca, "optional"), replication.Options{})
+	if err := tlsPool(t, ca, tlsAddr, "", "").Ping(context.Background()); err != nil {
+		t.Fatalf("PING without client certificate: %v", err)
+	}
+	// 出示了证书就必须由 CA 签发
+	certFile, keyFile := newTestCA(t).issue("client")
+	if err := tlsPool(t, ca, tlsAddr, certFile, keyFile).Ping(context.Background()); err == nil {
+		t.Fatal("client certificate from an unknown CA should be rejected")
+	}
+}
+
+// 超过 maxclients 的连接在后台完成 TLS 握手并应答，不完成握手的客户端不会阻塞后续的 accept。
+func TestMaxClientsStalledTLSHandshake(t *testing.T) {
+	secure, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCtx := newTestTLSContext(t, newTestCA(t), "no")
+	srv, _ := startServerConfig(t, serverOptions{
+		cfg:       &Config{MaxConnect: 1},
+		listeners: []net.Listener{tls.NewListener(secure, tlsCtx.ServerConfig())},
+	})
+	first := dialTest(t, srv.addr)
+	first.do("PING")
+
+	// 只建立 TCP 连接、不发 ClientHello
+	stalled, err := net.Dial("tcp", secure.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	waitFor(t, "stalled connection rejected", func() bool {
+		return infoField(first.do("INFO", "clients"), "rejected_connections") == "1"
+	})
+
+	start := time.Now()
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 3 * time.Second}, "tcp", secure.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil || string(got) != maxClientsReply {
+		t.Fatalf("rejected tls connection got %q, %v", got, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("rejection took %v behind a stalled handshake", elapsed)
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue("server")
+	tlsCtx, err := NewTLSContext(TLSOptions{CertFile: certFile, KeyFile: keyFile, CAFile: ca.file, AuthClients: "no"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tlsAddr := startTLSServer(t, IOModelGoroutine, tlsCtx, replication.Options{})
+
+	serverCert := func() *x509.Certificate {
+		t.Helper()
+		conn, err := tls.Dial("tcp", tlsAddr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0]
+	}
+	before := serverCert()
+
+	// 轮换证书：新证书覆盖原文件后 Reload，新连接立即使用新证书
+	rotatedCert, rotatedKey := ca.issue("rotated")
+	for _, f := range [][2]string{{rotatedCert, certFile}, {rotatedKey, keyFile}} {
+		if err := os.Rename(f[0], f[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tlsCtx.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if after := serverCert(); after.SerialNumber.Cmp(before.SerialNumber) == 0 {
+		t.Fatal("server still uses the old certificate after reload")
+	}
+
+	// 文件有误时保留原来的证书
+	if err := os.WriteFile(certFile, []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := tlsCtx.Reload(); err == nil {
+		t.Fatal("reload of a broken certificate should fail")
+	}
+	if err := tlsPool(t, ca, tlsAddr, "", "").Ping(context.Background()); err != nil {
+		t.Fatalf("PING after failed reload: %v", err)
+	}
+}
+
+func TestReplicationOverTLS(t *testing.T) {
+	ca := newTestCA(t)
+	masterTLS := newTestTLSContext(t, ca, "yes")
+	master, masterTLSAddr := startTLSServer(t, IOModelGoroutine, masterTLS, replication.Options{ReadOnly: true})
+	replicaTLS := newTestTLSContext(t, ca, "yes")
+	replica, _ := startTLSServer(t, IOModelGoroutine, replicaTLS, replication.Options{ReadOnly: true, TLSConfig: replicaTLS.ClientConfig()})
+
+	m := dialTest(t, master.addr)
+	r := dialTest(t, replica.addr)
+	m.do("SET", "before", "1")
+	_, port, _ := net.SplitHostPort(masterTLSAddr)
+	r.do("REPLICAOF", "127.0.0.1", port)
+	waitFor(t, "sync over tls", func() bool { return r.do("GET", "before") == "1" })
+	m.do("SET", "after", "2")
+	waitFor(t, "stream over tls", func() bool { return r.do("GET", "after") == "2" })
+	if got := infoField(m.do("INFO", "replication"), "connected_slaves"); got != "1" {
+		t.Fatalf("connected_slaves = %q", got)
+	}
+
+	// 副本的证书不是主库 CA 签发时无法建立复制链接
+	other := newTestCA(t)
+	strangerTLS := newTestTLSContext(t, other, "yes")
+	stranger, _ := startTLSServer(t, IOModelGoroutine, strangerTLS, replication.Options{ReadOnly: true, TLSConfig: strangerTLS.ClientConfig()})
+	s := dialTest(t, stranger.addr)
+	s.do("REPLICAOF", "127.0.0.1", port)
+	time.Sleep(300 * time.Millisecond)
+	if got := s.do("GET", "before"); got != "(nil)" {
+		t.Fatalf("replica with an untrusted certificate synced: GET = %q", got)
+	}
+	if got := infoField(m.do("INFO", "replication"), "connected_slaves"); got != "1" {
+		t.Fatalf("connected_slaves = %q", got)
+	}
+}